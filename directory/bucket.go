@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var (
+	// the bucket name is a part of the hbase table name
+	_bucketName = regexp.MustCompile(`^[a-zA-Z0-9_\-.]{1,63}$`)
+)
+
+// ValidBucketName check the bucket name.
+func ValidBucketName(name string) bool {
+	return _bucketName.MatchString(name)
+}
+
+// syncBuckets load the buckets from zookeeper and watch the bucket root.
+func (d *Directory) syncBuckets() (ev <-chan zk.Event, err error) {
+	var (
+		name    string
+		names   []string
+		b       *meta.Bucket
+		buckets = make(map[string]*meta.Bucket)
+	)
+	if names, ev, err = d.zk.WatchBuckets(); err != nil {
+		return
+	}
+	for _, name = range names {
+		if b, _, err = d.bucket(name); err != nil {
+			if err == errors.ErrBucketNotExist {
+				// deleted after the children got
+				err = nil
+				continue
+			}
+			return
+		}
+		buckets[name] = b
+	}
+	d.bucketLock.Lock()
+	d.buckets = buckets
+	d.bucketLock.Unlock()
+	return
+}
+
+// SyncBuckets synchronous the buckets to memory, which are checked by the
+// uploads.
+func (d *Directory) SyncBuckets() {
+	var (
+		ev  <-chan zk.Event
+		err error
+	)
+	for {
+		if ev, err = d.syncBuckets(); err != nil {
+			log.Errorf("syncBuckets() called error(%v)", err)
+			time.Sleep(retrySleep)
+			continue
+		}
+		select {
+		case <-ev:
+			log.Infof("buckets change")
+		case <-time.After(d.config.Zookeeper.PullInterval.Duration):
+		}
+	}
+}
+
+// bucketExist reports whether the bucket exists, all buckets exist if the
+// buckets are not managed.
+func (d *Directory) bucketExist(name string) (ok bool) {
+	if d.config.Zookeeper.BucketRoot == "" {
+		return true
+	}
+	d.bucketLock.RLock()
+	_, ok = d.buckets[name]
+	d.bucketLock.RUnlock()
+	return
+}
+
+// bucket get the bucket from zookeeper and the version of the node.
+func (d *Directory) bucket(name string) (b *meta.Bucket, version int32, err error) {
+	var data []byte
+	if data, version, err = d.zk.Bucket(name); err != nil {
+		if err == zk.ErrNoNode {
+			err = errors.ErrBucketNotExist
+		} else {
+			err = errors.ErrZookeeperDataError
+		}
+		return
+	}
+	b = new(meta.Bucket)
+	if err = json.Unmarshal(data, b); err != nil {
+		log.Errorf("json.Unmarshal(%s) error(%v)", data, err)
+		err = errors.ErrZookeeperDataError
+	}
+	return
+}
+
+// Bucket get the bucket.
+func (d *Directory) Bucket(name string) (b *meta.Bucket, err error) {
+	b, _, err = d.bucket(name)
+	return
+}
+
+// Buckets get all the buckets synchronized, sorted by the name.
+func (d *Directory) Buckets() (bs []*meta.Bucket) {
+	var b *meta.Bucket
+	d.bucketLock.RLock()
+	for _, b = range d.buckets {
+		bs = append(bs, b)
+	}
+	d.bucketLock.RUnlock()
+	sort.Sort(bucketList(bs))
+	return
+}
+
+// bucketList sort buckets by the name.
+type bucketList []*meta.Bucket
+
+func (p bucketList) Len() int           { return len(p) }
+func (p bucketList) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p bucketList) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// AddBucket create a bucket with new keys.
+func (d *Directory) AddBucket(b *meta.Bucket) (err error) {
+	var data []byte
+	if b.KeyId, b.KeySecret, err = meta.NewBucketKey(); err != nil {
+		log.Errorf("NewBucketKey() error(%v)", err)
+		return
+	}
+	b.CTime = time.Now().Unix()
+	b.MTime = b.CTime
+	if data, err = json.Marshal(b); err != nil {
+		return
+	}
+	if err = d.zk.AddBucket(b.Name, data); err != nil {
+		if err == zk.ErrNodeExists {
+			err = errors.ErrBucketExist
+		} else {
+			err = errors.ErrZookeeperDataError
+		}
+	}
+	return
+}
+
+// UpdateBucket update the bucket by the function, retried if the bucket
+// changed by others meanwhile.
+func (d *Directory) UpdateBucket(name string, update func(*meta.Bucket)) (b *meta.Bucket, err error) {
+	var (
+		version int32
+		data    []byte
+	)
+	for {
+		if b, version, err = d.bucket(name); err != nil {
+			return
+		}
+		update(b)
+		b.Name = name
+		b.MTime = time.Now().Unix()
+		if data, err = json.Marshal(b); err != nil {
+			return
+		}
+		if err = d.zk.SetBucket(name, data, version); err != zk.ErrBadVersion {
+			break
+		}
+		log.Warningf("bucket: %s changed by others, retry", name)
+	}
+	if err == zk.ErrNoNode {
+		err = errors.ErrBucketNotExist
+	} else if err != nil {
+		err = errors.ErrZookeeperDataError
+	}
+	return
+}
+
+// RotateBucket replace the keys of the bucket, the old keys are invalid
+// after the proxies reloaded.
+func (d *Directory) RotateBucket(name string) (b *meta.Bucket, err error) {
+	var keyId, keySecret string
+	if keyId, keySecret, err = meta.NewBucketKey(); err != nil {
+		log.Errorf("NewBucketKey() error(%v)", err)
+		return
+	}
+	return d.UpdateBucket(name, func(b *meta.Bucket) {
+		b.KeyId = keyId
+		b.KeySecret = keySecret
+	})
+}
+
+// DelBucket delete the bucket if no file in it, the expired files count.
+func (d *Directory) DelBucket(name string) (err error) {
+	var l = meta.NewLister("", "", "", 1, 0)
+	if _, _, err = d.bucket(name); err != nil {
+		return
+	}
+	if err = d.metaStore.List(name, l); err != nil {
+		log.Errorf("metaStore.List(%s) error(%v)", name, err)
+		return errors.ErrHBase
+	}
+	if len(l.List.Files) > 0 {
+		return errors.ErrBucketNotEmpty
+	}
+	if err = d.zk.DelBucket(name); err != nil {
+		if err == zk.ErrNoNode {
+			err = errors.ErrBucketNotExist
+		} else {
+			err = errors.ErrZookeeperDataError
+		}
+	}
+	return
+}
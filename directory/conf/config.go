@@ -14,11 +14,18 @@ type Config struct {
 	Snowflake *Snowflake
 	Zookeeper *Zookeeper
 	HBase     *HBase
+	Local     *Local
+	GC        *GC
 
 	MaxNum      int
 	ApiListen   string
 	PprofEnable bool
 	PprofListen string
+
+	// reference the needle of the same sha1 instead of writing a new one
+	Dedup bool
+	// the metadata backend, hbase or local, default hbase
+	MetaStore string
 }
 
 type Snowflake struct {
@@ -35,6 +42,9 @@ type Zookeeper struct {
 	VolumeRoot   string
 	StoreRoot    string
 	GroupRoot    string
+	ECRoot       string
+	// the buckets, empty means the buckets are not managed
+	BucketRoot string
 }
 
 // HBase config.
@@ -51,6 +61,25 @@ type HBase struct {
 	WritesTimeout xtime.Duration
 }
 
+// Local config of the embedded metadata store.
+type Local struct {
+	// the append only log of the metadata
+	File string
+}
+
+// GC config of the needles referenced by no file.
+type GC struct {
+	// the interval of the periodic gc, 0 disables it, enable it on one
+	// directory only
+	Interval duration
+	// the orphans unreferenced shorter than it are kept
+	Grace duration
+	// the needles checked per second, 0 means no limit
+	Rate float64
+	// report the orphans only, no needle deleted
+	DryRun bool
+}
+
 type ZookeeperHbase struct {
 	Root    string
 	Addrs   []string
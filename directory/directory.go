@@ -2,13 +2,13 @@ package main
 
 import (
 	"bfs/directory/conf"
-	"bfs/directory/hbase"
 	"bfs/directory/snowflake"
 	myzk "bfs/directory/zk"
 	"bfs/libs/errors"
 	"bfs/libs/meta"
 	"encoding/json"
 	"strconv"
+	"sync"
 	"time"
 
 	log "github.com/golang/glog"
@@ -33,9 +33,18 @@ type Directory struct {
 	// VOLUME
 	volume      map[int32]*meta.VolumeState // volume_id:volume_state
 	volumeStore map[int32][]string          // volume_id:store_server_id
+	volumeEC    map[int32]*meta.ECVolume    // volume_id:erasure_coded_volume
+
+	// BUCKET
+	bucketLock sync.RWMutex
+	buckets    map[string]*meta.Bucket // bucket_name:bucket
+
+	// GC
+	gcLock  sync.Mutex
+	gcMarks map[int64]int64 // needle_key:unix_nano_first_found_unreferenced
 
 	genkey     *snowflake.Genkey // snowflake client for gen key
-	hBase      *hbase.Client     // hBase client
+	metaStore  MetaStore         // metadata backend
 	dispatcher *Dispatcher       // dispatch for write or read reqs
 
 	config *conf.Config
@@ -44,6 +53,10 @@ type Directory struct {
 
 // NewDirectory .
 func NewDirectory(config *conf.Config) (d *Directory, err error) {
+	if config.GC != nil && !config.GC.DryRun && config.GC.Grace.Duration < _gcMinGrace {
+		log.Errorf("[gc] grace: %s less than %s", config.GC.Grace.Duration, _gcMinGrace)
+		return nil, errors.ErrParam
+	}
 	d = &Directory{}
 	d.config = config
 	if d.zk, err = myzk.NewZookeeper(config); err != nil {
@@ -53,9 +66,17 @@ func NewDirectory(config *conf.Config) (d *Directory, err error) {
 		return
 	}
 
-	d.hBase = hbase.NewClient(config.HBase)
+	if d.metaStore, err = newMetaStore(config); err != nil {
+		return
+	}
 	d.dispatcher = NewDispatcher()
 	go d.SyncZookeeper()
+	if config.Zookeeper.BucketRoot != "" {
+		go d.SyncBuckets()
+	}
+	if config.GC != nil && config.GC.Interval.Duration > 0 {
+		go d.GCProc()
+	}
 	return
 }
 
@@ -121,6 +142,7 @@ func (d *Directory) syncVolumes() (err error) {
 		volumeState     *meta.VolumeState
 		volume          map[int32]*meta.VolumeState
 		volumeStore     map[int32][]string
+		volumeEC        map[int32]*meta.ECVolume
 	)
 	// get all volumes
 	if volumes, err = d.zk.Volumes(); err != nil {
@@ -149,8 +171,52 @@ func (d *Directory) syncVolumes() (err error) {
 		}
 		volumeStore[int32(vid)] = stores
 	}
+	if volumeEC, err = d.syncECVolumes(volumeStore); err != nil {
+		return
+	}
 	d.volume = volume
 	d.volumeStore = volumeStore
+	d.volumeEC = volumeEC
+	return
+}
+
+// syncECVolumes get all erasure coded volumes in zk, the stores hold the
+// shards are added to the volume stores, every one of them can serve the
+// whole volume.
+func (d *Directory) syncECVolumes(volumeStore map[int32][]string) (volumeEC map[int32]*meta.ECVolume, err error) {
+	var (
+		ok       bool
+		str, sid string
+		volumes  []string
+		data     []byte
+		ev       *meta.ECVolume
+		has      map[string]bool
+	)
+	if volumes, err = d.zk.ECVolumes(); err != nil {
+		return
+	}
+	volumeEC = make(map[int32]*meta.ECVolume, len(volumes))
+	for _, str = range volumes {
+		if data, err = d.zk.ECVolume(str); err != nil {
+			return
+		}
+		ev = new(meta.ECVolume)
+		if err = json.Unmarshal(data, ev); err != nil {
+			log.Errorf("json.Unmarshal() error(%v)", err)
+			return
+		}
+		volumeEC[ev.Vid] = ev
+		has = make(map[string]bool)
+		for _, sid = range volumeStore[ev.Vid] {
+			has[sid] = true
+		}
+		for _, sid = range ev.Stores {
+			if _, ok = has[sid]; !ok && sid != "" {
+				has[sid] = true
+				volumeStore[ev.Vid] = append(volumeStore[ev.Vid], sid)
+			}
+		}
+	}
 	return
 }
 
@@ -239,8 +305,8 @@ func (d *Directory) GetStores(bucket, filename string) (n *meta.Needle, f *meta.
 		storeMeta *meta.Store
 		ok        bool
 	)
-	if n, f, err = d.hBase.Get(bucket, filename); err != nil {
-		log.Errorf("hBase.Get error(%v)", err)
+	if n, f, err = d.metaStore.Get(bucket, filename); err != nil {
+		log.Errorf("metaStore.Get error(%v)", err)
 		if err != errors.ErrNeedleNotExist {
 			err = errors.ErrHBase
 		}
@@ -260,7 +326,8 @@ func (d *Directory) GetStores(bucket, filename string) (n *meta.Needle, f *meta.
 			log.Errorf("store cannot match store:%s", store)
 			continue
 		}
-		if !storeMeta.CanRead() {
+		// the volume of a failed disk is routed around
+		if !storeMeta.CanReadVolume(n.Vid) {
 			continue
 		}
 		stores = append(stores, storeMeta.Api)
@@ -271,7 +338,9 @@ func (d *Directory) GetStores(bucket, filename string) (n *meta.Needle, f *meta.
 	return
 }
 
-// UploadStores get writable stores for http upload
+// UploadStores get writable stores for http upload, ErrNeedleDedup if the
+// file references the needle of the same sha1, no store to write. the sha1
+// of a new needle is put by PutSha1 after the stores written.
 func (d *Directory) UploadStores(bucket string, f *meta.File) (n *meta.Needle, stores []string, err error) {
 	var (
 		key       int64
@@ -281,6 +350,21 @@ func (d *Directory) UploadStores(bucket string, f *meta.File) (n *meta.Needle, s
 		storeMeta *meta.Store
 		ok        bool
 	)
+	if !d.bucketExist(bucket) {
+		err = errors.ErrBucketNotExist
+		return
+	}
+	if d.config.Dedup {
+		if n, err = d.metaStore.Ref(bucket, f); err == nil {
+			err = errors.ErrNeedleDedup
+			return
+		}
+		if err != errors.ErrNeedleNotExist {
+			log.Errorf("metaStore.Ref error(%v)", err)
+			err = errors.ErrHBase
+			return
+		}
+	}
 	if vid, err = d.dispatcher.VolumeID(d.group, d.storeVolume); err != nil {
 		log.Errorf("dispatcher.VolumeID error(%v)", err)
 		err = errors.ErrStoreNotAvailable
@@ -307,25 +391,60 @@ func (d *Directory) UploadStores(bucket string, f *meta.File) (n *meta.Needle, s
 	n.Cookie = d.cookie()
 	n.MTime = f.MTime
 	f.Key = key
-	if err = d.hBase.Put(bucket, f, n); err != nil {
+	if err = d.metaStore.Put(bucket, f, n); err != nil {
 		if err != errors.ErrNeedleExist {
-			log.Errorf("hBase.Put error(%v)", err)
+			log.Errorf("metaStore.Put error(%v)", err)
+			err = errors.ErrHBase
+		}
+		return
+	}
+	return
+}
+
+// PutSha1 put the sha1 of the needle of the file after the proxy wrote the
+// stores, the later uploads of the same sha1 reference the needle then, so
+// a failed upload is never shared. the ttl needle is never shared.
+func (d *Directory) PutSha1(bucket, filename string) (err error) {
+	var (
+		n *meta.Needle
+		f *meta.File
+	)
+	if !d.config.Dedup {
+		return
+	}
+	if n, f, err = d.metaStore.Get(bucket, filename); err != nil {
+		log.Errorf("metaStore.Get error(%v)", err)
+		if err != errors.ErrNeedleNotExist {
 			err = errors.ErrHBase
 		}
+		return
+	}
+	if n == nil {
+		err = errors.ErrNeedleNotExist
+		return
+	}
+	if f.Expire != 0 || f.Sha1 == "" {
+		return
+	}
+	if err = d.metaStore.PutSha1(f.Sha1, n); err != nil {
+		log.Errorf("metaStore.PutSha1(%s) error(%v)", f.Sha1, err)
+		err = errors.ErrHBase
 	}
 	return
 }
 
-// DelStores get delable stores for http del
+// DelStores get delable stores for http del, ErrNeedleReferenced if the
+// needle is still referenced by other files, no store to delete.
 func (d *Directory) DelStores(bucket, filename string) (n *meta.Needle, stores []string, err error) {
 	var (
 		ok        bool
+		last      bool
 		store     string
 		svrs      []string
 		storeMeta *meta.Store
 	)
-	if n, _, err = d.hBase.Get(bucket, filename); err != nil {
-		log.Errorf("hBase.Get error(%v)", err)
+	if n, _, err = d.metaStore.Get(bucket, filename); err != nil {
+		log.Errorf("metaStore.Get error(%v)", err)
 		if err != errors.ErrNeedleNotExist {
 			err = errors.ErrHBase
 		}
@@ -345,15 +464,61 @@ func (d *Directory) DelStores(bucket, filename string) (n *meta.Needle, stores [
 			err = errors.ErrZookeeperDataError
 			return
 		}
-		if !storeMeta.CanWrite() {
+		if !storeMeta.CanWriteVolume(n.Vid) {
 			err = errors.ErrStoreNotAvailable
 			return
 		}
 		stores = append(stores, storeMeta.Api)
 	}
-	if err = d.hBase.Del(bucket, filename); err != nil {
-		log.Errorf("hBase.Del error(%v)", err)
+	if last, err = d.metaStore.Del(bucket, filename); err != nil {
+		log.Errorf("metaStore.Del error(%v)", err)
+		err = errors.ErrHBase
+		return
+	}
+	if !last {
+		stores = nil
+		err = errors.ErrNeedleReferenced
+	}
+	return
+}
+
+// List list a page of the files of the bucket.
+func (d *Directory) List(bucket, prefix, delimiter, marker string, max int) (l *meta.FileList, err error) {
+	var lr = meta.NewLister(prefix, delimiter, marker, max, time.Now().Unix())
+	if err = d.metaStore.List(bucket, lr); err != nil {
+		log.Errorf("metaStore.List(%s) error(%v)", bucket, err)
+		err = errors.ErrHBase
+		return
+	}
+	l = &lr.List
+	return
+}
+
+// Check check the consistency of the meta store, the buckets are all the
+// managed buckets if empty, the rows updated in the grace are skipped.
+func (d *Directory) Check(buckets []string, fix bool, grace time.Duration) (r *meta.CheckReport, err error) {
+	var (
+		name string
+		ck   = &meta.Checker{Buckets: buckets, Fix: fix, Since: time.Now().Add(-grace).UnixNano()}
+	)
+	if len(ck.Buckets) == 0 {
+		d.bucketLock.RLock()
+		for name = range d.buckets {
+			ck.Buckets = append(ck.Buckets, name)
+		}
+		d.bucketLock.RUnlock()
+	}
+	ck.Volume = func(vid int32) (ok bool) {
+		if _, ok = d.volumeStore[vid]; !ok {
+			_, ok = d.volumeEC[vid]
+		}
+		return
+	}
+	if err = d.metaStore.Check(ck); err != nil {
+		log.Errorf("metaStore.Check() error(%v)", err)
 		err = errors.ErrHBase
 	}
+	log.Infof("check buckets: %v fix: %t report: %+v", ck.Buckets, fix, ck.Report)
+	r = &ck.Report
 	return
 }
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"os"
 	"testing"
 	// "time"
 
 	"bfs/directory/conf"
+	"bfs/directory/local"
 	dzk "bfs/directory/zk"
+	"bfs/libs/errors"
+	"bfs/libs/meta"
 )
 
 func TestDirectory(t *testing.T) {
@@ -42,3 +46,55 @@ func TestDirectory(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestPutSha1(t *testing.T) {
+	var (
+		err  error
+		s    *local.Store
+		n    *meta.Needle
+		d    *Directory
+		file = "./test_sha1.log"
+	)
+	os.Remove(file)
+	defer os.Remove(file)
+	if s, err = local.New(file); err != nil {
+		t.Errorf("local.New() error(%v)", err)
+		t.FailNow()
+	}
+	d = &Directory{config: &conf.Config{Dedup: true}, metaStore: s}
+	// the file put by UploadStores, the upload to the stores failed
+	if err = s.Put("b", &meta.File{Filename: "f1", Key: 1, Sha1: "s1"}, &meta.Needle{Key: 1, Vid: 1, Cookie: 1}); err != nil {
+		t.Errorf("Put() error(%v)", err)
+		t.FailNow()
+	}
+	if _, err = s.Ref("b", &meta.File{Filename: "f2", Sha1: "s1"}); err != errors.ErrNeedleNotExist {
+		t.Errorf("Ref() the needle of a failed upload error(%v)", err)
+		t.FailNow()
+	}
+	// the stores written
+	if err = d.PutSha1("b", "f1"); err != nil {
+		t.Errorf("PutSha1() error(%v)", err)
+		t.FailNow()
+	}
+	if n, err = s.Ref("b", &meta.File{Filename: "f2", Sha1: "s1"}); err != nil || n.Key != 1 {
+		t.Errorf("Ref() needle: %v error(%v)", n, err)
+		t.FailNow()
+	}
+	// the ttl needle is never shared
+	if err = s.Put("b", &meta.File{Filename: "f3", Key: 3, Sha1: "s3", Expire: 1}, &meta.Needle{Key: 3, Vid: 1, Cookie: 1}); err != nil {
+		t.Errorf("Put() error(%v)", err)
+		t.FailNow()
+	}
+	if err = d.PutSha1("b", "f3"); err != nil {
+		t.Errorf("PutSha1() error(%v)", err)
+		t.FailNow()
+	}
+	if _, err = s.Ref("b", &meta.File{Filename: "f4", Sha1: "s3"}); err != errors.ErrNeedleNotExist {
+		t.Errorf("Ref() the ttl needle error(%v)", err)
+		t.FailNow()
+	}
+	if err = d.PutSha1("b", "none"); err != errors.ErrNeedleNotExist {
+		t.Errorf("PutSha1() not exist error(%v)", err)
+		t.FailNow()
+	}
+}
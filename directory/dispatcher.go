@@ -88,8 +88,9 @@ func (d *Dispatcher) Update(group map[int][]string,
 				totalAdd = totalAdd + volumeState.TotalWriteProcessed
 				restSpace = restSpace + int(volumeState.FreeSpace)
 				totalAddDelay = totalAddDelay + volumeState.TotalWriteDelay
-				// cacl most suitable written vid
-				if volumeState.FreeSpace > minFreeSpace {
+				// cacl most suitable written vid, the volumes of a bad disk
+				// are skipped
+				if volumeState.FreeSpace > minFreeSpace && writable(store, stores, vid) {
 					if value, ok := wrtVids[sid]; !ok || vid < value {
 						wrtVids[sid] = vid
 					}
@@ -109,6 +110,21 @@ func (d *Dispatcher) Update(group map[int][]string,
 	return
 }
 
+// writable check all the stores of the group can write the volume.
+func writable(store map[string]*meta.Store, stores []string, vid int32) bool {
+	var (
+		ok        bool
+		sid       string
+		storeMeta *meta.Store
+	)
+	for _, sid = range stores {
+		if storeMeta, ok = store[sid]; !ok || !storeMeta.CanWriteVolume(vid) {
+			return false
+		}
+	}
+	return true
+}
+
 // cal_score algorithm of calculating score
 func (d *Dispatcher) calScore(totalAdd, totalAddDelay, restSpace int) (score int) {
 	var (
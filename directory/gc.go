@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"time"
+
+	log "github.com/golang/glog"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// the needle keys of a meta store lookup
+	_gcBatch = 1000
+	// the grace of the gc api if no [gc] configured
+	_gcGrace = 24 * time.Hour
+	// the min grace of a gc deletes the orphans, the needle of an upload in
+	// flight is unreferenced until the upload done
+	_gcMinGrace = 10 * time.Minute
+)
+
+// GCProc run the gc periodically, the orphans are deleted unless dry run.
+func (d *Directory) GCProc() {
+	var (
+		r   *meta.GCReport
+		err error
+	)
+	for {
+		time.Sleep(d.config.GC.Interval.Duration)
+		log.Infof("gc start")
+		if r, err = d.GC(d.config.GC.DryRun, d.config.GC.Grace.Duration); err != nil {
+			log.Errorf("GC() error(%v)", err)
+			continue
+		}
+		log.Infof("gc stop, report: %+v", *r)
+	}
+}
+
+// GC find the needles on the stores referenced by no file, which the failed
+// uploads and the fixed orphans leave. an orphan is marked when first found,
+// and deleted from all the replicas by a later gc after it's unreferenced
+// longer than the grace, the marks are kept in memory, so a restart delays
+// the deletes only. the erasure coded volumes are not collected. ErrParam if
+// not dry run and the grace is less than _gcMinGrace.
+func (d *Directory) GC(dryRun bool, grace time.Duration) (r *meta.GCReport, err error) {
+	var (
+		ok          bool
+		i, j        int
+		vid         int32
+		key, first  int64
+		svrs        []string
+		keys        []int64
+		orphans     []int64
+		l           = rate.NewLimiter(rate.Inf, _gcBatch)
+		now         = time.Now().UnixNano()
+		marks       = make(map[int64]int64)
+		volumeStore = d.volumeStore
+	)
+	if !dryRun && grace < _gcMinGrace {
+		log.Errorf("gc grace: %s less than %s", grace, _gcMinGrace)
+		return nil, errors.ErrParam
+	}
+	if d.config.GC != nil && d.config.GC.Rate > 0 {
+		l = rate.NewLimiter(rate.Limit(d.config.GC.Rate), _gcBatch)
+	}
+	d.gcLock.Lock()
+	defer d.gcLock.Unlock()
+	r = &meta.GCReport{DryRun: dryRun}
+	for vid, svrs = range volumeStore {
+		r.Volumes++
+		keys = d.gcKeys(vid, svrs, r)
+		r.Needles += int64(len(keys))
+		for i = 0; i < len(keys); i = j {
+			if j = i + _gcBatch; j > len(keys) {
+				j = len(keys)
+			}
+			time.Sleep(l.ReserveN(time.Now(), j-i).Delay())
+			if orphans, err = d.metaStore.Unreferenced(keys[i:j]); err != nil {
+				log.Errorf("metaStore.Unreferenced() error(%v)", err)
+				return nil, errors.ErrHBase
+			}
+			for _, key = range orphans {
+				r.Orphan(key)
+				if first, ok = d.gcMarks[key]; !ok {
+					first = now
+				}
+				if now-first < int64(grace) {
+					marks[key] = first
+					continue
+				}
+				if r.Expired++; dryRun {
+					marks[key] = first
+					continue
+				}
+				if !d.gcDelete(vid, svrs, key) {
+					r.Failed++
+					marks[key] = first
+					continue
+				}
+				r.Deleted++
+			}
+		}
+	}
+	// the needles referenced again or gone are unmarked
+	d.gcMarks = marks
+	log.Infof("gc dry run: %t grace: %s report: %+v", dryRun, grace, *r)
+	return
+}
+
+// gcKeys get the keys of the needles on all the replicas of the volume, the
+// replica failed to list is counted and skipped.
+func (d *Directory) gcKeys(vid int32, svrs []string, r *meta.GCReport) (keys []int64) {
+	var (
+		ok        bool
+		err       error
+		key       int64
+		skeys     []int64
+		store     string
+		storeMeta *meta.Store
+		seen      = make(map[int64]bool)
+	)
+	for _, store = range svrs {
+		if storeMeta, ok = d.store[store]; !ok {
+			r.Failed++
+			continue
+		}
+		if skeys, err = storeMeta.Needles(vid); err != nil {
+			log.Errorf("store: %s Needles(%d) error(%v)", store, vid, err)
+			r.Failed++
+			continue
+		}
+		for _, key = range skeys {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return
+}
+
+// gcDelete delete the needle from all the replicas of the volume, ok if all
+// deleted.
+func (d *Directory) gcDelete(vid int32, svrs []string, key int64) (ok bool) {
+	var (
+		err       error
+		store     string
+		storeMeta *meta.Store
+	)
+	ok = true
+	for _, store = range svrs {
+		if storeMeta, ok = d.store[store]; !ok {
+			return
+		}
+		if err = storeMeta.Delete(vid, key); err != nil {
+			log.Errorf("store: %s Delete(%d, %d) error(%v)", store, vid, key, err)
+			ok = false
+			return
+		}
+	}
+	log.Warningf("gc: orphan needle key: %d vid: %d deleted", key, vid)
+	return
+}
+
+// Unreferenced filter the needle keys referenced by no file, the anti-entropy
+// repair never copies them to the other replicas.
+func (d *Directory) Unreferenced(keys []int64) (orphans []int64, err error) {
+	if orphans, err = d.metaStore.Unreferenced(keys); err != nil {
+		log.Errorf("metaStore.Unreferenced() error(%v)", err)
+		err = errors.ErrHBase
+	}
+	return
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bfs/directory/conf"
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testGCMetaStore a meta store of the referenced keys, only Unreferenced is
+// called by the gc.
+type testGCMetaStore struct {
+	MetaStore
+	refs map[int64]bool
+}
+
+func (s *testGCMetaStore) Unreferenced(keys []int64) (orphans []int64, err error) {
+	var key int64
+	for _, key = range keys {
+		if !s.refs[key] {
+			orphans = append(orphans, key)
+		}
+	}
+	return
+}
+
+// testGCStore a store of the needle keys of volume 1, fails to list or
+// delete if set.
+type testGCStore struct {
+	lock     sync.Mutex
+	keys     map[int64]bool
+	dels     []int64
+	listFail bool
+	delFail  bool
+}
+
+func (s *testGCStore) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
+	var (
+		key  int64
+		keys []int64
+		bs   []byte
+	)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch r.URL.Path {
+	case "/needles":
+		if s.listFail {
+			http.Error(wr, "internal error", http.StatusInternalServerError)
+			return
+		}
+		for key = range s.keys {
+			keys = append(keys, key)
+		}
+		bs, _ = json.Marshal(map[string]interface{}{"ret": errors.RetOK, "keys": keys})
+		wr.Write(bs)
+	case "/del":
+		if s.delFail {
+			fmt.Fprintf(wr, `{"ret":%d}`, errors.RetInternalErr)
+			return
+		}
+		key, _ = strconv.ParseInt(r.FormValue("key"), 10, 64)
+		delete(s.keys, key)
+		s.dels = append(s.dels, key)
+		fmt.Fprintf(wr, `{"ret":%d}`, errors.RetOK)
+	}
+}
+
+// testGCMarks check the marked keys.
+func testGCMarks(marks map[int64]int64, keys []int64) bool {
+	var (
+		ok  bool
+		key int64
+	)
+	if len(marks) != len(keys) {
+		return false
+	}
+	for _, key = range keys {
+		if _, ok = marks[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGC(t *testing.T) {
+	var (
+		ok     bool
+		i      int
+		key    int64
+		err    error
+		r      *meta.GCReport
+		old    map[int64]int64
+		ms     = &testGCMetaStore{refs: map[int64]bool{1: true}}
+		s1     = &testGCStore{keys: map[int64]bool{1: true, 2: true, 3: true}}
+		s2     = &testGCStore{keys: map[int64]bool{1: true, 2: true, 3: true}}
+		h1     = httptest.NewServer(s1)
+		h2     = httptest.NewServer(s2)
+		d      = &Directory{config: &conf.Config{}, metaStore: ms, gcMarks: make(map[int64]int64)}
+		minute = int64(time.Minute)
+		steps  = []struct {
+			name     string
+			dryRun   bool
+			grace    time.Duration
+			age      int64 // the marks found earlier
+			refs     []int64
+			listFail bool
+			delFail  bool
+			err      error
+			orphans  int64
+			expired  int64
+			deleted  int64
+			failed   int64
+			marks    []int64
+			dels     [2]int // the deletes of s1 and s2
+		}{
+			{name: "grace less than min", grace: time.Minute, err: errors.ErrParam},
+			{name: "orphans first found", grace: _gcMinGrace, orphans: 2, marks: []int64{2, 3}},
+			{name: "dry run never deletes", dryRun: true, orphans: 2, expired: 2, marks: []int64{2, 3}},
+			{name: "referenced again", grace: _gcMinGrace, refs: []int64{3}, orphans: 1, marks: []int64{2}},
+			{name: "replicas failed", grace: _gcMinGrace, age: 11 * minute, listFail: true, delFail: true, orphans: 1, expired: 1, failed: 2, marks: []int64{2}, dels: [2]int{1, 0}},
+			{name: "deleted after grace", grace: _gcMinGrace, orphans: 1, expired: 1, deleted: 1, dels: [2]int{1, 1}},
+		}
+	)
+	defer h1.Close()
+	defer h2.Close()
+	d.volumeStore = map[int32][]string{1: {"s1", "s2"}}
+	d.store = map[string]*meta.Store{
+		"s1": {Api: strings.TrimPrefix(h1.URL, "http://"), Admin: strings.TrimPrefix(h1.URL, "http://")},
+		"s2": {Api: strings.TrimPrefix(h2.URL, "http://"), Admin: strings.TrimPrefix(h2.URL, "http://")},
+	}
+	for i = range steps {
+		step := &steps[i]
+		for key = range d.gcMarks {
+			d.gcMarks[key] -= step.age
+		}
+		for _, key = range step.refs {
+			ms.refs[key] = true
+		}
+		old = make(map[int64]int64)
+		for key = range d.gcMarks {
+			old[key] = d.gcMarks[key]
+		}
+		s1.dels, s2.dels = nil, nil
+		s2.listFail, s2.delFail = step.listFail, step.delFail
+		if r, err = d.GC(step.dryRun, step.grace); err != step.err {
+			t.Errorf("%s: GC() error(%v) must be %v", step.name, err, step.err)
+			t.FailNow()
+		}
+		if err != nil {
+			continue
+		}
+		if r.Volumes != 1 || r.Orphans != step.orphans || r.Expired != step.expired || r.Deleted != step.deleted || r.Failed != step.failed {
+			t.Errorf("%s: GC() report: %+v not match", step.name, *r)
+			t.FailNow()
+		}
+		if !testGCMarks(d.gcMarks, step.marks) {
+			t.Errorf("%s: marks: %v must be %v", step.name, d.gcMarks, step.marks)
+			t.FailNow()
+		}
+		// the first found time is carried over
+		for key = range d.gcMarks {
+			if _, ok = old[key]; ok && old[key] != d.gcMarks[key] {
+				t.Errorf("%s: mark: %d changed", step.name, key)
+				t.FailNow()
+			}
+		}
+		if len(s1.dels) != step.dels[0] || len(s2.dels) != step.dels[1] {
+			t.Errorf("%s: deletes: %v %v not match", step.name, s1.dels, s2.dels)
+			t.FailNow()
+		}
+	}
+}
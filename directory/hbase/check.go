@@ -0,0 +1,161 @@
+package hbase
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+
+	log "github.com/golang/glog"
+)
+
+// Check check the files of the buckets and all the needle rows, the file
+// without the needle row and the needle row referenced by no file are
+// orphans, the needles of the unknown volumes are reported only.
+func (c *Client) Check(ck *meta.Checker) (err error) {
+	var bucket string
+	for _, bucket = range ck.Buckets {
+		if err = c.checkFiles(bucket, ck); err != nil {
+			return
+		}
+	}
+	return c.checkNeedles(ck)
+}
+
+// checkFiles check the files of the bucket page by page.
+func (c *Client) checkFiles(bucket string, ck *meta.Checker) (err error) {
+	var (
+		f  *meta.File
+		n  *meta.Needle
+		sn *meta.Needle
+		l  = meta.NewLister("", "", "", meta.MaxListKeys, 0)
+	)
+	for {
+		if err = c.listFile(bucket, l); err != nil {
+			return
+		}
+		for _, f = range l.List.Files {
+			ck.Report.Files++
+			if n, err = c.getNeedle(f.Key); err == nil {
+				if ck.Volume != nil && !ck.Volume(n.Vid) {
+					ck.UnknownVolume(n.Key)
+				}
+				continue
+			}
+			if err != errors.ErrNeedleNotExist {
+				return
+			}
+			err = nil
+			if ck.Recent(f.MTime) {
+				continue
+			}
+			ck.OrphanFile(bucket, f.Filename)
+			if !ck.Fix {
+				continue
+			}
+			log.Warningf("check: orphan file bucket: %s filename: %s key: %d deleted", bucket, f.Filename, f.Key)
+			if err = c.delFile(bucket, f.Filename); err != nil {
+				return
+			}
+			// the sha1 of the missing needle is not referenced any more
+			if sn, _, err = c.getSha1(f.Sha1); err == nil && sn.Key == f.Key {
+				err = c.delSha1(f.Sha1)
+			} else if err == errors.ErrNeedleNotExist {
+				err = nil
+			}
+			if err != nil {
+				return
+			}
+			ck.Report.Fixed++
+		}
+		if !l.List.Truncated {
+			return
+		}
+		l = meta.NewLister("", "", l.List.Marker, meta.MaxListKeys, 0)
+	}
+}
+
+// checkNeedles check the needle rows are referenced by the owner file or
+// the sha1 of it.
+func (c *Client) checkNeedles(ck *meta.Checker) (err error) {
+	var serr error
+	if serr = c.scanNeedles(func(n *meta.Needle, o owner) bool {
+		var ok bool
+		ck.Report.Needles++
+		if o.bucket == "" {
+			ck.Report.Unowned++
+			return true
+		}
+		if ck.Recent(n.MTime) {
+			return true
+		}
+		if ok, err = c.owned(n, o); err != nil || ok {
+			return err == nil
+		}
+		ck.OrphanNeedle(n.Key)
+		if ck.Fix {
+			log.Warningf("check: orphan needle key: %d vid: %d of bucket: %s filename: %s deleted", n.Key, n.Vid, o.bucket, o.filename)
+			if err = c.delNeedle(n.Key); err != nil {
+				return false
+			}
+			ck.Report.Fixed++
+		}
+		return true
+	}); serr != nil {
+		err = serr
+	}
+	return
+}
+
+// owned reports whether the needle is referenced by the owner file or the
+// sha1 of it.
+func (c *Client) owned(n *meta.Needle, o owner) (ok bool, err error) {
+	var (
+		f  *meta.File
+		sn *meta.Needle
+	)
+	if f, err = c.getFile(o.bucket, o.filename); err == nil {
+		ok = f.Key == n.Key
+	} else if err != errors.ErrNeedleNotExist {
+		return
+	}
+	if !ok && o.sha1 != "" {
+		if sn, _, err = c.getSha1(o.sha1); err == nil {
+			ok = sn.Key == n.Key
+		} else if err != errors.ErrNeedleNotExist {
+			return
+		}
+	}
+	err = nil
+	return
+}
+
+// Unreferenced filter the needle keys referenced by no file, the key
+// without the needle row or the needle row not owned, the rows of old
+// versions without the owner are taken as referenced.
+func (c *Client) Unreferenced(keys []int64) (orphans []int64, err error) {
+	var (
+		ok  bool
+		key int64
+		n   *meta.Needle
+		o   owner
+	)
+	for _, key = range keys {
+		if n, o, err = c.getNeedleOwner(key); err == errors.ErrNeedleNotExist {
+			orphans = append(orphans, key)
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if o.bucket == "" {
+			continue
+		}
+		if ok, err = c.owned(n, o); err != nil {
+			return
+		}
+		if !ok {
+			orphans = append(orphans, key)
+		}
+	}
+	err = nil
+	return
+}
@@ -18,6 +18,8 @@ var (
 	_columnSha1   = "sha1"
 	_columnMine   = "mine"
 	_columnStatus = "status"
+	_columnExpire = "expire"
+	_columnSize   = "size"
 )
 
 func (c *Client) getFile(bucket, filename string) (f *meta.File, err error) {
@@ -38,7 +40,13 @@ func (c *Client) getFile(bucket, filename string) (f *meta.File, err error) {
 	f = &meta.File{
 		Filename: filename,
 	}
-	for _, cell := range result.Cells {
+	parseFile(f, result.Cells)
+	return
+}
+
+// parseFile parse the file columns of the row.
+func parseFile(f *meta.File, cells []*hrpc.Cell) {
+	for _, cell := range cells {
 		if cell == nil {
 			continue
 		}
@@ -53,10 +61,13 @@ func (c *Client) getFile(bucket, filename string) (f *meta.File, err error) {
 				f.Status = int32(binary.BigEndian.Uint32(cell.Value))
 			} else if bytes.Equal(cell.Qualifier, []byte(_columnUpdateTime)) {
 				f.MTime = int64(binary.BigEndian.Uint64(cell.Value))
+			} else if bytes.Equal(cell.Qualifier, []byte(_columnExpire)) {
+				f.Expire = int64(binary.BigEndian.Uint64(cell.Value))
+			} else if bytes.Equal(cell.Qualifier, []byte(_columnSize)) {
+				f.Size = int64(binary.BigEndian.Uint64(cell.Value))
 			}
 		}
 	}
-	return
 }
 
 func (c *Client) existFile(bucket, filename string) (exist bool, err error) {
@@ -82,6 +93,8 @@ func (c *Client) putFile(bucket string, f *meta.File) (err error) {
 		kbuf   = make([]byte, 8)
 		stbuf  = make([]byte, 4)
 		ubuf   = make([]byte, 8)
+		ebuf   = make([]byte, 8)
+		sbuf   = make([]byte, 8)
 		mutate *hrpc.Mutate
 		exist  bool
 	)
@@ -91,7 +104,7 @@ func (c *Client) putFile(bucket string, f *meta.File) (err error) {
 		return
 	}
 	if exist {
-		if err = c.updateFile(bucket, f.Filename, f.Sha1); err != nil {
+		if err = c.updateFile(bucket, f); err != nil {
 			return
 		}
 		err = errors.ErrNeedleExist
@@ -100,6 +113,8 @@ func (c *Client) putFile(bucket string, f *meta.File) (err error) {
 	binary.BigEndian.PutUint64(kbuf, uint64(f.Key))
 	binary.BigEndian.PutUint32(stbuf, uint32(f.Status))
 	binary.BigEndian.PutUint64(ubuf, uint64(f.MTime))
+	binary.BigEndian.PutUint64(ebuf, uint64(f.Expire))
+	binary.BigEndian.PutUint64(sbuf, uint64(f.Size))
 	values := map[string]map[string][]byte{
 		_familyFile: map[string][]byte{
 			_columnKey:        kbuf,
@@ -107,6 +122,8 @@ func (c *Client) putFile(bucket string, f *meta.File) (err error) {
 			_columnMine:       []byte(f.Mine),
 			_columnStatus:     stbuf,
 			_columnUpdateTime: ubuf,
+			_columnExpire:     ebuf,
+			_columnSize:       sbuf,
 		},
 	}
 	if mutate, err = hrpc.NewPut(context.Background(), c.tableName(bucket), []byte(f.Filename), values); err != nil {
@@ -133,24 +150,32 @@ func (c *Client) delFile(bucket, filename string) (err error) {
 	return
 }
 
-func (c *Client) updateFile(bucket, filename, sha1 string) (err error) {
+// updateFile update the file data, the expire of the new data overwrites the
+// old, zero means never.
+func (c *Client) updateFile(bucket string, f *meta.File) (err error) {
 	var (
 		ubuf   = make([]byte, 8)
+		ebuf   = make([]byte, 8)
+		sbuf   = make([]byte, 8)
 		mutate *hrpc.Mutate
 	)
 	binary.BigEndian.PutUint64(ubuf, uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint64(ebuf, uint64(f.Expire))
+	binary.BigEndian.PutUint64(sbuf, uint64(f.Size))
 	values := map[string]map[string][]byte{
 		_familyFile: map[string][]byte{
-			_columnSha1:       []byte(sha1),
+			_columnSha1:       []byte(f.Sha1),
 			_columnUpdateTime: ubuf,
+			_columnExpire:     ebuf,
+			_columnSize:       sbuf,
 		},
 	}
-	if mutate, err = hrpc.NewPut(context.Background(), c.tableName(bucket), []byte(filename), values); err != nil {
-		log.Errorf("Client.updateFile.NewPut(%v,%v) error:%v", bucket, filename, err.Error())
+	if mutate, err = hrpc.NewPut(context.Background(), c.tableName(bucket), []byte(f.Filename), values); err != nil {
+		log.Errorf("Client.updateFile.NewPut(%v,%v) error:%v", bucket, f.Filename, err.Error())
 		return
 	}
 	if _, err = c.c.Put(mutate); err != nil {
-		log.Errorf("Client.updateFile.Put(%v,%v) error:%v", bucket, filename, err.Error())
+		log.Errorf("Client.updateFile.Put(%v,%v) error:%v", bucket, f.Filename, err.Error())
 	}
 	return
 }
@@ -158,3 +183,47 @@ func (c *Client) updateFile(bucket, filename, sha1 string) (err error) {
 func (c *Client) tableName(bucket string) []byte {
 	return []byte(_prefix + bucket)
 }
+
+// listFile scan the file table of the bucket into the lister, batch by batch
+// of the page size, the files of a common prefix are skipped by the next
+// batch.
+func (c *Client) listFile(bucket string, l *meta.Lister) (err error) {
+	var (
+		scan    *hrpc.Scan
+		results []*hrpc.Result
+		result  *hrpc.Result
+		f       *meta.File
+		start   = l.Start()
+		stop    = l.Stop()
+		full    bool
+	)
+	for !full && (stop == "" || start < stop) {
+		if scan, err = hrpc.NewScanRange(context.Background(), c.tableName(bucket), []byte(start), []byte(stop), hrpc.Families(map[string][]string{_familyFile: nil})); err != nil {
+			log.Errorf("Client.listFile.NewScanRange(%v,%q) error:%v", bucket, start, err.Error())
+			return
+		}
+		scan.SetLimit(l.Max + 1)
+		if results, err = c.c.Scan(scan); err != nil {
+			log.Errorf("Client.listFile.Scan(%v,%q) error:%v", bucket, start, err.Error())
+			return
+		}
+		for _, result = range results {
+			if result == nil || len(result.Cells) == 0 {
+				continue
+			}
+			f = &meta.File{Filename: string(result.Cells[0].Row)}
+			parseFile(f, result.Cells)
+			if full = !l.Add(f); full {
+				break
+			}
+			if start = l.After(f.Filename); start == "" {
+				return
+			}
+		}
+		// the range is exhausted
+		if len(results) <= l.Max {
+			break
+		}
+	}
+	return
+}
@@ -56,34 +56,152 @@ func NewClient(c *conf.HBase, options ...gohbase.Option) *Client {
 	}
 }
 
-// Put put file and needle into hbase
+// Put put file and needle into hbase. the needle row is written before the
+// file row, which makes the file reachable, so a failure between them leaves
+// an unreachable needle row only, which is deleted by the checker. an
+// existing file is updated in place, ErrNeedleExist then, unless its needle
+// is in the sha1 table, which may be shared and is never rewritten, or it's
+// expired, whose needle is dropped by the stores, the reference of it is
+// dropped and the file is put with the new needle.
 func (c *Client) Put(bucket string, f *meta.File, n *meta.Needle) (err error) {
-	if err = c.putFile(bucket, f); err != nil {
+	var (
+		ok bool
+		of *meta.File
+	)
+	if of, err = c.getFile(bucket, f.Filename); err == nil {
+		if ok, err = c.inSha1(of); err != nil {
+			return
+		}
+		if !ok && !of.Expired(time.Now().Unix()) {
+			if err = c.updateFile(bucket, f); err == nil {
+				err = errors.ErrNeedleExist
+			}
+			return
+		}
+		if _, err = c.Del(bucket, f.Filename); err != nil && err != errors.ErrNeedleNotExist {
+			return
+		}
+	} else if err != errors.ErrNeedleNotExist {
 		return
 	}
-	if err = c.putNeedle(n); err != nil && err != errors.ErrNeedleExist {
-		log.Warningf("table not match: bucket: %s  filename: %s", bucket, f.Filename)
-		c.delFile(bucket, f.Filename)
+	if err = c.putNeedle(bucket, f, n); err != nil {
+		return
+	}
+	// the file may be put by others meanwhile, it's updated then
+	if err = c.putFile(bucket, f); err != nil {
+		c.delNeedle(n.Key)
 	}
 	return
 }
 
-// Get get needle from hbase
+// Get get needle from hbase, the file without the needle row is left to the
+// checker.
 func (c *Client) Get(bucket, filename string) (n *meta.Needle, f *meta.File, err error) {
 	if f, err = c.getFile(bucket, filename); err != nil {
 		return
 	}
 	if n, err = c.getNeedle(f.Key); err == errors.ErrNeedleNotExist {
 		log.Warningf("table not match: bucket: %s  filename: %s", bucket, filename)
-		c.delFile(bucket, filename)
 	}
 	return
 }
 
-// Del del file and needle from hbase
-func (c *Client) Del(bucket, filename string) (err error) {
+// List list the files of the bucket into the lister.
+func (c *Client) List(bucket string, l *meta.Lister) (err error) {
+	return c.listFile(bucket, l)
+}
+
+// Ref reference the needle of the same sha1 for a file, the file is put with
+// the key of it. an existing file of the same sha1 keeps its needle, the
+// reference of another one is dropped first, the needle is never rewritten
+// in place, the data of the last reference is left to the gc. a ttl file
+// neither references nor is referenced, the stores drop its needle when
+// expired. ErrNeedleNotExist if no needle of the sha1.
+func (c *Client) Ref(bucket string, f *meta.File) (n *meta.Needle, err error) {
+	var (
+		ref int64
+		of  *meta.File
+	)
+	if f.Expire != 0 {
+		return nil, errors.ErrNeedleNotExist
+	}
+	if of, err = c.getFile(bucket, f.Filename); err == nil {
+		if of.Sha1 == f.Sha1 && of.Expire == 0 {
+			if n, err = c.getNeedle(of.Key); err != nil {
+				return
+			}
+			err = c.updateFile(bucket, f)
+			return
+		}
+		if _, err = c.Del(bucket, f.Filename); err != nil && err != errors.ErrNeedleNotExist {
+			return
+		}
+	} else if err != errors.ErrNeedleNotExist {
+		return
+	}
+	if _, _, err = c.getSha1(f.Sha1); err != nil {
+		return
+	}
+	if ref, err = c.incrSha1(f.Sha1, 1); err != nil {
+		return
+	}
+	// the last reference is gone, the needle is being deleted
+	if ref <= 1 {
+		c.incrSha1(f.Sha1, -1)
+		err = errors.ErrNeedleNotExist
+		return
+	}
+	// the needle can't change while referenced
+	if n, _, err = c.getSha1(f.Sha1); err != nil {
+		c.incrSha1(f.Sha1, -1)
+		return
+	}
+	n.MTime = f.MTime
+	f.Key = n.Key
+	if err = c.putFile(bucket, f); err != nil {
+		log.Warningf("table not match: bucket: %s  filename: %s", bucket, f.Filename)
+		c.incrSha1(f.Sha1, -1)
+		n = nil
+	}
+	return
+}
+
+// inSha1 reports whether the needle of the file is in the sha1 table.
+func (c *Client) inSha1(f *meta.File) (ok bool, err error) {
+	var sn *meta.Needle
+	if f.Sha1 == "" {
+		return
+	}
+	if sn, _, err = c.getSha1(f.Sha1); err == nil {
+		ok = sn.Key == f.Key
+	} else if err == errors.ErrNeedleNotExist {
+		err = nil
+	}
+	return
+}
+
+// PutSha1 put the sha1 of a new needle, the later files of the same sha1
+// reference it, the first needle of a sha1 wins.
+func (c *Client) PutSha1(sha1 string, n *meta.Needle) (err error) {
+	var ref int64
+	if ref, err = c.incrSha1(sha1, 1); err != nil {
+		return
+	}
+	if ref > 1 {
+		_, err = c.incrSha1(sha1, -1)
+		return
+	}
+	err = c.putSha1(sha1, n)
+	return
+}
+
+// Del del file from hbase, the needle is deleted with the last file
+// references it, last reports whether the needle is deleted.
+func (c *Client) Del(bucket, filename string) (last bool, err error) {
 	var (
-		f *meta.File
+		ref int64
+		f   *meta.File
+		sn  *meta.Needle
 	)
 	if f, err = c.getFile(bucket, filename); err != nil {
 		return
@@ -91,6 +209,19 @@ func (c *Client) Del(bucket, filename string) (err error) {
 	if err = c.delFile(bucket, filename); err != nil {
 		return
 	}
+	if f.Sha1 != "" {
+		if sn, _, err = c.getSha1(f.Sha1); err == nil && sn.Key == f.Key {
+			if ref, err = c.incrSha1(f.Sha1, -1); err != nil || ref > 0 {
+				return
+			}
+			if err = c.delSha1(f.Sha1); err != nil {
+				return
+			}
+		} else if err != nil && err != errors.ErrNeedleNotExist {
+			return
+		}
+	}
+	last = true
 	err = c.delNeedle(f.Key)
 	return
 }
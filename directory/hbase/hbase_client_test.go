@@ -66,7 +66,7 @@ func TestPut(t *testing.T) {
 
 func TestDelete(t *testing.T) {
 	c := getClient()
-	if err := c.Del("test", "guhaotest.jpg"); err != nil {
+	if _, err := c.Del("test", "guhaotest.jpg"); err != nil {
 		t.Fatalf("err:%v", err.Error())
 	}
 }
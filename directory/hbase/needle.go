@@ -19,8 +19,21 @@ var (
 	_columnVid        = "vid"
 	_columnCookie     = "cookie"
 	_columnUpdateTime = "update_time"
+	// the owner file of the needle, checked by the checker
+	_columnBucket   = "bucket"
+	_columnFilename = "filename"
+
+	// the rows of a needle scan
+	_scanNeedles = 1000
 )
 
+// owner the file the needle row is written for.
+type owner struct {
+	bucket   string
+	filename string
+	sha1     string
+}
+
 func (c *Client) delNeedle(key int64) (err error) {
 	var (
 		mutate *hrpc.Mutate
@@ -35,9 +48,11 @@ func (c *Client) delNeedle(key int64) (err error) {
 	return
 }
 
-func (c *Client) putNeedle(n *meta.Needle) (err error) {
+// putNeedle put the needle of the owner file.
+func (c *Client) putNeedle(bucket string, f *meta.File, n *meta.Needle) (err error) {
 	var (
 		mutate *hrpc.Mutate
+		kbuf   = make([]byte, 8)
 		vbuf   = make([]byte, 4)
 		cbuf   = make([]byte, 4)
 		ubuf   = make([]byte, 8)
@@ -50,14 +65,19 @@ func (c *Client) putNeedle(n *meta.Needle) (err error) {
 		err = errors.ErrNeedleExist
 		return
 	}
+	binary.BigEndian.PutUint64(kbuf, uint64(n.Key))
 	binary.BigEndian.PutUint32(vbuf, uint32(n.Vid))
 	binary.BigEndian.PutUint32(cbuf, uint32(n.Cookie))
 	binary.BigEndian.PutUint64(ubuf, uint64(n.MTime))
 	values := map[string]map[string][]byte{
 		_familyBasic: map[string][]byte{
+			_columnNeedleKey:  kbuf,
 			_columnVid:        vbuf,
 			_columnCookie:     cbuf,
 			_columnUpdateTime: ubuf,
+			_columnBucket:     []byte(bucket),
+			_columnFilename:   []byte(f.Filename),
+			_columnSha1:       []byte(f.Sha1),
 		},
 	}
 	if mutate, err = hrpc.NewPut(context.Background(), _table, c.key(n.Key), values); err != nil {
@@ -92,17 +112,23 @@ func (c *Client) existNeedle(key int64) (exist bool, err error) {
 }
 
 func (c *Client) getNeedle(key int64) (n *meta.Needle, err error) {
+	n, _, err = c.getNeedleOwner(key)
+	return
+}
+
+// getNeedleOwner get the needle and the owner file of it.
+func (c *Client) getNeedleOwner(key int64) (n *meta.Needle, o owner, err error) {
 	var (
 		getter *hrpc.Get
 		result *hrpc.Result
 	)
 	if getter, err = hrpc.NewGet(context.Background(), _table, c.key(key)); err != nil {
-		log.Errorf("Client.getNeedle.NewGet(%v) error:%v", key, err.Error())
+		log.Errorf("Client.getNeedleOwner.NewGet(%v) error:%v", key, err.Error())
 		return
 	}
 	result, err = c.c.Get(getter)
 	if err != nil {
-		log.Errorf("Client.getNeedle.Get(%v) error:%v", key, err.Error())
+		log.Errorf("Client.getNeedleOwner.Get(%v) error:%v", key, err.Error())
 		return
 	}
 	if result == nil || len(result.Cells) == 0 {
@@ -112,23 +138,76 @@ func (c *Client) getNeedle(key int64) (n *meta.Needle, err error) {
 	n = &meta.Needle{
 		Key: key,
 	}
-	for _, cell := range result.Cells {
+	o = parseNeedle(n, result.Cells)
+	return
+}
+
+// parseNeedle parse the needle columns of the row, the key is parsed only if
+// saved, the owner is empty for the rows of old versions.
+func parseNeedle(n *meta.Needle, cells []*hrpc.Cell) (o owner) {
+	for _, cell := range cells {
 		if cell == nil {
 			continue
 		}
 		if bytes.Equal(cell.Family, []byte(_familyBasic)) {
-			if bytes.Equal(cell.Qualifier, []byte(_columnVid)) {
+			if bytes.Equal(cell.Qualifier, []byte(_columnNeedleKey)) {
+				n.Key = int64(binary.BigEndian.Uint64(cell.Value))
+			} else if bytes.Equal(cell.Qualifier, []byte(_columnVid)) {
 				n.Vid = int32(binary.BigEndian.Uint32(cell.Value))
 			} else if bytes.Equal(cell.Qualifier, []byte(_columnCookie)) {
 				n.Cookie = int32(binary.BigEndian.Uint32(cell.Value))
 			} else if bytes.Equal(cell.Qualifier, []byte(_columnUpdateTime)) {
 				n.MTime = int64(binary.BigEndian.Uint64(cell.Value))
+			} else if bytes.Equal(cell.Qualifier, []byte(_columnBucket)) {
+				o.bucket = string(cell.Value)
+			} else if bytes.Equal(cell.Qualifier, []byte(_columnFilename)) {
+				o.filename = string(cell.Value)
+			} else if bytes.Equal(cell.Qualifier, []byte(_columnSha1)) {
+				o.sha1 = string(cell.Value)
 			}
 		}
 	}
 	return
 }
 
+// scanNeedles scan all the needle rows in order of the row key, stop if the
+// function returns false.
+func (c *Client) scanNeedles(fn func(n *meta.Needle, o owner) bool) (err error) {
+	var (
+		scan    *hrpc.Scan
+		results []*hrpc.Result
+		result  *hrpc.Result
+		n       *meta.Needle
+		o       owner
+		start   []byte
+	)
+	for {
+		if scan, err = hrpc.NewScanRange(context.Background(), _table, start, nil, hrpc.Families(map[string][]string{_familyBasic: nil})); err != nil {
+			log.Errorf("Client.scanNeedles.NewScanRange(%x) error:%v", start, err.Error())
+			return
+		}
+		scan.SetLimit(_scanNeedles)
+		if results, err = c.c.Scan(scan); err != nil {
+			log.Errorf("Client.scanNeedles.Scan(%x) error:%v", start, err.Error())
+			return
+		}
+		for _, result = range results {
+			if result == nil || len(result.Cells) == 0 {
+				continue
+			}
+			n = new(meta.Needle)
+			o = parseNeedle(n, result.Cells)
+			if !fn(n, o) {
+				return
+			}
+			start = append(append([]byte(nil), result.Cells[0].Row...), 0)
+		}
+		if len(results) < _scanNeedles {
+			return
+		}
+	}
+}
+
 func (c *Client) key(key int64) []byte {
 	var (
 		sb [sha1.Size]byte
@@ -8,7 +8,7 @@ import (
 
 func TestPutNeedle(t *testing.T) {
 	c := getClient()
-	if err := c.putNeedle(&meta.Needle{
+	if err := c.putNeedle("test", &meta.File{Filename: "test"}, &meta.Needle{
 		Key:    1234567,
 		Cookie: 1111111,
 		Vid:    1,
@@ -0,0 +1,129 @@
+package hbase
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/gohbase/hrpc"
+	"bfs/libs/meta"
+	"bytes"
+	"context"
+	"encoding/binary"
+
+	log "github.com/golang/glog"
+)
+
+var (
+	// the needle of a sha1 and the number of files reference it
+	_tableSha1 = []byte("bfssha1")
+
+	_columnNeedleKey = "key"
+	_columnRef       = "ref"
+)
+
+// getSha1 get the needle of the sha1 and the references, ErrNeedleNotExist
+// if no needle or no reference.
+func (c *Client) getSha1(sha1 string) (n *meta.Needle, ref int64, err error) {
+	var (
+		key    bool
+		getter *hrpc.Get
+		result *hrpc.Result
+	)
+	if getter, err = hrpc.NewGet(context.Background(), _tableSha1, []byte(sha1)); err != nil {
+		log.Errorf("Client.getSha1.NewGet(%v) error:%v", sha1, err.Error())
+		return
+	}
+	if result, err = c.c.Get(getter); err != nil {
+		log.Errorf("Client.getSha1.Get(%v) error:%v", sha1, err.Error())
+		return
+	}
+	if result == nil || len(result.Cells) == 0 {
+		err = errors.ErrNeedleNotExist
+		return
+	}
+	n = &meta.Needle{}
+	for _, cell := range result.Cells {
+		if cell == nil || !bytes.Equal(cell.Family, []byte(_familyBasic)) {
+			continue
+		}
+		if bytes.Equal(cell.Qualifier, []byte(_columnNeedleKey)) {
+			n.Key = int64(binary.BigEndian.Uint64(cell.Value))
+			key = true
+		} else if bytes.Equal(cell.Qualifier, []byte(_columnVid)) {
+			n.Vid = int32(binary.BigEndian.Uint32(cell.Value))
+		} else if bytes.Equal(cell.Qualifier, []byte(_columnCookie)) {
+			n.Cookie = int32(binary.BigEndian.Uint32(cell.Value))
+		} else if bytes.Equal(cell.Qualifier, []byte(_columnRef)) {
+			ref = int64(binary.BigEndian.Uint64(cell.Value))
+		}
+	}
+	// a row only counted, or all the references are gone
+	if !key || ref <= 0 {
+		n = nil
+		err = errors.ErrNeedleNotExist
+	}
+	return
+}
+
+// putSha1 put the needle of the sha1, the references are counted by incrSha1.
+func (c *Client) putSha1(sha1 string, n *meta.Needle) (err error) {
+	var (
+		mutate *hrpc.Mutate
+		kbuf   = make([]byte, 8)
+		vbuf   = make([]byte, 4)
+		cbuf   = make([]byte, 4)
+	)
+	binary.BigEndian.PutUint64(kbuf, uint64(n.Key))
+	binary.BigEndian.PutUint32(vbuf, uint32(n.Vid))
+	binary.BigEndian.PutUint32(cbuf, uint32(n.Cookie))
+	values := map[string]map[string][]byte{
+		_familyBasic: map[string][]byte{
+			_columnNeedleKey: kbuf,
+			_columnVid:       vbuf,
+			_columnCookie:    cbuf,
+		},
+	}
+	if mutate, err = hrpc.NewPut(context.Background(), _tableSha1, []byte(sha1), values); err != nil {
+		log.Errorf("Client.putSha1.NewPut(%v) error:%v", sha1, err.Error())
+		return
+	}
+	if _, err = c.c.Put(mutate); err != nil {
+		log.Errorf("Client.putSha1.Put(%v) error:%v", sha1, err.Error())
+	}
+	return
+}
+
+// incrSha1 add delta to the references of the sha1 atomically, returns the
+// references after it.
+func (c *Client) incrSha1(sha1 string, delta int64) (ref int64, err error) {
+	var (
+		mutate *hrpc.Mutate
+		dbuf   = make([]byte, 8)
+	)
+	binary.BigEndian.PutUint64(dbuf, uint64(delta))
+	values := map[string]map[string][]byte{
+		_familyBasic: map[string][]byte{
+			_columnRef: dbuf,
+		},
+	}
+	if mutate, err = hrpc.NewInc(context.Background(), _tableSha1, []byte(sha1), values); err != nil {
+		log.Errorf("Client.incrSha1.NewInc(%v) error:%v", sha1, err.Error())
+		return
+	}
+	if ref, err = c.c.Increment(mutate); err != nil {
+		log.Errorf("Client.incrSha1.Increment(%v,%d) error:%v", sha1, delta, err.Error())
+	}
+	return
+}
+
+func (c *Client) delSha1(sha1 string) (err error) {
+	var (
+		mutate *hrpc.Mutate
+	)
+	if mutate, err = hrpc.NewDel(context.Background(), _tableSha1, []byte(sha1), nil); err != nil {
+		log.Errorf("Client.delSha1.NewDel(%v) error:%v", sha1, err.Error())
+		return
+	}
+	if _, err = c.c.Delete(mutate); err != nil {
+		log.Errorf("Client.delSha1.Delete(%v) error:%v", sha1, err.Error())
+	}
+	return
+}
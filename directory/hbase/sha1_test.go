@@ -0,0 +1,30 @@
+package hbase
+
+import (
+	"bfs/libs/meta"
+	"testing"
+)
+
+func TestSha1(t *testing.T) {
+	c := getClient()
+	if err := c.PutSha1("guhaotestsha1", &meta.Needle{
+		Key:    1234567,
+		Cookie: 1111111,
+		Vid:    1,
+	}); err != nil {
+		t.Fatalf("err:%v", err.Error())
+	}
+	n, ref, err := c.getSha1("guhaotestsha1")
+	if err != nil {
+		t.Fatalf("err:%v", err.Error())
+	}
+	if n.Key != 1234567 || ref != 1 {
+		t.Fatalf("n:%v ref:%d not match", n, ref)
+	}
+	if ref, err = c.incrSha1("guhaotestsha1", -1); err != nil || ref != 0 {
+		t.Fatalf("ref:%d err:%v", ref, err)
+	}
+	if err = c.delSha1("guhaotestsha1"); err != nil {
+		t.Fatalf("err:%v", err.Error())
+	}
+}
@@ -67,3 +67,103 @@ func HttpDelWriter(r *http.Request, wr http.ResponseWriter, start time.Time, res
 	log.Infof("%s path:%s(params:%s,time:%f,ret:%v)", r.Method,
 		r.URL.Path, r.Form.Encode(), time.Now().Sub(start).Seconds(), ret)
 }
+
+// HttpListWriter
+func HttpListWriter(r *http.Request, wr http.ResponseWriter, start time.Time, res *meta.ListResponse) {
+	var (
+		err      error
+		byteJson []byte
+		ret      = res.Ret
+	)
+	if byteJson, err = json.Marshal(res); err != nil {
+		log.Errorf("json.Marshal(\"%v\") failed (%v)", res, err)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json;charset=utf-8")
+	if _, err = wr.Write(byteJson); err != nil {
+		log.Errorf("HttpWriter Write error(%v)", err)
+		return
+	}
+	log.Infof("%s path:%s(params:%s,time:%f,ret:%v)", r.Method,
+		r.URL.Path, r.Form.Encode(), time.Now().Sub(start).Seconds(), ret)
+}
+
+// HttpBucketWriter
+func HttpBucketWriter(r *http.Request, wr http.ResponseWriter, start time.Time, res *meta.BucketResponse) {
+	var (
+		err      error
+		byteJson []byte
+		ret      = res.Ret
+	)
+	if byteJson, err = json.Marshal(res); err != nil {
+		log.Errorf("json.Marshal(\"%v\") failed (%v)", res, err)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json;charset=utf-8")
+	if _, err = wr.Write(byteJson); err != nil {
+		log.Errorf("HttpWriter Write error(%v)", err)
+		return
+	}
+	log.Infof("%s path:%s(params:%s,time:%f,ret:%v)", r.Method,
+		r.URL.Path, r.Form.Encode(), time.Now().Sub(start).Seconds(), ret)
+}
+
+// HttpCheckWriter
+func HttpCheckWriter(r *http.Request, wr http.ResponseWriter, start time.Time, res *meta.CheckResponse) {
+	var (
+		err      error
+		byteJson []byte
+		ret      = res.Ret
+	)
+	if byteJson, err = json.Marshal(res); err != nil {
+		log.Errorf("json.Marshal(\"%v\") failed (%v)", res, err)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json;charset=utf-8")
+	if _, err = wr.Write(byteJson); err != nil {
+		log.Errorf("HttpWriter Write error(%v)", err)
+		return
+	}
+	log.Infof("%s path:%s(params:%s,time:%f,ret:%v)", r.Method,
+		r.URL.Path, r.Form.Encode(), time.Now().Sub(start).Seconds(), ret)
+}
+
+// HttpGCWriter
+func HttpGCWriter(r *http.Request, wr http.ResponseWriter, start time.Time, res *meta.GCResponse) {
+	var (
+		err      error
+		byteJson []byte
+		ret      = res.Ret
+	)
+	if byteJson, err = json.Marshal(res); err != nil {
+		log.Errorf("json.Marshal(\"%v\") failed (%v)", res, err)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json;charset=utf-8")
+	if _, err = wr.Write(byteJson); err != nil {
+		log.Errorf("HttpWriter Write error(%v)", err)
+		return
+	}
+	log.Infof("%s path:%s(params:%s,time:%f,ret:%v)", r.Method,
+		r.URL.Path, r.Form.Encode(), time.Now().Sub(start).Seconds(), ret)
+}
+
+// HttpUnreferencedWriter
+func HttpUnreferencedWriter(r *http.Request, wr http.ResponseWriter, start time.Time, res *meta.UnreferencedResponse) {
+	var (
+		err      error
+		byteJson []byte
+		ret      = res.Ret
+	)
+	if byteJson, err = json.Marshal(res); err != nil {
+		log.Errorf("json.Marshal(\"%v\") failed (%v)", res, err)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json;charset=utf-8")
+	if _, err = wr.Write(byteJson); err != nil {
+		log.Errorf("HttpWriter Write error(%v)", err)
+		return
+	}
+	log.Infof("%s path:%s(params:%s,time:%f,ret:%v)", r.Method,
+		r.URL.Path, r.Form.Encode(), time.Now().Sub(start).Seconds(), ret)
+}
@@ -8,12 +8,15 @@ import (
 	"time"
 
 	"strconv"
+	"strings"
 
 	log "github.com/golang/glog"
 )
 
 const (
 	_pingOk = 0
+	// the rows updated recently may be written in flight
+	_checkGrace = 10 * time.Minute
 )
 
 type server struct {
@@ -30,7 +33,20 @@ func StartApi(addr string, d *Directory) {
 		)
 		serveMux.HandleFunc("/get", s.get)
 		serveMux.HandleFunc("/upload", s.upload)
+		serveMux.HandleFunc("/sha1", s.sha1)
 		serveMux.HandleFunc("/del", s.del)
+		serveMux.HandleFunc("/list", s.list)
+		serveMux.HandleFunc("/check", s.check)
+		serveMux.HandleFunc("/gc", s.gc)
+		serveMux.HandleFunc("/unreferenced", s.unreferenced)
+		if d.config.Zookeeper.BucketRoot != "" {
+			serveMux.HandleFunc("/bucket/get", s.getBucket)
+			serveMux.HandleFunc("/bucket/list", s.listBuckets)
+			serveMux.HandleFunc("/bucket/add", s.addBucket)
+			serveMux.HandleFunc("/bucket/update", s.updateBucket)
+			serveMux.HandleFunc("/bucket/rotate", s.rotateBucket)
+			serveMux.HandleFunc("/bucket/del", s.delBucket)
+		}
 		serveMux.HandleFunc("/ping", s.ping)
 		if err = http.ListenAndServe(addr, serveMux); err != nil {
 			log.Errorf("http.ListenAndServe(\"%s\") error(%v)", addr, err)
@@ -73,6 +89,12 @@ func (s *server) get(wr http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	// the expired needle is deleted by the stores, the row is left until
+	// overwritten or deleted
+	if f.Expired(time.Now().Unix()) {
+		res.Ret = errors.RetNeedleNotExist
+		return
+	}
 	res.Ret = errors.RetOK
 	res.Key = n.Key
 	res.Cookie = n.Cookie
@@ -97,6 +119,7 @@ func (s *server) upload(wr http.ResponseWriter, r *http.Request) {
 		ok       bool
 		uerr     errors.Error
 		mtimeStr string
+		str      string
 	)
 	if r.Method != "POST" {
 		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
@@ -127,11 +150,28 @@ func (s *server) upload(wr http.ResponseWriter, r *http.Request) {
 		http.Error(wr, "bad request", http.StatusBadRequest)
 		return
 	}
+	// optional, the unix seconds the file expires at
+	if str = r.FormValue("expire"); str != "" {
+		if f.Expire, err = strconv.ParseInt(str, 10, 64); err != nil || f.Expire < 0 {
+			http.Error(wr, "bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	// optional, the size of the file data
+	if str = r.FormValue("size"); str != "" {
+		if f.Size, err = strconv.ParseInt(str, 10, 64); err != nil || f.Size < 0 {
+			http.Error(wr, "bad request", http.StatusBadRequest)
+			return
+		}
+	}
 	defer HttpUploadWriter(r, wr, time.Now(), &res)
 
 	res.Ret = errors.RetOK
 	if n, res.Stores, err = s.d.UploadStores(bucket, f); err != nil {
-		if err == errors.ErrNeedleExist {
+		if err == errors.ErrNeedleDedup {
+			// the needle of the same sha1, no store to write
+			res.Ret = errors.RetNeedleDedup
+		} else if err == errors.ErrNeedleExist {
 			// update file data
 			res.Ret = errors.RetNeedleExist
 			if n, _, res.Stores, err = s.d.GetStores(bucket, f.Filename); err != nil {
@@ -163,6 +203,41 @@ func (s *server) upload(wr http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// sha1 put the sha1 of the file uploaded, called by the proxy after the
+// stores written.
+func (s *server) sha1(wr http.ResponseWriter, r *http.Request) {
+	var (
+		err      error
+		bucket   string
+		filename string
+		res      meta.Response
+		ok       bool
+		uerr     errors.Error
+	)
+	if r.Method != "POST" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if bucket = r.FormValue("bucket"); bucket == "" {
+		http.Error(wr, "bad request", http.StatusBadRequest)
+		return
+	}
+	if filename = r.FormValue("filename"); filename == "" {
+		http.Error(wr, "bad request", http.StatusBadRequest)
+		return
+	}
+	defer HttpUploadWriter(r, wr, time.Now(), &res)
+	res.Ret = errors.RetOK
+	if err = s.d.PutSha1(bucket, filename); err != nil {
+		if uerr, ok = err.(errors.Error); ok {
+			res.Ret = int(uerr)
+		} else {
+			res.Ret = errors.RetInternalErr
+		}
+	}
+	return
+}
+
 func (s *server) del(wr http.ResponseWriter, r *http.Request) {
 	var (
 		err      error
@@ -187,6 +262,11 @@ func (s *server) del(wr http.ResponseWriter, r *http.Request) {
 	}
 	defer HttpDelWriter(r, wr, time.Now(), &res)
 	if n, res.Stores, err = s.d.DelStores(bucket, filename); err != nil {
+		// the needle is still referenced, no store to delete
+		if err == errors.ErrNeedleReferenced {
+			res.Ret = errors.RetNeedleReferenced
+			return
+		}
 		log.Errorf("DelStores() error(%v)", err)
 		if uerr, ok = err.(errors.Error); ok {
 			res.Ret = int(uerr)
@@ -202,6 +282,177 @@ func (s *server) del(wr http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// list list the files of a bucket in order of the filename, the files of
+// the same common prefix before the delimiter are grouped, the page starts
+// after the marker.
+func (s *server) list(wr http.ResponseWriter, r *http.Request) {
+	var (
+		err    error
+		max    int
+		bucket string
+		str    string
+		l      *meta.FileList
+		res    meta.ListResponse
+		ok     bool
+		uerr   errors.Error
+	)
+	if r.Method != "GET" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if bucket = r.FormValue("bucket"); bucket == "" {
+		http.Error(wr, "bad request", http.StatusBadRequest)
+		return
+	}
+	if str = r.FormValue("max-keys"); str != "" {
+		if max, err = strconv.Atoi(str); err != nil || max <= 0 || max > meta.MaxListKeys {
+			http.Error(wr, "bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	defer HttpListWriter(r, wr, time.Now(), &res)
+	if l, err = s.d.List(bucket, r.FormValue("prefix"), r.FormValue("delimiter"), r.FormValue("marker"), max); err != nil {
+		log.Errorf("List() error(%v)", err)
+		if uerr, ok = err.(errors.Error); ok {
+			res.Ret = int(uerr)
+		} else {
+			res.Ret = errors.RetInternalErr
+		}
+		return
+	}
+	res.Ret = errors.RetOK
+	res.FileList = *l
+	return
+}
+
+// check check the consistency of the files and the needles, the orphans
+// are deleted if fix, the rows updated in the grace seconds are skipped.
+func (s *server) check(wr http.ResponseWriter, r *http.Request) {
+	var (
+		err     error
+		fix     bool
+		grace   = _checkGrace
+		buckets []string
+		str     string
+		secs    int64
+		res     meta.CheckResponse
+		ok      bool
+		uerr    errors.Error
+	)
+	if r.Method != "POST" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if str = r.FormValue("fix"); str != "" {
+		if fix, err = strconv.ParseBool(str); err != nil {
+			http.Error(wr, "bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	if str = r.FormValue("grace"); str != "" {
+		if secs, err = strconv.ParseInt(str, 10, 64); err != nil || secs < 0 {
+			http.Error(wr, "bad request", http.StatusBadRequest)
+			return
+		}
+		grace = time.Duration(secs) * time.Second
+	}
+	if str = r.FormValue("buckets"); str != "" {
+		buckets = strings.Split(str, ",")
+	}
+	defer HttpCheckWriter(r, wr, time.Now(), &res)
+	res.Ret = errors.RetOK
+	if res.Report, err = s.d.Check(buckets, fix, grace); err != nil {
+		if uerr, ok = err.(errors.Error); ok {
+			res.Ret = int(uerr)
+		} else {
+			res.Ret = errors.RetInternalErr
+		}
+	}
+	return
+}
+
+func (s *server) gc(wr http.ResponseWriter, r *http.Request) {
+	var (
+		err    error
+		dryRun = true
+		grace  = _gcGrace
+		str    string
+		secs   int64
+		res    meta.GCResponse
+		ok     bool
+		uerr   errors.Error
+	)
+	if r.Method != "POST" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.d.config.GC != nil {
+		grace = s.d.config.GC.Grace.Duration
+	}
+	// report only by default
+	if str = r.FormValue("dry_run"); str != "" {
+		if dryRun, err = strconv.ParseBool(str); err != nil {
+			http.Error(wr, "bad request", http.StatusBadRequest)
+			return
+		}
+	}
+	if str = r.FormValue("grace"); str != "" {
+		if secs, err = strconv.ParseInt(str, 10, 64); err != nil || secs < 0 {
+			http.Error(wr, "bad request", http.StatusBadRequest)
+			return
+		}
+		grace = time.Duration(secs) * time.Second
+	}
+	defer HttpGCWriter(r, wr, time.Now(), &res)
+	res.Ret = errors.RetOK
+	if res.Report, err = s.d.GC(dryRun, grace); err != nil {
+		if uerr, ok = err.(errors.Error); ok {
+			res.Ret = int(uerr)
+		} else {
+			res.Ret = errors.RetInternalErr
+		}
+	}
+	return
+}
+
+func (s *server) unreferenced(wr http.ResponseWriter, r *http.Request) {
+	var (
+		err  error
+		key  int64
+		str  string
+		strs []string
+		keys []int64
+		res  meta.UnreferencedResponse
+		ok   bool
+		uerr errors.Error
+	)
+	if r.Method != "POST" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if strs = strings.Split(r.FormValue("keys"), ","); len(strs) > _gcBatch {
+		http.Error(wr, "bad request", http.StatusBadRequest)
+		return
+	}
+	for _, str = range strs {
+		if key, err = strconv.ParseInt(str, 10, 64); err != nil {
+			http.Error(wr, "bad request", http.StatusBadRequest)
+			return
+		}
+		keys = append(keys, key)
+	}
+	defer HttpUnreferencedWriter(r, wr, time.Now(), &res)
+	res.Ret = errors.RetOK
+	if res.Keys, err = s.d.Unreferenced(keys); err != nil {
+		if uerr, ok = err.(errors.Error); ok {
+			res.Ret = int(uerr)
+		} else {
+			res.Ret = errors.RetInternalErr
+		}
+	}
+	return
+}
+
 func (s *server) ping(wr http.ResponseWriter, r *http.Request) {
 	var (
 		byteJson []byte
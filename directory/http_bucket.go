@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// bucket admin apis, the changes are saved in zookeeper and watched by the
+// directories and proxies.
+
+// parseBucket parse the bucket properties of the form into the bucket, only
+// the present ones are set.
+func parseBucket(r *http.Request, b *meta.Bucket) (err error) {
+	var (
+		ok  bool
+		str string
+	)
+	if _, ok = r.Form["property"]; ok {
+		if b.Property, err = strconv.Atoi(r.FormValue("property")); err != nil || !meta.ValidBucketProperty(b.Property) {
+			return errors.ErrParam
+		}
+	}
+	if _, ok = r.Form["domain"]; ok {
+		b.Domain = r.FormValue("domain")
+	}
+	if _, ok = r.Form["cache_control"]; ok {
+		if str = r.FormValue("cache_control"); str == "" {
+			b.CacheControl = 0
+		} else if b.CacheControl, err = strconv.ParseInt(str, 10, 64); err != nil || b.CacheControl < 0 {
+			return errors.ErrParam
+		}
+	}
+	if _, ok = r.Form["durability"]; ok {
+		if b.Durability = r.FormValue("durability"); !meta.ValidDurability(b.Durability) {
+			return errors.ErrParam
+		}
+	}
+	if _, ok = r.Form["compress"]; ok {
+		if b.Compress = r.FormValue("compress"); !meta.ValidCodec(b.Compress) {
+			return errors.ErrParam
+		}
+	}
+	return
+}
+
+// retBucket set the ret of the bucket api error.
+func retBucket(res *meta.BucketResponse, err error) {
+	var (
+		ok   bool
+		uerr errors.Error
+	)
+	if uerr, ok = err.(errors.Error); ok {
+		res.Ret = int(uerr)
+	} else {
+		res.Ret = errors.RetInternalErr
+	}
+}
+
+// bucketName get the valid bucket name of the form.
+func bucketName(wr http.ResponseWriter, r *http.Request, method string) (name string, ok bool) {
+	if r.Method != method {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if name = r.FormValue("name"); !ValidBucketName(name) {
+		http.Error(wr, "bad request", http.StatusBadRequest)
+		return
+	}
+	return name, true
+}
+
+func (s *server) getBucket(wr http.ResponseWriter, r *http.Request) {
+	var (
+		ok   bool
+		name string
+		err  error
+		res  meta.BucketResponse
+	)
+	if name, ok = bucketName(wr, r, "GET"); !ok {
+		return
+	}
+	defer HttpBucketWriter(r, wr, time.Now(), &res)
+	if res.Bucket, err = s.d.Bucket(name); err != nil {
+		retBucket(&res, err)
+		return
+	}
+	res.Ret = errors.RetOK
+	return
+}
+
+func (s *server) listBuckets(wr http.ResponseWriter, r *http.Request) {
+	var res meta.BucketResponse
+	if r.Method != "GET" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer HttpBucketWriter(r, wr, time.Now(), &res)
+	res.Ret = errors.RetOK
+	res.Buckets = s.d.Buckets()
+	return
+}
+
+func (s *server) addBucket(wr http.ResponseWriter, r *http.Request) {
+	var (
+		ok  bool
+		err error
+		b   = new(meta.Bucket)
+		res meta.BucketResponse
+	)
+	if b.Name, ok = bucketName(wr, r, "POST"); !ok {
+		return
+	}
+	// private write by default
+	b.Property = meta.BucketPrivateWrite
+	if err = parseBucket(r, b); err != nil {
+		http.Error(wr, "bad request", http.StatusBadRequest)
+		return
+	}
+	defer HttpBucketWriter(r, wr, time.Now(), &res)
+	if err = s.d.AddBucket(b); err != nil {
+		log.Errorf("AddBucket(%s) error(%v)", b.Name, err)
+		retBucket(&res, err)
+		return
+	}
+	res.Ret = errors.RetOK
+	res.Bucket = b
+	return
+}
+
+func (s *server) updateBucket(wr http.ResponseWriter, r *http.Request) {
+	var (
+		ok   bool
+		name string
+		err  error
+		nb   = new(meta.Bucket)
+		res  meta.BucketResponse
+	)
+	if name, ok = bucketName(wr, r, "POST"); !ok {
+		return
+	}
+	// validate before the update
+	if err = parseBucket(r, nb); err != nil {
+		http.Error(wr, "bad request", http.StatusBadRequest)
+		return
+	}
+	defer HttpBucketWriter(r, wr, time.Now(), &res)
+	if res.Bucket, err = s.d.UpdateBucket(name, func(b *meta.Bucket) {
+		parseBucket(r, b)
+	}); err != nil {
+		log.Errorf("UpdateBucket(%s) error(%v)", name, err)
+		res.Bucket = nil
+		retBucket(&res, err)
+		return
+	}
+	res.Ret = errors.RetOK
+	return
+}
+
+func (s *server) rotateBucket(wr http.ResponseWriter, r *http.Request) {
+	var (
+		ok   bool
+		name string
+		err  error
+		res  meta.BucketResponse
+	)
+	if name, ok = bucketName(wr, r, "POST"); !ok {
+		return
+	}
+	defer HttpBucketWriter(r, wr, time.Now(), &res)
+	if res.Bucket, err = s.d.RotateBucket(name); err != nil {
+		log.Errorf("RotateBucket(%s) error(%v)", name, err)
+		res.Bucket = nil
+		retBucket(&res, err)
+		return
+	}
+	res.Ret = errors.RetOK
+	return
+}
+
+func (s *server) delBucket(wr http.ResponseWriter, r *http.Request) {
+	var (
+		ok   bool
+		name string
+		err  error
+		res  meta.BucketResponse
+	)
+	if name, ok = bucketName(wr, r, "POST"); !ok {
+		return
+	}
+	defer HttpBucketWriter(r, wr, time.Now(), &res)
+	if err = s.d.DelBucket(name); err != nil {
+		log.Errorf("DelBucket(%s) error(%v)", name, err)
+		retBucket(&res, err)
+		return
+	}
+	res.Ret = errors.RetOK
+	return
+}
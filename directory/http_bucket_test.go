@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bfs/libs/meta"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseBucket(t *testing.T) {
+	var (
+		err error
+		r   *http.Request
+		b   *meta.Bucket
+	)
+	for _, name := range []string{"test", "a.b-c_1"} {
+		if !ValidBucketName(name) {
+			t.Errorf("ValidBucketName(%s) not valid", name)
+		}
+	}
+	for _, name := range []string{"", "a/b", strings.Repeat("a", 64)} {
+		if ValidBucketName(name) {
+			t.Errorf("ValidBucketName(%s) valid", name)
+		}
+	}
+	// only the present params are set
+	b = &meta.Bucket{Property: meta.BucketPrivateWrite, Domain: "http://a.com", Compress: meta.CodecGzip}
+	r, _ = http.NewRequest("POST", "/bucket/update", strings.NewReader(url.Values{"property": {"3"}, "cache_control": {"60"}, "compress": {""}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ParseForm()
+	if err = parseBucket(r, b); err != nil || b.Property != meta.BucketPrivate || b.CacheControl != 60 || b.Domain != "http://a.com" || b.Compress != "" {
+		t.Fatalf("parseBucket() bucket: %+v error(%v)", b, err)
+	}
+	for _, params := range []url.Values{{"property": {"4"}}, {"cache_control": {"-1"}}, {"durability": {"none"}}, {"compress": {"zip"}}} {
+		r, _ = http.NewRequest("POST", "/bucket/update", strings.NewReader(params.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ParseForm()
+		if err = parseBucket(r, new(meta.Bucket)); err == nil {
+			t.Errorf("parseBucket(%v) no error", params)
+		}
+	}
+}
@@ -0,0 +1,562 @@
+package local
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// Store an embedded metadata store of the directory, the files, needles and
+// sha1s are kept in memory and every change is appended to a log file, which
+// is replayed and compacted when open. it's for a single directory, such as
+// the dev or test cluster, the log is not replicated.
+//
+// log record format, one json per line, the newer row replaces the older:
+// {"op":"file","bucket":"b","file":{...}}
+// {"op":"delfile","bucket":"b","file":{"filename":"f"}}
+// {"op":"needle","needle":{...}}
+// {"op":"delneedle","needle":{"key":1}}
+// {"op":"sha1","sha1":"s","needle":{...},"ref":1}
+// {"op":"delsha1","sha1":"s"}
+// the records of a change are written as a batch in one line, which is
+// applied as a whole or dropped as a torn line:
+// {"op":"batch","batch":[{...},{...}]}
+const (
+	_opFile      = "file"
+	_opDelFile   = "delfile"
+	_opNeedle    = "needle"
+	_opDelNeedle = "delneedle"
+	_opSha1      = "sha1"
+	_opDelSha1   = "delsha1"
+	_opBatch     = "batch"
+)
+
+type record struct {
+	Op     string       `json:"op"`
+	Bucket string       `json:"bucket,omitempty"`
+	File   *meta.File   `json:"file,omitempty"`
+	Needle *meta.Needle `json:"needle,omitempty"`
+	Sha1   string       `json:"sha1,omitempty"`
+	Ref    int64        `json:"ref,omitempty"`
+	Batch  []*record    `json:"batch,omitempty"`
+}
+
+// sha1Ref the needle of a sha1 and the files reference it.
+type sha1Ref struct {
+	n   *meta.Needle
+	ref int64
+}
+
+// Store the embedded metadata store.
+type Store struct {
+	file    string
+	f       *os.File
+	w       *bufio.Writer
+	lock    sync.Mutex
+	buckets map[string]map[string]*meta.File
+	needles map[int64]*meta.Needle
+	sha1s   map[string]*sha1Ref
+	// the files and sha1s reference a needle key, kept by apply
+	refs map[int64]int
+}
+
+// New open the store of the log file, create it if not exist.
+func New(file string) (s *Store, err error) {
+	s = &Store{
+		file:    file,
+		buckets: make(map[string]map[string]*meta.File),
+		needles: make(map[int64]*meta.Needle),
+		sha1s:   make(map[string]*sha1Ref),
+		refs:    make(map[int64]int),
+	}
+	if err = s.replay(); err != nil {
+		return nil, err
+	}
+	if err = s.compact(); err != nil {
+		return nil, err
+	}
+	if s.f, err = os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0664); err != nil {
+		log.Errorf("os.OpenFile(\"%s\") error(%v)", file, err)
+		return nil, err
+	}
+	s.w = bufio.NewWriter(s.f)
+	return
+}
+
+// replay load the rows of the log, a torn record, which is the last line
+// without the newline, is dropped, any other bad record fails the replay.
+func (s *Store) replay() (err error) {
+	var (
+		f    *os.File
+		r    *record
+		rd   *bufio.Reader
+		line []byte
+		no   int
+	)
+	if f, err = os.Open(s.file); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		} else {
+			log.Errorf("os.Open(\"%s\") error(%v)", s.file, err)
+		}
+		return
+	}
+	defer f.Close()
+	rd = bufio.NewReader(f)
+	for {
+		if line, err = rd.ReadBytes('\n'); err != nil && err != io.EOF {
+			log.Errorf("local meta: %s read error(%v)", s.file, err)
+			return
+		}
+		if len(line) == 0 {
+			return nil
+		}
+		no++
+		r = new(record)
+		if err = json.Unmarshal(line, r); err != nil {
+			if line[len(line)-1] != '\n' {
+				log.Warningf("local meta: %s torn record line: %d dropped, error(%v)", s.file, no, err)
+				return nil
+			}
+			log.Errorf("local meta: %s bad record line: %d, error(%v)", s.file, no, err)
+			return
+		}
+		s.apply(r)
+	}
+}
+
+// apply apply a record to the rows.
+func (s *Store) apply(r *record) {
+	var (
+		ok    bool
+		f     *meta.File
+		sr    *sha1Ref
+		files map[string]*meta.File
+	)
+	switch r.Op {
+	case _opFile:
+		if files = s.buckets[r.Bucket]; files == nil {
+			files = make(map[string]*meta.File)
+			s.buckets[r.Bucket] = files
+		}
+		if f, ok = files[r.File.Filename]; ok {
+			s.unref(f.Key)
+		}
+		files[r.File.Filename] = r.File
+		s.refs[r.File.Key]++
+	case _opDelFile:
+		if f, ok = s.buckets[r.Bucket][r.File.Filename]; ok {
+			s.unref(f.Key)
+			delete(s.buckets[r.Bucket], r.File.Filename)
+		}
+	case _opNeedle:
+		s.needles[r.Needle.Key] = r.Needle
+	case _opDelNeedle:
+		delete(s.needles, r.Needle.Key)
+	case _opSha1:
+		if sr, ok = s.sha1s[r.Sha1]; ok {
+			s.unref(sr.n.Key)
+		}
+		s.sha1s[r.Sha1] = &sha1Ref{n: r.Needle, ref: r.Ref}
+		s.refs[r.Needle.Key]++
+	case _opDelSha1:
+		if sr, ok = s.sha1s[r.Sha1]; ok {
+			s.unref(sr.n.Key)
+			delete(s.sha1s, r.Sha1)
+		}
+	case _opBatch:
+		for _, r = range r.Batch {
+			s.apply(r)
+		}
+	}
+}
+
+// unref drop a reference of the needle key.
+func (s *Store) unref(key int64) {
+	if s.refs[key]--; s.refs[key] <= 0 {
+		delete(s.refs, key)
+	}
+}
+
+// compact rewrite the log by the rows, then rename it atomically.
+func (s *Store) compact() (err error) {
+	var (
+		f      *os.File
+		w      *bufio.Writer
+		enc    *json.Encoder
+		bucket string
+		sha1   string
+		files  map[string]*meta.File
+		file   *meta.File
+		n      *meta.Needle
+		sr     *sha1Ref
+		tmp    = s.file + ".tmp"
+	)
+	if f, err = os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664); err != nil {
+		log.Errorf("os.OpenFile(\"%s\") error(%v)", tmp, err)
+		return
+	}
+	w = bufio.NewWriter(f)
+	enc = json.NewEncoder(w)
+	for bucket, files = range s.buckets {
+		for _, file = range files {
+			if err == nil {
+				err = enc.Encode(&record{Op: _opFile, Bucket: bucket, File: file})
+			}
+		}
+	}
+	for _, n = range s.needles {
+		if err == nil {
+			err = enc.Encode(&record{Op: _opNeedle, Needle: n})
+		}
+	}
+	for sha1, sr = range s.sha1s {
+		if err == nil {
+			err = enc.Encode(&record{Op: _opSha1, Sha1: sha1, Needle: sr.n, Ref: sr.ref})
+		}
+	}
+	if err == nil {
+		if err = w.Flush(); err == nil {
+			err = f.Sync()
+		}
+	}
+	f.Close()
+	if err != nil {
+		log.Errorf("local meta: %s compact error(%v)", s.file, err)
+		os.Remove(tmp)
+		return
+	}
+	if err = os.Rename(tmp, s.file); err != nil {
+		log.Errorf("os.Rename(\"%s\", \"%s\") error(%v)", tmp, s.file, err)
+	}
+	return
+}
+
+// write append the records to the log atomically and sync, then apply them.
+func (s *Store) write(rs ...*record) (err error) {
+	var r = rs[0]
+	if len(rs) > 1 {
+		r = &record{Op: _opBatch, Batch: rs}
+	}
+	if err = json.NewEncoder(s.w).Encode(r); err != nil {
+		log.Errorf("json.Encode() error(%v)", err)
+		return
+	}
+	if err = s.w.Flush(); err == nil {
+		err = s.f.Sync()
+	}
+	if err != nil {
+		log.Errorf("local meta: %s write error(%v)", s.file, err)
+		return
+	}
+	s.apply(r)
+	return
+}
+
+func (s *Store) getFile(bucket, filename string) (f *meta.File, err error) {
+	var ok bool
+	if f, ok = s.buckets[bucket][filename]; !ok {
+		err = errors.ErrNeedleNotExist
+	}
+	return
+}
+
+// Put put file and needle, an existing file is updated in place, unless its
+// needle is in the sha1 table, which may be shared and is never rewritten,
+// or it's expired, whose needle is dropped by the stores, the reference of
+// it is dropped and the file is put with the new needle.
+func (s *Store) Put(bucket string, f *meta.File, n *meta.Needle) (err error) {
+	var (
+		ok bool
+		of *meta.File
+	)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if of, err = s.getFile(bucket, f.Filename); err == nil {
+		if !s.inSha1(of) && !of.Expired(time.Now().Unix()) {
+			if err = s.updateFile(bucket, f); err == nil {
+				err = errors.ErrNeedleExist
+			}
+			return
+		}
+		if _, err = s.del(bucket, of); err != nil {
+			return
+		}
+	}
+	if _, ok = s.needles[n.Key]; ok {
+		return errors.ErrNeedleExist
+	}
+	err = s.write(&record{Op: _opFile, Bucket: bucket, File: copyFile(f)}, &record{Op: _opNeedle, Needle: copyNeedle(n)})
+	return
+}
+
+// inSha1 reports whether the needle of the file is in the sha1 table.
+func (s *Store) inSha1(f *meta.File) bool {
+	var (
+		ok bool
+		sr *sha1Ref
+	)
+	sr, ok = s.sha1s[f.Sha1]
+	return ok && sr.n.Key == f.Key
+}
+
+// updateFile update the file data, the expire of the new data overwrites the
+// old, zero means never.
+func (s *Store) updateFile(bucket string, f *meta.File) (err error) {
+	var nf = copyFile(s.buckets[bucket][f.Filename])
+	nf.Sha1 = f.Sha1
+	nf.MTime = time.Now().UnixNano()
+	nf.Expire = f.Expire
+	nf.Size = f.Size
+	return s.write(&record{Op: _opFile, Bucket: bucket, File: nf})
+}
+
+// Get get needle and file.
+func (s *Store) Get(bucket, filename string) (n *meta.Needle, f *meta.File, err error) {
+	var ok bool
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if f, err = s.getFile(bucket, filename); err != nil {
+		return
+	}
+	if n, ok = s.needles[f.Key]; !ok {
+		log.Warningf("table not match: bucket: %s  filename: %s", bucket, filename)
+		return nil, nil, errors.ErrNeedleNotExist
+	}
+	return copyNeedle(n), copyFile(f), nil
+}
+
+// List list the files of the bucket into the lister.
+func (s *Store) List(bucket string, l *meta.Lister) (err error) {
+	var (
+		i         int
+		filename  string
+		filenames []string
+		files     map[string]*meta.File
+		start     = l.Start()
+		stop      = l.Stop()
+	)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	files = s.buckets[bucket]
+	for filename = range files {
+		if filename >= start && (stop == "" || filename < stop) {
+			filenames = append(filenames, filename)
+		}
+	}
+	sort.Strings(filenames)
+	for i < len(filenames) {
+		if !l.Add(copyFile(files[filenames[i]])) {
+			break
+		}
+		if start = l.After(filenames[i]); start == "" {
+			break
+		}
+		// skip the files of the common prefix
+		for i++; i < len(filenames) && filenames[i] < start; i++ {
+		}
+	}
+	return
+}
+
+// Del del file, the needle is deleted with the last file references it, last
+// reports whether the needle is deleted.
+func (s *Store) Del(bucket, filename string) (last bool, err error) {
+	var f *meta.File
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if f, err = s.getFile(bucket, filename); err != nil {
+		return
+	}
+	return s.del(bucket, f)
+}
+
+// del del the file and drop the reference of its needle.
+func (s *Store) del(bucket string, f *meta.File) (last bool, err error) {
+	var (
+		sr *sha1Ref
+		rs []*record
+	)
+	rs = append(rs, &record{Op: _opDelFile, Bucket: bucket, File: &meta.File{Filename: f.Filename}})
+	if s.inSha1(f) {
+		if sr = s.sha1s[f.Sha1]; sr.ref > 1 {
+			rs = append(rs, &record{Op: _opSha1, Sha1: f.Sha1, Needle: sr.n, Ref: sr.ref - 1})
+			return false, s.write(rs...)
+		}
+		rs = append(rs, &record{Op: _opDelSha1, Sha1: f.Sha1})
+	}
+	rs = append(rs, &record{Op: _opDelNeedle, Needle: &meta.Needle{Key: f.Key}})
+	if err = s.write(rs...); err == nil {
+		last = true
+	}
+	return
+}
+
+// Ref reference the needle of the same sha1 for a file, the file is put with
+// the key of it. an existing file of the same sha1 keeps its needle, the
+// reference of another one is dropped first, the needle is never rewritten
+// in place, the data of the last reference is left to the gc. a ttl file
+// neither references nor is referenced, the stores drop its needle when
+// expired. ErrNeedleNotExist if no needle of the sha1.
+func (s *Store) Ref(bucket string, f *meta.File) (n *meta.Needle, err error) {
+	var (
+		ok bool
+		of *meta.File
+		sr *sha1Ref
+		nf *meta.File
+	)
+	if f.Expire != 0 {
+		return nil, errors.ErrNeedleNotExist
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if of, err = s.getFile(bucket, f.Filename); err == nil {
+		if of.Sha1 == f.Sha1 && of.Expire == 0 {
+			if n, ok = s.needles[of.Key]; !ok {
+				return nil, errors.ErrNeedleNotExist
+			}
+			n = copyNeedle(n)
+			err = s.updateFile(bucket, f)
+			return
+		}
+		if _, err = s.del(bucket, of); err != nil {
+			return
+		}
+	}
+	if sr, ok = s.sha1s[f.Sha1]; !ok {
+		return nil, errors.ErrNeedleNotExist
+	}
+	nf = copyFile(f)
+	nf.Key = sr.n.Key
+	if err = s.write(&record{Op: _opFile, Bucket: bucket, File: nf}, &record{Op: _opSha1, Sha1: f.Sha1, Needle: sr.n, Ref: sr.ref + 1}); err != nil {
+		return
+	}
+	f.Key = sr.n.Key
+	n = copyNeedle(sr.n)
+	n.MTime = f.MTime
+	return
+}
+
+// PutSha1 put the sha1 of a new needle, the later files of the same sha1
+// reference it, the first needle of a sha1 wins.
+func (s *Store) PutSha1(sha1 string, n *meta.Needle) (err error) {
+	var ok bool
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok = s.sha1s[sha1]; ok {
+		return
+	}
+	return s.write(&record{Op: _opSha1, Sha1: sha1, Needle: copyNeedle(n), Ref: 1})
+}
+
+// Check check the files of the buckets and all the needles, the file
+// without the needle and the needle referenced by no file or sha1 are
+// orphans, the needles of the unknown volumes are reported only.
+func (s *Store) Check(ck *meta.Checker) (err error) {
+	var (
+		ok     bool
+		bucket string
+		f      *meta.File
+		n      *meta.Needle
+		sr     *sha1Ref
+		files  map[string]*meta.File
+		refs   = make(map[int64]bool)
+	)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, bucket = range ck.Buckets {
+		for _, f = range s.buckets[bucket] {
+			ck.Report.Files++
+			if n, ok = s.needles[f.Key]; ok {
+				if ck.Volume != nil && !ck.Volume(n.Vid) {
+					ck.UnknownVolume(n.Key)
+				}
+				continue
+			}
+			if ck.Recent(f.MTime) {
+				continue
+			}
+			ck.OrphanFile(bucket, f.Filename)
+			if !ck.Fix {
+				continue
+			}
+			log.Warningf("check: orphan file bucket: %s filename: %s key: %d deleted", bucket, f.Filename, f.Key)
+			if sr, ok = s.sha1s[f.Sha1]; ok && sr.n.Key == f.Key {
+				err = s.write(&record{Op: _opDelFile, Bucket: bucket, File: &meta.File{Filename: f.Filename}}, &record{Op: _opDelSha1, Sha1: f.Sha1})
+			} else {
+				err = s.write(&record{Op: _opDelFile, Bucket: bucket, File: &meta.File{Filename: f.Filename}})
+			}
+			if err != nil {
+				return
+			}
+			ck.Report.Fixed++
+		}
+	}
+	for _, files = range s.buckets {
+		for _, f = range files {
+			refs[f.Key] = true
+		}
+	}
+	for _, sr = range s.sha1s {
+		refs[sr.n.Key] = true
+	}
+	for _, n = range s.needles {
+		if ck.Report.Needles++; refs[n.Key] || ck.Recent(n.MTime) {
+			continue
+		}
+		ck.OrphanNeedle(n.Key)
+		if ck.Fix {
+			log.Warningf("check: orphan needle key: %d vid: %d deleted", n.Key, n.Vid)
+			if err = s.write(&record{Op: _opDelNeedle, Needle: &meta.Needle{Key: n.Key}}); err != nil {
+				return
+			}
+			ck.Report.Fixed++
+		}
+	}
+	return
+}
+
+// Unreferenced filter the needle keys referenced by no file or sha1, the
+// key without the needle is too.
+func (s *Store) Unreferenced(keys []int64) (orphans []int64, err error) {
+	var (
+		ok  bool
+		key int64
+	)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, key = range keys {
+		if _, ok = s.needles[key]; !ok || s.refs[key] == 0 {
+			orphans = append(orphans, key)
+		}
+	}
+	return
+}
+
+// Close close the store.
+func (s *Store) Close() (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err = s.w.Flush(); err != nil {
+		log.Errorf("local meta: %s flush error(%v)", s.file, err)
+	}
+	return s.f.Close()
+}
+
+func copyFile(f *meta.File) *meta.File {
+	var nf = *f
+	return &nf
+}
+
+func copyNeedle(n *meta.Needle) *meta.Needle {
+	var nn = *n
+	return &nn
+}
@@ -0,0 +1,315 @@
+package local
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	var (
+		err  error
+		ok   bool
+		last bool
+		s    *Store
+		n    *meta.Needle
+		f    *meta.File
+		file = "./test.log"
+	)
+	os.Remove(file)
+	defer os.Remove(file)
+	if s, err = New(file); err != nil {
+		t.Fatalf("New() error(%v)", err)
+	}
+	if err = s.Put("b", &meta.File{Filename: "f1", Key: 1, Sha1: "s1"}, &meta.Needle{Key: 1, Vid: 1, Cookie: 1}); err != nil {
+		t.Fatalf("Put() error(%v)", err)
+	}
+	// the needle not in the sha1 table is updated in place
+	if err = s.Put("b", &meta.File{Filename: "f1", Key: 2, Sha1: "s1"}, &meta.Needle{Key: 2}); err != errors.ErrNeedleExist {
+		t.Fatalf("Put() exist error(%v)", err)
+	}
+	if err = s.PutSha1("s1", &meta.Needle{Key: 1, Vid: 1, Cookie: 1}); err != nil {
+		t.Fatalf("PutSha1() error(%v)", err)
+	}
+	// the file of the same sha1 references the needle
+	f = &meta.File{Filename: "f2", Sha1: "s1"}
+	if n, err = s.Ref("b", f); err != nil || n.Key != 1 || f.Key != 1 {
+		t.Fatalf("Ref() needle: %v error(%v)", n, err)
+	}
+	if n, err = s.Ref("b", &meta.File{Filename: "f3", Sha1: "s2"}); err != errors.ErrNeedleNotExist {
+		t.Fatalf("Ref() not exist error(%v)", err)
+	}
+	// the ttl file never references
+	if n, err = s.Ref("b", &meta.File{Filename: "f4", Sha1: "s1", Expire: 1}); err != errors.ErrNeedleNotExist {
+		t.Fatalf("Ref() ttl error(%v)", err)
+	}
+	// the shared needle is never rewritten, the overwrite drops the reference
+	// and puts a new needle
+	if n, err = s.Ref("b", &meta.File{Filename: "f2", Sha1: "s3"}); err != errors.ErrNeedleNotExist {
+		t.Fatalf("Ref() overwrite error(%v)", err)
+	}
+	if err = s.Put("b", &meta.File{Filename: "f2", Key: 3, Sha1: "s3"}, &meta.Needle{Key: 3, Vid: 1}); err != nil {
+		t.Fatalf("Put() overwrite error(%v)", err)
+	}
+	if err = s.Put("b", &meta.File{Filename: "f1", Key: 4, Sha1: "s4"}, &meta.Needle{Key: 4, Vid: 1}); err != nil {
+		t.Fatalf("Put() overwrite error(%v)", err)
+	}
+	if _, ok = s.sha1s["s1"]; ok {
+		t.Fatal("sha1 of no reference not deleted")
+	}
+	if _, ok = s.needles[1]; ok {
+		t.Fatal("needle of no reference not deleted")
+	}
+	// the expired file is absent, put with the new needle
+	if err = s.Put("b", &meta.File{Filename: "f5", Key: 5, Expire: 1}, &meta.Needle{Key: 5, Vid: 1}); err != nil {
+		t.Fatalf("Put() error(%v)", err)
+	}
+	if err = s.Put("b", &meta.File{Filename: "f5", Key: 6}, &meta.Needle{Key: 6, Vid: 1}); err != nil {
+		t.Fatalf("Put() expired error(%v)", err)
+	}
+	if _, ok = s.needles[5]; ok {
+		t.Fatal("needle of the expired file not deleted")
+	}
+	s.Close()
+	// replay
+	if s, err = New(file); err != nil {
+		t.Fatalf("New() error(%v)", err)
+	}
+	defer s.Close()
+	if n, f, err = s.Get("b", "f2"); err != nil || n.Key != 3 || n.Vid != 1 || f.Key != 3 {
+		t.Fatalf("Get() needle: %v file: %v error(%v)", n, f, err)
+	}
+	if last, err = s.Del("b", "f1"); err != nil || !last {
+		t.Fatalf("Del() last: %t error(%v)", last, err)
+	}
+	if last, err = s.Del("b", "f2"); err != nil || !last {
+		t.Fatalf("Del() last: %t error(%v)", last, err)
+	}
+	if _, _, err = s.Get("b", "f2"); err != errors.ErrNeedleNotExist {
+		t.Fatalf("Get() deleted error(%v)", err)
+	}
+	if _, err = s.Del("b", "f2"); err != errors.ErrNeedleNotExist {
+		t.Fatalf("Del() deleted error(%v)", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var (
+		err  error
+		s    *Store
+		file = "./test_replay.log"
+	)
+	os.Remove(file)
+	defer os.Remove(file)
+	// a bad record not at the end fails the replay, the later ones are kept
+	if err = ioutil.WriteFile(file, []byte(`{"op":"needle","needle":{"key":1}}
+{"op":"needle","ne
+{"op":"needle","needle":{"key":2}}
+`), 0664); err != nil {
+		t.Fatalf("ioutil.WriteFile() error(%v)", err)
+	}
+	if _, err = New(file); err == nil {
+		t.Fatal("New() bad record must fail")
+	}
+	// a torn record at the end is dropped
+	if err = ioutil.WriteFile(file, []byte(`{"op":"needle","needle":{"key":1}}
+{"op":"needle","needle":{"key":2}}
+{"op":"needle","ne`), 0664); err != nil {
+		t.Fatalf("ioutil.WriteFile() error(%v)", err)
+	}
+	if s, err = New(file); err != nil {
+		t.Fatalf("New() error(%v)", err)
+	}
+	defer s.Close()
+	if len(s.needles) != 2 {
+		t.Fatalf("replay needles: %d not match", len(s.needles))
+	}
+}
+
+func TestList(t *testing.T) {
+	var (
+		i     int
+		err   error
+		s     *Store
+		l     *meta.Lister
+		files []string
+		file  = "./test_list.log"
+	)
+	os.Remove(file)
+	defer os.Remove(file)
+	if s, err = New(file); err != nil {
+		t.Fatalf("New() error(%v)", err)
+	}
+	defer s.Close()
+	for i, file = range []string{"a.jpg", "b/1.jpg", "b/2.jpg", "b/c/3.jpg", "c.jpg", "d/4.jpg", "e.jpg"} {
+		if err = s.Put("b", &meta.File{Filename: file, Key: int64(i + 1), Size: int64(i)}, &meta.Needle{Key: int64(i + 1)}); err != nil {
+			t.Fatalf("Put() error(%v)", err)
+		}
+	}
+	// expired
+	if err = s.Put("b", &meta.File{Filename: "b/0.jpg", Key: 100, Expire: 1}, &meta.Needle{Key: 100}); err != nil {
+		t.Fatalf("Put() error(%v)", err)
+	}
+	// the prefixes are grouped, the pages continue after the marker
+	l = meta.NewLister("", "/", "", 2, 2)
+	if err = s.List("b", l); err != nil || len(l.List.Files) != 1 || l.List.Files[0].Filename != "a.jpg" || len(l.List.Prefixes) != 1 || l.List.Prefixes[0] != "b/" || !l.List.Truncated || l.List.Marker != "b/" {
+		t.Fatalf("List() list: %+v files: %v error(%v)", l.List, names(l.List.Files), err)
+	}
+	l = meta.NewLister("", "/", l.List.Marker, 2, 2)
+	if err = s.List("b", l); err != nil || len(l.List.Files) != 1 || l.List.Files[0].Filename != "c.jpg" || l.List.Prefixes[0] != "d/" || !l.List.Truncated {
+		t.Fatalf("List() list: %+v files: %v error(%v)", l.List, names(l.List.Files), err)
+	}
+	l = meta.NewLister("", "/", l.List.Marker, 2, 2)
+	if err = s.List("b", l); err != nil || len(l.List.Files) != 1 || l.List.Files[0].Filename != "e.jpg" || l.List.Truncated || l.List.Marker != "" {
+		t.Fatalf("List() list: %+v files: %v error(%v)", l.List, names(l.List.Files), err)
+	}
+	// prefix, the expired file is skipped
+	l = meta.NewLister("b/", "/", "", 0, 2)
+	if err = s.List("b", l); err != nil || len(l.List.Files) != 2 || l.List.Files[1].Filename != "b/2.jpg" || l.List.Files[1].Size != 2 || len(l.List.Prefixes) != 1 || l.List.Prefixes[0] != "b/c/" {
+		t.Fatalf("List() list: %+v files: %v error(%v)", l.List, names(l.List.Files), err)
+	}
+	// no delimiter
+	l = meta.NewLister("b/", "", "", 0, 2)
+	if err = s.List("b", l); err != nil {
+		t.Fatalf("List() error(%v)", err)
+	}
+	for _, f := range l.List.Files {
+		files = append(files, f.Filename)
+	}
+	if len(files) != 3 || files[2] != "b/c/3.jpg" || len(l.List.Prefixes) != 0 {
+		t.Fatalf("List() files: %v", files)
+	}
+	l = meta.NewLister("", "", "", 0, 2)
+	if err = s.List("none", l); err != nil || len(l.List.Files) != 0 {
+		t.Fatalf("List() list: %+v files: %v error(%v)", l.List, names(l.List.Files), err)
+	}
+}
+
+func names(fs []*meta.File) (ns []string) {
+	for _, f := range fs {
+		ns = append(ns, f.Filename)
+	}
+	return
+}
+
+func TestCheck(t *testing.T) {
+	var (
+		err  error
+		s    *Store
+		f    *os.File
+		ck   *meta.Checker
+		file = "./test_check.log"
+	)
+	os.Remove(file)
+	defer os.Remove(file)
+	if s, err = New(file); err != nil {
+		t.Fatalf("New() error(%v)", err)
+	}
+	if err = s.Put("b", &meta.File{Filename: "f1", Key: 1, Sha1: "s1"}, &meta.Needle{Key: 1, Vid: 1}); err != nil {
+		t.Fatalf("Put() error(%v)", err)
+	}
+	if err = s.Put("b", &meta.File{Filename: "f2", Key: 2}, &meta.Needle{Key: 2, Vid: 2}); err != nil {
+		t.Fatalf("Put() error(%v)", err)
+	}
+	// orphans of the old versions
+	if err = s.write(&record{Op: _opFile, Bucket: "b", File: &meta.File{Filename: "f3", Key: 3, Sha1: "s3"}}, &record{Op: _opSha1, Sha1: "s3", Needle: &meta.Needle{Key: 3}, Ref: 1}); err != nil {
+		t.Fatalf("write() error(%v)", err)
+	}
+	if err = s.write(&record{Op: _opNeedle, Needle: &meta.Needle{Key: 4, Vid: 1}}); err != nil {
+		t.Fatalf("write() error(%v)", err)
+	}
+	s.Close()
+	// a torn batch is dropped as a whole
+	if f, err = os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0664); err != nil {
+		t.Fatalf("os.OpenFile() error(%v)", err)
+	}
+	f.WriteString(`{"op":"batch","batch":[{"op":"file","bucket":"b","file":{"filename":"f5","key":5}},{"op":"needle","ne`)
+	f.Close()
+	if s, err = New(file); err != nil {
+		t.Fatalf("New() error(%v)", err)
+	}
+	defer s.Close()
+	if _, _, err = s.Get("b", "f5"); err != errors.ErrNeedleNotExist {
+		t.Fatalf("Get() torn error(%v)", err)
+	}
+	ck = &meta.Checker{Buckets: []string{"b"}, Since: 1 << 62, Volume: func(vid int32) bool { return vid == 1 }}
+	if err = s.Check(ck); err != nil {
+		t.Fatalf("Check() error(%v)", err)
+	}
+	if ck.Report.Files != 3 || ck.Report.Needles != 3 || ck.Report.OrphanFiles != 1 || ck.Report.OrphanFileSample[0] != "b/f3" || ck.Report.OrphanNeedles != 1 || ck.Report.OrphanNeedleSample[0] != 4 || ck.Report.UnknownVolumes != 1 || ck.Report.UnknownVolumeSample[0] != 2 || ck.Report.Fixed != 0 {
+		t.Fatalf("Check() report: %+v", ck.Report)
+	}
+	// the recent rows are skipped
+	ck = &meta.Checker{Buckets: []string{"b"}, Fix: true}
+	if err = s.Check(ck); err != nil || ck.Report.OrphanFiles != 0 || ck.Report.OrphanNeedles != 0 {
+		t.Fatalf("Check() report: %+v error(%v)", ck.Report, err)
+	}
+	ck = &meta.Checker{Buckets: []string{"b"}, Fix: true, Since: 1 << 62}
+	if err = s.Check(ck); err != nil || ck.Report.Fixed != 2 {
+		t.Fatalf("Check() report: %+v error(%v)", ck.Report, err)
+	}
+	ck = &meta.Checker{Buckets: []string{"b"}, Since: 1 << 62}
+	if err = s.Check(ck); err != nil || ck.Report.Files != 2 || ck.Report.Needles != 2 || ck.Report.OrphanFiles != 0 || ck.Report.OrphanNeedles != 0 {
+		t.Fatalf("Check() report: %+v error(%v)", ck.Report, err)
+	}
+	if _, err = s.Ref("b", &meta.File{Filename: "f6", Sha1: "s3"}); err != errors.ErrNeedleNotExist {
+		t.Fatalf("Ref() orphan sha1 error(%v)", err)
+	}
+}
+
+func TestUnreferenced(t *testing.T) {
+	var (
+		err     error
+		s       *Store
+		orphans []int64
+		file    = "./test_unreferenced.log"
+	)
+	os.Remove(file)
+	defer os.Remove(file)
+	if s, err = New(file); err != nil {
+		t.Fatalf("New() error(%v)", err)
+	}
+	if err = s.Put("b", &meta.File{Filename: "f1", Key: 1, Sha1: "s1"}, &meta.Needle{Key: 1, Vid: 1}); err != nil {
+		t.Fatalf("Put() error(%v)", err)
+	}
+	if err = s.PutSha1("s1", &meta.Needle{Key: 1, Vid: 1}); err != nil {
+		t.Fatalf("PutSha1() error(%v)", err)
+	}
+	if err = s.Put("b", &meta.File{Filename: "f2", Key: 2, Sha1: "s2"}, &meta.Needle{Key: 2, Vid: 1}); err != nil {
+		t.Fatalf("Put() error(%v)", err)
+	}
+	// the needle of the deleted file is kept by the dedup file
+	if _, err = s.Ref("b", &meta.File{Filename: "f3", Sha1: "s1"}); err != nil {
+		t.Fatalf("Ref() error(%v)", err)
+	}
+	if _, err = s.Del("b", "f1"); err != nil {
+		t.Fatalf("Del() error(%v)", err)
+	}
+	if _, err = s.Del("b", "f2"); err != nil {
+		t.Fatalf("Del() error(%v)", err)
+	}
+	// the needle row referenced by no file
+	if err = s.write(&record{Op: _opNeedle, Needle: &meta.Needle{Key: 4, Vid: 1}}); err != nil {
+		t.Fatalf("write() error(%v)", err)
+	}
+	if orphans, err = s.Unreferenced([]int64{1, 2, 4, 9}); err != nil {
+		t.Fatalf("Unreferenced() error(%v)", err)
+	}
+	if len(orphans) != 3 || orphans[0] != 2 || orphans[1] != 4 || orphans[2] != 9 {
+		t.Fatalf("Unreferenced() orphans: %v not match", orphans)
+	}
+	// the references are rebuilt by the replay
+	s.Close()
+	if s, err = New(file); err != nil {
+		t.Fatalf("New() error(%v)", err)
+	}
+	defer s.Close()
+	if len(s.refs) != 1 || s.refs[1] != 2 {
+		t.Fatalf("refs: %v not match", s.refs)
+	}
+	if orphans, err = s.Unreferenced([]int64{1, 2, 4, 9}); err != nil || len(orphans) != 3 {
+		t.Fatalf("Unreferenced() orphans: %v error(%v)", orphans, err)
+	}
+}
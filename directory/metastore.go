@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bfs/directory/conf"
+	"bfs/directory/hbase"
+	"bfs/directory/local"
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+
+	log "github.com/golang/glog"
+)
+
+const (
+	// metadata backends
+	_metaHBase = "hbase"
+	_metaLocal = "local"
+)
+
+// MetaStore the metadata backend of the directory, keeps the files of the
+// buckets and the needles they reference.
+type MetaStore interface {
+	// Get get the file and its needle, ErrNeedleNotExist if no such file.
+	Get(bucket, filename string) (n *meta.Needle, f *meta.File, err error)
+	// List list the files of the bucket into the lister in order of the
+	// filename.
+	List(bucket string, l *meta.Lister) error
+	// Put put the file and its needle, ErrNeedleExist if the file exists,
+	// the data of it is updated then.
+	Put(bucket string, f *meta.File, n *meta.Needle) error
+	// Del del the file, the needle is deleted with the last file references
+	// it, last reports whether the needle is deleted.
+	Del(bucket, filename string) (last bool, err error)
+	// Ref reference the needle of the same sha1 for the file,
+	// ErrNeedleNotExist if no needle of the sha1.
+	Ref(bucket string, f *meta.File) (n *meta.Needle, err error)
+	// PutSha1 put the sha1 of a new needle.
+	PutSha1(sha1 string, n *meta.Needle) error
+	// Check check the consistency of the files and the needles, the orphans
+	// are deleted if fix.
+	Check(ck *meta.Checker) error
+	// Unreferenced filter the needle keys referenced by no file, the needle
+	// rows of old versions without the owner are taken as referenced.
+	Unreferenced(keys []int64) (orphans []int64, err error)
+}
+
+// newMetaStore new the metadata backend of the config, hbase by default.
+func newMetaStore(c *conf.Config) (ms MetaStore, err error) {
+	switch c.MetaStore {
+	case "", _metaHBase:
+		ms = hbase.NewClient(c.HBase)
+	case _metaLocal:
+		if c.Local == nil || c.Local.File == "" {
+			log.Error("local metastore needs the [local] file")
+			err = errors.ErrParam
+			return
+		}
+		if ms, err = local.New(c.Local.File); err != nil {
+			log.Errorf("local.New(\"%s\") error(%v)", c.Local.File, err)
+		}
+	default:
+		log.Errorf("unknown metastore: %s", c.MetaStore)
+		err = errors.ErrParam
+	}
+	return
+}
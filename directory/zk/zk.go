@@ -5,6 +5,8 @@ import (
 	log "github.com/golang/glog"
 	"github.com/samuel/go-zookeeper/zk"
 	"path"
+	"strconv"
+	"time"
 )
 
 type Zookeeper struct {
@@ -100,6 +102,29 @@ func (z *Zookeeper) VolumeStores(volume string) (nodes []string, err error) {
 	return
 }
 
+// ECVolumes get all erasure coded volumes, no ec root means none.
+func (z *Zookeeper) ECVolumes() (nodes []string, err error) {
+	if z.config.Zookeeper.ECRoot == "" {
+		return
+	}
+	if nodes, _, err = z.c.Children(z.config.Zookeeper.ECRoot); err != nil {
+		if err == zk.ErrNoNode {
+			return nil, nil
+		}
+		log.Errorf("zk.Children(\"%s\") error(%v)", z.config.Zookeeper.ECRoot, err)
+	}
+	return
+}
+
+// ECVolume get erasure coded volume node data
+func (z *Zookeeper) ECVolume(volume string) (data []byte, err error) {
+	var spath = path.Join(z.config.Zookeeper.ECRoot, volume)
+	if data, _, err = z.c.Get(spath); err != nil {
+		log.Errorf("zk.Get(\"%s\") error(%v)", spath, err)
+	}
+	return
+}
+
 // Groups get all groups and watch
 func (z *Zookeeper) Groups() (nodes []string, err error) {
 	if nodes, _, err = z.c.Children(z.config.Zookeeper.GroupRoot); err != nil {
@@ -117,6 +142,86 @@ func (z *Zookeeper) GroupStores(group string) (nodes []string, err error) {
 	return
 }
 
+// WatchBuckets get all buckets and watch the bucket root, the data of it is
+// touched by every bucket change. the root is created if not exist.
+func (z *Zookeeper) WatchBuckets() (nodes []string, ev <-chan zk.Event, err error) {
+	var root = z.config.Zookeeper.BucketRoot
+	if _, err = z.c.Create(root, []byte(""), 0, zk.WorldACL(zk.PermAll)); err != nil && err != zk.ErrNodeExists {
+		log.Errorf("zk.Create(\"%s\") error(%v)", root, err)
+		return
+	}
+	if _, _, ev, err = z.c.GetW(root); err != nil {
+		log.Errorf("zk.GetW(\"%s\") error(%v)", root, err)
+		return
+	}
+	if nodes, _, err = z.c.Children(root); err != nil {
+		log.Errorf("zk.Children(\"%s\") error(%v)", root, err)
+	}
+	return
+}
+
+// Bucket get bucket node data and the version.
+func (z *Zookeeper) Bucket(name string) (data []byte, version int32, err error) {
+	var (
+		stat  *zk.Stat
+		spath = path.Join(z.config.Zookeeper.BucketRoot, name)
+	)
+	if data, stat, err = z.c.Get(spath); err != nil {
+		if err != zk.ErrNoNode {
+			log.Errorf("zk.Get(\"%s\") error(%v)", spath, err)
+		}
+		return
+	}
+	version = stat.Version
+	return
+}
+
+// AddBucket create the bucket node, zk.ErrNodeExists if exists.
+func (z *Zookeeper) AddBucket(name string, data []byte) (err error) {
+	var spath = path.Join(z.config.Zookeeper.BucketRoot, name)
+	if _, err = z.c.Create(spath, data, 0, zk.WorldACL(zk.PermAll)); err != nil {
+		if err != zk.ErrNodeExists {
+			log.Errorf("zk.Create(\"%s\") error(%v)", spath, err)
+		}
+		return
+	}
+	return z.touchBuckets()
+}
+
+// SetBucket set the bucket node data of the version, zk.ErrBadVersion if
+// changed by others.
+func (z *Zookeeper) SetBucket(name string, data []byte, version int32) (err error) {
+	var spath = path.Join(z.config.Zookeeper.BucketRoot, name)
+	if _, err = z.c.Set(spath, data, version); err != nil {
+		if err != zk.ErrBadVersion && err != zk.ErrNoNode {
+			log.Errorf("zk.Set(\"%s\") error(%v)", spath, err)
+		}
+		return
+	}
+	return z.touchBuckets()
+}
+
+// DelBucket delete the bucket node.
+func (z *Zookeeper) DelBucket(name string) (err error) {
+	var spath = path.Join(z.config.Zookeeper.BucketRoot, name)
+	if err = z.c.Delete(spath, -1); err != nil {
+		if err != zk.ErrNoNode {
+			log.Errorf("zk.Delete(\"%s\") error(%v)", spath, err)
+		}
+		return
+	}
+	return z.touchBuckets()
+}
+
+// touchBuckets set the bucket root data, the watchers of it reload buckets.
+func (z *Zookeeper) touchBuckets() (err error) {
+	var root = z.config.Zookeeper.BucketRoot
+	if _, err = z.c.Set(root, []byte(strconv.FormatInt(time.Now().UnixNano(), 10)), -1); err != nil {
+		log.Errorf("zk.Set(\"%s\") error(%v)", root, err)
+	}
+	return
+}
+
 // Close close the zookeeper connection.
 func (z *Zookeeper) Close() {
 	z.c.Close()
@@ -0,0 +1,143 @@
+// Package ec implements a systematic reed-solomon erasure code in GF(2^8),
+// k data shards are coded into m parity shards, any k of the k+m shards can
+// reconstruct the others.
+package ec
+
+import "bfs/libs/errors"
+
+// Coder is a reed-solomon coder.
+type Coder struct {
+	K      int
+	M      int
+	matrix matrix // (k+m)*k, the top k rows is identity
+}
+
+// New new a coder of k data shards and m parity shards.
+func New(k, m int) (c *Coder, err error) {
+	var (
+		i   int
+		top matrix
+		v   matrix
+		ids = make([]int, k)
+	)
+	if k <= 0 || m <= 0 || k+m > _fieldSize {
+		err = errors.ErrECParam
+		return
+	}
+	for i = 0; i < k; i++ {
+		ids[i] = i
+	}
+	v = vandermonde(k+m, k)
+	if top, err = v.rows(ids).invert(); err != nil {
+		return
+	}
+	c = &Coder{K: k, M: m, matrix: v.mul(top)}
+	return
+}
+
+// size check the shards number and get the size of shards, nil shards are
+// skipped.
+func (c *Coder) size(shards [][]byte) (size int, err error) {
+	var shard []byte
+	if len(shards) != c.K+c.M {
+		err = errors.ErrECParam
+		return
+	}
+	size = -1
+	for _, shard = range shards {
+		if shard == nil {
+			continue
+		}
+		if size == -1 {
+			size = len(shard)
+		} else if size != len(shard) {
+			err = errors.ErrECShardSize
+			return
+		}
+	}
+	return
+}
+
+// Encode code the parity shards from the data shards, a nil parity shard is
+// allocated.
+func (c *Coder) Encode(shards [][]byte) (err error) {
+	var (
+		i, j, size int
+		parity     []byte
+	)
+	if size, err = c.size(shards); err != nil {
+		return
+	}
+	for i = 0; i < c.K; i++ {
+		if shards[i] == nil {
+			return errors.ErrECShardLack
+		}
+	}
+	for i = c.K; i < c.K+c.M; i++ {
+		if parity = shards[i]; parity == nil {
+			parity = make([]byte, size)
+			shards[i] = parity
+		} else {
+			for j = range parity {
+				parity[j] = 0
+			}
+		}
+		for j = 0; j < c.K; j++ {
+			gfMulAdd(c.matrix[i][j], shards[j], parity)
+		}
+	}
+	return
+}
+
+// Reconstruct rebuild the nil shards, at least k shards must be present.
+func (c *Coder) Reconstruct(shards [][]byte) (err error) {
+	var (
+		i, j, size int
+		inv        matrix
+		ids        = make([]int, 0, c.K)
+		lost       bool
+	)
+	if size, err = c.size(shards); err != nil {
+		return
+	}
+	for i = 0; i < c.K+c.M && len(ids) < c.K; i++ {
+		if shards[i] != nil {
+			ids = append(ids, i)
+		}
+	}
+	if len(ids) < c.K {
+		return errors.ErrECShardLack
+	}
+	// rebuild data shards: data = inv(rows of present) * present
+	for i = 0; i < c.K; i++ {
+		if shards[i] == nil {
+			lost = true
+			break
+		}
+	}
+	if lost {
+		if inv, err = c.matrix.rows(ids).invert(); err != nil {
+			return
+		}
+		for i = 0; i < c.K; i++ {
+			if shards[i] != nil {
+				continue
+			}
+			shards[i] = make([]byte, size)
+			for j = 0; j < c.K; j++ {
+				gfMulAdd(inv[i][j], shards[ids[j]], shards[i])
+			}
+		}
+	}
+	// rebuild parity shards from data
+	for i = c.K; i < c.K+c.M; i++ {
+		if shards[i] != nil {
+			continue
+		}
+		shards[i] = make([]byte, size)
+		for j = 0; j < c.K; j++ {
+			gfMulAdd(c.matrix[i][j], shards[j], shards[i])
+		}
+	}
+	return
+}
@@ -0,0 +1,65 @@
+package ec
+
+import (
+	"bfs/libs/errors"
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestCoder(t *testing.T) {
+	var (
+		i, j   int
+		err    error
+		c      *Coder
+		shards [][]byte
+		origin [][]byte
+		lost   = [][]int{{0}, {5}, {0, 1}, {2, 4}, {4, 5}, {1, 3}}
+	)
+	if _, err = New(0, 2); err != errors.ErrECParam {
+		t.Errorf("New(0, 2) error(%v) not match", err)
+		t.FailNow()
+	}
+	if c, err = New(4, 2); err != nil {
+		t.Errorf("New(4, 2) error(%v)", err)
+		t.FailNow()
+	}
+	origin = make([][]byte, 6)
+	for i = 0; i < 4; i++ {
+		origin[i] = make([]byte, 1024)
+		rand.Read(origin[i])
+	}
+	if err = c.Encode(origin); err != nil {
+		t.Errorf("Encode() error(%v)", err)
+		t.FailNow()
+	}
+	for _, ids := range lost {
+		shards = make([][]byte, 6)
+		for i = 0; i < 6; i++ {
+			shards[i] = append([]byte(nil), origin[i]...)
+		}
+		for _, j = range ids {
+			shards[j] = nil
+		}
+		if err = c.Reconstruct(shards); err != nil {
+			t.Errorf("Reconstruct() lost: %v error(%v)", ids, err)
+			t.FailNow()
+		}
+		for i = 0; i < 6; i++ {
+			if !bytes.Equal(shards[i], origin[i]) {
+				t.Errorf("Reconstruct() lost: %v shard: %d not match", ids, i)
+				t.FailNow()
+			}
+		}
+	}
+	shards = [][]byte{origin[0], nil, nil, nil, origin[4], origin[5]}
+	if err = c.Reconstruct(shards); err != errors.ErrECShardLack {
+		t.Errorf("Reconstruct() error(%v) not match", err)
+		t.FailNow()
+	}
+	shards = [][]byte{origin[0], origin[1][:10], nil, nil, origin[4], origin[5]}
+	if err = c.Reconstruct(shards); err != errors.ErrECShardSize {
+		t.Errorf("Reconstruct() error(%v) not match", err)
+		t.FailNow()
+	}
+}
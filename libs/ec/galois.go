@@ -0,0 +1,69 @@
+package ec
+
+const (
+	_fieldSize = 256
+	// x^8 + x^4 + x^3 + x^2 + 1
+	_polynomial = 0x11d
+)
+
+var (
+	_exp [2 * _fieldSize]byte
+	_log [_fieldSize]int
+	_mul [_fieldSize][_fieldSize]byte
+)
+
+func init() {
+	var i, j, x int
+	x = 1
+	for i = 0; i < _fieldSize-1; i++ {
+		_exp[i] = byte(x)
+		_log[x] = i
+		if x <<= 1; x&_fieldSize != 0 {
+			x ^= _polynomial
+		}
+	}
+	for i = _fieldSize - 1; i < len(_exp); i++ {
+		_exp[i] = _exp[i-(_fieldSize-1)]
+	}
+	for i = 1; i < _fieldSize; i++ {
+		for j = 1; j < _fieldSize; j++ {
+			_mul[i][j] = _exp[_log[i]+_log[j]]
+		}
+	}
+}
+
+// gfMul multiply a and b in GF(2^8).
+func gfMul(a, b byte) byte {
+	return _mul[a][b]
+}
+
+// gfInv get the multiplicative inverse of a, a must not be zero.
+func gfInv(a byte) byte {
+	return _exp[_fieldSize-1-_log[a]]
+}
+
+// gfExp get a to the power n.
+func gfExp(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return _exp[(_log[a]*n)%(_fieldSize-1)]
+}
+
+// gfMulAdd out ^= c * in.
+func gfMulAdd(c byte, in, out []byte) {
+	var (
+		i int
+		b byte
+		t = &_mul[c]
+	)
+	if c == 0 {
+		return
+	}
+	for i, b = range in {
+		out[i] ^= t[b]
+	}
+}
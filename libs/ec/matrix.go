@@ -0,0 +1,90 @@
+package ec
+
+import "bfs/libs/errors"
+
+// matrix is a matrix in GF(2^8).
+type matrix [][]byte
+
+func newMatrix(rows, cols int) (m matrix) {
+	var i int
+	m = make(matrix, rows)
+	for i = 0; i < rows; i++ {
+		m[i] = make([]byte, cols)
+	}
+	return
+}
+
+// vandermonde m[r][c] = r^c, any cols rows of it are independent.
+func vandermonde(rows, cols int) (m matrix) {
+	var r, c int
+	m = newMatrix(rows, cols)
+	for r = 0; r < rows; r++ {
+		for c = 0; c < cols; c++ {
+			m[r][c] = gfExp(byte(r), c)
+		}
+	}
+	return
+}
+
+// mul get m * o.
+func (m matrix) mul(o matrix) (p matrix) {
+	var r, c, i int
+	p = newMatrix(len(m), len(o[0]))
+	for r = 0; r < len(m); r++ {
+		for c = 0; c < len(o[0]); c++ {
+			for i = 0; i < len(o); i++ {
+				p[r][c] ^= gfMul(m[r][i], o[i][c])
+			}
+		}
+	}
+	return
+}
+
+// rows get a matrix of the specified rows.
+func (m matrix) rows(rows []int) (p matrix) {
+	var i, r int
+	p = make(matrix, len(rows))
+	for i, r = range rows {
+		p[i] = append([]byte(nil), m[r]...)
+	}
+	return
+}
+
+// invert get the inverse of a square matrix by gauss-jordan elimination.
+func (m matrix) invert() (p matrix, err error) {
+	var (
+		r, c, i, n int
+		b          byte
+		a          = newMatrix(len(m), 2*len(m))
+	)
+	n = len(m)
+	for r = 0; r < n; r++ {
+		copy(a[r], m[r])
+		a[r][n+r] = 1
+	}
+	for c = 0; c < n; c++ {
+		// find a pivot row
+		for r = c; r < n && a[r][c] == 0; r++ {
+		}
+		if r == n {
+			err = errors.ErrECParam
+			return
+		}
+		a[r], a[c] = a[c], a[r]
+		if b = gfInv(a[c][c]); b != 1 {
+			for i = 0; i < 2*n; i++ {
+				a[c][i] = gfMul(a[c][i], b)
+			}
+		}
+		for r = 0; r < n; r++ {
+			if r != c && a[r][c] != 0 {
+				gfMulAdd(a[r][c], a[c], a[r])
+			}
+		}
+	}
+	p = make(matrix, n)
+	for r = 0; r < n; r++ {
+		p[r] = a[r][n:]
+	}
+	return
+}
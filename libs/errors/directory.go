@@ -9,6 +9,12 @@ const (
 	RetStoreNotAvailable = 30300
 	// zookeeper
 	RetZookeeperDataError = 30400
+	// dedup
+	RetNeedleDedup      = 30500
+	RetNeedleReferenced = 30501
+	// bucket
+	RetBucketExist    = 30600
+	RetBucketNotEmpty = 30601
 )
 
 var (
@@ -20,4 +26,10 @@ var (
 	ErrStoreNotAvailable = Error(RetStoreNotAvailable)
 	// zookeeper
 	ErrZookeeperDataError = Error(RetZookeeperDataError)
+	// dedup
+	ErrNeedleDedup      = Error(RetNeedleDedup)
+	ErrNeedleReferenced = Error(RetNeedleReferenced)
+	// bucket
+	ErrBucketExist    = Error(RetBucketExist)
+	ErrBucketNotEmpty = Error(RetBucketNotEmpty)
 )
@@ -14,7 +14,8 @@ var (
 		RetParamErr:    "store param error",
 		RetInternalErr: "internal server error",
 		// api
-		RetUploadMaxFile: "exceed upload max file num",
+		RetUploadMaxFile:       "exceed upload max file num",
+		RetRangeNotSatisfiable: "range not satisfiable",
 		// block
 		RetSuperBlockMagic:      "super block magic not match",
 		RetSuperBlockVer:        "super block ver not match",
@@ -23,6 +24,7 @@ var (
 		RetSuperBlockRepairSize: "super block repair size must equal original",
 		RetSuperBlockClosed:     "super block closed",
 		RetSuperBlockOffset:     "super block offset not consistency with size",
+		RetSuperBlockReadEngine: "super block read engine not supported",
 		// index
 		RetIndexSize:   "index size error",
 		RetIndexClosed: "index closed",
@@ -46,6 +48,10 @@ var (
 		RetNeedleFooterSize:  "needle footer size",
 		RetNeedlePaddingSize: "needle padding size",
 		RetNeedleFull:        "needle full",
+		RetNeedleChunkSize:   "needle stream chunk size",
+		RetNeedleExpired:     "needle expired",
+		RetNeedleMeta:        "needle meta not valid",
+		RetNeedleCodec:       "needle codec not supported",
 		// ring
 		RetRingEmpty: "index ring buffer empty",
 		RetRingFull:  "index ring buffer full",
@@ -60,6 +66,32 @@ var (
 		RetVolumeInCompact: "volume in compacting",
 		RetVolumeClosed:    "volume closed",
 		RetVolumeBatch:     "volume exceed batch write number",
+		RetVolumeChanged:   "volume file changed",
+		RetVolumeRecover:   "volume in recovering",
+		RetVolumeNotFull:   "volume not full",
+		RetVolumeOffline:   "volume offline, the disk failed",
+		RetVolumeReadOnly:  "volume read only, the disk is slow",
+		// erasure code
+		RetECParam:         "erasure code param error",
+		RetECShardLack:     "erasure code shards not enough",
+		RetECShardSize:     "erasure code shards size not match",
+		RetECShardNotExist: "erasure code shard not exist",
+		// archive
+		RetArchiveMagic: "archive magic not match",
+		RetArchiveVer:   "archive ver not match",
+		RetArchiveCount: "archive needle count not match",
+		RetArchiveVid:   "archive vid not match",
+		// snapshot
+		RetSnapshotMagic:    "snapshot magic not match",
+		RetSnapshotVer:      "snapshot ver not match",
+		RetSnapshotChecksum: "snapshot checksum not match",
+		RetSnapshotRange:    "snapshot range not match",
+		RetSnapshotRebase:   "snapshot invalid after compaction, need a new base",
+		// checkpoint
+		RetCheckpointMagic:    "checkpoint magic not match",
+		RetCheckpointVer:      "checkpoint ver not match",
+		RetCheckpointChecksum: "checkpoint checksum not match",
+		RetCheckpointStale:    "checkpoint not match the block or index",
 		/* ========================= Store ========================= */
 		/* ========================= Directory ========================= */
 		// hbase
@@ -70,6 +102,12 @@ var (
 		RetStoreNotAvailable: "store not available",
 		// zookeeper
 		RetZookeeperDataError: "zookeeper data error",
+		// dedup
+		RetNeedleDedup:      "needle of the same sha1 referenced",
+		RetNeedleReferenced: "needle still referenced by other files",
+		// bucket
+		RetBucketExist:    "bucket already exists",
+		RetBucketNotEmpty: "bucket not empty",
 		/* ========================= Directory ========================= */
 		/* ========================= Proxy ========================= */
 		// common
@@ -78,6 +116,8 @@ var (
 		RetUrlBad:         "bad url",
 		// upload
 		RetFileTooLarge: "file too large",
+		// replica
+		RetReplicaWrite: "replica write not satisfy the write policy",
 		/* ========================= Proxy ========================= */
 	}
 )
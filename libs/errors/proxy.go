@@ -7,6 +7,8 @@ const (
 	RetBucketNotExist = 404
 	// upload
 	RetFileTooLarge = 413
+	// replica
+	RetReplicaWrite = 502
 )
 
 var (
@@ -16,4 +18,6 @@ var (
 	ErrBucketNotExist = Error(RetBucketNotExist)
 	// upload
 	ErrFileTooLarge = Error(RetFileTooLarge)
+	// replica
+	ErrReplicaWrite = Error(RetReplicaWrite)
 )
@@ -2,8 +2,9 @@ package errors
 
 const (
 	// api
-	RetUploadMaxFile = 2000
-	RetDelMaxFile    = 2001
+	RetUploadMaxFile       = 2000
+	RetDelMaxFile          = 2001
+	RetRangeNotSatisfiable = 2002
 	// block
 	RetSuperBlockMagic      = 3000
 	RetSuperBlockVer        = 3001
@@ -12,6 +13,7 @@ const (
 	RetSuperBlockRepairSize = 3004
 	RetSuperBlockClosed     = 3005
 	RetSuperBlockOffset     = 3006
+	RetSuperBlockReadEngine = 3007
 	// index
 	RetIndexSize   = 4000
 	RetIndexClosed = 4001
@@ -34,6 +36,10 @@ const (
 	RetNeedleFooterSize  = 5014
 	RetNeedlePaddingSize = 5015
 	RetNeedleFull        = 5016
+	RetNeedleChunkSize   = 5017
+	RetNeedleExpired     = 5018
+	RetNeedleMeta        = 5019
+	RetNeedleCodec       = 5020
 	// ring
 	RetRingEmpty = 6000
 	RetRingFull  = 6001
@@ -48,11 +54,38 @@ const (
 	RetVolumeInCompact = 8003
 	RetVolumeClosed    = 8004
 	RetVolumeBatch     = 8005
+	RetVolumeChanged   = 8006
+	RetVolumeRecover   = 8007
+	RetVolumeNotFull   = 8008
+	RetVolumeOffline   = 8009
+	RetVolumeReadOnly  = 8010
+	// erasure code
+	RetECParam         = 9000
+	RetECShardLack     = 9001
+	RetECShardSize     = 9002
+	RetECShardNotExist = 9003
+	// archive
+	RetArchiveMagic = 10000
+	RetArchiveVer   = 10001
+	RetArchiveCount = 10002
+	RetArchiveVid   = 10003
+	// snapshot
+	RetSnapshotMagic    = 11000
+	RetSnapshotVer      = 11001
+	RetSnapshotChecksum = 11002
+	RetSnapshotRange    = 11003
+	RetSnapshotRebase   = 11004
+	// checkpoint
+	RetCheckpointMagic    = 12000
+	RetCheckpointVer      = 12001
+	RetCheckpointChecksum = 12002
+	RetCheckpointStale    = 12003
 )
 
 var (
-	ErrUploadMaxFile = Error(RetUploadMaxFile)
-	ErrDelMaxFile    = Error(RetDelMaxFile)
+	ErrUploadMaxFile       = Error(RetUploadMaxFile)
+	ErrDelMaxFile          = Error(RetDelMaxFile)
+	ErrRangeNotSatisfiable = Error(RetRangeNotSatisfiable)
 	// block
 	ErrSuperBlockMagic      = Error(RetSuperBlockMagic)
 	ErrSuperBlockVer        = Error(RetSuperBlockVer)
@@ -61,6 +94,7 @@ var (
 	ErrSuperBlockRepairSize = Error(RetSuperBlockRepairSize)
 	ErrSuperBlockClosed     = Error(RetSuperBlockClosed)
 	ErrSuperBlockOffset     = Error(RetSuperBlockOffset)
+	ErrSuperBlockReadEngine = Error(RetSuperBlockReadEngine)
 	// index
 	ErrIndexSize   = Error(RetIndexSize)
 	ErrIndexClosed = Error(RetIndexClosed)
@@ -83,6 +117,10 @@ var (
 	ErrNeedleFooterSize  = Error(RetNeedleFooterSize)
 	ErrNeedlePaddingSize = Error(RetNeedlePaddingSize)
 	ErrNeedleFull        = Error(RetNeedleFull)
+	ErrNeedleChunkSize   = Error(RetNeedleChunkSize)
+	ErrNeedleExpired     = Error(RetNeedleExpired)
+	ErrNeedleMeta        = Error(RetNeedleMeta)
+	ErrNeedleCodec       = Error(RetNeedleCodec)
 	// ring
 	ErrRingEmpty = Error(RetRingEmpty)
 	ErrRingFull  = Error(RetRingFull)
@@ -97,4 +135,30 @@ var (
 	ErrVolumeInCompact = Error(RetVolumeInCompact)
 	ErrVolumeClosed    = Error(RetVolumeClosed)
 	ErrVolumeBatch     = Error(RetVolumeBatch)
+	ErrVolumeChanged   = Error(RetVolumeChanged)
+	ErrVolumeRecover   = Error(RetVolumeRecover)
+	ErrVolumeNotFull   = Error(RetVolumeNotFull)
+	ErrVolumeOffline   = Error(RetVolumeOffline)
+	ErrVolumeReadOnly  = Error(RetVolumeReadOnly)
+	// erasure code
+	ErrECParam         = Error(RetECParam)
+	ErrECShardLack     = Error(RetECShardLack)
+	ErrECShardSize     = Error(RetECShardSize)
+	ErrECShardNotExist = Error(RetECShardNotExist)
+	// archive
+	ErrArchiveMagic = Error(RetArchiveMagic)
+	ErrArchiveVer   = Error(RetArchiveVer)
+	ErrArchiveCount = Error(RetArchiveCount)
+	ErrArchiveVid   = Error(RetArchiveVid)
+	// snapshot
+	ErrSnapshotMagic    = Error(RetSnapshotMagic)
+	ErrSnapshotVer      = Error(RetSnapshotVer)
+	ErrSnapshotChecksum = Error(RetSnapshotChecksum)
+	ErrSnapshotRange    = Error(RetSnapshotRange)
+	ErrSnapshotRebase   = Error(RetSnapshotRebase)
+	// checkpoint
+	ErrCheckpointMagic    = Error(RetCheckpointMagic)
+	ErrCheckpointVer      = Error(RetCheckpointVer)
+	ErrCheckpointChecksum = Error(RetCheckpointChecksum)
+	ErrCheckpointStale    = Error(RetCheckpointStale)
 )
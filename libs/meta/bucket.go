@@ -0,0 +1,57 @@
+package meta
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+const (
+	// bucket property bits, a private bucket must be authorized
+	BucketPrivateRead  = 1 << 0
+	BucketPrivateWrite = 1 << 1
+	BucketPrivate      = BucketPrivateRead | BucketPrivateWrite
+
+	_keyIdSize     = 8
+	_keySecretSize = 15
+)
+
+// Bucket the bucket meta, saved in zookeeper BucketRoot/<name>.
+type Bucket struct {
+	Name      string `json:"name"`
+	Property  int    `json:"property"`
+	KeyId     string `json:"key_id"`
+	KeySecret string `json:"key_secret"`
+	// the download domain, empty means the default of the proxy
+	Domain string `json:"domain"`
+	// the max-age of the downloads, zero means the default of the proxy
+	CacheControl int64 `json:"cache_control"`
+	// the default durability of the uploads, see Durability*
+	Durability string `json:"durability"`
+	// the codec compresses the compressible uploads, see Codec*
+	Compress string `json:"compress"`
+	CTime    int64  `json:"ctime"`
+	MTime    int64  `json:"mtime"`
+}
+
+// ValidBucketProperty check the property bits.
+func ValidBucketProperty(property int) bool {
+	return property >= 0 && property <= BucketPrivate
+}
+
+// NewBucketKey generate a random key id and secret.
+func NewBucketKey() (keyId, keySecret string, err error) {
+	var b = make([]byte, _keyIdSize+_keySecretSize)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	keyId = hex.EncodeToString(b[:_keyIdSize])
+	keySecret = hex.EncodeToString(b[_keyIdSize:])
+	return
+}
+
+// BucketResponse response of the directory bucket apis.
+type BucketResponse struct {
+	Ret     int       `json:"ret"`
+	Bucket  *Bucket   `json:"bucket,omitempty"`
+	Buckets []*Bucket `json:"buckets,omitempty"`
+}
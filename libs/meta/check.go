@@ -0,0 +1,73 @@
+package meta
+
+const (
+	// the max samples of every kind in the check report
+	_maxCheckSamples = 1000
+)
+
+// CheckReport the inconsistencies found by the checker, the counts are
+// complete, the samples are capped.
+type CheckReport struct {
+	Files   int64 `json:"files"`
+	Needles int64 `json:"needles"`
+	// the file rows whose needle row is missing, bucket/filename
+	OrphanFiles      int64    `json:"orphan_files"`
+	OrphanFileSample []string `json:"orphan_file_sample"`
+	// the needle rows referenced by no file
+	OrphanNeedles      int64   `json:"orphan_needles"`
+	OrphanNeedleSample []int64 `json:"orphan_needle_sample"`
+	// the needles on the volumes unknown to the directory
+	UnknownVolumes      int64   `json:"unknown_volumes"`
+	UnknownVolumeSample []int64 `json:"unknown_volume_sample"`
+	// the needle rows of old versions without the owner, not checked
+	Unowned int64 `json:"unowned"`
+	// the orphans deleted
+	Fixed int64 `json:"fixed"`
+}
+
+// Checker the options and the report of a consistency check of the meta
+// store.
+type Checker struct {
+	// the buckets to check the files
+	Buckets []string
+	// reports whether the volume is known
+	Volume func(vid int32) bool
+	// delete the orphans
+	Fix bool
+	// the rows updated after it, unix nanoseconds, are skipped, they may be
+	// written in flight
+	Since  int64
+	Report CheckReport
+}
+
+// Recent reports whether the row of the mtime is in flight.
+func (c *Checker) Recent(mtime int64) bool {
+	return mtime >= c.Since
+}
+
+// OrphanFile add an orphan file.
+func (c *Checker) OrphanFile(bucket, filename string) {
+	if c.Report.OrphanFiles++; len(c.Report.OrphanFileSample) < _maxCheckSamples {
+		c.Report.OrphanFileSample = append(c.Report.OrphanFileSample, bucket+"/"+filename)
+	}
+}
+
+// OrphanNeedle add an orphan needle.
+func (c *Checker) OrphanNeedle(key int64) {
+	if c.Report.OrphanNeedles++; len(c.Report.OrphanNeedleSample) < _maxCheckSamples {
+		c.Report.OrphanNeedleSample = append(c.Report.OrphanNeedleSample, key)
+	}
+}
+
+// UnknownVolume add a needle of an unknown volume.
+func (c *Checker) UnknownVolume(key int64) {
+	if c.Report.UnknownVolumes++; len(c.Report.UnknownVolumeSample) < _maxCheckSamples {
+		c.Report.UnknownVolumeSample = append(c.Report.UnknownVolumeSample, key)
+	}
+}
+
+// CheckResponse response of the directory check api.
+type CheckResponse struct {
+	Ret    int          `json:"ret"`
+	Report *CheckReport `json:"report,omitempty"`
+}
@@ -0,0 +1,68 @@
+package meta
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	// CodecGzip compress the needle data by gzip, also the http
+	// Content-Encoding of the compressed data.
+	CodecGzip = "gzip"
+)
+
+var (
+	// the content types worth compressing besides text/*
+	_compressible = map[string]bool{
+		"application/json":       true,
+		"application/xml":        true,
+		"application/javascript": true,
+		"application/x-ndjson":   true,
+		"image/svg+xml":          true,
+	}
+)
+
+// ValidCodec check the codec, empty means no compression.
+func ValidCodec(codec string) bool {
+	return codec == "" || codec == CodecGzip
+}
+
+// Compressible check the content type is worth compressing, the compressed
+// image or video is not.
+func Compressible(mine string) bool {
+	var i int
+	if i = strings.Index(mine, ";"); i >= 0 {
+		mine = mine[:i]
+	}
+	mine = strings.ToLower(strings.TrimSpace(mine))
+	return strings.HasPrefix(mine, "text/") || strings.HasSuffix(mine, "+json") || _compressible[mine]
+}
+
+// AcceptEncoding check the http Accept-Encoding header accepts the codec, a
+// zero q value refuses it.
+func AcceptEncoding(header, codec string) bool {
+	var (
+		i      int
+		q      float64
+		err    error
+		name   string
+		coding string
+		params string
+	)
+	for _, coding = range strings.Split(header, ",") {
+		params = ""
+		if i = strings.Index(coding, ";"); i >= 0 {
+			coding, params = coding[:i], coding[i+1:]
+		}
+		if name = strings.ToLower(strings.TrimSpace(coding)); name != codec && name != "*" {
+			continue
+		}
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			if q, err = strconv.ParseFloat(params[2:], 64); err != nil || q <= 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -2,4 +2,6 @@ package meta
 
 const (
 	MaxBlockOffset = uint32(4294967295)
+	// v1 block padding
+	_v1Padding = 8
 )
@@ -0,0 +1,60 @@
+package meta
+
+import (
+	"bfs/libs/encoding/binary"
+	"hash/fnv"
+)
+
+const (
+	// DigestBuckets the number of buckets a volume digest hashed into.
+	DigestBuckets = 1024
+
+	_digestNeedleSize = 13 // key + size + del
+)
+
+// Digest is the needles digest of a volume, the needles are hashed into
+// buckets by key, every bucket keeps the sum of its needles hash, so the
+// replicas can find the diverged buckets without transferring all the keys.
+type Digest struct {
+	Vid     int32    `json:"vid"`
+	Buckets []uint64 `json:"buckets"`
+}
+
+// DigestNeedle is a needle in a digest bucket.
+type DigestNeedle struct {
+	Key    int64 `json:"key"`
+	Cookie int32 `json:"cookie"`
+	Size   int32 `json:"size"`
+	Del    bool  `json:"del"`
+}
+
+// DigestBucket get the digest bucket of a needle key.
+func DigestBucket(key int64) int {
+	return int(uint64(key) % DigestBuckets)
+}
+
+// Hash get the needle hash, cookie is not included for it's not in memory.
+func (n *DigestNeedle) Hash() uint64 {
+	var (
+		buf [_digestNeedleSize]byte
+		h   = fnv.New64a()
+	)
+	binary.BigEndian.PutInt64(buf[0:], n.Key)
+	binary.BigEndian.PutInt32(buf[8:], n.Size)
+	if n.Del {
+		buf[12] = 1
+	}
+	h.Write(buf[:])
+	return h.Sum64()
+}
+
+// Diff get the diverged buckets of two digests.
+func (d *Digest) Diff(od *Digest) (buckets []int) {
+	var i int
+	for i = 0; i < DigestBuckets; i++ {
+		if i >= len(d.Buckets) || i >= len(od.Buckets) || d.Buckets[i] != od.Buckets[i] {
+			buckets = append(buckets, i)
+		}
+	}
+	return
+}
@@ -16,3 +16,9 @@ type Response struct {
 	Sha1   string   `json:"sha1"`
 	Mine   string   `json:"mine"`
 }
+
+// ListResponse response of the directory list api.
+type ListResponse struct {
+	Ret int `json:"ret"`
+	FileList
+}
@@ -0,0 +1,56 @@
+package meta
+
+const (
+	// disk status, the volumes of a slow disk are read only, the volumes of
+	// a failed disk are offline.
+	DiskStatusHealth = 0
+	DiskStatusRead   = 1
+	DiskStatusFail   = 2
+)
+
+// Disk a mount point of a store, the health is checked every
+// DiskCheckInterval, the io errors and delay are in the last check window,
+// the offline volumes are broken alone, the disk may be healthy.
+type Disk struct {
+	Path     string  `json:"path"`
+	Status   int     `json:"status"`
+	Volumes  []int32 `json:"volumes"`
+	Offline  []int32 `json:"offline,omitempty"`
+	IOErrors uint64  `json:"io_errors"`
+	Delay    uint64  `json:"delay"`
+}
+
+// VolumeStatus get the disk status of the volume in the store, health if
+// the volume is not on a reported disk.
+func (s *Store) VolumeStatus(vid int32) int {
+	var (
+		d *Disk
+		v int32
+	)
+	for _, d = range s.Disks {
+		for _, v = range d.Offline {
+			if v == vid {
+				return DiskStatusFail
+			}
+		}
+		if d.Status == DiskStatusHealth {
+			continue
+		}
+		for _, v = range d.Volumes {
+			if v == vid {
+				return d.Status
+			}
+		}
+	}
+	return DiskStatusHealth
+}
+
+// CanReadVolume reports whether the store can read the volume.
+func (s *Store) CanReadVolume(vid int32) bool {
+	return s.CanRead() && s.VolumeStatus(vid) != DiskStatusFail
+}
+
+// CanWriteVolume reports whether the store can write the volume.
+func (s *Store) CanWriteVolume(vid int32) bool {
+	return s.CanWrite() && s.VolumeStatus(vid) == DiskStatusHealth
+}
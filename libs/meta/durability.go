@@ -0,0 +1,17 @@
+package meta
+
+const (
+	// DurabilityCache ack after the needle is in the page cache, flushed by
+	// the SyncWrite of the block.
+	DurabilityCache = "cache"
+	// DurabilitySync ack after a fdatasync of the block.
+	DurabilitySync = "sync"
+	// DurabilityGroup ack after a fdatasync of the block shared by the
+	// writers in the group commit window.
+	DurabilityGroup = "group"
+)
+
+// ValidDurability check the durability mode, empty means the default.
+func ValidDurability(mode string) bool {
+	return mode == "" || mode == DurabilityCache || mode == DurabilitySync || mode == DurabilityGroup
+}
@@ -0,0 +1,71 @@
+package meta
+
+// ECVolume is an erasure coded volume, the block is cut into K data shards
+// of ShardSize (the last one is padded with zero), M parity shards are coded
+// from them, Stores is the store id of every shard.
+type ECVolume struct {
+	Vid       int32    `json:"vid"`
+	K         int      `json:"k"`
+	M         int      `json:"m"`
+	BlockSize int64    `json:"block_size"`
+	ShardSize int64    `json:"shard_size"`
+	Padding   uint32   `json:"padding"` // block padding, zero means v1 block
+	Stores    []string `json:"stores"`
+}
+
+// ECRange is a range of a shard.
+type ECRange struct {
+	Shard  int
+	Offset int64
+	Size   int64
+}
+
+// NewECVolume new a erasure coded volume meta.
+func NewECVolume(vid int32, k, m int, bsize int64) *ECVolume {
+	return &ECVolume{
+		Vid:       vid,
+		K:         k,
+		M:         m,
+		BlockSize: bsize,
+		ShardSize: (bsize + int64(k) - 1) / int64(k),
+		Stores:    make([]string, k+m),
+	}
+}
+
+// BlockOffset get the block offset of a needle offset.
+func (v *ECVolume) BlockOffset(offset uint32) int64 {
+	if v.Padding == 0 {
+		return int64(offset) * _v1Padding
+	}
+	return int64(offset) * int64(v.Padding)
+}
+
+// Locate split a block range into the data shard ranges.
+func (v *ECVolume) Locate(offset, size int64) (rs []ECRange) {
+	var r ECRange
+	for size > 0 {
+		r.Shard = int(offset / v.ShardSize)
+		r.Offset = offset % v.ShardSize
+		if r.Size = v.ShardSize - r.Offset; r.Size > size {
+			r.Size = size
+		}
+		rs = append(rs, r)
+		offset += r.Size
+		size -= r.Size
+	}
+	return
+}
+
+// Shards get the shards of a store.
+func (v *ECVolume) Shards(id string) (shards []int) {
+	var (
+		i   int
+		sid string
+	)
+	for i, sid = range v.Stores {
+		if sid == id {
+			shards = append(shards, i)
+		}
+	}
+	return
+}
@@ -1,11 +1,87 @@
 package meta
 
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
 // File meta info.
 type File struct {
 	Filename string `json:"filename"`
 	Key      int64  `json:"key"`
 	Sha1     string `json:"sha1"`
 	Mine     string `json:"mine"`
+	Size     int64  `json:"size"`
 	Status   int32  `json:"status"`
 	MTime    int64  `json:"update_time"`
+	Expire   int64  `json:"expire"` // unix seconds, zero means never
+}
+
+// Expired reports whether the file is expired at the unix seconds.
+func (f *File) Expired(now int64) bool {
+	return f.Expire > 0 && f.Expire <= now
+}
+
+// FormatExpires get the http Expires header value of the expire unix
+// seconds.
+func FormatExpires(expire int64) string {
+	return time.Unix(expire, 0).UTC().Format(http.TimeFormat)
+}
+
+// ParseExpires parse the http Expires header value into unix seconds, empty
+// or malformed header means never.
+func ParseExpires(s string) int64 {
+	var (
+		err error
+		t   time.Time
+	)
+	if s == "" {
+		return 0
+	}
+	if t, err = http.ParseTime(s); err != nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// FormatDisposition get the http Content-Disposition header value of the
+// filename, empty if not valid.
+func FormatDisposition(filename string) string {
+	return mime.FormatMediaType("inline", map[string]string{"filename": filename})
+}
+
+// CopyParams set the upload params of a needle copy from the headers of the
+// store get, the expire, the compression and the needle meta (mine, mtime in
+// seconds and filename) are kept.
+func CopyParams(h http.Header, params url.Values) {
+	var (
+		err    error
+		expire int64
+		str    string
+		t      time.Time
+		ps     map[string]string
+	)
+	params.Del("expire")
+	params.Del("encoding")
+	params.Del("mine")
+	params.Del("mtime")
+	params.Del("filename")
+	if expire = ParseExpires(h.Get("Expires")); expire > 0 {
+		params.Set("expire", strconv.FormatInt(expire, 10))
+	}
+	if str = h.Get("Content-Encoding"); str != "" {
+		params.Set("encoding", str)
+	}
+	if str = h.Get("Content-Type"); str != "" {
+		params.Set("mine", str)
+	}
+	if t, err = http.ParseTime(h.Get("Last-Modified")); err == nil {
+		params.Set("mtime", strconv.FormatInt(t.UnixNano(), 10))
+	}
+	if _, ps, err = mime.ParseMediaType(h.Get("Content-Disposition")); err == nil && ps["filename"] != "" {
+		params.Set("filename", ps["filename"])
+	}
 }
@@ -0,0 +1,38 @@
+package meta
+
+// GCReport the needles on the stores referenced by no file, the counts are
+// complete, the samples are capped.
+type GCReport struct {
+	DryRun  bool  `json:"dry_run"`
+	Volumes int64 `json:"volumes"`
+	Needles int64 `json:"needles"`
+	// the needles referenced by no file
+	Orphans      int64   `json:"orphans"`
+	OrphanSample []int64 `json:"orphan_sample"`
+	// the orphans unreferenced longer than the grace, deleted unless dry run
+	Expired int64 `json:"expired"`
+	Deleted int64 `json:"deleted"`
+	// the volumes failed to list and the needles failed to delete, retried
+	// by the next gc
+	Failed int64 `json:"failed"`
+}
+
+// Orphan add an orphan needle.
+func (r *GCReport) Orphan(key int64) {
+	if r.Orphans++; len(r.OrphanSample) < _maxCheckSamples {
+		r.OrphanSample = append(r.OrphanSample, key)
+	}
+}
+
+// GCResponse response of the directory gc api.
+type GCResponse struct {
+	Ret    int       `json:"ret"`
+	Report *GCReport `json:"report,omitempty"`
+}
+
+// UnreferencedResponse response of the directory unreferenced api, the keys
+// referenced by no file.
+type UnreferencedResponse struct {
+	Ret  int     `json:"ret"`
+	Keys []int64 `json:"keys"`
+}
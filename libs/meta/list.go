@@ -0,0 +1,132 @@
+package meta
+
+import (
+	"strings"
+)
+
+const (
+	// MaxListKeys the max files and prefixes of a list page, also the
+	// default.
+	MaxListKeys = 1000
+)
+
+// FileList a page of the files of a bucket in order of the filename, the
+// files of the same common prefix are grouped by the delimiter.
+type FileList struct {
+	Files     []*File  `json:"files"`
+	Prefixes  []string `json:"prefixes"`
+	Truncated bool     `json:"truncated"`
+	// the marker of the next page if truncated
+	Marker string `json:"next_marker,omitempty"`
+}
+
+// Lister build a list page from the files scanned in order of the filename.
+type Lister struct {
+	Prefix    string
+	Delimiter string
+	Marker    string
+	Max       int
+	List      FileList
+	// the unix seconds the expired files are skipped at
+	now  int64
+	last string
+}
+
+// NewLister new a lister, max out of (0, MaxListKeys] means MaxListKeys.
+func NewLister(prefix, delimiter, marker string, max int, now int64) *Lister {
+	if max <= 0 || max > MaxListKeys {
+		max = MaxListKeys
+	}
+	return &Lister{Prefix: prefix, Delimiter: delimiter, Marker: marker, Max: max, now: now}
+}
+
+// common get the common prefix of the filename, ok is false if the filename
+// is not grouped.
+func (l *Lister) common(filename string) (prefix string, ok bool) {
+	var i int
+	if l.Delimiter == "" || !strings.HasPrefix(filename, l.Prefix) {
+		return
+	}
+	if i = strings.Index(filename[len(l.Prefix):], l.Delimiter); i < 0 {
+		return
+	}
+	return filename[:len(l.Prefix)+i+len(l.Delimiter)], true
+}
+
+// Start get the first filename to scan, inclusive, the files of the common
+// prefix of the marker are skipped.
+func (l *Lister) Start() string {
+	var (
+		ok     bool
+		prefix string
+	)
+	if l.Marker <= l.Prefix {
+		return l.Prefix
+	}
+	if prefix, ok = l.common(l.Marker); ok {
+		return PrefixEnd(prefix)
+	}
+	return l.Marker + "\x00"
+}
+
+// Stop get the filename to stop the scan at, exclusive, empty means the end.
+func (l *Lister) Stop() string {
+	return PrefixEnd(l.Prefix)
+}
+
+// After get the filename to continue the scan after the added filename, the
+// other files of the listed common prefix are skipped. empty means the end.
+func (l *Lister) After(filename string) string {
+	var (
+		ok     bool
+		prefix string
+	)
+	if prefix, ok = l.common(filename); ok && prefix == l.last {
+		return PrefixEnd(prefix)
+	}
+	return filename + "\x00"
+}
+
+// Add add the next file, returns false if the page is full, the list is
+// truncated then.
+func (l *Lister) Add(f *File) bool {
+	var (
+		ok     bool
+		prefix string
+	)
+	if f.Filename <= l.Marker || !strings.HasPrefix(f.Filename, l.Prefix) || f.Expired(l.now) {
+		return true
+	}
+	if prefix, ok = l.common(f.Filename); ok && prefix == l.last {
+		return true
+	}
+	if len(l.List.Files)+len(l.List.Prefixes) >= l.Max {
+		l.List.Truncated = true
+		l.List.Marker = l.last
+		return false
+	}
+	if ok {
+		l.List.Prefixes = append(l.List.Prefixes, prefix)
+		l.last = prefix
+	} else {
+		l.List.Files = append(l.List.Files, f)
+		l.last = f.Filename
+	}
+	return true
+}
+
+// PrefixEnd get the first string after all the strings of the prefix,
+// empty means no such string.
+func PrefixEnd(prefix string) string {
+	var (
+		i int
+		b = []byte(prefix)
+	)
+	for i = len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,128 @@
+package meta
+
+import (
+	"bfs/libs/errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	_rangeUnit = "bytes="
+)
+
+// Range is a single http byte range.
+//
+// range spec:
+// bytes=first-last  [first, last]
+// bytes=first-      [first, end]
+// bytes=-suffix     last suffix bytes
+type Range struct {
+	First int64 `json:"-"` // -1 means suffix range
+	Last  int64 `json:"-"` // -1 means to the end
+	// after fit
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+	Total  int64 `json:"total"`
+}
+
+// ParseRange parse a http range header, multi ranges or malformed header
+// returns nil, the caller should ignore it and response the whole file.
+func ParseRange(s string) (r *Range) {
+	var (
+		i           int
+		err         error
+		first, last int64
+	)
+	if !strings.HasPrefix(s, _rangeUnit) {
+		return
+	}
+	s = strings.TrimSpace(s[len(_rangeUnit):])
+	if strings.Contains(s, ",") {
+		return
+	}
+	if i = strings.Index(s, "-"); i < 0 {
+		return
+	}
+	first, last = -1, -1
+	if i > 0 {
+		if first, err = strconv.ParseInt(strings.TrimSpace(s[:i]), 10, 64); err != nil || first < 0 {
+			return
+		}
+	}
+	if i < len(s)-1 {
+		if last, err = strconv.ParseInt(strings.TrimSpace(s[i+1:]), 10, 64); err != nil || last < 0 {
+			return
+		}
+	}
+	if first == -1 && last == -1 {
+		return
+	}
+	if first != -1 && last != -1 && last < first {
+		return
+	}
+	r = &Range{First: first, Last: last}
+	return
+}
+
+// Fit fit the range with a data total size, set the offset and size.
+func (r *Range) Fit(total int64) (err error) {
+	r.Total = total
+	if r.First == -1 {
+		// suffix
+		if r.Last == 0 || total == 0 {
+			return errors.ErrRangeNotSatisfiable
+		}
+		if r.Size = r.Last; r.Size > total {
+			r.Size = total
+		}
+		r.Offset = total - r.Size
+		return
+	}
+	if r.First >= total {
+		return errors.ErrRangeNotSatisfiable
+	}
+	r.Offset = r.First
+	if r.Last == -1 || r.Last >= total {
+		r.Size = total - r.Offset
+	} else {
+		r.Size = r.Last - r.First + 1
+	}
+	return
+}
+
+// Whole reports whether the fitted range covers the whole data.
+func (r *Range) Whole() bool {
+	return r.Offset == 0 && r.Size == r.Total
+}
+
+// ContentRange get the http Content-Range header value.
+func (r *Range) ContentRange() string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Offset, r.Offset+r.Size-1, r.Total)
+}
+
+// UnsatisfiedRange get the http Content-Range header value for a 416 response.
+func UnsatisfiedRange(total int64) string {
+	return fmt.Sprintf("bytes */%d", total)
+}
+
+// IfRange check the http If-Range header value match the file etag (sha1) or
+// the last modified time (mtime in nanoseconds).
+func IfRange(s, etag string, mtime int64) bool {
+	var (
+		err error
+		t   time.Time
+	)
+	if s == "" {
+		return true
+	}
+	if strings.HasPrefix(s, "\"") || strings.HasPrefix(s, "W/") {
+		return strings.Trim(s, "\"") == etag
+	}
+	if t, err = http.ParseTime(s); err != nil {
+		return s == etag
+	}
+	return time.Unix(0, mtime).Unix() == t.Unix()
+}
@@ -0,0 +1,14 @@
+package meta
+
+// Snapshot is a consistent cut of a volume for the incremental backup, the
+// block and index are append-only, so an increment is the bytes after the
+// last cut and the needles deleted since it. a compaction replaces the block
+// file, the cuts of the old one are invalid and a new base is needed.
+type Snapshot struct {
+	Vid   int32  `json:"vid"`
+	File  string `json:"file"`  // block file
+	Block int64  `json:"block"` // block size
+	Index int64  `json:"index"` // index size
+	Epoch int64  `json:"epoch"` // the delete log epoch, reset when volume opened or compacted
+	Dels  int    `json:"dels"`  // the delete log length
+}
@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/golang/glog"
@@ -27,6 +30,13 @@ const (
 	statAPI  = "http://%s/info"
 	getAPI   = "http://%s/get?key=%d&cookie=%d&vid=%d"
 	probeAPI = "http://%s/probe?vid=%d"
+	// anti-entropy api
+	digestAPI       = "http://%s/digest?vid=%d"
+	digestBucketAPI = "http://%s/digest?vid=%d&bucket=%d"
+	uploadAPI       = "http://%s/upload?key=%d&cookie=%d&vid=%d"
+	delAPI          = "http://%s/del"
+	// gc api
+	needlesAPI = "http://%s/needles?vid=%d"
 )
 
 var (
@@ -60,6 +70,8 @@ type Store struct {
 	Id     string `json:"id"`
 	Rack   string `json:"rack"`
 	Status int    `json:"status"`
+	// the disks of the store, only the volumes of a bad disk are routed around
+	Disks []*Disk `json:"disks,omitempty"`
 }
 
 func (s *Store) String() string {
@@ -82,7 +94,12 @@ func (s *Store) statAPI() string {
 
 // getApi get file http api
 func (s *Store) getAPI(n *Needle, vid int32) string {
-	return fmt.Sprintf(getAPI, s.Stat, n.Key, n.Cookie, vid)
+	return fmt.Sprintf(getAPI, s.Api, n.Key, n.Cookie, vid)
+}
+
+// uploadAPI upload file http api.
+func (s *Store) uploadAPI(n *Needle, vid int32) string {
+	return fmt.Sprintf(uploadAPI, s.Api, n.Key, n.Cookie, vid)
 }
 
 // probeApi probe store
@@ -156,3 +173,185 @@ func (s *Store) CanWrite() bool {
 func (s *Store) CanRead() bool {
 	return s.Status == StoreStatusRead || s.Status == StoreStatusHealth
 }
+
+type needlesRet struct {
+	Ret  int     `json:"ret"`
+	Keys []int64 `json:"keys"`
+}
+
+type digestRet struct {
+	Ret     int             `json:"ret"`
+	Digest  *Digest         `json:"digest"`
+	Needles []*DigestNeedle `json:"needles"`
+}
+
+// storeRet check the store json ret.
+func storeRet(ret int) (err error) {
+	if ret != errors.RetOK {
+		if ret == 0 {
+			err = errors.ErrInternal
+		} else {
+			err = errors.Error(ret)
+		}
+	}
+	return
+}
+
+// do send a request to store and decode the json response.
+func (s *Store) do(req *http.Request, res interface{}) (err error) {
+	var (
+		body []byte
+		resp *http.Response
+		uri  = req.URL.String()
+	)
+	if resp, err = _client.Do(req); err != nil {
+		log.Errorf("_client.do(%s) error(%v)", uri, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("_client.do(%s) status: %d", uri, resp.StatusCode)
+		err = errors.ErrInternal
+		return
+	}
+	if body, err = ioutil.ReadAll(resp.Body); err != nil {
+		log.Errorf("ioutil.ReadAll() error(%v)", err)
+		return
+	}
+	if err = json.Unmarshal(body, res); err != nil {
+		log.Errorf("json.Unmarshal(\"%s\") error(%v)", body, err)
+	}
+	return
+}
+
+// Digest get the needles digest of a volume.
+func (s *Store) Digest(vid int32) (d *Digest, err error) {
+	var (
+		req *http.Request
+		ret = new(digestRet)
+		uri = fmt.Sprintf(digestAPI, s.Admin, vid)
+	)
+	if req, err = http.NewRequest("GET", uri, nil); err != nil {
+		log.Errorf("http.NewRequest(GET,%s) error(%v)", uri, err)
+		return
+	}
+	if err = s.do(req, ret); err != nil {
+		return
+	}
+	if err = storeRet(ret.Ret); err == nil {
+		d = ret.Digest
+	}
+	return
+}
+
+// DigestNeedles get the needles of a volume digest bucket.
+func (s *Store) DigestNeedles(vid int32, bucket int) (dns []*DigestNeedle, err error) {
+	var (
+		req *http.Request
+		ret = new(digestRet)
+		uri = fmt.Sprintf(digestBucketAPI, s.Admin, vid, bucket)
+	)
+	if req, err = http.NewRequest("GET", uri, nil); err != nil {
+		log.Errorf("http.NewRequest(GET,%s) error(%v)", uri, err)
+		return
+	}
+	if err = s.do(req, ret); err != nil {
+		return
+	}
+	if err = storeRet(ret.Ret); err == nil {
+		dns = ret.Needles
+	}
+	return
+}
+
+// Needles get the keys of the live needles of a volume.
+func (s *Store) Needles(vid int32) (keys []int64, err error) {
+	var (
+		req *http.Request
+		ret = new(needlesRet)
+		uri = fmt.Sprintf(needlesAPI, s.Admin, vid)
+	)
+	if req, err = http.NewRequest("GET", uri, nil); err != nil {
+		log.Errorf("http.NewRequest(GET,%s) error(%v)", uri, err)
+		return
+	}
+	if err = s.do(req, ret); err != nil {
+		return
+	}
+	if err = storeRet(ret.Ret); err == nil {
+		keys = ret.Keys
+	}
+	return
+}
+
+// Copy copy a needle of the volume from the store to the dst store, the
+// data is streamed, not buffered, the expire of a ttl needle, the needle meta
+// and the compression are kept.
+func (s *Store) Copy(dst *Store, vid int32, n *Needle) (err error) {
+	var (
+		req    *http.Request
+		resp   *http.Response
+		ret    = new(StoreRet)
+		params = url.Values{}
+		uri    = s.getAPI(n, vid)
+	)
+	if req, err = http.NewRequest("GET", uri, nil); err != nil {
+		log.Errorf("http.NewRequest(GET,%s) error(%v)", uri, err)
+		return
+	}
+	// a compressed needle is copied as is
+	req.Header.Set("Accept-Encoding", CodecGzip)
+	if resp, err = _client.Do(req); err != nil {
+		log.Errorf("_client.do(%s) error(%v)", uri, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("_client.do(%s) status: %d", uri, resp.StatusCode)
+		if resp.StatusCode == http.StatusNotFound {
+			err = errors.ErrNeedleNotExist
+		} else {
+			err = errors.ErrInternal
+		}
+		return
+	}
+	uri = dst.uploadAPI(n, vid)
+	if CopyParams(resp.Header, params); len(params) > 0 {
+		uri += "&" + params.Encode()
+	}
+	if req, err = http.NewRequest("POST", uri, resp.Body); err != nil {
+		log.Errorf("http.NewRequest(POST,%s) error(%v)", uri, err)
+		return
+	}
+	req.ContentLength = resp.ContentLength
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if err = dst.do(req, ret); err != nil {
+		return
+	}
+	return storeRet(ret.Ret)
+}
+
+// Delete delete a needle of the volume, a needle not exist or already
+// deleted is ok.
+func (s *Store) Delete(vid int32, key int64) (err error) {
+	var (
+		req    *http.Request
+		ret    = new(StoreRet)
+		uri    = fmt.Sprintf(delAPI, s.Api)
+		params = url.Values{}
+	)
+	params.Set("key", strconv.FormatInt(key, 10))
+	params.Set("vid", strconv.FormatInt(int64(vid), 10))
+	if req, err = http.NewRequest("POST", uri, strings.NewReader(params.Encode())); err != nil {
+		log.Errorf("http.NewRequest(POST,%s) error(%v)", uri, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err = s.do(req, ret); err != nil {
+		return
+	}
+	if ret.Ret == errors.RetNeedleNotExist || ret.Ret == errors.RetNeedleDeleted {
+		return
+	}
+	return storeRet(ret.Ret)
+}
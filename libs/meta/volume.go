@@ -3,9 +3,14 @@ package meta
 import "bfs/libs/stat"
 
 type Volume struct {
-	Id    int32       `json:"id"`
-	Block *SuperBlock `json:"block"`
-	Stats *stat.Stats `json:"stats"`
+	Id           int32       `json:"id"`
+	Block        *SuperBlock `json:"block"`
+	Stats        *stat.Stats `json:"stats"`
+	LiveBytes    int64       `json:"live_bytes"`
+	DeletedBytes int64       `json:"deleted_bytes"`
+	Corrupts     []int64     `json:"corrupts"`
+	Repair       bool        `json:"repair"`
+	Status       int         `json:"status"`
 }
 
 type Volumes struct {
@@ -17,4 +17,8 @@ STORE_RACK = {}
 STORE_VOLUME = {}
 STORE_GROUP = {}
 
-MAX_VOLUME_ID = 0
\ No newline at end of file
+MAX_VOLUME_ID = 0
+
+STORE_STATUS_HEALTH = (1 << 31) | 3   # enable | read | write
+RECOVERY = {}    # failed store_id to recovery progress
+EC = {}    # volume_id to erasure code progress
@@ -10,13 +10,15 @@ import config
 from global_var import *
 
 global_store_conn_pool = {}
+EC_TIMEOUT = 3600
 
 class HttpConnection:
     DEFAULT_TIMEOUT = 60
 
-    def __init__(self, host, port):
+    def __init__(self, host, port, timeout=DEFAULT_TIMEOUT):
         self.host = host
         self.port = port
+        self.timeout = timeout
         self.http_conn = None
 
     def request(self, method, url, body=None, headers={}):
@@ -24,7 +26,7 @@ class HttpConnection:
         try:
             print url,body
             if self.http_conn is None:
-                self.http_conn = httplib.HTTPConnection(self.host, self.port, timeout=HttpConnection.DEFAULT_TIMEOUT)
+                self.http_conn = httplib.HTTPConnection(self.host, self.port, timeout=self.timeout)
 
             self.http_conn.request(method, url, body, headers)
             conn_resp = self.http_conn.getresponse()
@@ -130,3 +132,113 @@ def storeBulkVolume(store_ip, body):
 
 def storeCompactVolume(store_ip, body):
     pass
+
+
+def storeRecoverVolume(store_ip, volume_id, src_ip):
+    store_conn = genStoreConn(store_ip, config.store_admin_port)
+    url = "/recover_volume"
+    value = {}
+    value['vid'] = volume_id
+    value['src'] = '%s:%d' % (src_ip, config.store_admin_port)
+    body = urllib.urlencode(value)
+
+    retcode, status, data = store_conn.request('POST', url, body, headers=genHttpHeaders())
+    if retcode and status >= 200 and status < 300:
+        return json.loads(data)
+
+    logger.error("storeRecoverVolume() called failed: store_ip: %s, volume_id: %d, src_ip: %s", store_ip, volume_id, src_ip)
+    return None
+
+
+def storeRecovery(store_ip, volume_id):
+    store_conn = genStoreConn(store_ip, config.store_admin_port)
+    url = "/recover_volume?vid=%d" % volume_id
+
+    retcode, status, data = store_conn.request('GET', url)
+    if retcode and status >= 200 and status < 300:
+        return json.loads(data)
+
+    logger.error("storeRecovery() called failed: store_ip: %s, volume_id: %d", store_ip, volume_id)
+    return None
+
+
+def storeDelVolume(store_ip, volume_id):
+    store_conn = genStoreConn(store_ip, config.store_admin_port)
+    url = "/del_volume"
+    value = {}
+    value['vid'] = volume_id
+    body = urllib.urlencode(value)
+
+    retcode, status, data = store_conn.request('POST', url, body, headers=genHttpHeaders())
+    if retcode and status >= 200 and status < 300:
+        return json.loads(data)
+
+    logger.error("storeDelVolume() called failed: store_ip: %s, volume_id: %d", store_ip, volume_id)
+    return None
+
+
+def storeECEncode(store_ip, volume_id, k, m):
+    # encoding a whole block takes a while
+    store_conn = HttpConnection(host=store_ip, port=config.store_admin_port, timeout=EC_TIMEOUT)
+    url = "/ec_encode"
+    value = {}
+    value['vid'] = volume_id
+    value['k'] = k
+    value['m'] = m
+    body = urllib.urlencode(value)
+
+    retcode, status, data = store_conn.request('POST', url, body, headers=genHttpHeaders())
+    if retcode and status >= 200 and status < 300:
+        return json.loads(data)
+
+    logger.error("storeECEncode() called failed: store_ip: %s, volume_id: %d", store_ip, volume_id)
+    return None
+
+
+def storeECAddShard(store_ip, volume_id, shards, src_ip, base_dir):
+    store_conn = HttpConnection(host=store_ip, port=config.store_admin_port, timeout=EC_TIMEOUT)
+    url = "/ec_add_shard"
+    value = {}
+    value['vid'] = volume_id
+    value['shards'] = ','.join([str(shard) for shard in shards])
+    value['src'] = '%s:%d' % (src_ip, config.store_admin_port)
+    value['dir'] = base_dir
+    body = urllib.urlencode(value)
+
+    retcode, status, data = store_conn.request('POST', url, body, headers=genHttpHeaders())
+    if retcode and status >= 200 and status < 300:
+        return json.loads(data)
+
+    logger.error("storeECAddShard() called failed: store_ip: %s, volume_id: %d, src_ip: %s", store_ip, volume_id, src_ip)
+    return None
+
+
+def storeECMeta(store_ip, ec_meta):
+    store_conn = genStoreConn(store_ip, config.store_admin_port)
+    url = "/ec_meta"
+    value = {}
+    value['meta'] = json.dumps(ec_meta)
+    body = urllib.urlencode(value)
+
+    retcode, status, data = store_conn.request('POST', url, body, headers=genHttpHeaders())
+    if retcode and status >= 200 and status < 300:
+        return json.loads(data)
+
+    logger.error("storeECMeta() called failed: store_ip: %s, volume_id: %d", store_ip, ec_meta['vid'])
+    return None
+
+
+def storeECRebuild(store_ip, volume_id, shard):
+    store_conn = HttpConnection(host=store_ip, port=config.store_admin_port, timeout=EC_TIMEOUT)
+    url = "/ec_rebuild"
+    value = {}
+    value['vid'] = volume_id
+    value['shard'] = shard
+    body = urllib.urlencode(value)
+
+    retcode, status, data = store_conn.request('POST', url, body, headers=genHttpHeaders())
+    if retcode and status >= 200 and status < 300:
+        return json.loads(data)
+
+    logger.error("storeECRebuild() called failed: store_ip: %s, volume_id: %d, shard: %d", store_ip, volume_id, shard)
+    return None
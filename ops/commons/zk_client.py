@@ -92,6 +92,21 @@ def getAllVolume():
 		return False
 
 
+def setECVolume(volume_id, ec_meta):
+	try:
+		if zk_client.exists('/ec') is None:
+			zk_client.create('/ec')
+		path = '/ec/' + str(volume_id)
+		if zk_client.exists(path) is None:
+			zk_client.create(path, json.dumps(ec_meta))
+		else:
+			zk_client.set(path, json.dumps(ec_meta))
+		return True
+	except Exception as ex:
+		logger.error("setECVolume() called   error: %s", str(ex))
+		return False
+
+
 def addGroupStore(group_id, store_id):
 	try:
 		if zk_client.exists('/group') is None:
@@ -133,6 +148,41 @@ def getAllGroup():
 		return False
 
 
+def delVolumeStore(volume_id, store_id):
+	try:
+		path = '/volume/' + str(volume_id) + '/' + str(store_id)
+		if zk_client.exists(path) is not None:
+			zk_client.delete(path)
+		return True
+	except Exception as ex:
+		logger.error("delVolumeStore() called   error: %s", str(ex))
+		return False
+
+
+def delGroupStore(group_id, store_id):
+	try:
+		path = '/group/' + str(group_id) + '/' + str(store_id)
+		if zk_client.exists(path) is not None:
+			zk_client.delete(path)
+		return True
+	except Exception as ex:
+		logger.error("delGroupStore() called   error: %s", str(ex))
+		return False
+
+
+def setStoreStatus(store_id, status):
+	try:
+		path = join(join('/rack', STORE_RACK[store_id]), store_id)
+		data, stat = zk_client.get(path)
+		parsed_data = json.loads(data)
+		parsed_data['status'] = status
+		zk_client.set(path, json.dumps(parsed_data), stat.version)
+		return True
+	except Exception as ex:
+		logger.error("setStoreStatus() called   error: %s", str(ex))
+		return False
+
+
 def initFromZk():
 	if getRack():
 		logger.info("getRack() called success")
@@ -3,6 +3,8 @@
 
 import json
 import httplib
+import threading
+import time
 from commons import *
 from commons.global_var import *
 
@@ -217,3 +219,222 @@ def bfsopsVolumesPost():
 	resp_str = json.dumps(resp)
 	logger.info("bfsopsVolumesPost() called, success, resp: %s", resp_str)
 	return resp_str
+
+
+def recoverStore(failed_store, new_store, src_store, group_id, volumes):
+	progress = RECOVERY[failed_store]
+	for volume_id in volumes:
+		progress['volume'] = volume_id
+		result = store_client.storeRecoverVolume(STORE_TO_IP[new_store], int(volume_id), STORE_TO_IP[src_store])
+		# 8007: volume in recovering, resumed by the former request
+		if result is None or result['ret'] not in [1, 8007]:
+			logger.error("storeRecoverVolume() called, failed, store: %s, volume_id: %s", new_store, volume_id)
+			progress['status'] = "failed"
+			return
+		while True:
+			time.sleep(5)
+			result = store_client.storeRecovery(STORE_TO_IP[new_store], int(volume_id))
+			if result is None or result['ret'] != 1:
+				continue
+			progress['recovery'] = result['recovery']
+			if result['recovery']['status'] in ["done", "failed"]:
+				break
+		if result['recovery']['status'] != "done":
+			logger.error("recover volume failed, store: %s, volume_id: %s, error: %s", new_store, volume_id, result['recovery'].get('err'))
+			progress['status'] = "failed"
+			return
+		if not zk_client.addVolumeStore(volume_id, new_store) or not zk_client.delVolumeStore(volume_id, failed_store):
+			progress['status'] = "failed"
+			return
+		STORE_VOLUME.setdefault(new_store, []).append(volume_id)
+		STORE_INFO[VOLUME_KEY+new_store] += 1
+		STORE_INFO[FREE_VOLUME_KEY+new_store] -= 1
+		progress['done'].append(volume_id)
+		logger.info("recover volume success, store: %s, volume_id: %s", new_store, volume_id)
+
+	# replace the failed store in the group, then let it writable
+	if not zk_client.addGroupStore(group_id, new_store) or not zk_client.delGroupStore(group_id, failed_store):
+		progress['status'] = "failed"
+		return
+	GROUP_STORE[group_id].remove(failed_store)
+	GROUP_STORE[group_id].append(new_store)
+	del STORE_GROUP[failed_store]
+	STORE_GROUP[new_store] = group_id
+	if not zk_client.setStoreStatus(new_store, STORE_STATUS_HEALTH):
+		progress['status'] = "failed"
+		return
+	progress['status'] = "done"
+	logger.info("recover store success, failed store: %s, new store: %s", failed_store, new_store)
+
+
+@app.route('/bfsops/recovery', methods = ["POST"])
+#@login_required
+def bfsopsRecoveryPost():
+	if not request.json:
+		abort(400)
+
+	try:
+		failed_store = request.json['store'].encode('utf-8')
+		group_id = STORE_GROUP[failed_store]
+	except BaseException, e:
+		logger.warn('Exception:%s', str(e))
+		abort(400)
+
+	if RECOVERY.has_key(failed_store) and RECOVERY[failed_store]['status'] == "running":
+		return jsonify(status="failed", errorMsg="store in recovering")
+
+	src_stores = [store_id for store_id in GROUP_STORE[group_id] if store_id != failed_store]
+	if len(src_stores) == 0:
+		return jsonify(status="failed", errorMsg="no surviving replica")
+	volumes = STORE_VOLUME.get(failed_store, [])
+
+	# resume with the same new store, the store resumes the copied volumes
+	new_store = None
+	if RECOVERY.has_key(failed_store):
+		new_store = RECOVERY[failed_store]['new_store']
+	else:
+		recovering = [progress['new_store'] for progress in RECOVERY.values()]
+		for store_id in STORE_RACK:
+			if store_id in STORE_GROUP or store_id in recovering:
+				continue
+			if STORE_INFO.get(FREE_VOLUME_KEY+store_id, 0) >= len(volumes):
+				new_store = store_id
+				break
+	if new_store is None:
+		return jsonify(status="failed", errorMsg="no store has enough free volumes")
+
+	done = []
+	if RECOVERY.has_key(failed_store):
+		done = RECOVERY[failed_store]['done']
+	RECOVERY[failed_store] = {'new_store': new_store, 'src_store': src_stores[0], 'status': "running", 'done': done}
+	volumes = [volume_id for volume_id in volumes if volume_id not in done]
+	thread = threading.Thread(target=recoverStore, args=(failed_store, new_store, src_stores[0], group_id, volumes))
+	thread.daemon = True
+	thread.start()
+	logger.info("bfsopsRecoveryPost() called, failed store: %s, new store: %s, volumes: %s", failed_store, new_store, volumes)
+	return jsonify(status="ok", errorMsg="", content=RECOVERY[failed_store])
+
+
+@app.route('/bfsops/recovery', methods = ["GET"])
+#@login_required
+def bfsopsRecoveryGet():
+	resp = {}
+	resp['status'] = "ok"
+	resp['errorMsg'] = ""
+	resp['content'] = RECOVERY
+	return json.dumps(resp)
+
+
+def volumeStores(volume_id):
+	# STORE_VOLUME mixes the volume ids loaded from zk (str) and added (int)
+	return [store_id for store_id, volumes in STORE_VOLUME.items() if str(volume_id) in [str(vid) for vid in volumes]]
+
+
+def ecStores(src_store, n):
+	# spread the shards across racks, the src store keeps the first one
+	racks = {}
+	for store_id, rack_name in STORE_RACK.items():
+		if store_id != src_store:
+			racks.setdefault(rack_name, []).append(store_id)
+	stores = [src_store]
+	while len(stores) < n and len(racks) > 0:
+		for rack_name in sorted(racks.keys()):
+			if len(stores) >= n:
+				break
+			stores.append(racks[rack_name].pop(0))
+			if len(racks[rack_name]) == 0:
+				del racks[rack_name]
+	# not enough stores, some hold more than one shard
+	i = 0
+	while len(stores) < n:
+		stores.append(stores[i])
+		i += 1
+	return stores
+
+
+def ecVolume(volume_id, k, m, base_dir, drop):
+	progress = EC[volume_id]
+	replicas = volumeStores(volume_id)
+	src_store = progress['src_store']
+	progress['step'] = "encode"
+	result = store_client.storeECEncode(STORE_TO_IP[src_store], volume_id, k, m)
+	if result is None or result['ret'] != 1:
+		logger.error("storeECEncode() called, failed, store: %s, volume_id: %d", src_store, volume_id)
+		progress['status'] = "failed"
+		return
+	ec_meta = result['meta']
+	ec_meta['stores'] = ecStores(src_store, k+m)
+	progress['step'] = "shard"
+	for store_id in set(ec_meta['stores']):
+		if store_id == src_store:
+			continue
+		shards = [i for i, sid in enumerate(ec_meta['stores']) if sid == store_id]
+		result = store_client.storeECAddShard(STORE_TO_IP[store_id], volume_id, shards, STORE_TO_IP[src_store], base_dir)
+		if result is None or result['ret'] != 1:
+			logger.error("storeECAddShard() called, failed, store: %s, volume_id: %d", store_id, volume_id)
+			progress['status'] = "failed"
+			return
+		progress['done'].append(store_id)
+	# the src store removes the moved shards
+	progress['step'] = "meta"
+	for store_id in set(ec_meta['stores']):
+		result = store_client.storeECMeta(STORE_TO_IP[store_id], ec_meta)
+		if result is None or result['ret'] != 1:
+			progress['status'] = "failed"
+			return
+	if not zk_client.setECVolume(volume_id, ec_meta):
+		progress['status'] = "failed"
+		return
+	progress['meta'] = ec_meta
+	# the replicas are useless once the shards are online
+	if drop:
+		progress['step'] = "drop"
+		for store_id in replicas:
+			result = store_client.storeDelVolume(STORE_TO_IP[store_id], volume_id)
+			if result is None or result['ret'] != 1 or not zk_client.delVolumeStore(volume_id, store_id):
+				progress['status'] = "failed"
+				return
+			STORE_VOLUME[store_id] = [vid for vid in STORE_VOLUME[store_id] if str(vid) != str(volume_id)]
+			STORE_INFO[VOLUME_KEY+store_id] -= 1
+	progress['status'] = "done"
+	logger.info("erasure code volume success, volume_id: %d, stores: %s", volume_id, ec_meta['stores'])
+
+
+@app.route('/bfsops/ec', methods = ["POST"])
+#@login_required
+def bfsopsECPost():
+	if not request.json:
+		abort(400)
+
+	try:
+		volume_id = int(request.json['volume'])
+		k = int(request.json['k'])
+		m = int(request.json['m'])
+		base_dir = request.json['dir'].encode('utf-8')
+		drop = bool(request.json.get('drop', False))
+	except BaseException, e:
+		logger.warn('Exception:%s', str(e))
+		abort(400)
+
+	if EC.has_key(volume_id) and EC[volume_id]['status'] == "running":
+		return jsonify(status="failed", errorMsg="volume in encoding")
+	replicas = volumeStores(volume_id)
+	if len(replicas) == 0:
+		return jsonify(status="failed", errorMsg="volume not exist")
+
+	EC[volume_id] = {'src_store': replicas[0], 'status': "running", 'step': "", 'done': []}
+	thread = threading.Thread(target=ecVolume, args=(volume_id, k, m, base_dir, drop))
+	thread.daemon = True
+	thread.start()
+	logger.info("bfsopsECPost() called, volume_id: %d, k: %d, m: %d, src store: %s", volume_id, k, m, replicas[0])
+	return jsonify(status="ok", errorMsg="", content=EC[volume_id])
+
+
+@app.route('/bfsops/ec', methods = ["GET"])
+#@login_required
+def bfsopsECGet():
+	resp = {}
+	resp['status'] = "ok"
+	resp['errorMsg'] = ""
+	resp['content'] = EC
+	return json.dumps(resp)
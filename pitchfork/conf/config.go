@@ -10,17 +10,27 @@ import (
 type Config struct {
 	Store     *Store
 	Zookeeper *Zookeeper
+	Directory *Directory
 }
 
 type Store struct {
 	StoreCheckInterval  duration
 	NeedleCheckInterval duration
 	RackCheckInterval   duration
+	RepairInterval      duration
+	RepairRate          float64
+}
+
+// Directory the directory checked before the anti-entropy copies a needle,
+// no copy if not configured.
+type Directory struct {
+	Addr string
 }
 
 type Zookeeper struct {
 	VolumeRoot    string
 	StoreRoot     string
+	GroupRoot     string
 	PitchforkRoot string
 	Addrs         []string
 	Timeout       duration
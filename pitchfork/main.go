@@ -34,6 +34,7 @@ func main() {
 	}
 	log.Infof("starts probe stores...")
 	go p.Probe()
+	go p.Repair()
 	StartSignal()
 	return
 }
@@ -185,6 +185,7 @@ func (p *Pitchfork) checkHealth(store *meta.Store, stop chan struct{}) {
 			break
 		}
 		storeReadOnly := true
+		storeRepair := false
 		status = store.Status
 		store.Status = meta.StoreStatusHealth
 		for i = 0; i < _retryCount; i++ {
@@ -195,11 +196,21 @@ func (p *Pitchfork) checkHealth(store *meta.Store, stop chan struct{}) {
 		}
 		if err == nil {
 			for _, volume = range volumes {
+				if volume.Repair {
+					log.Warningf("store: %s volume: %d corrupt needles: %v, need repair", store.Id, volume.Id, volume.Corrupts)
+					storeRepair = true
+				}
+				// the volume of a failed disk is offline, the store routes
+				// around it, the other volumes are still healthy
+				if volume.Status == meta.DiskStatusFail {
+					log.Warningf("store: %s volume: %d offline, block.lastErr: %v", store.Id, volume.Id, volume.Block.LastErr)
+					continue
+				}
 				if volume.Block.LastErr != nil {
 					log.Infof("get store block.lastErr:%s host:%s", volume.Block.LastErr, store.Stat)
 					store.Status = meta.StoreStatusFail
 					break
-				} else if !volume.Block.Full() {
+				} else if volume.Status == meta.DiskStatusHealth && !volume.Block.Full() {
 					log.Infof("block: %s, offset: %d", volume.Block.File, volume.Block.Offset)
 					storeReadOnly = false
 				}
@@ -214,6 +225,10 @@ func (p *Pitchfork) checkHealth(store *meta.Store, stop chan struct{}) {
 		if storeReadOnly {
 			store.Status = meta.StoreStatusRead
 		}
+		// downgrade the store with corrupt needles until repaired
+		if storeRepair && store.Status == meta.StoreStatusHealth {
+			store.Status = meta.StoreStatusRead
+		}
 		if status != store.Status {
 			if err = p.zk.SetStore(store); err != nil {
 				log.Errorf("update store zk status failed, retry")
@@ -246,6 +261,9 @@ func (p *Pitchfork) checkNeedles(store *meta.Store, stop chan struct{}) {
 		}
 		status = store.Status
 		for _, volume = range volumes {
+			if volume.Status == meta.DiskStatusFail {
+				continue
+			}
 			if err = volume.Block.LastErr; err != nil {
 				// ignore volume error
 				break
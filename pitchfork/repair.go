@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+	"golang.org/x/time/rate"
+)
+
+const (
+	_directoryUnreferencedAPI = "http://%s/unreferenced"
+	// the keys of a directory lookup
+	_unreferencedBatch = 1000
+)
+
+var (
+	_client = &http.Client{Timeout: 10 * time.Second}
+)
+
+// repairStat the anti-entropy result of a volume.
+type repairStat struct {
+	copied    int
+	deleted   int
+	conflicts int
+	corrupts  int
+}
+
+// Repair anti-entropy main flow, compare the replicas of every volume of the
+// groups owned by this pitchfork and repair the diverged needles.
+func (p *Pitchfork) Repair() {
+	var l *rate.Limiter
+	if p.config.Store.RepairInterval.Duration <= 0 {
+		log.Infof("anti-entropy repair disabled")
+		return
+	}
+	l = rate.NewLimiter(rate.Limit(p.config.Store.RepairRate), 1)
+	if p.config.Directory == nil || p.config.Directory.Addr == "" {
+		log.Warningf("no [directory] configured, the needles missing on some replicas are not copied")
+	}
+	for {
+		time.Sleep(p.config.Store.RepairInterval.Duration)
+		log.Infof("anti-entropy repair start")
+		p.repair(l)
+		log.Infof("anti-entropy repair stop")
+	}
+}
+
+// repair repair the groups owned by this pitchfork, a group is owned by the
+// pitchfork which index equals the group index mod the pitchforks number.
+func (p *Pitchfork) repair(l *rate.Limiter) {
+	var (
+		i, idx     int
+		err        error
+		group      string
+		groups     []string
+		pitchforks []string
+		stores     map[string]*meta.Store
+	)
+	if pitchforks, err = p.zk.Pitchforks(); err != nil {
+		return
+	}
+	sort.Strings(pitchforks)
+	if idx = sort.SearchStrings(pitchforks, p.ID); idx == len(pitchforks) || pitchforks[idx] != p.ID {
+		log.Errorf("pitchfork: %s not registered", p.ID)
+		return
+	}
+	if groups, err = p.zk.Groups(); err != nil {
+		return
+	}
+	sort.Strings(groups)
+	if stores, err = p.stores(); err != nil {
+		return
+	}
+	for i, group = range groups {
+		if i%len(pitchforks) == idx {
+			p.repairGroup(group, stores, l)
+		}
+	}
+}
+
+// stores get all the stores by id.
+func (p *Pitchfork) stores() (sm map[string]*meta.Store, err error) {
+	var (
+		rack, store   string
+		racks, stores []string
+		data          []byte
+		storeMeta     *meta.Store
+	)
+	if racks, err = p.zk.Racks(); err != nil {
+		return
+	}
+	sm = make(map[string]*meta.Store)
+	for _, rack = range racks {
+		if stores, err = p.zk.Stores(rack); err != nil {
+			return
+		}
+		for _, store = range stores {
+			if data, err = p.zk.Store(rack, store); err != nil {
+				return
+			}
+			storeMeta = new(meta.Store)
+			if err = json.Unmarshal(data, storeMeta); err != nil {
+				log.Errorf("json.Unmarshal() error(%v)", err)
+				return
+			}
+			sm[storeMeta.Id] = storeMeta
+		}
+	}
+	return
+}
+
+// repairGroup repair every volume of a group which has more than one replica,
+// the group is skipped when any store unavailable, for a missing needle can
+// not be told from an unreachable one.
+func (p *Pitchfork) repairGroup(group string, sm map[string]*meta.Store, l *rate.Limiter) {
+	var (
+		ok      bool
+		err     error
+		id      string
+		ids     []string
+		vid     int32
+		store   *meta.Store
+		stores  []*meta.Store
+		volume  *meta.Volume
+		volumes []*meta.Volume
+		vm      = make(map[int32][]*meta.Store)
+		vvm     = make(map[int32][]*meta.Volume)
+	)
+	if ids, err = p.zk.GroupStores(group); err != nil {
+		return
+	}
+	sort.Strings(ids)
+	for _, id = range ids {
+		if store, ok = sm[id]; !ok {
+			log.Errorf("group: %s store: %s not exist, skip repair", group, id)
+			return
+		}
+		if volumes, err = store.Info(); err != nil {
+			log.Errorf("group: %s store: %s info error(%v), skip repair", group, id, err)
+			return
+		}
+		for _, volume = range volumes {
+			vm[volume.Id] = append(vm[volume.Id], store)
+			vvm[volume.Id] = append(vvm[volume.Id], volume)
+		}
+	}
+	for vid, stores = range vm {
+		if len(stores) > 1 {
+			p.repairVolume(vid, stores, vvm[vid], l)
+		}
+	}
+}
+
+// repairVolume first rewrite the corrupt needles found by the store scrubber,
+// then compare the volume digests of the stores, merge the needles of the
+// diverged buckets and repair:
+// deleted on any store: delete from the others.
+// live on some stores, missing on the others: copy from a live store.
+// live with different size: conflict, only report it.
+func (p *Pitchfork) repairVolume(vid int32, stores []*meta.Store, volumes []*meta.Volume, l *rate.Limiter) {
+	var (
+		i, bucket int
+		err       error
+		d         *meta.Digest
+		ds        = make([]*meta.Digest, len(stores))
+		bm        = make(map[int]struct{})
+		st        = new(repairStat)
+	)
+	p.repairCorrupts(vid, stores, volumes, l, st)
+	for i = 0; i < len(stores); i++ {
+		if ds[i], err = stores[i].Digest(vid); err != nil {
+			log.Errorf("store: %s volume: %d digest error(%v), skip repair", stores[i].Id, vid, err)
+			return
+		}
+	}
+	for _, d = range ds[1:] {
+		for _, bucket = range ds[0].Diff(d) {
+			bm[bucket] = struct{}{}
+		}
+	}
+	if len(bm) == 0 && st.corrupts == 0 {
+		return
+	}
+	for bucket = range bm {
+		if err = p.repairBucket(vid, bucket, stores, l, st); err != nil {
+			log.Errorf("volume: %d bucket: %d repair error(%v)", vid, bucket, err)
+		}
+	}
+	log.Infof("volume: %d corrupts: %d, diverged buckets: %d, copied: %d, deleted: %d, conflicts: %d", vid, st.corrupts, len(bm), st.copied, st.deleted, st.conflicts)
+}
+
+// repairCorrupts copy the corrupt needles from a store which has a sound one,
+// the rewritten needle clears the corrupt mark; if it's deleted on the sound
+// store, delete it.
+func (p *Pitchfork) repairCorrupts(vid int32, stores []*meta.Store, volumes []*meta.Volume, l *rate.Limiter, st *repairStat) {
+	var (
+		i, j   int
+		ok     bool
+		err    error
+		key    int64
+		dn     *meta.DigestNeedle
+		dns    []*meta.DigestNeedle
+		needle *meta.Needle
+		cms    = make([]map[int64]struct{}, len(volumes))
+	)
+	for i = 0; i < len(volumes); i++ {
+		cms[i] = make(map[int64]struct{}, len(volumes[i].Corrupts))
+		for _, key = range volumes[i].Corrupts {
+			cms[i][key] = struct{}{}
+		}
+	}
+	for i = 0; i < len(volumes); i++ {
+		for _, key = range volumes[i].Corrupts {
+			for j = 0; j < len(volumes); j++ {
+				if _, ok = cms[j][key]; !ok {
+					break
+				}
+			}
+			if j == len(volumes) {
+				log.Errorf("volume: %d key: %d corrupt on all stores, can't repair", vid, key)
+				continue
+			}
+			if dns, err = stores[j].DigestNeedles(vid, meta.DigestBucket(key)); err != nil {
+				log.Errorf("store: %s volume: %d key: %d digest needles error(%v)", stores[j].Id, vid, key, err)
+				continue
+			}
+			dn = nil
+			for _, dn = range dns {
+				if dn.Key == key {
+					break
+				}
+			}
+			if dn == nil || dn.Key != key {
+				log.Errorf("store: %s volume: %d key: %d not exist, can't repair", stores[j].Id, vid, key)
+				continue
+			}
+			p.wait(l)
+			if dn.Del {
+				err = stores[i].Delete(vid, key)
+			} else {
+				needle = &meta.Needle{Key: key, Cookie: dn.Cookie, Vid: vid}
+				err = stores[j].Copy(stores[i], vid, needle)
+			}
+			if err != nil {
+				log.Errorf("store: %s -> store: %s volume: %d key: %d repair corrupt error(%v)", stores[j].Id, stores[i].Id, vid, key, err)
+				continue
+			}
+			log.Infof("store: %s -> store: %s volume: %d key: %d corrupt repaired", stores[j].Id, stores[i].Id, vid, key)
+			st.corrupts++
+		}
+	}
+}
+
+// repairBucket repair the needles of a digest bucket.
+func (p *Pitchfork) repairBucket(vid int32, bucket int, stores []*meta.Store, l *rate.Limiter, st *repairStat) (err error) {
+	var (
+		i, j    int
+		ok      bool
+		del     bool
+		key     int64
+		src     *meta.DigestNeedle
+		dn      *meta.DigestNeedle
+		dns     []*meta.DigestNeedle
+		needle  *meta.Needle
+		copies  []int64
+		orphans []int64
+		nms     = make([]map[int64]*meta.DigestNeedle, len(stores))
+		keys    = make(map[int64]struct{})
+		srcs    = make(map[int64]int) // key:the index of the source store
+	)
+	for i = 0; i < len(stores); i++ {
+		if dns, err = stores[i].DigestNeedles(vid, bucket); err != nil {
+			return
+		}
+		nms[i] = make(map[int64]*meta.DigestNeedle, len(dns))
+		for _, dn = range dns {
+			nms[i][dn.Key] = dn
+			keys[dn.Key] = struct{}{}
+		}
+	}
+	for key = range keys {
+		del, src = false, nil
+		for i = 0; i < len(stores); i++ {
+			if dn, ok = nms[i][key]; ok && dn.Del {
+				del = true
+				break
+			}
+		}
+		if del {
+			for i = 0; i < len(stores); i++ {
+				if dn, ok = nms[i][key]; !ok || dn.Del {
+					continue
+				}
+				p.wait(l)
+				if err = stores[i].Delete(vid, key); err != nil {
+					log.Errorf("store: %s volume: %d key: %d delete error(%v)", stores[i].Id, vid, key, err)
+					continue
+				}
+				log.Infof("store: %s volume: %d key: %d deleted", stores[i].Id, vid, key)
+				st.deleted++
+			}
+			continue
+		}
+		for i = 0; i < len(stores); i++ {
+			if dn, ok = nms[i][key]; !ok {
+				continue
+			}
+			if src == nil {
+				src, j = dn, i
+			} else if src.Size != dn.Size {
+				log.Errorf("volume: %d key: %d conflict, store: %s size: %d, store: %s size: %d", vid, key, stores[j].Id, src.Size, stores[i].Id, dn.Size)
+				st.conflicts++
+				src = nil
+				break
+			}
+		}
+		if src != nil {
+			srcs[key] = j
+		}
+	}
+	if len(srcs) == 0 {
+		return nil
+	}
+	// a replica may have compacted the tombstone of a deleted needle which
+	// another one missed, only the needles of the existing files are copied
+	for key = range srcs {
+		copies = append(copies, key)
+	}
+	if orphans, err = p.unreferenced(copies); err != nil {
+		return
+	}
+	for _, key = range orphans {
+		log.Warningf("volume: %d key: %d referenced by no file, not copied", vid, key)
+		delete(srcs, key)
+	}
+	for key, j = range srcs {
+		needle = &meta.Needle{Key: key, Cookie: nms[j][key].Cookie, Vid: vid}
+		for i = 0; i < len(stores); i++ {
+			if _, ok = nms[i][key]; ok {
+				continue
+			}
+			p.wait(l)
+			if err = stores[j].Copy(stores[i], vid, needle); err != nil {
+				log.Errorf("store: %s -> store: %s volume: %d key: %d copy error(%v)", stores[j].Id, stores[i].Id, vid, key, err)
+				continue
+			}
+			log.Infof("store: %s -> store: %s volume: %d key: %d copied", stores[j].Id, stores[i].Id, vid, key)
+			st.copied++
+		}
+	}
+	err = nil
+	return
+}
+
+// unreferenced get the keys referenced by no file from the directory, all
+// the keys if no directory configured.
+func (p *Pitchfork) unreferenced(keys []int64) (orphans []int64, err error) {
+	var (
+		i, j   int
+		key    int64
+		strs   []string
+		resp   *http.Response
+		body   []byte
+		res    meta.UnreferencedResponse
+		params url.Values
+		uri    string
+	)
+	if p.config.Directory == nil || p.config.Directory.Addr == "" {
+		return keys, nil
+	}
+	uri = fmt.Sprintf(_directoryUnreferencedAPI, p.config.Directory.Addr)
+	for i = 0; i < len(keys); i = j {
+		if j = i + _unreferencedBatch; j > len(keys) {
+			j = len(keys)
+		}
+		strs = strs[:0]
+		for _, key = range keys[i:j] {
+			strs = append(strs, strconv.FormatInt(key, 10))
+		}
+		params = url.Values{}
+		params.Set("keys", strings.Join(strs, ","))
+		if resp, err = _client.PostForm(uri, params); err != nil {
+			log.Errorf("_client.PostForm(%s) error(%v)", uri, err)
+			return
+		}
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.Errorf("ioutil.ReadAll() error(%v)", err)
+			return
+		}
+		if resp.StatusCode != http.StatusOK {
+			log.Errorf("_client.PostForm(%s) status: %d", uri, resp.StatusCode)
+			return nil, errors.ErrInternal
+		}
+		res = meta.UnreferencedResponse{}
+		if err = json.Unmarshal(body, &res); err != nil {
+			log.Errorf("json.Unmarshal(\"%s\") error(%v)", body, err)
+			return
+		}
+		if res.Ret != errors.RetOK {
+			log.Errorf("directory unreferenced ret: %d", res.Ret)
+			return nil, errors.Error(res.Ret)
+		}
+		orphans = append(orphans, res.Keys...)
+	}
+	return
+}
+
+// wait wait the rate limiter.
+func (p *Pitchfork) wait(l *rate.Limiter) {
+	time.Sleep(l.Reserve().Delay())
+}
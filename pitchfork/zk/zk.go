@@ -111,6 +111,39 @@ func (z *Zookeeper) Stores(rack string) (stores []string, err error) {
 	return
 }
 
+// Racks get all the rack nodes.
+func (z *Zookeeper) Racks() (racks []string, err error) {
+	if racks, _, err = z.c.Children(z.config.Zookeeper.StoreRoot); err != nil {
+		log.Errorf("zk.Children(\"%s\") error(%v)", z.config.Zookeeper.StoreRoot, err)
+	}
+	return
+}
+
+// Pitchforks get all the pitchfork nodes.
+func (z *Zookeeper) Pitchforks() (nodes []string, err error) {
+	if nodes, _, err = z.c.Children(z.config.Zookeeper.PitchforkRoot); err != nil {
+		log.Errorf("zk.Children(\"%s\") error(%v)", z.config.Zookeeper.PitchforkRoot, err)
+	}
+	return
+}
+
+// Groups get all the group nodes.
+func (z *Zookeeper) Groups() (groups []string, err error) {
+	if groups, _, err = z.c.Children(z.config.Zookeeper.GroupRoot); err != nil {
+		log.Errorf("zk.Children(\"%s\") error(%v)", z.config.Zookeeper.GroupRoot, err)
+	}
+	return
+}
+
+// GroupStores get the store ids of a group.
+func (z *Zookeeper) GroupStores(group string) (stores []string, err error) {
+	var spath = path.Join(z.config.Zookeeper.GroupRoot, group)
+	if stores, _, err = z.c.Children(spath); err != nil {
+		log.Errorf("zk.Children(\"%s\") error(%v)", spath, err)
+	}
+	return
+}
+
 // Store get a store node data.
 func (z *Zookeeper) Store(rack, store string) (data []byte, err error) {
 	var spath = path.Join(z.config.Zookeeper.StoreRoot, rack, store)
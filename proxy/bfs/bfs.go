@@ -23,10 +23,14 @@ import (
 )
 
 const (
+	// upload stream chunk
+	_chunkSize = 64 * 1024
 	// api
 	_directoryGetApi    = "http://%s/get"
 	_directoryUploadApi = "http://%s/upload"
+	_directorySha1Api   = "http://%s/sha1"
 	_directoryDelApi    = "http://%s/del"
+	_directoryListApi   = "http://%s/list"
 	_storeGetApi        = "http://%s/get"
 	_storeUploadApi     = "http://%s/upload"
 	_storeDelApi        = "http://%s/del"
@@ -52,17 +56,22 @@ var (
 )
 
 type Bfs struct {
-	c *conf.Config
+	c        *conf.Config
+	repairer *Repairer
 }
 
 func New(c *conf.Config) (b *Bfs) {
 	b = &Bfs{}
 	b.c = c
+	b.repairer = NewRepairer(c.RepairLog)
 	return
 }
 
-// Get
-func (b *Bfs) Get(bucket, filename string) (src io.ReadCloser, ctlen int, mtime int64, sha1, mine string, err error) {
+// Get get a file, rng is the http Range header, ifRange is the http If-Range
+// header, crange returns the Content-Range of a partial or unsatisfied range.
+// accept is the http Accept-Encoding header, a compressed file is returned as
+// is if accepted, encoding returns the Content-Encoding of it.
+func (b *Bfs) Get(bucket, filename, rng, ifRange, accept string) (src io.ReadCloser, ctlen int, mtime int64, sha1, mine, crange, encoding string, err error) {
 	var (
 		i, ix, l int
 		uri      string
@@ -90,6 +99,9 @@ func (b *Bfs) Get(bucket, filename string) (src io.ReadCloser, ctlen int, mtime
 	mtime = res.MTime
 	sha1 = res.Sha1
 	mine = res.Mine
+	if !meta.IfRange(ifRange, sha1, mtime) {
+		rng = ""
+	}
 	params = url.Values{}
 	l = len(res.Stores)
 	ix = _rand.Intn(l)
@@ -101,6 +113,12 @@ func (b *Bfs) Get(bucket, filename string) (src io.ReadCloser, ctlen int, mtime
 		if req, err = http.NewRequest("GET", uri, nil); err != nil {
 			continue
 		}
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		if accept != "" {
+			req.Header.Set("Accept-Encoding", accept)
+		}
 		td := _timer.Start(5*time.Second, func() {
 			_canceler(req)
 		})
@@ -109,16 +127,27 @@ func (b *Bfs) Get(bucket, filename string) (src io.ReadCloser, ctlen int, mtime
 			continue
 		}
 		td.Stop()
-		if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			crange = resp.Header.Get("Content-Range")
+			resp.Body.Close()
+			break
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
 			resp.Body.Close()
 			continue
 		}
+		if resp.StatusCode == http.StatusPartialContent {
+			crange = resp.Header.Get("Content-Range")
+		}
 		src = resp.Body
 		ctlen = int(resp.ContentLength)
+		encoding = resp.Header.Get("Content-Encoding")
 		break
 	}
 	if err == nil {
-		if resp.StatusCode == http.StatusServiceUnavailable {
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			err = errors.ErrRangeNotSatisfiable
+		} else if resp.StatusCode == http.StatusServiceUnavailable {
 			err = errors.ErrStoreNotAvailable
 		} else if resp.StatusCode == http.StatusNotFound {
 			err = errors.ErrNeedleNotExist
@@ -127,27 +156,45 @@ func (b *Bfs) Get(bucket, filename string) (src io.ReadCloser, ctlen int, mtime
 	return
 }
 
-// Upload
-func (b *Bfs) Upload(bucket, filename, mine, sha1 string, mtime int64, buf []byte) (err error) {
+// Upload upload a file, the data is read from rd once and streamed to all
+// the replica stores concurrently, the write policy decides how many replicas
+// must be written, the failed replicas are repaired async. expire is the unix
+// seconds the file expires at, zero means never. mode is the durability the
+// stores ack after, the default of the stores if empty. codec compresses the
+// file in the stores, no compression if empty. the file references the needle
+// of the same sha1 if the directory dedups, nothing is written. the sha1 of
+// a new needle is put into the directory after the write policy met.
+func (b *Bfs) Upload(bucket, filename, mine, sha1 string, mtime, expire int64, mode, codec string, rd io.Reader, size int64) (rp *Report, err error) {
 	var (
 		params = url.Values{}
 		uri    string
-		host   string
 		res    meta.Response
-		sRet   meta.StoreRet
 	)
 	params.Set("bucket", bucket)
 	params.Set("filename", filename)
 	params.Set("mine", mine)
 	params.Set("sha1", sha1)
 	params.Set("mtime", strconv.FormatInt(mtime, 10))
+	if expire > 0 {
+		params.Set("expire", strconv.FormatInt(expire, 10))
+	}
+	params.Set("size", strconv.FormatInt(size, 10))
 	uri = fmt.Sprintf(_directoryUploadApi, b.c.BfsAddr)
 	if err = Http("POST", uri, params, nil, &res); err != nil {
 		return
 	}
-	if res.Ret != errors.RetOK && res.Ret != errors.RetNeedleExist {
+	if res.Ret != errors.RetOK && res.Ret != errors.RetNeedleExist && res.Ret != errors.RetNeedleDedup {
 		log.Errorf("http.Post directory res.Ret: %d %s", res.Ret, uri)
-		err = errors.ErrInternal
+		if res.Ret == errors.RetBucketNotExist {
+			// deleted, the proxy not reloaded yet
+			err = errors.ErrBucketNotExist
+		} else {
+			err = errors.ErrInternal
+		}
+		return
+	}
+	if res.Ret == errors.RetNeedleDedup {
+		log.Infof("bfs.upload bucket:%s filename:%s dedup key:%d cookie:%d vid:%d", bucket, filename, res.Key, res.Cookie, res.Vid)
 		return
 	}
 	// same sha1sum.
@@ -157,22 +204,35 @@ func (b *Bfs) Upload(bucket, filename, mine, sha1 string, mtime int64, buf []byt
 	}
 
 	params = url.Values{}
-	for _, host = range res.Stores {
-		params.Set("key", strconv.FormatInt(res.Key, 10))
-		params.Set("cookie", strconv.FormatInt(int64(res.Cookie), 10))
-		params.Set("vid", strconv.FormatInt(int64(res.Vid), 10))
-		uri = fmt.Sprintf(_storeUploadApi, host)
-		if err = Http("POST", uri, params, buf, &sRet); err != nil {
-			return
-		}
-		if sRet.Ret != 1 {
-			log.Errorf("http.Post store sRet.Ret: %d  %s %d %d %d", sRet.Ret, uri, res.Key, res.Cookie, res.Vid)
-			err = errors.ErrInternal
-			return
-		}
+	params.Set("key", strconv.FormatInt(res.Key, 10))
+	params.Set("cookie", strconv.FormatInt(int64(res.Cookie), 10))
+	params.Set("vid", strconv.FormatInt(int64(res.Vid), 10))
+	if expire > 0 {
+		params.Set("expire", strconv.FormatInt(expire, 10))
+	}
+	if mode != "" {
+		params.Set("durability", mode)
+	}
+	if codec != "" {
+		params.Set("compress", codec)
+	}
+	// needle meta, only saved by the v2 blocks
+	params.Set("mine", mine)
+	params.Set("mtime", strconv.FormatInt(mtime, 10))
+	params.Set("filename", filename)
+	rp = storeUpload(res.Stores, params, rd, size)
+	if rp.OK() != len(rp.Stores) {
+		log.Errorf("storeUpload key: %d cookie: %d vid: %d replicas: %s", res.Key, res.Cookie, res.Vid, rp.Detail())
+		b.repairer.Add(RepairUpload, res.Vid, res.Key, res.Cookie, rp)
+	}
+	if !rp.Satisfy(b.c.WritePolicy) {
+		err = errors.ErrReplicaWrite
+		return
 	}
 	if res.Ret == errors.RetNeedleExist {
 		err = errors.ErrNeedleExist
+	} else if expire == 0 {
+		b.putSha1(bucket, filename)
 	}
 	if res.MTime > 0 {
 		mtime = res.MTime
@@ -181,14 +241,32 @@ func (b *Bfs) Upload(bucket, filename, mine, sha1 string, mtime int64, buf []byt
 	return
 }
 
-// Delete
-func (b *Bfs) Delete(bucket, filename string) (err error) {
+// putSha1 put the sha1 of the file written into the directory, the later
+// uploads of the same sha1 reference its needle, a failure only misses the
+// dedup.
+func (b *Bfs) putSha1(bucket, filename string) {
+	var (
+		err    error
+		uri    string
+		res    meta.Response
+		params = url.Values{}
+	)
+	params.Set("bucket", bucket)
+	params.Set("filename", filename)
+	uri = fmt.Sprintf(_directorySha1Api, b.c.BfsAddr)
+	if err = Http("POST", uri, params, nil, &res); err != nil || res.Ret != errors.RetOK {
+		log.Errorf("http.Post directory %s res.Ret: %d error(%v)", uri, res.Ret, err)
+	}
+}
+
+// Delete delete a file from all the replica stores concurrently, the write
+// policy decides how many replicas must be deleted, the failed replicas are
+// repaired async. the needle still referenced by other files is kept.
+func (b *Bfs) Delete(bucket, filename string) (rp *Report, err error) {
 	var (
 		params = url.Values{}
-		host   string
 		uri    string
 		res    meta.Response
-		sRet   meta.StoreRet
 	)
 	params.Set("bucket", bucket)
 	params.Set("filename", filename)
@@ -197,6 +275,10 @@ func (b *Bfs) Delete(bucket, filename string) (err error) {
 		log.Errorf("Delete called Http error(%v)", err)
 		return
 	}
+	if res.Ret == errors.RetNeedleReferenced {
+		log.Infof("bfs.delete bucket:%s filename:%s needle still referenced", bucket, filename)
+		return
+	}
 	if res.Ret != errors.RetOK {
 		log.Errorf("http.Get directory res.Ret: %d %s", res.Ret, uri)
 		if res.Ret == errors.RetNeedleNotExist {
@@ -208,20 +290,46 @@ func (b *Bfs) Delete(bucket, filename string) (err error) {
 	}
 
 	params = url.Values{}
-	for _, host = range res.Stores {
-		params.Set("key", strconv.FormatInt(res.Key, 10))
-		params.Set("vid", strconv.FormatInt(int64(res.Vid), 10))
-		uri = fmt.Sprintf(_storeDelApi, host)
-		if err = Http("POST", uri, params, nil, &sRet); err != nil {
-			log.Errorf("Update called Http error(%v)", err)
-			return
-		}
-		if sRet.Ret != 1 {
-			log.Errorf("Delete store sRet.Ret: %d  %s", sRet.Ret, uri)
-			err = errors.ErrInternal
-			return
-		}
+	params.Set("key", strconv.FormatInt(res.Key, 10))
+	params.Set("vid", strconv.FormatInt(int64(res.Vid), 10))
+	rp = storeDelete(res.Stores, params)
+	if rp.OK() != len(rp.Stores) {
+		log.Errorf("storeDelete key: %d vid: %d replicas: %s", res.Key, res.Vid, rp.Detail())
+		b.repairer.Add(RepairDelete, res.Vid, res.Key, res.Cookie, rp)
+	}
+	if !rp.Satisfy(b.c.WritePolicy) {
+		err = errors.ErrReplicaWrite
+	}
+	return
+}
+
+// List list a page of the files of the bucket, the files of the same common
+// prefix before the delimiter are grouped, the page starts after the marker,
+// max zero means the default page size.
+func (b *Bfs) List(bucket, prefix, delimiter, marker string, max int) (l *meta.FileList, err error) {
+	var (
+		params = url.Values{}
+		uri    string
+		res    meta.ListResponse
+	)
+	params.Set("bucket", bucket)
+	params.Set("prefix", prefix)
+	params.Set("delimiter", delimiter)
+	params.Set("marker", marker)
+	if max > 0 {
+		params.Set("max-keys", strconv.Itoa(max))
+	}
+	uri = fmt.Sprintf(_directoryListApi, b.c.BfsAddr)
+	if err = Http("GET", uri, params, nil, &res); err != nil {
+		log.Errorf("List called Http error(%v)", err)
+		return
+	}
+	if res.Ret != errors.RetOK {
+		log.Errorf("http.Get directory res.Ret: %d %s", res.Ret, uri)
+		err = errors.ErrInternal
+		return
 	}
+	l = &res.FileList
 	return
 }
 
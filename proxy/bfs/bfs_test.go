@@ -1,14 +1,130 @@
 package bfs
 
 import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"bfs/proxy/conf"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
-	"time"
-	//	"fmt"
 )
 
 func TestBfs(t *testing.T) {
+}
+
+func TestDedup(t *testing.T) {
 	var (
-		err error
+		writes int32
+		err    error
+		rp     *Report
+		data   = []byte("bfs")
+		store  = httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&writes, 1)
+			wr.Write([]byte(`{"ret":1}`))
+		}))
+		dir = httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+			var ret = errors.RetNeedleDedup
+			if r.URL.Path == "/del" {
+				ret = errors.RetNeedleReferenced
+			}
+			fmt.Fprintf(wr, `{"ret":%d,"key":1,"cookie":1,"vid":1,"stores":["%s"]}`, ret, strings.TrimPrefix(store.URL, "http://"))
+		}))
+		b = &Bfs{c: &conf.Config{BfsAddr: strings.TrimPrefix(dir.URL, "http://"), WritePolicy: WriteAll}}
 	)
+	defer store.Close()
+	defer dir.Close()
+	if rp, err = b.Upload("test", "1.jpg", "image/jpeg", "sha1", 1, 0, "", "", bytes.NewReader(data), int64(len(data))); err != nil || rp != nil {
+		t.Errorf("Upload() error(%v) report: %v", err, rp)
+		t.FailNow()
+	}
+	if rp, err = b.Delete("test", "1.jpg"); err != nil || rp != nil {
+		t.Errorf("Delete() error(%v) report: %v", err, rp)
+		t.FailNow()
+	}
+	if writes != 0 {
+		t.Errorf("stores written %d times", writes)
+		t.FailNow()
+	}
+}
 
+func TestUploadSha1(t *testing.T) {
+	var (
+		fails int32 = 1
+		sha1s int32
+		err   error
+		data  = []byte("bfs")
+		store = httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+			if atomic.LoadInt32(&fails) == 1 {
+				http.Error(wr, "internal error", http.StatusInternalServerError)
+				return
+			}
+			wr.Write([]byte(`{"ret":1}`))
+		}))
+		dir = httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/sha1" {
+				atomic.AddInt32(&sha1s, 1)
+				wr.Write([]byte(`{"ret":1}`))
+				return
+			}
+			fmt.Fprintf(wr, `{"ret":1,"key":1,"cookie":1,"vid":1,"stores":["%s"]}`, strings.TrimPrefix(store.URL, "http://"))
+		}))
+		b = &Bfs{c: &conf.Config{BfsAddr: strings.TrimPrefix(dir.URL, "http://"), WritePolicy: WriteAll}}
+	)
+	defer store.Close()
+	defer dir.Close()
+	// the sha1 of a failed upload is never put, so never shared
+	if _, err = b.Upload("test", "1.jpg", "image/jpeg", "sha1", 1, 0, "", "", bytes.NewReader(data), int64(len(data))); err != errors.ErrReplicaWrite {
+		t.Errorf("Upload() error(%v)", err)
+		t.FailNow()
+	}
+	if atomic.LoadInt32(&sha1s) != 0 {
+		t.Errorf("sha1 put of a failed upload")
+		t.FailNow()
+	}
+	atomic.StoreInt32(&fails, 0)
+	if _, err = b.Upload("test", "1.jpg", "image/jpeg", "sha1", 1, 0, "", "", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Errorf("Upload() error(%v)", err)
+		t.FailNow()
+	}
+	if atomic.LoadInt32(&sha1s) != 1 {
+		t.Errorf("sha1 put %d times", sha1s)
+		t.FailNow()
+	}
+	// the ttl needle is never shared
+	if _, err = b.Upload("test", "2.jpg", "image/jpeg", "sha1", 1, 100, "", "", bytes.NewReader(data), int64(len(data))); err != nil || atomic.LoadInt32(&sha1s) != 1 {
+		t.Errorf("Upload() error(%v) sha1 put %d times", err, sha1s)
+		t.FailNow()
+	}
+}
+
+func TestList(t *testing.T) {
+	var (
+		err error
+		l   *meta.FileList
+		dir = httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/list" || r.FormValue("bucket") != "test" || r.FormValue("prefix") != "a/" || r.FormValue("delimiter") != "/" || r.FormValue("marker") != "a/1.jpg" || r.FormValue("max-keys") != "2" {
+				wr.Write([]byte(`{"ret":65534}`))
+				return
+			}
+			wr.Write([]byte(`{"ret":1,"files":[{"filename":"a/2.jpg","size":3}],"prefixes":["a/b/"],"truncated":true,"next_marker":"a/b/"}`))
+		}))
+		b = &Bfs{c: &conf.Config{BfsAddr: strings.TrimPrefix(dir.URL, "http://")}}
+	)
+	defer dir.Close()
+	if l, err = b.List("test", "a/", "/", "a/1.jpg", 2); err != nil {
+		t.Errorf("List() error(%v)", err)
+		t.FailNow()
+	}
+	if len(l.Files) != 1 || l.Files[0].Filename != "a/2.jpg" || l.Files[0].Size != 3 || len(l.Prefixes) != 1 || !l.Truncated || l.Marker != "a/b/" {
+		t.Errorf("List() list: %+v", l)
+		t.FailNow()
+	}
+	if _, err = b.List("none", "", "", "", 0); err != errors.ErrInternal {
+		t.Errorf("List() error(%v)", err)
+		t.FailNow()
+	}
 }
@@ -0,0 +1,191 @@
+package bfs
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+const (
+	// repair op
+	RepairUpload = "upload"
+	RepairDelete = "delete"
+
+	_repairQueue = 1024
+	_repairRetry = 3
+	_repairDelay = 5 * time.Second
+)
+
+// Repair is a failed replica write which must be compensated, an upload is
+// repaired by copying the needle from a written replica, a delete is
+// repaired by retrying.
+type Repair struct {
+	Op     string   `json:"op"`
+	Vid    int32    `json:"vid"`
+	Key    int64    `json:"key"`
+	Cookie int32    `json:"cookie"`
+	Srcs   []string `json:"srcs"`
+	Dst    string   `json:"dst"`
+	Retry  int      `json:"retry"`
+}
+
+func (r *Repair) String() string {
+	return fmt.Sprintf("op: %s, vid: %d, key: %d, srcs: %v, dst: %s, retry: %d", r.Op, r.Vid, r.Key, r.Srcs, r.Dst, r.Retry)
+}
+
+// Repairer retry the failed replica writes async, the repair which can not
+// be done is recorded to the repair log for later repair.
+type Repairer struct {
+	ch   chan *Repair
+	lock sync.Mutex
+	file string
+}
+
+// NewRepairer new a repairer, file is the repair log, empty means only log.
+func NewRepairer(file string) (r *Repairer) {
+	r = &Repairer{
+		ch:   make(chan *Repair, _repairQueue),
+		file: file,
+	}
+	go r.repairproc()
+	return
+}
+
+// Add add the failed replicas of a report to repair.
+func (r *Repairer) Add(op string, vid int32, key int64, cookie int32, rp *Report) {
+	var (
+		dst  string
+		srcs = rp.Oks()
+	)
+	// no written replica, nothing to copy from
+	if op == RepairUpload && len(srcs) == 0 {
+		return
+	}
+	for _, dst = range rp.Fails() {
+		r.add(&Repair{Op: op, Vid: vid, Key: key, Cookie: cookie, Srcs: srcs, Dst: dst})
+	}
+}
+
+func (r *Repairer) add(rp *Repair) {
+	select {
+	case r.ch <- rp:
+	default:
+		log.Errorf("repair queue full, record repair: %s", rp)
+		r.record(rp)
+	}
+}
+
+func (r *Repairer) repairproc() {
+	var (
+		err error
+		rp  *Repair
+	)
+	for rp = range r.ch {
+		if err = rp.do(); err == nil {
+			log.Infof("repair: %s ok", rp)
+			continue
+		}
+		log.Errorf("repair: %s error(%v)", rp, err)
+		if rp.Retry++; rp.Retry >= _repairRetry {
+			r.record(rp)
+			continue
+		}
+		r.delay(rp)
+	}
+}
+
+// delay re-add the repair after a backoff.
+func (r *Repairer) delay(rp *Repair) {
+	time.AfterFunc(_repairDelay*time.Duration(rp.Retry), func() {
+		r.add(rp)
+	})
+}
+
+// record append the repair to the repair log as a json line.
+func (r *Repairer) record(rp *Repair) {
+	var (
+		err  error
+		bs   []byte
+		file *os.File
+	)
+	log.Errorf("replica diverged, need repair: %s", rp)
+	if r.file == "" {
+		return
+	}
+	if bs, err = json.Marshal(rp); err != nil {
+		log.Errorf("json.Marshal(%s) error(%v)", rp, err)
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if file, err = os.OpenFile(r.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664); err != nil {
+		log.Errorf("os.OpenFile(\"%s\") error(%v)", r.file, err)
+		return
+	}
+	if _, err = file.Write(append(bs, '\n')); err != nil {
+		log.Errorf("file.Write() error(%v)", err)
+	}
+	file.Close()
+}
+
+func (rp *Repair) do() (err error) {
+	var (
+		src    string
+		params = url.Values{}
+	)
+	params.Set("key", strconv.FormatInt(rp.Key, 10))
+	params.Set("vid", strconv.FormatInt(int64(rp.Vid), 10))
+	if rp.Op == RepairDelete {
+		return storeDel(fmt.Sprintf(_storeDelApi, rp.Dst), params)
+	}
+	params.Set("cookie", strconv.FormatInt(int64(rp.Cookie), 10))
+	err = errors.ErrStoreNotAvailable
+	for _, src = range rp.Srcs {
+		if err = storeCopy(src, rp.Dst, params); err == nil {
+			break
+		}
+	}
+	return
+}
+
+// storeCopy copy a needle from the src store to the dst store, the expire of
+// a ttl needle, the needle meta and the compression are kept.
+func storeCopy(src, dst string, params url.Values) (err error) {
+	var (
+		req  *http.Request
+		resp *http.Response
+		uri  = fmt.Sprintf(_storeGetApi, src) + "?" + params.Encode()
+	)
+	if req, err = http.NewRequest("GET", uri, nil); err != nil {
+		return
+	}
+	// a compressed needle is copied as is
+	req.Header.Set("Accept-Encoding", meta.CodecGzip)
+	td := _timer.Start(5*time.Second, func() {
+		_canceler(req)
+	})
+	if resp, err = _client.Do(req); err != nil {
+		log.Errorf("_client.Do(%s) error(%v)", uri, err)
+		return
+	}
+	td.Stop()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("_client.Do(%s) status: %d", uri, resp.StatusCode)
+		if resp.StatusCode == http.StatusNotFound {
+			return errors.ErrNeedleNotExist
+		}
+		return errors.ErrInternal
+	}
+	meta.CopyParams(resp.Header, params)
+	return storeStream(fmt.Sprintf(_storeUploadApi, dst), params, resp.Body, resp.ContentLength)
+}
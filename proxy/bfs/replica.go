@@ -0,0 +1,259 @@
+package bfs
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+const (
+	// replica write policy
+	// all: every replica must be written.
+	// quorum: most of the replicas must be written.
+	// one: at least one replica written, the others repaired async.
+	WriteAll    = "all"
+	WriteQuorum = "quorum"
+	WriteOne    = "one"
+)
+
+// Report is the per replica result of a write, a nil error means ok.
+type Report struct {
+	Stores []string
+	Errs   []error
+}
+
+// NewReport new a report of the stores.
+func NewReport(stores []string) *Report {
+	return &Report{Stores: stores, Errs: make([]error, len(stores))}
+}
+
+// OK get the written replicas number.
+func (r *Report) OK() (n int) {
+	var err error
+	for _, err = range r.Errs {
+		if err == nil {
+			n++
+		}
+	}
+	return
+}
+
+// Oks get the written replica stores.
+func (r *Report) Oks() (stores []string) {
+	var (
+		i   int
+		err error
+	)
+	for i, err = range r.Errs {
+		if err == nil {
+			stores = append(stores, r.Stores[i])
+		}
+	}
+	return
+}
+
+// Fails get the failed replica stores.
+func (r *Report) Fails() (stores []string) {
+	var (
+		i   int
+		err error
+	)
+	for i, err = range r.Errs {
+		if err != nil {
+			stores = append(stores, r.Stores[i])
+		}
+	}
+	return
+}
+
+// Satisfy check the report satisfies the write policy.
+func (r *Report) Satisfy(policy string) bool {
+	var ok = r.OK()
+	switch policy {
+	case WriteOne:
+		return ok > 0
+	case WriteQuorum:
+		return ok > len(r.Stores)/2
+	default:
+		return ok == len(r.Stores)
+	}
+}
+
+// String get the "ok/total" summary.
+func (r *Report) String() string {
+	return fmt.Sprintf("%d/%d", r.OK(), len(r.Stores))
+}
+
+// Detail get every replica result, used in log.
+func (r *Report) Detail() string {
+	var (
+		i   int
+		err error
+		rs  = make([]string, 0, len(r.Stores))
+	)
+	for i, err = range r.Errs {
+		if err == nil {
+			rs = append(rs, r.Stores[i]+":ok")
+		} else {
+			rs = append(rs, r.Stores[i]+":"+err.Error())
+		}
+	}
+	return strings.Join(rs, ",")
+}
+
+// fanout write to many pipes, a broken pipe is dropped and the others keep
+// going, it fails only when all the pipes broken.
+type fanout struct {
+	pws  []*io.PipeWriter
+	errs []error
+}
+
+func (f *fanout) Write(p []byte) (n int, err error) {
+	var (
+		i    int
+		live int
+		pw   *io.PipeWriter
+	)
+	for i, pw = range f.pws {
+		if f.errs[i] != nil {
+			continue
+		}
+		if _, f.errs[i] = pw.Write(p); f.errs[i] == nil {
+			live++
+		}
+	}
+	if live == 0 {
+		return 0, io.ErrClosedPipe
+	}
+	return len(p), nil
+}
+
+// storeUpload stream the data to all the stores concurrently, every store
+// request reads from its own pipe, the data is read only once and copied
+// into the pipes chunk by chunk, a failed store does not break the others.
+func storeUpload(stores []string, params url.Values, rd io.Reader, size int64) (rp *Report) {
+	var (
+		i     int
+		err   error
+		host  string
+		pr    *io.PipeReader
+		pw    *io.PipeWriter
+		done  = make(chan int, len(stores))
+		f     = &fanout{pws: make([]*io.PipeWriter, len(stores)), errs: make([]error, len(stores))}
+		chunk = make([]byte, _chunkSize)
+	)
+	rp = NewReport(stores)
+	for i, host = range stores {
+		pr, pw = io.Pipe()
+		f.pws[i] = pw
+		go func(i int, uri string, pr *io.PipeReader) {
+			if rp.Errs[i] = storeStream(uri, params, pr, size); rp.Errs[i] != nil {
+				pr.CloseWithError(rp.Errs[i])
+			} else {
+				pr.Close()
+			}
+			done <- i
+		}(i, fmt.Sprintf(_storeUploadApi, host), pr)
+	}
+	if _, err = io.CopyBuffer(f, rd, chunk); err != nil {
+		log.Errorf("storeUpload(%v) copy error(%v)", stores, err)
+	}
+	for _, pw = range f.pws {
+		pw.CloseWithError(err)
+	}
+	for i = 0; i < len(stores); i++ {
+		<-done
+	}
+	return
+}
+
+// storeStream post the raw data to a store upload api.
+func storeStream(uri string, params url.Values, rd io.Reader, size int64) (err error) {
+	var (
+		body []byte
+		req  *http.Request
+		resp *http.Response
+		sRet meta.StoreRet
+	)
+	uri = uri + "?" + params.Encode()
+	if req, err = http.NewRequest("POST", uri, rd); err != nil {
+		return
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	td := _timer.Start(5*time.Second, func() {
+		_canceler(req)
+	})
+	if resp, err = _client.Do(req); err != nil {
+		log.Errorf("_client.Do(%s) error(%v)", uri, err)
+		return
+	}
+	td.Stop()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("_client.Do(%s) status: %d", uri, resp.StatusCode)
+		err = errors.ErrInternal
+		return
+	}
+	if body, err = ioutil.ReadAll(resp.Body); err != nil {
+		log.Errorf("ioutil.ReadAll(%s) uri(%s) error(%v)", body, uri, err)
+		return
+	}
+	if err = json.Unmarshal(body, &sRet); err != nil {
+		log.Errorf("json.Unmarshal(%s) uri(%s) error(%v)", body, uri, err)
+		return
+	}
+	if sRet.Ret != errors.RetOK {
+		log.Errorf("http.Post store sRet.Ret: %d %s", sRet.Ret, uri)
+		err = errors.Error(sRet.Ret)
+	}
+	return
+}
+
+// storeDelete delete a needle from all the stores concurrently.
+func storeDelete(stores []string, params url.Values) (rp *Report) {
+	var (
+		i    int
+		host string
+		done = make(chan int, len(stores))
+	)
+	rp = NewReport(stores)
+	for i, host = range stores {
+		go func(i int, uri string) {
+			rp.Errs[i] = storeDel(uri, params)
+			done <- i
+		}(i, fmt.Sprintf(_storeDelApi, host))
+	}
+	for i = 0; i < len(stores); i++ {
+		<-done
+	}
+	return
+}
+
+// storeDel delete a needle from a store, a needle not exist or already
+// deleted is ok, delete is idempotent.
+func storeDel(uri string, params url.Values) (err error) {
+	var sRet meta.StoreRet
+	if err = Http("POST", uri, params, nil, &sRet); err != nil {
+		log.Errorf("Delete called Http error(%v)", err)
+		return
+	}
+	if sRet.Ret != errors.RetOK && sRet.Ret != errors.RetNeedleNotExist && sRet.Ret != errors.RetNeedleDeleted {
+		log.Errorf("Delete store sRet.Ret: %d  %s", sRet.Ret, uri)
+		if sRet.Ret == 0 {
+			err = errors.ErrInternal
+		} else {
+			err = errors.Error(sRet.Ret)
+		}
+	}
+	return
+}
@@ -0,0 +1,122 @@
+package bfs
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	var rp = NewReport([]string{"s1", "s2", "s3"})
+	rp.Errs[1] = errors.ErrInternal
+	if rp.OK() != 2 {
+		t.Errorf("rp.OK(): %d != 2", rp.OK())
+		t.FailNow()
+	}
+	if rp.Satisfy(WriteAll) {
+		t.Error("2/3 must not satisfy all")
+		t.FailNow()
+	}
+	if !rp.Satisfy(WriteQuorum) || !rp.Satisfy(WriteOne) {
+		t.Error("2/3 must satisfy quorum and one")
+		t.FailNow()
+	}
+	rp.Errs[2] = errors.ErrInternal
+	if rp.Satisfy(WriteQuorum) || !rp.Satisfy(WriteOne) {
+		t.Error("1/3 must only satisfy one")
+		t.FailNow()
+	}
+	if fails := rp.Fails(); len(fails) != 2 || fails[0] != "s2" || fails[1] != "s3" {
+		t.Errorf("rp.Fails(): %v not match", fails)
+		t.FailNow()
+	}
+	if rp.String() != "1/3" {
+		t.Errorf("rp.String(): %s not match", rp.String())
+		t.FailNow()
+	}
+}
+
+func TestStoreUpload(t *testing.T) {
+	var (
+		lock sync.Mutex
+		got  [][]byte
+		data = bytes.Repeat([]byte("bfs"), _chunkSize)
+		ok   = httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			lock.Lock()
+			got = append(got, body)
+			lock.Unlock()
+			wr.Write([]byte(`{"ret":1}`))
+		}))
+		bad = httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+			wr.Write([]byte(`{"ret":8001}`))
+		}))
+		s1 = strings.TrimPrefix(ok.URL, "http://")
+		s2 = strings.TrimPrefix(bad.URL, "http://")
+		rp *Report
+	)
+	defer ok.Close()
+	defer bad.Close()
+	rp = storeUpload([]string{s1, s2, s1}, url.Values{}, bytes.NewReader(data), int64(len(data)))
+	if rp.OK() != 2 || rp.Errs[1] != errors.ErrVolumeNotExist {
+		t.Errorf("storeUpload() report: %s not match", rp.Detail())
+		t.FailNow()
+	}
+	if len(got) != 2 || !bytes.Equal(got[0], data) || !bytes.Equal(got[1], data) {
+		t.Error("storeUpload() data not match")
+		t.FailNow()
+	}
+}
+
+func TestStoreCopy(t *testing.T) {
+	var (
+		err    error
+		got    url.Values
+		mtime  = time.Unix(1476700000, 0)
+		params = url.Values{}
+		src    = httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+			// the needle 2 has no meta
+			wr.Header()["Content-Type"] = nil
+			if r.FormValue("key") == "1" {
+				wr.Header().Set("Content-Type", "image/png")
+				wr.Header().Set("Last-Modified", mtime.UTC().Format(http.TimeFormat))
+				wr.Header().Set("Content-Disposition", meta.FormatDisposition("a/1.png"))
+				wr.Header().Set("Expires", meta.FormatExpires(1476800000))
+			}
+			wr.Write([]byte("bfs"))
+		}))
+		dst = httptest.NewServer(http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+			got = r.URL.Query()
+			wr.Write([]byte(`{"ret":1}`))
+		}))
+	)
+	defer src.Close()
+	defer dst.Close()
+	params.Set("key", "1")
+	if err = storeCopy(strings.TrimPrefix(src.URL, "http://"), strings.TrimPrefix(dst.URL, "http://"), params); err != nil {
+		t.Errorf("storeCopy() error(%v)", err)
+		t.FailNow()
+	}
+	if got.Get("mine") != "image/png" || got.Get("mtime") != "1476700000000000000" || got.Get("filename") != "a/1.png" || got.Get("expire") != "1476800000" {
+		t.Errorf("storeCopy() params: %v not match", got)
+		t.FailNow()
+	}
+	// the params of the last copy are not kept
+	params.Set("key", "2")
+	if err = storeCopy(strings.TrimPrefix(src.URL, "http://"), strings.TrimPrefix(dst.URL, "http://"), params); err != nil {
+		t.Errorf("storeCopy() error(%v)", err)
+		t.FailNow()
+	}
+	if got.Get("mine") != "" || got.Get("mtime") != "" || got.Get("filename") != "" || got.Get("expire") != "" {
+		t.Errorf("storeCopy() params: %v not match", got)
+		t.FailNow()
+	}
+}
@@ -1,24 +1,40 @@
 package bucket
 
 import (
+	"encoding/json"
 	"fmt"
+	"path"
+	"sync"
+	"time"
 
 	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"bfs/proxy/conf"
+
+	log "github.com/golang/glog"
+	"github.com/samuel/go-zookeeper/zk"
 )
 
 const (
-	// status bit
-	_privateReadBit  = 0
-	_privateWriteBit = 1
-	// status
-	_public           = int(0)
-	_privateRead      = int(1 << _privateReadBit)
-	_privateWrite     = int(1 << _privateWriteBit)
-	_privateReadWrite = int(_privateRead | _privateWrite)
+	_retrySleep = time.Second
 )
 
-// bucket_name  property  key_id  key_secret
+var (
+	// the built-in bucket if the buckets are not managed
+	_testBucket = &Item{
+		Name:      "test",
+		KeyId:     "221bce6492eba70f",
+		KeySecret: "6eb80603e85842542f9736eb13b7e3",
+		property:  meta.BucketPrivateWrite,
+	}
+)
+
+// Bucket the buckets loaded from zookeeper, reloaded when the bucket root
+// is touched by the directory.
 type Bucket struct {
+	c    *conf.Zookeeper
+	zk   *zk.Conn
+	lock sync.RWMutex
 	data map[string]*Item
 }
 
@@ -32,20 +48,43 @@ type Item struct {
 	KeySecret string
 	Domain    string
 	Header    *Header
+	// the default durability of the uploads, see meta.Durability*, empty
+	// means the default of the stores
+	Durability string
+	// the codec compresses the uploads of the compressible content types,
+	// see meta.Codec*, empty means no compression
+	Compress string
 	// property   第0位：读 (0表示共有，1表示私有)  第1位：写 (0表示共有，1表示私有)
 	property int
 }
 
+// NewItem new a item of the bucket meta.
+func NewItem(b *meta.Bucket) (item *Item) {
+	item = &Item{
+		Name:       b.Name,
+		KeyId:      b.KeyId,
+		KeySecret:  b.KeySecret,
+		Domain:     b.Domain,
+		Durability: b.Durability,
+		Compress:   b.Compress,
+		property:   b.Property,
+	}
+	if b.CacheControl > 0 {
+		item.Header = &Header{CacheControl: b.CacheControl}
+	}
+	return
+}
+
 func (i *Item) String() string {
 	return fmt.Sprintf("{name: %s, property: %d}", i.Name, i.property)
 }
 
 func (i *Item) writePublic() bool {
-	return i.property&_privateWrite == 0
+	return i.property&meta.BucketPrivateWrite == 0
 }
 
 func (i *Item) readPublic() bool {
-	return i.property&_privateRead == 0
+	return i.property&meta.BucketPrivateRead == 0
 }
 
 // Public check the item is public or not.
@@ -56,25 +95,111 @@ func (i *Item) Public(read bool) bool {
 	return i.writePublic()
 }
 
-// New a bucket.
-func New() (b *Bucket, err error) {
-	var item *Item
-	b = new(Bucket)
-	b.data = make(map[string]*Item)
-	// bucket test
-	item = new(Item)
-	item.Name = "test"
-	item.property = _privateWrite
-	item.KeyId = "221bce6492eba70f"
-	item.KeySecret = "6eb80603e85842542f9736eb13b7e3"
-	b.data[item.Name] = item
+// New a bucket, the buckets are loaded before return. if no [zookeeper]
+// configured, only the built-in bucket test exists.
+func New(c *conf.Config) (b *Bucket, err error) {
+	var (
+		s  <-chan zk.Event
+		ev <-chan zk.Event
+	)
+	if c.Zookeeper == nil {
+		log.Warning("no [zookeeper] of the buckets configured, only the built-in bucket test")
+		b = &Bucket{data: make(map[string]*Item)}
+		b.data[_testBucket.Name] = _testBucket
+		return
+	}
+	b = &Bucket{c: c.Zookeeper, data: make(map[string]*Item)}
+	if b.zk, s, err = zk.Connect(c.Zookeeper.Addrs, time.Duration(c.Zookeeper.Timeout)); err != nil {
+		log.Errorf("zk.Connect(\"%v\") error(%v)", c.Zookeeper.Addrs, err)
+		return
+	}
+	go func() {
+		var e zk.Event
+		for {
+			if e = <-s; e.Type == 0 {
+				return
+			}
+			log.Infof("zookeeper get a event: %s", e.State.String())
+		}
+	}()
+	if ev, err = b.sync(); err != nil {
+		b.zk.Close()
+		return
+	}
+	go b.syncproc(ev)
+	return
+}
+
+// sync load all the buckets and watch the bucket root, no root means no
+// bucket yet, then watch the creation of it.
+func (b *Bucket) sync() (ev <-chan zk.Event, err error) {
+	var (
+		name  string
+		names []string
+		data  []byte
+		spath string
+		mb    *meta.Bucket
+		items = make(map[string]*Item)
+	)
+	if _, _, ev, err = b.zk.GetW(b.c.BucketRoot); err != nil {
+		if err != zk.ErrNoNode {
+			log.Errorf("zk.GetW(\"%s\") error(%v)", b.c.BucketRoot, err)
+			return
+		}
+		if _, _, ev, err = b.zk.ExistsW(b.c.BucketRoot); err != nil {
+			log.Errorf("zk.ExistsW(\"%s\") error(%v)", b.c.BucketRoot, err)
+			return
+		}
+	} else if names, _, err = b.zk.Children(b.c.BucketRoot); err != nil {
+		log.Errorf("zk.Children(\"%s\") error(%v)", b.c.BucketRoot, err)
+		return
+	}
+	for _, name = range names {
+		spath = path.Join(b.c.BucketRoot, name)
+		if data, _, err = b.zk.Get(spath); err != nil {
+			if err == zk.ErrNoNode {
+				err = nil
+				continue
+			}
+			log.Errorf("zk.Get(\"%s\") error(%v)", spath, err)
+			return
+		}
+		mb = new(meta.Bucket)
+		if err = json.Unmarshal(data, mb); err != nil {
+			log.Errorf("json.Unmarshal(%s) error(%v)", data, err)
+			err = nil
+			continue
+		}
+		items[name] = NewItem(mb)
+	}
+	b.lock.Lock()
+	b.data = items
+	b.lock.Unlock()
+	log.Infof("buckets loaded: %d", len(items))
 	return
 }
 
+// syncproc reload the buckets when the bucket root is touched.
+func (b *Bucket) syncproc(ev <-chan zk.Event) {
+	var err error
+	for {
+		<-ev
+		for {
+			if ev, err = b.sync(); err == nil {
+				break
+			}
+			time.Sleep(_retrySleep)
+		}
+	}
+}
+
 // Get get a bucket, if not exist then error.
 func (b *Bucket) Get(name string) (item *Item, err error) {
 	var ok bool
-	if item, ok = b.data[name]; !ok {
+	b.lock.RLock()
+	item, ok = b.data[name]
+	b.lock.RUnlock()
+	if !ok {
 		err = errors.ErrBucketNotExist
 	}
 	return
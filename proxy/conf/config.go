@@ -41,6 +41,19 @@ type Config struct {
 	Mc       *memcache.Config
 	// limit rate
 	Limit *Limit
+	// replica write policy: all, quorum, one
+	WritePolicy string
+	// failed replica repair log
+	RepairLog string
+	// buckets
+	Zookeeper *Zookeeper
+}
+
+// Zookeeper the buckets in zookeeper, watched for the changes.
+type Zookeeper struct {
+	Addrs      []string
+	Timeout    time.Duration
+	BucketRoot string
 }
 
 type Ats struct {
@@ -2,16 +2,14 @@ package main
 
 import (
 	"bfs/libs/errors"
+	"bfs/libs/meta"
 	"bfs/proxy/auth"
 	"bfs/proxy/bfs"
 	ibucket "bfs/proxy/bucket"
 	"bfs/proxy/conf"
-	"crypto/sha1"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
@@ -46,7 +44,7 @@ func StartAPI(c *conf.Config) (err error) {
 	}
 	s.c = c
 	s.bfs = bfs.New(c)
-	if s.bucket, err = ibucket.New(); err != nil {
+	if s.bucket, err = ibucket.New(c); err != nil {
 		return
 	}
 	if s.auth, err = auth.New(c); err != nil {
@@ -96,10 +94,14 @@ func (s *server) do(wr http.ResponseWriter, r *http.Request) {
 		http.Error(wr, "", http.StatusMethodNotAllowed)
 		return
 	}
-	if bucket, file, status = s.parseURI(r, upload); status != http.StatusOK {
+	if bucket, file, status = s.parseURI(r, upload || r.Method == "GET"); status != http.StatusOK {
 		http.Error(wr, "", status)
 		return
 	}
+	// GET /bucket lists the files
+	if file == "" && r.Method == "GET" {
+		h = s.list
+	}
 	if len(file) > _maxFileNameLength {
 		http.Error(wr, "", http.StatusRequestEntityTooLarge)
 		return
@@ -135,8 +137,9 @@ func setCode(wr http.ResponseWriter, status *int) {
 	wr.Header().Set("Code", strconv.Itoa(*status))
 }
 
-// parseURI get uri's bucket and filename.
-func (s *server) parseURI(r *http.Request, upload bool) (bucket, file string, status int) {
+// parseURI get uri's bucket and filename, the filename may be empty if
+// bucketURI.
+func (s *server) parseURI(r *http.Request, bucketURI bool) (bucket, file string, status int) {
 	var b, e int
 	status = http.StatusOK
 	if s.c.Prefix == "" {
@@ -160,7 +163,7 @@ func (s *server) parseURI(r *http.Request, upload bool) (bucket, file string, st
 		bucket = r.URL.Path[b : b+e]
 		file = r.URL.Path[b+e+1:] // skip "/"
 	}
-	if bucket == "" || (file == "" && !upload) {
+	if bucket == "" || (file == "" && !bucketURI) {
 		log.Errorf("parseURI(%s) error, bucket: %s or file: %s empty", r.URL.Path, bucket, file)
 		status = http.StatusBadRequest
 	}
@@ -191,6 +194,8 @@ func (s *server) download(item *ibucket.Item, bucket, file string, wr http.Respo
 		ctlen      int
 		mine       string
 		sha1       string
+		crange     string
+		encoding   string
 		start      = time.Now()
 		src        io.ReadCloser
 		status     = http.StatusOK
@@ -198,8 +203,13 @@ func (s *server) download(item *ibucket.Item, bucket, file string, wr http.Respo
 		bucketItem *ibucket.Item
 	)
 	defer httpLog("download", r.URL.Path, &bucket, &file, start, &status, &err)
-	if src, ctlen, mtime, sha1, mine, err = s.srv.Get(bucket, file); err == nil {
+	if src, ctlen, mtime, sha1, mine, crange, encoding, err = s.srv.Get(bucket, file, r.Header.Get("Range"), r.Header.Get("If-Range"), r.Header.Get("Accept-Encoding")); err == nil {
+		if encoding != "" {
+			wr.Header().Set("Content-Encoding", encoding)
+			wr.Header().Set("Vary", "Accept-Encoding")
+		}
 		wr.Header().Set("Content-Length", strconv.Itoa(ctlen))
+		wr.Header().Set("Accept-Ranges", "bytes")
 		wr.Header().Set("Content-Type", mine)
 		wr.Header().Set("Server", "bfs")
 		wr.Header().Set("Last-Modified", time.Unix(0, mtime).Format(http.TimeFormat))
@@ -212,6 +222,11 @@ func (s *server) download(item *ibucket.Item, bucket, file string, wr http.Respo
 			wr.Header().Set("Cache-Control", "max-age=315360000")
 			wr.Header().Set("Expires", time.Unix(_expires, mtime).Format(http.TimeFormat))
 		}
+		if crange != "" {
+			status = http.StatusPartialContent
+			wr.Header().Set("Content-Range", crange)
+			wr.WriteHeader(status)
+		}
 		if src != nil {
 			if r.Method == "GET" {
 				io.Copy(wr, src)
@@ -221,6 +236,11 @@ func (s *server) download(item *ibucket.Item, bucket, file string, wr http.Respo
 	} else {
 		if err == errors.ErrNeedleNotExist {
 			status = http.StatusNotFound
+		} else if err == errors.ErrRangeNotSatisfiable {
+			status = http.StatusRequestedRangeNotSatisfiable
+			if crange != "" {
+				wr.Header().Set("Content-Range", crange)
+			}
 		} else if err == errors.ErrStoreNotAvailable || err == errors.ErrServiceUnavailable {
 			status = http.StatusServiceUnavailable
 		} else {
@@ -240,12 +260,16 @@ func retCode(wr http.ResponseWriter, status *int) {
 func (s *server) upload(item *ibucket.Item, bucket, file string, wr http.ResponseWriter, r *http.Request) {
 	var (
 		ok       bool
-		body     []byte
+		sp       *spool
+		rp       *bfs.Report
 		mine     string
 		location string
-		sha1sum  string
 		ext      string
-		sha      [sha1.Size]byte
+		str      string
+		ttl      int64
+		expire   int64
+		mode     string
+		codec    string
 		err      error
 		uerr     errors.Error
 		status   = http.StatusOK
@@ -257,32 +281,56 @@ func (s *server) upload(item *ibucket.Item, bucket, file string, wr http.Respons
 		status = http.StatusBadRequest
 		return
 	}
+	// optional ttl in seconds, the file expires after it
+	if str = r.Header.Get("Ttl"); str != "" {
+		if ttl, err = strconv.ParseInt(str, 10, 64); err != nil || ttl <= 0 {
+			log.Errorf("ttl: %s not valid", str)
+			status = http.StatusBadRequest
+			return
+		}
+		expire = start.Unix() + ttl
+	}
+	// optional durability, the default of the bucket if empty
+	if mode = r.Header.Get("Durability"); mode == "" {
+		mode = item.Durability
+	}
+	if !meta.ValidDurability(mode) {
+		log.Errorf("durability: %s not valid", mode)
+		status = http.StatusBadRequest
+		return
+	}
+	// compress the compressible content types of the bucket
+	if item.Compress != "" && meta.Compressible(mine) {
+		codec = item.Compress
+	}
 	if ext = path.Base(mine); ext == "jpeg" {
 		ext = "jpg"
 	}
-	if body, err = ioutil.ReadAll(r.Body); err != nil {
+	if sp, err = newSpool(r.Body, int64(s.c.MaxFileSize)); err != nil {
 		status = http.StatusBadRequest
-		log.Errorf("ioutil.ReadAll(r.Body) error(%s)", err)
+		log.Errorf("newSpool(r.Body) error(%s)", err)
 		return
 	}
+	defer sp.Close()
 	r.Body.Close()
-	length := len(body)
-	if length > s.c.MaxFileSize {
+	if sp.Size > int64(s.c.MaxFileSize) {
 		status = http.StatusRequestEntityTooLarge
 		return
 	}
-	if length == 0 {
+	if sp.Size == 0 {
 		status = http.StatusBadRequest
 		log.Errorf("file size equals 0")
 		return
 	}
-	sha = sha1.Sum(body)
-	sha1sum = hex.EncodeToString(sha[:])
 	// if empty filename or endwith "/": dir
 	if file == "" || strings.HasSuffix(file, "/") {
-		file += sha1sum + "." + ext
+		file += sp.Sha1 + "." + ext
 	}
-	if err = s.srv.Upload(bucket, file, mine, sha1sum, body); err != nil && err != errors.ErrNeedleExist {
+	rp, err = s.srv.Upload(bucket, file, mine, expire, mode, codec, sp)
+	if rp != nil {
+		wr.Header().Set("Replicas", rp.String())
+	}
+	if err != nil && err != errors.ErrNeedleExist {
 		if uerr, ok = (err).(errors.Error); ok {
 			status = int(uerr)
 		} else {
@@ -292,7 +340,7 @@ func (s *server) upload(item *ibucket.Item, bucket, file string, wr http.Respons
 	}
 	location = s.getURI(bucket, file)
 	wr.Header().Set("Location", location)
-	wr.Header().Set("ETag", sha1sum)
+	wr.Header().Set("ETag", sp.Sha1)
 	return
 }
 
@@ -302,11 +350,15 @@ func (s *server) delete(item *ibucket.Item, bucket, file string, wr http.Respons
 		ok     bool
 		err    error
 		uerr   errors.Error
+		rp     *bfs.Report
 		status = http.StatusOK
 		start  = time.Now()
 	)
 	defer httpLog("delete", r.URL.Path, &bucket, &file, start, &status, &err)
-	if err = s.srv.Delete(bucket, file); err != nil {
+	if rp, err = s.srv.Delete(bucket, file); rp != nil {
+		wr.Header().Set("Replicas", rp.String())
+	}
+	if err != nil {
 		if err == errors.ErrNeedleNotExist {
 			status = http.StatusNotFound
 			http.Error(wr, "", status)
@@ -323,6 +375,47 @@ func (s *server) delete(item *ibucket.Item, bucket, file string, wr http.Respons
 	return
 }
 
+// list list the files of the bucket, query prefix, delimiter, marker and
+// max-keys.
+func (s *server) list(item *ibucket.Item, bucket, file string, wr http.ResponseWriter, r *http.Request) {
+	var (
+		max    int
+		str    string
+		data   []byte
+		l      *meta.FileList
+		query  = r.URL.Query()
+		status = http.StatusOK
+		start  = time.Now()
+		err    error
+	)
+	defer httpLog("list", r.URL.Path, &bucket, &file, start, &status, &err)
+	if str = query.Get("max-keys"); str != "" {
+		if max, err = strconv.Atoi(str); err != nil || max <= 0 || max > meta.MaxListKeys {
+			status = http.StatusBadRequest
+			http.Error(wr, "", status)
+			return
+		}
+	}
+	if l, err = s.srv.List(bucket, query.Get("prefix"), query.Get("delimiter"), query.Get("marker"), max); err != nil {
+		if err == errors.ErrServiceUnavailable {
+			status = http.StatusServiceUnavailable
+		} else {
+			status = http.StatusInternalServerError
+		}
+		http.Error(wr, err.Error(), status)
+		return
+	}
+	if data, err = json.Marshal(l); err != nil {
+		status = http.StatusInternalServerError
+		http.Error(wr, "", status)
+		return
+	}
+	wr.Header().Set("Content-Type", "application/json;charset=utf-8")
+	wr.Header().Set("Server", "bfs")
+	wr.Write(data)
+	return
+}
+
 // monitorPing sure program now runs correctly, when return http status 200.
 func (s *server) ping(wr http.ResponseWriter, r *http.Request) {
 	var (
@@ -52,17 +52,29 @@ func (s *Service) cacheproc() {
 	}
 }
 
-// Get get
-func (s *Service) Get(bucket, filename string) (src io.ReadCloser, ctlen int, mtime int64, sha1, mine string, err error) {
+// Get get a file, rng and ifRange are the http Range and If-Range header,
+// crange returns the Content-Range of a partial or unsatisfied range.
+func (s *Service) Get(bucket, filename, rng, ifRange, accept string) (src io.ReadCloser, ctlen int, mtime int64, sha1, mine, crange, encoding string, err error) {
 	var (
 		mf *meta.File
 		bs []byte
+		r  *meta.Range
 	)
-	if mf, err = s.cache.Meta(bucket, filename); err == nil && mf != nil {
+	if mf, err = s.cache.Meta(bucket, filename); err == nil && mf != nil && !mf.Expired(time.Now().Unix()) {
 		if bs, err = s.cache.File(bucket, filename); err == nil && len(bs) > 0 {
 			mtime = mf.MTime
 			sha1 = mf.Sha1
 			mine = mf.Mine
+			if r = meta.ParseRange(rng); r != nil && meta.IfRange(ifRange, sha1, mtime) {
+				if err = r.Fit(int64(len(bs))); err != nil {
+					crange = meta.UnsatisfiedRange(int64(len(bs)))
+					return
+				}
+				if !r.Whole() {
+					crange = r.ContentRange()
+					bs = bs[r.Offset : r.Offset+r.Size]
+				}
+			}
 			ctlen = len(bs)
 			src = ioutil.NopCloser(bytes.NewReader(bs))
 			return
@@ -74,28 +86,36 @@ func (s *Service) Get(bucket, filename string) (src io.ReadCloser, ctlen int, mt
 		return
 	}
 	// get from bfs
-	if src, ctlen, mtime, sha1, mine, err = s.bfs.Get(bucket, filename); err != nil {
+	if src, ctlen, mtime, sha1, mine, crange, encoding, err = s.bfs.Get(bucket, filename, rng, ifRange, accept); err != nil {
 		log.Errorf("service.bfs.Get(%s,%s),error(%v)", bucket, filename, err)
 	}
 	return
 }
 
-// Upload upload
-func (s *Service) Upload(bucket, filename, mine, sha1 string, buf []byte) (err error) {
+// Upload upload, expire is the unix seconds the file expires at, zero means
+// never, mode is the durability of the stores, codec is the compression.
+func (s *Service) Upload(bucket, filename, mine string, expire int64, mode, codec string, sp *spool) (rp *bfs.Report, err error) {
 	var (
 		mtime = time.Now().UnixNano()
 		mf    *meta.File
+		rd    io.Reader
+		buf   []byte
 	)
-	if err = s.bfs.Upload(bucket, filename, mine, sha1, mtime, buf); err != nil && err != errors.ErrNeedleExist {
+	if rd, err = sp.Reader(); err != nil {
+		return
+	}
+	if rp, err = s.bfs.Upload(bucket, filename, mine, sp.Sha1, mtime, expire, mode, codec, rd, sp.Size); err != nil && err != errors.ErrNeedleExist {
 		log.Errorf("service.bfs.Upload(%s,%s),error(%s)", bucket, filename, err)
 		return
 	}
 	mf = &meta.File{
-		MTime: mtime,
-		Sha1:  sha1,
-		Mine:  mine,
+		MTime:  mtime,
+		Sha1:   sp.Sha1,
+		Mine:   mine,
+		Size:   sp.Size,
+		Expire: expire,
 	}
-	if len(buf) < _mcMaxLength {
+	if buf = sp.Bytes(); buf != nil && len(buf) < _mcMaxLength {
 		s.addCache(func() {
 			s.cache.SetMeta(bucket, filename, mf)
 			s.cache.SetFile(bucket, filename, buf)
@@ -105,16 +125,32 @@ func (s *Service) Upload(bucket, filename, mine, sha1 string, buf []byte) (err e
 }
 
 // Delete delete
-func (s *Service) Delete(bucket, filename string) (err error) {
-	if err = s.bfs.Delete(bucket, filename); err != nil {
+func (s *Service) Delete(bucket, filename string) (rp *bfs.Report, err error) {
+	if rp, err = s.bfs.Delete(bucket, filename); err != nil {
 		log.Errorf("service.bfs.Delete(%s,%s),error(%v)", bucket, filename, err)
-		return
+		// the file meta is deleted, the cache must be deleted too
+		if err != errors.ErrReplicaWrite {
+			return
+		}
 	}
 	s.cache.DelMeta(bucket, filename)
 	s.cache.DelFile(bucket, filename)
 	return
 }
 
+// List list a page of the files of the bucket.
+func (s *Service) List(bucket, prefix, delimiter, marker string, max int) (l *meta.FileList, err error) {
+	if !s.rl.Allow() {
+		err = errors.ErrServiceUnavailable
+		log.Errorf("service.bfs.List.RateLimit(%s),error(%v)", bucket, err)
+		return
+	}
+	if l, err = s.bfs.List(bucket, prefix, delimiter, marker, max); err != nil {
+		log.Errorf("service.bfs.List(%s),error(%v)", bucket, err)
+	}
+	return
+}
+
 // Ping .
 func (s *Service) Ping() (err error) {
 	if err = s.bfs.Ping(); err != nil {
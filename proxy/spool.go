@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+
+	log "github.com/golang/glog"
+)
+
+const _chunkSize = 64 * 1024 // upload stream chunk
+
+// spool hold an upload body, the sha1 is computed as it streams, the small
+// body keeps in memory, the large one is spooled to an unlinked temp file, so
+// the memory of an upload is bounded by the chunk size.
+type spool struct {
+	Size int64
+	Sha1 string
+	buf  []byte
+	file *os.File
+}
+
+// newSpool read at most max+1 bytes from rd, the caller must check the size.
+func newSpool(rd io.Reader, max int64) (s *spool, err error) {
+	var (
+		n    int
+		n1   int64
+		h    = sha1.New()
+		buf  = make([]byte, _chunkSize)
+		file *os.File
+	)
+	s = new(spool)
+	rd = io.TeeReader(io.LimitReader(rd, max+1), h)
+	if n, err = io.ReadFull(rd, buf); err == io.EOF || err == io.ErrUnexpectedEOF {
+		s.buf = buf[:n]
+		s.Size = int64(n)
+		err = nil
+	} else if err == nil {
+		if file, err = ioutil.TempFile("", "bfs-spool-"); err != nil {
+			log.Errorf("ioutil.TempFile() error(%v)", err)
+			return
+		}
+		// unlink, the file freed when closed
+		os.Remove(file.Name())
+		s.file = file
+		if _, err = file.Write(buf); err != nil {
+			log.Errorf("file.Write() error(%v)", err)
+			s.Close()
+			return
+		}
+		if n1, err = io.CopyBuffer(file, rd, buf); err != nil {
+			log.Errorf("io.CopyBuffer() error(%v)", err)
+			s.Close()
+			return
+		}
+		s.Size = int64(len(buf)) + n1
+	} else {
+		log.Errorf("io.ReadFull() error(%v)", err)
+		return
+	}
+	s.Sha1 = hex.EncodeToString(h.Sum(nil))
+	return
+}
+
+// Reader get a reader of the whole body from the beginning.
+func (s *spool) Reader() (rd io.Reader, err error) {
+	if s.file == nil {
+		return bytes.NewReader(s.buf), nil
+	}
+	if _, err = s.file.Seek(0, os.SEEK_SET); err != nil {
+		log.Errorf("file.Seek() error(%v)", err)
+		return
+	}
+	rd = s.file
+	return
+}
+
+// Bytes get the in memory body, nil if spooled to file.
+func (s *spool) Bytes() []byte {
+	return s.buf
+}
+
+// Close free the spooled file.
+func (s *spool) Close() {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+}
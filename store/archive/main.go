@@ -0,0 +1,165 @@
+package main
+
+// archive export a volume (or a key range) from a store as a portable
+// archive, import an archive into a free volume of a store, or check an
+// archive offline, every needle is verified (magic, checksum and padding).
+//
+// usage:
+//  archive -a export -s 127.0.0.1:6063 -v 1 [-start 0] [-end 0] -f ./volume_1.bfs
+//  archive -a import -s 127.0.0.1:6063 -v 1 -f ./volume_1.bfs
+//  archive -a check -f ./volume_1.bfs [-n 10485760]
+
+import (
+	"bfs/libs/errors"
+	"bfs/store/needle"
+	"bfs/store/volume"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+const (
+	_exportURI = "http://%s/export_volume?%s"
+	_importURI = "http://%s/import_volume?%s"
+	_tmp       = ".tmp"
+)
+
+var (
+	action     string
+	addr       string
+	vid        int
+	start, end int64
+	file       string
+	maxSize    int
+)
+
+func init() {
+	flag.StringVar(&action, "a", "check", "action: export, import or check")
+	flag.StringVar(&addr, "s", "", "store admin address, e.g. 127.0.0.1:6063")
+	flag.IntVar(&vid, "v", 0, "volume id")
+	flag.Int64Var(&start, "start", 0, "export the keys >= start")
+	flag.Int64Var(&end, "end", 0, "export the keys < end, zero means no limit")
+	flag.StringVar(&file, "f", "", "archive file")
+	flag.IntVar(&maxSize, "n", 10485760, "needle max size, same as the store config")
+}
+
+func main() {
+	var err error
+	flag.Parse()
+	if file == "" || (action != "check" && addr == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	switch action {
+	case "export":
+		err = export()
+	case "import":
+		err = imports()
+	case "check":
+		err = check(file)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "archive: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// export download the archive into a temp file, then verify and rename it.
+func export() (err error) {
+	var (
+		f      *os.File
+		resp   *http.Response
+		params = url.Values{}
+	)
+	params.Set("vid", strconv.Itoa(vid))
+	params.Set("start", strconv.FormatInt(start, 10))
+	params.Set("end", strconv.FormatInt(end, 10))
+	if resp, err = http.Get(fmt.Sprintf(_exportURI, addr, params.Encode())); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("export volume: %d status: %d", vid, resp.StatusCode)
+	}
+	if f, err = os.Create(file + _tmp); err != nil {
+		return
+	}
+	if _, err = io.Copy(f, resp.Body); err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(file + _tmp)
+		return
+	}
+	// the stream is cut if the store failed in the middle
+	if err = check(file + _tmp); err != nil {
+		os.Remove(file + _tmp)
+		return
+	}
+	return os.Rename(file+_tmp, file)
+}
+
+// imports post the archive to the store.
+func imports() (err error) {
+	var (
+		f      *os.File
+		resp   *http.Response
+		params = url.Values{}
+		res    struct {
+			Ret     int `json:"ret"`
+			Needles int `json:"needles"`
+		}
+	)
+	if f, err = os.Open(file); err != nil {
+		return
+	}
+	defer f.Close()
+	params.Set("vid", strconv.Itoa(vid))
+	if resp, err = http.Post(fmt.Sprintf(_importURI, addr, params.Encode()), "application/octet-stream", f); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return
+	}
+	if res.Ret != errors.RetOK {
+		return fmt.Errorf("import volume: %d ret: %d", vid, res.Ret)
+	}
+	fmt.Printf("volume: %d imported, needles: %d\n", vid, res.Needles)
+	return
+}
+
+// check verify every needle of the archive.
+func check(name string) (err error) {
+	var (
+		f           *os.File
+		id          int32
+		n           int64
+		lives, dels int
+	)
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+	defer f.Close()
+	if id, n, err = volume.ReadArchive(f, needle.MaxSize(maxSize), func(nd *needle.Needle) error {
+		if nd.Flag == needle.FlagOK {
+			lives++
+		} else {
+			dels++
+		}
+		return nil
+	}); err != nil {
+		return
+	}
+	fmt.Printf("archive: %s volume: %d needles: %d, live: %d, deleted: %d\n", name, id, n, lives, dels)
+	return
+}
@@ -0,0 +1,344 @@
+package block
+
+import (
+	"bfs/libs/errors"
+	"bfs/store/conf"
+	myos "bfs/store/os"
+	log "github.com/golang/glog"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// read engines
+	ReadPread  = "pread"
+	ReadDirect = "direct"
+	ReadAIO    = "aio"
+	// aligned buffer size classes, 4KB << class
+	_bufferClasses = 16
+	// reap the completions of a closing aio engine
+	_reapTimeout = 100 * time.Millisecond
+)
+
+var (
+	_buffers [_bufferClasses]sync.Pool
+)
+
+// reader a random read engine of the block, the page cache pread uses the
+// block read file directly. the reads are not locked by the volume, so an
+// engine waits them in Close and fails the reads after it.
+type reader interface {
+	ReadAt(buf []byte, off int64) (n int, err error)
+	Close() error
+}
+
+// newReader open the read engine of the block file, nil if pread.
+func newReader(file string, c *conf.Block) (r reader, err error) {
+	var f *os.File
+	switch c.ReadEngine {
+	case "", ReadPread:
+		return
+	case ReadDirect, ReadAIO:
+	default:
+		err = errors.ErrSuperBlockReadEngine
+		return
+	}
+	if f, err = os.OpenFile(file, os.O_RDONLY|myos.O_NOATIME|myos.O_DIRECT, 0664); err != nil {
+		log.Errorf("os.OpenFile(\"%s\") error(%v)", file, err)
+		return
+	}
+	if c.ReadEngine == ReadDirect {
+		r = &directReader{f: f, fd: int(f.Fd())}
+	} else if r, err = newAIOReader(f, c.ReadQueueDepth); err != nil {
+		log.Errorf("block: %s aio engine error(%v)", file, err)
+		f.Close()
+	}
+	return
+}
+
+// bufferClass get the size class of the aligned buffer.
+func bufferClass(size int) (class int) {
+	for class = 0; class < _bufferClasses && myos.DirectAlign<<uint(class) < size; class++ {
+	}
+	return
+}
+
+// getBuffer get an aligned buffer from the pool, the buffer larger than the
+// max class is not pooled.
+func getBuffer(size int) (buf []byte) {
+	var (
+		v     interface{}
+		class = bufferClass(size)
+	)
+	if class == _bufferClasses {
+		return myos.AlignedBuffer(size)
+	}
+	if v = _buffers[class].Get(); v != nil {
+		buf = v.([]byte)
+	} else {
+		buf = myos.AlignedBuffer(myos.DirectAlign << uint(class))
+	}
+	return buf[:size]
+}
+
+// putBuffer put back an aligned buffer.
+func putBuffer(buf []byte) {
+	var class = bufferClass(cap(buf))
+	if class < _bufferClasses && cap(buf) == myos.DirectAlign<<uint(class) {
+		_buffers[class].Put(buf[:cap(buf)])
+	}
+}
+
+// alignedRange get the aligned offset and size covers [off, off+size).
+func alignedRange(off int64, size int) (aoff int64, asize int) {
+	aoff = myos.AlignDown(off)
+	asize = int(myos.AlignUp(off+int64(size)) - aoff)
+	return
+}
+
+// copyOut copy the read bytes of the aligned buffer, a short read is io.EOF
+// like the os.File ReadAt.
+func copyOut(buf, abuf []byte, skip, read int) (n int, err error) {
+	if read > skip {
+		n = copy(buf, abuf[skip:read])
+	}
+	if n < len(buf) {
+		err = io.EOF
+	}
+	return
+}
+
+// directReader an O_DIRECT pread engine, bypasses the page cache, the reads
+// are aligned with the pooled buffers.
+type directReader struct {
+	f      *os.File
+	fd     int
+	lock   sync.RWMutex
+	closed bool
+}
+
+func (r *directReader) ReadAt(buf []byte, off int64) (n int, err error) {
+	var (
+		read        int
+		abuf        []byte
+		aoff, asize = alignedRange(off, len(buf))
+		skip        = int(off - aoff)
+	)
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.closed {
+		err = errors.ErrSuperBlockClosed
+		return
+	}
+	abuf = getBuffer(asize)
+	if read, err = myos.ReadFull(r.fd, abuf, aoff, skip+len(buf)); err == nil || err == io.EOF {
+		n, err = copyOut(buf, abuf, skip, read)
+	}
+	putBuffer(abuf)
+	return
+}
+
+func (r *directReader) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.f.Close()
+}
+
+// aioRequest an O_DIRECT pread submitted to the aio context.
+type aioRequest struct {
+	cb   myos.IOCB
+	buf  []byte
+	res  int64
+	done chan error
+}
+
+// aioReader a linux aio engine, the reads are batched by one submit job and
+// completed by one reap job, so the deep queue depth costs no blocked thread
+// per read.
+type aioReader struct {
+	f     *os.File
+	fd    int
+	ctx   myos.AIOContext
+	reqs  chan *aioRequest
+	free  chan int
+	lock  sync.Mutex
+	slots []*aioRequest
+	wg    sync.WaitGroup
+	// the submit job exited
+	done bool
+	// the reads in flight
+	rlock  sync.RWMutex
+	closed bool
+}
+
+func newAIOReader(f *os.File, depth int) (r *aioReader, err error) {
+	var i int
+	r = &aioReader{f: f, fd: int(f.Fd())}
+	if r.ctx, err = myos.IOSetup(depth); err != nil {
+		return nil, err
+	}
+	r.reqs = make(chan *aioRequest, depth)
+	r.free = make(chan int, depth)
+	r.slots = make([]*aioRequest, depth)
+	for i = 0; i < depth; i++ {
+		r.free <- i
+	}
+	r.wg.Add(2)
+	go r.submitproc()
+	go r.reapproc()
+	return
+}
+
+func (r *aioReader) ReadAt(buf []byte, off int64) (n int, err error) {
+	var (
+		read        int
+		aoff, asize = alignedRange(off, len(buf))
+		skip        = int(off - aoff)
+		req         = &aioRequest{done: make(chan error, 1)}
+	)
+	r.rlock.RLock()
+	defer r.rlock.RUnlock()
+	if r.closed {
+		err = errors.ErrSuperBlockClosed
+		return
+	}
+	req.buf = getBuffer(asize)
+	myos.PreparePread(&req.cb, r.fd, req.buf, aoff, 0)
+	r.reqs <- req
+	if err = <-req.done; err == nil {
+		// a short aligned read is not the end of the file, pread the left
+		if read = int(req.res); read < skip+len(buf) && read&(myos.DirectAlign-1) == 0 {
+			read, err = myos.ReadFull(r.fd, req.buf[read:], aoff+int64(read), skip+len(buf)-read)
+			read += int(req.res)
+		}
+		if err == nil || err == io.EOF {
+			n, err = copyOut(buf, req.buf, skip, read)
+		}
+	}
+	putBuffer(req.buf)
+	return
+}
+
+// submitproc submit the queued reads in one io_submit, bounded by the free
+// slots of the queue depth.
+func (r *aioReader) submitproc() {
+	var (
+		i, n  int
+		ok    bool
+		err   error
+		req   *aioRequest
+		batch []*aioRequest
+		cb    *myos.IOCB
+		cbs   []*myos.IOCB
+	)
+	for {
+		if req, ok = <-r.reqs; !ok {
+			break
+		}
+		batch = append(batch[:0], req)
+	batch:
+		for len(batch) < cap(r.free) {
+			select {
+			case req, ok = <-r.reqs:
+				if !ok {
+					break batch
+				}
+				batch = append(batch, req)
+			default:
+				break batch
+			}
+		}
+		cbs = cbs[:0]
+		for _, req = range batch {
+			i = <-r.free
+			r.lock.Lock()
+			r.slots[i] = req
+			r.lock.Unlock()
+			req.cb.Data = uint64(i)
+			cbs = append(cbs, &req.cb)
+		}
+		for len(cbs) > 0 {
+			if n, err = myos.IOSubmit(r.ctx, cbs); err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				log.Errorf("io_submit() error(%v)", err)
+				for _, cb = range cbs {
+					r.complete(int(cb.Data), 0, err)
+				}
+				break
+			}
+			cbs = cbs[n:]
+		}
+	}
+	r.lock.Lock()
+	r.done = true
+	r.lock.Unlock()
+	r.wg.Done()
+}
+
+// reapproc complete the reads, exit after the submit job exited and all the
+// slots are free.
+func (r *aioReader) reapproc() {
+	var (
+		i, n    int
+		err     error
+		ev      myos.IOEvent
+		events  = make([]myos.IOEvent, cap(r.free))
+		timeout = syscall.NsecToTimespec(int64(_reapTimeout))
+	)
+	for {
+		if n, err = myos.IOGetevents(r.ctx, 1, events, &timeout); err != nil && err != syscall.EINTR {
+			log.Errorf("io_getevents() error(%v)", err)
+			time.Sleep(_reapTimeout)
+		}
+		for i = 0; i < n; i++ {
+			if ev = events[i]; ev.Res < 0 {
+				r.complete(int(ev.Data), 0, syscall.Errno(-ev.Res))
+			} else {
+				r.complete(int(ev.Data), ev.Res, nil)
+			}
+		}
+		r.lock.Lock()
+		if r.done && len(r.free) == cap(r.free) {
+			r.lock.Unlock()
+			break
+		}
+		r.lock.Unlock()
+	}
+	r.wg.Done()
+}
+
+// complete free the slot and ack the read.
+func (r *aioReader) complete(i int, res int64, err error) {
+	var req *aioRequest
+	r.lock.Lock()
+	req, r.slots[i] = r.slots[i], nil
+	r.lock.Unlock()
+	r.free <- i
+	req.res = res
+	req.done <- err
+}
+
+// Close wait the reads in flight, then close the engine.
+func (r *aioReader) Close() (err error) {
+	r.rlock.Lock()
+	defer r.rlock.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	close(r.reqs)
+	r.wg.Wait()
+	if err = myos.IODestroy(r.ctx); err != nil {
+		log.Errorf("io_destroy() error(%v)", err)
+	}
+	return r.f.Close()
+}
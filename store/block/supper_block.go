@@ -18,24 +18,32 @@ import (
 //  --------------
 // | magic number |   ---- 4bytes
 // | version      |   ---- 1byte
-// | padding      |   ---- aligned with needle padding size (for furtuer  used)
+// | shift        |   ---- 1byte, only in v2, the block padding is 1 << shift
+// | padding      |   ---- aligned with block padding size (for furtuer  used)
 //  --------------
 //
+// the needle offset is a uint32 in block padding, a v1 block padding is the
+// needle padding 8, so the max block size is 32GB, a v2 block padding is set
+// when created, e.g. 64 makes a 256GB block, every needle is followed by a
+// zero fill up to the block padding.
 
 const (
 	// size
-	_headerSize  = needle.PaddingSize
-	_magicSize   = 4
-	_verSize     = 1
-	_paddingSize = _headerSize - _magicSize - _verSize
+	_headerSize = needle.PaddingSize
+	_magicSize  = 4
+	_verSize    = 1
+	_shiftSize  = 1
 	// offset
-	_headerOffset  = _headerSize
-	_magicOffset   = 0
-	_verOffset     = _magicOffset + _verSize
-	_paddingOffset = _verOffset + _paddingSize
-	_paddingByte   = byte(0)
+	_magicOffset = 0
+	_verOffset   = _magicOffset + _magicSize
+	_shiftOffset = _verOffset + _verSize
+	_paddingByte = byte(0)
 	// ver
 	Ver1 = byte(1)
+	Ver2 = byte(2)
+	// block padding
+	_minPadding = needle.PaddingSize
+	_maxPadding = 4096
 	// limits
 	// offset aligned 8 bytes, 4GB * needle_padding_size
 	_maxSize   = 4 * 1024 * 1024 * 1024 * needle.PaddingSize
@@ -44,8 +52,7 @@ const (
 
 var (
 	_magic    = []byte{0xab, 0xcd, 0xef, 0x00}
-	_ver      = []byte{Ver1}
-	_padding  = bytes.Repeat([]byte{_paddingByte}, _paddingSize)
+	_fill     = bytes.Repeat([]byte{_paddingByte}, _maxPadding)
 	_pagesize = syscall.Getpagesize()
 )
 
@@ -53,6 +60,7 @@ var (
 type SuperBlock struct {
 	r       *os.File
 	w       *os.File
+	rd      reader
 	conf    *conf.Config
 	File    string `json:"file"`
 	Offset  uint32 `json:"offset"`
@@ -65,6 +73,8 @@ type SuperBlock struct {
 	closed     bool
 	write      int
 	syncOffset uint32
+	// stream write chunk, writer is exclusive so only one chunk
+	chunk []byte
 }
 
 // NewSuperBlock creae a new super block.
@@ -75,7 +85,6 @@ func NewSuperBlock(file string, c *conf.Config) (b *SuperBlock, err error) {
 	b.closed = false
 	b.write = 0
 	b.syncOffset = 0
-	b.Padding = needle.PaddingSize
 	if b.w, err = os.OpenFile(file, os.O_WRONLY|os.O_CREATE|myos.O_NOATIME, 0664); err != nil {
 		log.Errorf("os.OpenFile(\"%s\") error(%v)", file, err)
 		b.Close()
@@ -86,6 +95,11 @@ func NewSuperBlock(file string, c *conf.Config) (b *SuperBlock, err error) {
 		b.Close()
 		return nil, err
 	}
+	if b.rd, err = newReader(file, c.Block); err != nil {
+		log.Errorf("block: %s newReader(\"%s\") error(%v)", file, c.Block.ReadEngine, err)
+		b.Close()
+		return nil, err
+	}
 	if err = b.init(); err != nil {
 		log.Errorf("block: %s init() error(%v)", file, err)
 		b.Close()
@@ -110,33 +124,48 @@ func (b *SuperBlock) init() (err error) {
 			log.Errorf("block: %s writeMeta() error(%v)", b.File, err)
 			return
 		}
-		b.Size = _headerSize
+		b.Size = b.headerSize()
 	} else {
 		if err = b.parseMeta(); err != nil {
 			log.Errorf("block: %s parseMeta() error(%v)", b.File, err)
 			return
 		}
-		if _, err = b.w.Seek(_headerOffset, os.SEEK_SET); err != nil {
+		if _, err = b.w.Seek(b.headerSize(), os.SEEK_SET); err != nil {
 			log.Errorf("block: %s Seek() error(%v)", b.File, err)
 			return
 		}
 	}
-	b.Offset = needle.NeedleOffset(_headerOffset)
+	b.Offset = b.NeedleOffset(b.headerSize())
 	return
 }
 
-// writeMeta write block meta info.
+// writeMeta write block meta info, the version and padding of a new block is
+// from config.
 func (b *SuperBlock) writeMeta() (err error) {
-	// magic
-	if _, err = b.w.Write(_magic); err != nil {
-		return
+	var (
+		shift byte
+		buf   []byte
+	)
+	b.Ver, b.Padding = Ver1, needle.PaddingSize
+	if b.conf.Block.Ver == int(Ver2) {
+		b.Ver, b.Padding = Ver2, uint32(b.conf.Block.Padding)
+		if shift, err = paddingShift(b.Padding); err != nil {
+			return
+		}
+	} else if b.conf.Block.Ver > int(Ver2) {
+		return errors.ErrSuperBlockVer
 	}
+	buf = make([]byte, b.Padding)
+	// magic
+	copy(buf[_magicOffset:_verOffset], _magic)
 	// ver
-	if _, err = b.w.Write(_ver); err != nil {
-		return
+	buf[_verOffset] = b.Ver
+	// shift
+	if b.Ver == Ver2 {
+		buf[_shiftOffset] = shift
 	}
 	// padding
-	_, err = b.w.Write(_padding)
+	_, err = b.w.Write(buf)
 	return
 }
 
@@ -151,30 +180,130 @@ func (b *SuperBlock) parseMeta() (err error) {
 	if !bytes.Equal(b.magic, _magic) {
 		return errors.ErrSuperBlockMagic
 	}
-	if b.Ver == Ver1 {
+	switch b.Ver {
+	case Ver1:
+		b.Padding = needle.PaddingSize
+	case Ver2:
+		b.Padding = uint32(1) << buf[_shiftOffset]
+		if _, err = paddingShift(b.Padding); err != nil {
+			return
+		}
+	default:
 		return errors.ErrSuperBlockVer
 	}
 	// b.magic = nil // avoid memory leak
 	return
 }
 
+// paddingShift get the shift of a block padding, which must be a power of
+// two in [8, 4096].
+func paddingShift(padding uint32) (shift byte, err error) {
+	if padding < _minPadding || padding > _maxPadding || padding&(padding-1) != 0 {
+		return 0, errors.ErrSuperBlockPadding
+	}
+	for padding > 1 {
+		padding >>= 1
+		shift++
+	}
+	return
+}
+
+// headerSize get the header size, the header takes one block padding.
+func (b *SuperBlock) headerSize() int64 {
+	return int64(b.Padding)
+}
+
+// BlockOffset get super block file offset.
+func (b *SuperBlock) BlockOffset(offset uint32) int64 {
+	return int64(offset) * int64(b.Padding)
+}
+
+// NeedleOffset convert a size to needle offset, rounded up to the block
+// padding.
+func (b *SuperBlock) NeedleOffset(size int64) uint32 {
+	return uint32((size + int64(b.Padding) - 1) / int64(b.Padding))
+}
+
+// FirstOffset get the needle offset of the first needle, after the header.
+func (b *SuperBlock) FirstOffset() uint32 {
+	return b.NeedleOffset(b.headerSize())
+}
+
+// incrOffset get the needle offset a needle takes.
+func (b *SuperBlock) incrOffset(n *needle.Needle) uint32 {
+	return b.NeedleOffset(int64(n.TotalSize))
+}
+
+// writeFill write the zero fill after a needle up to the block padding.
+func (b *SuperBlock) writeFill(n *needle.Needle, incr uint32) (err error) {
+	var size = b.BlockOffset(incr) - int64(n.TotalSize)
+	if size > 0 {
+		_, err = b.w.Write(_fill[:size])
+	}
+	return
+}
+
 // Write write needle to the block.
 func (b *SuperBlock) Write(n *needle.Needle) (err error) {
+	var incr = b.incrOffset(n)
 	if b.LastErr != nil {
 		return b.LastErr
 	}
-	if _maxOffset-n.IncrOffset < b.Offset {
+	if _maxOffset-incr < b.Offset {
 		err = errors.ErrSuperBlockNoSpace
 		return
 	}
 	if _, err = b.w.Write(n.Buffer()); err == nil {
+		err = b.writeFill(n, incr)
+	}
+	if err == nil {
 		err = b.flush(false)
 	} else {
 		b.LastErr = err
 		return
 	}
-	b.Offset += n.IncrOffset
-	b.Size += int64(n.TotalSize)
+	b.Offset += incr
+	b.Size += b.BlockOffset(incr)
+	return
+}
+
+// WriteFrom stream a needle from io.Reader into the super block, if the
+// stream broken, rewind the writer to the last needle end, so no half needle
+// left in block.
+func (b *SuperBlock) WriteFrom(n *needle.Needle, rd io.Reader) (err error) {
+	var (
+		err1   error
+		offset int64
+		incr   = b.incrOffset(n)
+	)
+	if b.LastErr != nil {
+		return b.LastErr
+	}
+	if _maxOffset-incr < b.Offset {
+		err = errors.ErrSuperBlockNoSpace
+		return
+	}
+	if b.chunk == nil {
+		b.chunk = make([]byte, b.conf.Block.StreamBuffer)
+	}
+	if err = n.WriteFrom(rd, b.w, b.chunk); err == nil {
+		err = b.writeFill(n, incr)
+	}
+	if err != nil {
+		log.Errorf("block: %s stream needle: %d error(%v)", b.File, n.Key, err)
+		offset = b.BlockOffset(b.Offset)
+		if _, err1 = b.w.Seek(offset, os.SEEK_SET); err1 != nil {
+			log.Errorf("block: %s Seek() error(%v)", b.File, err1)
+			b.LastErr = err1
+		} else if err1 = b.w.Truncate(offset); err1 != nil {
+			log.Errorf("block: %s Truncate() error(%v)", b.File, err1)
+			b.LastErr = err1
+		}
+		return
+	}
+	err = b.flush(false)
+	b.Offset += incr
+	b.Size += b.BlockOffset(incr)
 	return
 }
 
@@ -189,8 +318,8 @@ func (b *SuperBlock) flush(force bool) (err error) {
 		return
 	}
 	b.write = 0
-	offset = needle.BlockOffset(b.syncOffset)
-	size = needle.BlockOffset(b.Offset - b.syncOffset)
+	offset = b.BlockOffset(b.syncOffset)
+	size = b.BlockOffset(b.Offset - b.syncOffset)
 	fd = b.w.Fd()
 	if b.conf.Block.Syncfilerange {
 		if err = myos.Syncfilerange(fd, offset, size, myos.SYNC_FILE_RANGE_WRITE); err != nil {
@@ -214,24 +343,46 @@ func (b *SuperBlock) flush(force bool) (err error) {
 	return
 }
 
+// Sync fdatasync the written needles, it's safe to call concurrently with
+// the writer, no sync offset is changed. a failed fdatasync breaks the block,
+// the written pages may be lost.
+func (b *SuperBlock) Sync() (err error) {
+	if err = myos.Fdatasync(b.w.Fd()); err != nil {
+		log.Errorf("block: %s Fdatasync() error(%v)", b.File, err)
+		b.LastErr = err
+	}
+	return
+}
+
 // WriteAt write a needle by specified offset;
 func (b *SuperBlock) WriteAt(offset uint32, n *needle.Needle) (err error) {
 	if b.LastErr != nil {
 		return b.LastErr
 	}
-	if _, err = b.w.WriteAt(n.Buffer(), needle.BlockOffset(offset)); err != nil {
+	if _, err = b.w.WriteAt(n.Buffer(), b.BlockOffset(offset)); err != nil {
 		b.LastErr = err
 	}
 	return
 }
 
+// readAt random read the block by the read engine, the page cache pread if
+// no engine.
+func (b *SuperBlock) readAt(buf []byte, off int64) (err error) {
+	if b.rd != nil {
+		_, err = b.rd.ReadAt(buf, off)
+	} else {
+		_, err = b.r.ReadAt(buf, off)
+	}
+	return
+}
+
 // ReadAt read a needle by specified offset, before call it, must set needle
 // TotalSize.
 func (b *SuperBlock) ReadAt(n *needle.Needle) (err error) {
 	if b.LastErr != nil {
 		return b.LastErr
 	}
-	if _, err = b.r.ReadAt(n.Buffer(), needle.BlockOffset(n.Offset)); err == nil {
+	if err = b.readAt(n.Buffer(), b.BlockOffset(n.Offset)); err == nil {
 		err = n.Parse()
 	} else {
 		b.LastErr = err
@@ -239,6 +390,44 @@ func (b *SuperBlock) ReadAt(n *needle.Needle) (err error) {
 	return
 }
 
+// ReadHeaderAt read a needle header by specified offset, before call it, must
+// set needle Offset. the header of a meta needle may be longer than the first
+// read, then read the whole header again.
+func (b *SuperBlock) ReadHeaderAt(n *needle.Needle) (err error) {
+	if b.LastErr != nil {
+		return b.LastErr
+	}
+	var buf []byte
+	for {
+		buf = n.HeaderBuffer()
+		if err = b.readAt(buf, b.BlockOffset(n.Offset)); err != nil {
+			b.LastErr = err
+			return
+		}
+		if err = n.ParseHeader(); err != errors.ErrNeedleHeaderSize || len(n.HeaderBuffer()) == len(buf) {
+			return
+		}
+	}
+}
+
+// ReadDataAt read a window [offset, offset+size) of needle data, only pread
+// the window bytes, must called after ReadHeaderAt.
+func (b *SuperBlock) ReadDataAt(n *needle.Needle, offset, size int64) (err error) {
+	if b.LastErr != nil {
+		return b.LastErr
+	}
+	if offset < 0 || size < 0 || offset+size > int64(n.Size) {
+		return errors.ErrNeedleDataSize
+	}
+	var data = make([]byte, size)
+	if err = b.readAt(data, b.BlockOffset(n.Offset)+int64(n.HeaderSize)+offset); err == nil {
+		n.Data = data
+	} else {
+		b.LastErr = err
+	}
+	return
+}
+
 // Delete logical del a needls, only update the flag to it.
 func (b *SuperBlock) Delete(offset uint32) (err error) {
 	if b.LastErr != nil {
@@ -246,16 +435,18 @@ func (b *SuperBlock) Delete(offset uint32) (err error) {
 	}
 	// WriteAt won't update the file offset.
 	if _, err = b.w.WriteAt(needle.FlagDelBytes,
-		needle.BlockOffset(offset)+needle.FlagOffset); err != nil {
+		b.BlockOffset(offset)+needle.FlagOffset); err != nil {
 		b.LastErr = err
 	}
 	return
 }
 
-// Scan scan a block file.
+// Scan scan a block file, both v1 and v2 block, the zero fill after every
+// needle of a v2 block is skipped.
 func (b *SuperBlock) Scan(r *os.File, offset uint32, fn func(*needle.Needle, uint32, uint32) error) (err error) {
 	var (
 		so, eo uint32
+		incr   uint32
 		bso    int64
 		fi     os.FileInfo
 		fd     = r.Fd()
@@ -263,10 +454,10 @@ func (b *SuperBlock) Scan(r *os.File, offset uint32, fn func(*needle.Needle, uin
 		rd     = bufio.NewReaderSize(r, b.conf.Block.BufferSize)
 	)
 	if offset == 0 {
-		offset = needle.NeedleOffset(_headerOffset)
+		offset = b.NeedleOffset(b.headerSize())
 	}
 	so, eo = offset, offset
-	bso = needle.BlockOffset(so)
+	bso = b.BlockOffset(so)
 	// advise sequential read
 	if fi, err = r.Stat(); err != nil {
 		log.Errorf("block: %s Stat() error(%v)", b.File)
@@ -296,16 +487,21 @@ func (b *SuperBlock) Scan(r *os.File, offset uint32, fn func(*needle.Needle, uin
 		if log.V(1) {
 			log.Info(n.String())
 		}
-		eo += n.IncrOffset
+		incr = b.incrOffset(n)
+		eo += incr
 		if err = fn(n, so, eo); err != nil {
 			log.Errorf("block: callback from offset: %d:%d error(%v)", so, eo, err)
 			break
 		}
 		so = eo
+		// discard the fill after the callback, the needle buffer is peeked
+		if _, err = rd.Discard(int(b.BlockOffset(incr) - int64(n.TotalSize))); err != nil {
+			break
+		}
 	}
 	if err == io.EOF {
 		// advise no need page cache
-		if err = myos.Fadvise(fd, bso, needle.BlockOffset(eo-so), myos.POSIX_FADV_DONTNEED); err != nil {
+		if err = myos.Fadvise(fd, bso, b.BlockOffset(eo-so), myos.POSIX_FADV_DONTNEED); err != nil {
 			log.Errorf("block: %s Fadvise() error(%v)", b.File)
 			return
 		}
@@ -322,7 +518,7 @@ func (b *SuperBlock) Recovery(offset uint32, fn func(*needle.Needle, uint32, uin
 	var rsize int64
 	// WARN block may be no left data, must update block offset first
 	if offset == 0 {
-		offset = needle.NeedleOffset(_headerOffset)
+		offset = b.NeedleOffset(b.headerSize())
 	}
 	b.Offset = offset
 	if err = b.Scan(b.r, offset, func(n *needle.Needle, so, eo uint32) (err1 error) {
@@ -341,7 +537,7 @@ func (b *SuperBlock) Recovery(offset uint32, fn func(*needle.Needle, uint32, uin
 		log.Errorf("block: %s Fadvise() error(%v)", b.File)
 		return
 	}
-	rsize = needle.BlockOffset(b.Offset)
+	rsize = b.BlockOffset(b.Offset)
 	// reset b.w offset, discard left space which can't parse to a needle
 	if _, err = b.w.Seek(rsize, os.SEEK_SET); err != nil {
 		log.Errorf("block: %s Seek() error(%v)", b.File, err)
@@ -350,7 +546,7 @@ func (b *SuperBlock) Recovery(offset uint32, fn func(*needle.Needle, uint32, uin
 	// recheck offset, keep size and offset consistency
 	if b.Size != rsize {
 		log.Warningf("block: %s [real size: %d, offset: %d] but [size: %d, offset: %d] not consistency, truncate file for force recovery, this may lost data",
-			b.File, b.Size, needle.NeedleOffset(b.Size),
+			b.File, b.Size, b.NeedleOffset(b.Size),
 			rsize, b.Offset)
 		// truncate file
 		if err = b.w.Truncate(rsize); err != nil {
@@ -360,6 +556,34 @@ func (b *SuperBlock) Recovery(offset uint32, fn func(*needle.Needle, uint32, uin
 	return
 }
 
+// Verify scan the whole block, every needle must pass the checksum and the
+// needles must fill the block exactly, used for a block copied from another
+// store.
+func (b *SuperBlock) Verify() (err error) {
+	var (
+		fi     os.FileInfo
+		offset = b.NeedleOffset(b.headerSize())
+	)
+	if b.LastErr != nil {
+		return b.LastErr
+	}
+	if err = b.Scan(b.r, offset, func(n *needle.Needle, so, eo uint32) error {
+		offset = eo
+		return nil
+	}); err != nil {
+		return
+	}
+	if fi, err = b.r.Stat(); err != nil {
+		log.Errorf("block: %s Stat() error(%v)", b.File, err)
+		return
+	}
+	if b.BlockOffset(offset) != fi.Size() {
+		log.Errorf("block: %s verify offset: %d, size: %d not consistency", b.File, offset, fi.Size())
+		err = errors.ErrSuperBlockOffset
+	}
+	return
+}
+
 // Compact compact the orig block, copy to disk dst block.
 func (b *SuperBlock) Compact(offset uint32, fn func(*needle.Needle, uint32, uint32) error) (err error) {
 	if b.LastErr != nil {
@@ -396,6 +620,11 @@ func (b *SuperBlock) Open() (err error) {
 		b.Close()
 		return
 	}
+	if b.rd, err = newReader(b.File, b.conf.Block); err != nil {
+		log.Errorf("block: %s newReader(\"%s\") error(%v)", b.File, b.conf.Block.ReadEngine, err)
+		b.Close()
+		return
+	}
 	if err = b.init(); err != nil {
 		b.Close()
 		return
@@ -426,6 +655,12 @@ func (b *SuperBlock) Close() {
 		}
 		b.r = nil
 	}
+	// the closed engine fails the unlocked reads
+	if b.rd != nil {
+		if err = b.rd.Close(); err != nil {
+			log.Errorf("block: %s close read engine error(%v)", b.File, err)
+		}
+	}
 	b.closed = true
 	b.LastErr = errors.ErrSuperBlockClosed
 	return
@@ -1,11 +1,13 @@
 package block
 
 import (
+	"bfs/libs/errors"
 	"bfs/store/conf"
 	"bfs/store/needle"
 	"bytes"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
 )
 
@@ -27,6 +29,7 @@ func TestSuperBlock(t *testing.T) {
 		n                  *needle.Needle
 		offset, v2, v3, v4 uint32
 		err                error
+		f                  *os.File
 		buf                = &bytes.Buffer{}
 		needles            = make(map[int64]int64)
 		data               = []byte("test")
@@ -303,6 +306,252 @@ func TestSuperBlock(t *testing.T) {
 		t.Error("needle.Value(4) not match")
 		t.FailNow()
 	}
+	// test verify
+	if err = b.Verify(); err != nil {
+		t.Errorf("b.Verify() error(%v)", err)
+		t.FailNow()
+	}
+	if f, err = os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0664); err != nil {
+		t.Errorf("os.OpenFile() error(%v)", err)
+		t.FailNow()
+	}
+	f.Write(make([]byte, needle.PaddingSize))
+	f.Close()
+	if err = b.Verify(); err == nil {
+		t.Error("b.Verify() must fail")
+		t.FailNow()
+	}
+}
+
+func TestSuperBlockV2(t *testing.T) {
+	var (
+		b       *SuperBlock
+		n       *needle.Needle
+		err     error
+		offsets []uint32
+		size    int32
+		data    = []byte("test")
+		file    = "../test/test2.block"
+		c       = *testConf
+		bc      = *testConf.Block
+		m       = &needle.Meta{Mine: "image/png", Filename: string(bytes.Repeat([]byte("a"), 64))}
+	)
+	os.Remove(file)
+	defer os.Remove(file)
+	c.Block = &bc
+	bc.StreamBuffer = 1024
+	bc.Ver, bc.Padding = int(Ver2), 100
+	if _, err = NewSuperBlock(file, &c); err == nil {
+		t.Error("NewSuperBlock() must fail for padding 100")
+		t.FailNow()
+	}
+	os.Remove(file)
+	bc.Padding = 64
+	if b, err = NewSuperBlock(file, &c); err != nil {
+		t.Errorf("NewSuperBlock(\"%s\") error(%v)", file, err)
+		t.FailNow()
+	}
+	if b.Ver != Ver2 || b.Padding != 64 || b.Offset != 1 || b.Size != 64 {
+		t.Errorf("ver: %d, padding: %d, offset: %d, size: %d not match", b.Ver, b.Padding, b.Offset, b.Size)
+		t.FailNow()
+	}
+	// a v1 needle and a meta needle, both filled to the block padding
+	offsets = append(offsets, b.Offset)
+	n = needle.NewWriter(1, 1, 4)
+	n.ReadFrom(bytes.NewReader(data))
+	if err = b.Write(n); err != nil {
+		t.Errorf("b.Write() error(%v)", err)
+		t.FailNow()
+	}
+	size = n.TotalSize
+	n.Close()
+	offsets = append(offsets, b.Offset)
+	n = needle.NewMetaStreamWriter(2, 2, 4, m)
+	if err = b.WriteFrom(n, bytes.NewReader(data)); err != nil {
+		t.Errorf("b.WriteFrom() error(%v)", err)
+		t.FailNow()
+	}
+	if b.Offset != offsets[1]+b.NeedleOffset(int64(n.TotalSize)) || b.Size != b.BlockOffset(b.Offset) {
+		t.Errorf("offset: %d, size: %d not match", b.Offset, b.Size)
+		t.FailNow()
+	}
+	b.Close()
+	// reopen, the padding is parsed from header
+	bc.Ver, bc.Padding = int(Ver1), 8
+	if err = b.Open(); err != nil {
+		t.Errorf("Open() error(%v)", err)
+		t.FailNow()
+	}
+	defer b.Close()
+	if b.Ver != Ver2 || b.Padding != 64 {
+		t.Errorf("ver: %d, padding: %d not match", b.Ver, b.Padding)
+		t.FailNow()
+	}
+	if err = b.Recovery(0, func(rn *needle.Needle, so, eo uint32) error {
+		if so != offsets[rn.Key-1] {
+			t.Errorf("needle: %d offset: %d not match", rn.Key, so)
+		}
+		return nil
+	}); err != nil {
+		t.Errorf("b.Recovery() error(%v)", err)
+		t.FailNow()
+	}
+	if err = b.Verify(); err != nil {
+		t.Errorf("b.Verify() error(%v)", err)
+		t.FailNow()
+	}
+	n = needle.NewReader(1, needle.NewCache(offsets[0], size))
+	defer n.Close()
+	if err = b.ReadAt(n); err != nil {
+		t.Errorf("b.ReadAt() error(%v)", err)
+		t.FailNow()
+	}
+	if err = compareTestNeedle(t, 1, 1, needle.FlagOK, n, data); err != nil {
+		t.FailNow()
+	}
+	// the meta needle header is longer than the first header read
+	n = needle.NewRangeReader(2, needle.NewCache(offsets[1], 0))
+	defer n.Close()
+	if err = b.ReadHeaderAt(n); err != nil {
+		t.Errorf("b.ReadHeaderAt() error(%v)", err)
+		t.FailNow()
+	}
+	if n.Meta == nil || *n.Meta != *m {
+		t.Errorf("meta: %v not match", n.Meta)
+		t.FailNow()
+	}
+	if err = b.ReadDataAt(n, 0, 4); err != nil || !bytes.Equal(n.Data, data) {
+		t.Errorf("b.ReadDataAt() data: %s error(%v)", n.Data, err)
+		t.FailNow()
+	}
+}
+
+func TestSuperBlockReadEngine(t *testing.T) {
+	var (
+		b       *SuperBlock
+		n       *needle.Needle
+		i       int
+		err     error
+		end     uint32
+		rd      reader
+		engine  string
+		wg      sync.WaitGroup
+		offsets []uint32
+		sizes   []int32
+		datas   [][]byte
+		errs    = make(chan error, 8)
+		file    = "../test/test_engine.block"
+		c       = *testConf
+		bc      = *testConf.Block
+		read    = func(key int) (err error) {
+			var n = needle.NewReader(int64(key), needle.NewCache(offsets[key], sizes[key]))
+			defer n.Close()
+			if err = b.ReadAt(n); err != nil {
+				return
+			}
+			if !bytes.Equal(n.Data, datas[key]) {
+				return fmt.Errorf("needle: %d data not match", key)
+			}
+			// the window crosses the page
+			n = needle.NewRangeReader(int64(key), needle.NewCache(offsets[key], 0))
+			defer n.Close()
+			if err = b.ReadHeaderAt(n); err != nil {
+				return
+			}
+			if err = b.ReadDataAt(n, 1, int64(len(datas[key])-1)); err != nil {
+				return
+			}
+			if !bytes.Equal(n.Data, datas[key][1:]) {
+				return fmt.Errorf("needle: %d window not match", key)
+			}
+			return
+		}
+	)
+	os.Remove(file)
+	defer os.Remove(file)
+	c.Block = &bc
+	bc.ReadQueueDepth = 4
+	if b, err = NewSuperBlock(file, &c); err != nil {
+		t.Errorf("NewSuperBlock(\"%s\") error(%v)", file, err)
+		t.FailNow()
+	}
+	// needles not aligned with the page
+	for i = 0; i < 64; i++ {
+		datas = append(datas, bytes.Repeat([]byte{byte(i)}, 1+i*797))
+		n = needle.NewWriter(int64(i), int32(i), int32(len(datas[i])))
+		n.ReadFrom(bytes.NewReader(datas[i]))
+		offsets = append(offsets, b.Offset)
+		sizes = append(sizes, n.TotalSize)
+		if err = b.Write(n); err != nil {
+			t.Errorf("b.Write() error(%v)", err)
+			t.FailNow()
+		}
+		n.Close()
+	}
+	end = b.Offset
+	b.Close()
+	bc.ReadEngine = "mmap"
+	if err = b.Open(); err == nil {
+		t.Errorf("Open() must fail for engine mmap")
+		t.FailNow()
+	}
+	for _, engine = range []string{ReadPread, ReadDirect, ReadAIO} {
+		bc.ReadEngine = engine
+		if err = b.Open(); err != nil {
+			t.Errorf("engine: %s Open() error(%v)", engine, err)
+			t.FailNow()
+		}
+		for i = 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func(i int) {
+				var key, err1 = 0, error(nil)
+				for key = i; key < len(datas) && err1 == nil; key += cap(errs) {
+					err1 = read(key)
+				}
+				errs <- err1
+				wg.Done()
+			}(i)
+		}
+		wg.Wait()
+		for i = 0; i < cap(errs); i++ {
+			if err = <-errs; err != nil {
+				t.Errorf("engine: %s read error(%v)", engine, err)
+				t.FailNow()
+			}
+		}
+		// out of the block end
+		n = needle.NewReader(1, needle.NewCache(end, 4096))
+		if err = b.ReadAt(n); err == nil {
+			t.Errorf("engine: %s ReadAt() must fail out of the block", engine)
+			t.FailNow()
+		}
+		n.Close()
+		if rd = b.rd; rd == nil {
+			b.Close()
+			continue
+		}
+		// the reads are not locked, the engine closed in flight
+		for i = 0; i < cap(errs); i++ {
+			go func(i int) {
+				var (
+					err1 error
+					buf  = make([]byte, sizes[i])
+				)
+				for err1 == nil {
+					_, err1 = rd.ReadAt(buf, b.BlockOffset(offsets[i]))
+				}
+				errs <- err1
+			}(i)
+		}
+		rd.Close()
+		for i = 0; i < cap(errs); i++ {
+			if err = <-errs; err != errors.ErrSuperBlockClosed {
+				t.Errorf("engine: %s read error(%v), must be closed", engine, err)
+				t.FailNow()
+			}
+		}
+		b.Close()
+	}
 }
 
 func compareTestNeedle(t *testing.T, key int64, cookie int32, flag byte, n *needle.Needle, data []byte) (err error) {
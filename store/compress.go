@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"bfs/store/needle"
+	"bytes"
+	"io"
+	"sync"
+
+	log "github.com/golang/glog"
+)
+
+// parseCodec parse the optional compression codec of the upload, empty means
+// no compression.
+func parseCodec(str string) (codec string, err error) {
+	if !meta.ValidCodec(str) {
+		log.Errorf("codec: \"%s\" not valid", str)
+		err = errors.ErrParam
+		return
+	}
+	codec = str
+	return
+}
+
+var (
+	_codecBuffers = sync.Pool{
+		New: func() interface{} {
+			return new(codecBuffer)
+		},
+	}
+)
+
+// codecBuffer the buffers of a compressed upload, pooled across the uploads.
+// the raw data is at most the max size and the compressed one is cut once it
+// is not smaller than the raw, so an upload holds less than 2 * max.
+type codecBuffer struct {
+	max   int64
+	data  []byte
+	cdata bytes.Buffer
+	// the compressed data not smaller than the raw
+	full bool
+}
+
+// newCodecBuffer get a codec buffer of the max upload size.
+func newCodecBuffer(max int) (b *codecBuffer) {
+	b = _codecBuffers.Get().(*codecBuffer)
+	b.max = int64(max)
+	return
+}
+
+// Close put the buffer back, the readers got from it are invalid then.
+func (b *codecBuffer) Close() {
+	b.cdata.Reset()
+	_codecBuffers.Put(b)
+}
+
+// Write append the compressed data, fails once it's not smaller than the raw.
+func (b *codecBuffer) Write(p []byte) (n int, err error) {
+	if b.cdata.Len()+len(p) >= len(b.data) {
+		b.full = true
+		return 0, io.ErrShortWrite
+	}
+	return b.cdata.Write(p)
+}
+
+// read read the upload data of the size into the raw buffer.
+func (b *codecBuffer) read(rd io.Reader, size int64) (data []byte, err error) {
+	if size > b.max {
+		log.Errorf("upload size: %d more than %d", size, b.max)
+		err = errors.ErrNeedleTooLarge
+		return
+	}
+	if int64(cap(b.data)) < size {
+		b.data = make([]byte, size)
+	}
+	b.data = b.data[:size]
+	if _, err = io.ReadFull(rd, b.data); err != nil {
+		log.Errorf("io.ReadFull() error(%v)", err)
+		err = errors.ErrParam
+		return
+	}
+	data = b.data
+	return
+}
+
+// compress compress the upload data by the codec, the codec and the raw size
+// are saved in the meta. the data not smaller after compression is kept raw.
+func (b *codecBuffer) compress(codec string, m *needle.Meta, rd io.Reader, size int64) (crd io.Reader, csize int64, err error) {
+	var data []byte
+	if data, err = b.read(rd, size); err != nil {
+		return
+	}
+	b.cdata.Reset()
+	b.full = false
+	if err = needle.CompressTo(codec, b, data); err != nil {
+		if b.full {
+			return bytes.NewReader(data), size, nil
+		}
+		log.Errorf("needle.CompressTo(%s) error(%v)", codec, err)
+		return
+	}
+	m.Codec = codec
+	m.Size = size
+	return bytes.NewReader(b.cdata.Bytes()), int64(b.cdata.Len()), nil
+}
+
+// encoded take the upload data already compressed by the codec, the copy of
+// a compressed needle, the raw size is counted by a decompression, which
+// verifies the data too.
+func (b *codecBuffer) encoded(codec string, m *needle.Meta, rd io.Reader, size int64) (crd io.Reader, err error) {
+	var cdata []byte
+	if cdata, err = b.read(rd, size); err != nil {
+		return
+	}
+	if m.Size, err = needle.DecompressedSize(codec, cdata); err != nil {
+		return
+	}
+	m.Codec = codec
+	return bytes.NewReader(cdata), nil
+}
+
+// decompress get the response data of a compressed needle, the data is passed
+// through if the client accepts the codec and no partial range, encoding is
+// the codec then, otherwise it's decompressed and cut by the range.
+func decompress(n *needle.Needle, rng *meta.Range, accept string) (data []byte, encoding string, err error) {
+	if (rng == nil || rng.Whole()) && meta.AcceptEncoding(accept, n.Meta.Codec) {
+		return n.Data, n.Meta.Codec, nil
+	}
+	if data, err = needle.Decompress(n.Meta.Codec, n.Data, n.Meta.Size); err != nil {
+		return
+	}
+	if rng != nil && !rng.Whole() {
+		data = data[rng.Offset : rng.Offset+rng.Size]
+	}
+	return
+}
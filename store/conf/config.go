@@ -2,12 +2,26 @@ package conf
 
 import (
 	"bfs/store/needle"
+	"fmt"
 	"github.com/BurntSushi/toml"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
+const (
+	// default stream upload chunk size
+	_streamBuffer = 64 * 1024
+	// default new block version
+	_blockVer = 1
+	// default aio read engine queue depth
+	_readQueueDepth = 128
+	// default erasure coded volume index, saved with the volume index
+	_ecVolumeIndex = "ec_volume.idx"
+)
+
 type Config struct {
 	Pprof       bool
 	PprofListen string
@@ -28,19 +42,39 @@ type Config struct {
 }
 
 type Store struct {
-	VolumeIndex     string
-	FreeVolumeIndex string
+	VolumeIndex       string
+	FreeVolumeIndex   string
+	ECVolumeIndex     string
+	DiskCheckInterval Duration
+	DiskMaxErrors     int      // io errors in a check fail the disk
+	DiskMaxDelay      Duration // average delay in a check makes the disk read only
 }
 
 type Volume struct {
-	SyncDelete      int
-	SyncDeleteDelay Duration
+	SyncDelete         int
+	SyncDeleteDelay    Duration
+	ScrubInterval      Duration
+	ScrubRate          int
+	ScrubMark          bool
+	CompactInterval    Duration
+	CompactRatio       float64
+	CompactRate        int
+	CompactWindows     []Window
+	Needles            string // needle index type, map or compact
+	CheckpointInterval Duration
+	Durability         string // default durability of the writes, cache, sync or group
+	GroupCommit        Duration
 }
 
 type Block struct {
-	BufferSize    int `toml:"-"`
-	SyncWrite     int
-	Syncfilerange bool
+	BufferSize     int `toml:"-"`
+	StreamBuffer   int
+	SyncWrite      int
+	Syncfilerange  bool
+	Ver            int // new block version
+	Padding        int // new v2 block padding
+	ReadEngine     string
+	ReadQueueDepth int
 }
 
 type Index struct {
@@ -83,6 +117,42 @@ func (d *Duration) UnmarshalText(text []byte) error {
 	return err
 }
 
+// Window is a daily time window, e.g. "22:00-06:00" crosses the midnight.
+type Window struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+func (w *Window) UnmarshalText(text []byte) (err error) {
+	var (
+		t          time.Time
+		start, end string
+		i          = strings.Index(string(text), "-")
+	)
+	if i < 0 {
+		return fmt.Errorf("window: %s not valid", text)
+	}
+	start, end = string(text[:i]), string(text[i+1:])
+	if t, err = time.Parse("15:04", start); err != nil {
+		return
+	}
+	w.Start = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if t, err = time.Parse("15:04", end); err != nil {
+		return
+	}
+	w.End = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	return
+}
+
+// In check the time in the window.
+func (w *Window) In(t time.Time) bool {
+	var d = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if w.Start <= w.End {
+		return d >= w.Start && d < w.End
+	}
+	return d >= w.Start || d < w.End
+}
+
 // NewConfig new a config.
 func NewConfig(conf string) (c *Config, err error) {
 	var (
@@ -97,8 +167,43 @@ func NewConfig(conf string) (c *Config, err error) {
 		return
 	}
 	if err = toml.Unmarshal(blob, c); err == nil {
-		c.BlockMaxSize = needle.Size(c.NeedleMaxSize)
-		c.Block.BufferSize = needle.Size(c.NeedleMaxSize)
+		c.BlockMaxSize = needle.MaxSize(c.NeedleMaxSize)
+		c.Block.BufferSize = needle.MaxSize(c.NeedleMaxSize)
+		if c.Block.StreamBuffer <= 0 {
+			c.Block.StreamBuffer = _streamBuffer
+		}
+		if c.Block.Ver <= 0 {
+			c.Block.Ver = _blockVer
+		}
+		if c.Block.Padding <= 0 {
+			c.Block.Padding = needle.PaddingSize
+		}
+		if c.Block.ReadQueueDepth <= 0 {
+			c.Block.ReadQueueDepth = _readQueueDepth
+		}
+		if c.Store.ECVolumeIndex == "" {
+			c.Store.ECVolumeIndex = filepath.Join(filepath.Dir(c.Store.VolumeIndex), _ecVolumeIndex)
+		}
 	}
 	return
 }
+
+// NewOfflineConfig new the config of the block and index for the offline
+// tools, no store config needed, only the size and the buffers are used.
+func NewOfflineConfig(needleMaxSize int) *Config {
+	return &Config{
+		NeedleMaxSize: needleMaxSize,
+		BlockMaxSize:  needle.MaxSize(needleMaxSize),
+		Block: &Block{
+			BufferSize: needle.MaxSize(needleMaxSize),
+			SyncWrite:  1024,
+		},
+		Index: &Index{
+			BufferSize: 4096,
+			MergeDelay: Duration{Duration: time.Hour},
+			MergeWrite: 1024,
+			RingBuffer: 1024,
+			SyncWrite:  1024,
+		},
+	}
+}
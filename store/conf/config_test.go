@@ -2,7 +2,37 @@ package conf
 
 import (
 	"testing"
+	"time"
 )
 
 func TestConfig(t *testing.T) {
 }
+
+func TestWindow(t *testing.T) {
+	var (
+		w   Window
+		err error
+		day = time.Date(2016, 1, 1, 0, 0, 0, 0, time.Local)
+	)
+	if err = w.UnmarshalText([]byte("0200")); err == nil {
+		t.Errorf("UnmarshalText() no error")
+		t.FailNow()
+	}
+	if err = w.UnmarshalText([]byte("02:00-06:30")); err != nil {
+		t.Errorf("UnmarshalText() error(%v)", err)
+		t.FailNow()
+	}
+	if !w.In(day.Add(2*time.Hour)) || !w.In(day.Add(6*time.Hour)) || w.In(day.Add(6*time.Hour+30*time.Minute)) || w.In(day.Add(time.Hour)) {
+		t.Errorf("In() not match")
+		t.FailNow()
+	}
+	// cross the midnight
+	if err = w.UnmarshalText([]byte("22:00-02:00")); err != nil {
+		t.Errorf("UnmarshalText() error(%v)", err)
+		t.FailNow()
+	}
+	if !w.In(day.Add(23*time.Hour)) || !w.In(day.Add(time.Hour)) || w.In(day.Add(12*time.Hour)) {
+		t.Errorf("In() not match")
+		t.FailNow()
+	}
+}
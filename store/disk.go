@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	myos "bfs/store/os"
+	"bfs/store/volume"
+	log "github.com/golang/glog"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Disk a mount point of the store, the volumes on it fail together.
+type Disk struct {
+	meta.Disk
+	// the totals of the volumes at the check
+	ioErrors uint64
+	ops      uint64
+	delay    uint64
+}
+
+// offline reports whether the volume is offline alone.
+func (d *Disk) offline(vid int32) bool {
+	var id int32
+	for _, id = range d.Offline {
+		if id == vid {
+			return true
+		}
+	}
+	return false
+}
+
+// window get the increment of a total since the last check, the total may
+// shrink if a volume is moved away.
+func window(total, last uint64) uint64 {
+	if total < last {
+		return total
+	}
+	return total - last
+}
+
+// diskproc check the disks every DiskCheckInterval, the status changes are
+// saved into zookeeper, then directory routes around the bad volumes.
+func (s *Store) diskproc() {
+	var err error
+	for {
+		time.Sleep(s.conf.Store.DiskCheckInterval.Duration)
+		if !s.checkDisks() {
+			continue
+		}
+		if err = s.SetZookeeper(); err != nil {
+			log.Errorf("SetZookeeper() error(%v)", err)
+		}
+	}
+}
+
+// mountPoint get the mount point of the block dir, the dir itself if failed.
+func (s *Store) mountPoint(file string) (mp string) {
+	var (
+		ok  bool
+		err error
+		dir = filepath.Dir(file)
+	)
+	s.dlock.Lock()
+	mp, ok = s.mounts[dir]
+	s.dlock.Unlock()
+	if ok {
+		return
+	}
+	if mp, err = myos.MountPoint(dir); err != nil {
+		log.Errorf("MountPoint(\"%s\") error(%v)", dir, err)
+		return dir
+	}
+	s.dlock.Lock()
+	s.mounts[dir] = mp
+	s.dlock.Unlock()
+	return
+}
+
+// checkDisks group the volumes by the mount point. a disk fails if the io
+// errors in the check window reach DiskMaxErrors, then it stays failed until
+// reset by ResetDisk, for its offline volumes count no more errors. it's read
+// only if the average delay of the gets and writes is over DiskMaxDelay, the
+// volumes follow the disk, a broken volume is offline alone. returns whether
+// a status changed.
+func (s *Store) checkDisks() (changed bool) {
+	var (
+		ok     bool
+		mp     string
+		status int
+		d, od  *Disk
+		v      *volume.Volume
+		ops    uint64
+		olds   map[string]*Disk
+		resets map[string]bool
+		disks  = make(map[string]*Disk)
+	)
+	for _, v = range s.Volumes {
+		mp = s.mountPoint(v.Block.File)
+		if d, ok = disks[mp]; !ok {
+			d = &Disk{}
+			d.Path = mp
+			disks[mp] = d
+		}
+		d.Volumes = append(d.Volumes, v.Id)
+		if v.Broken() {
+			d.Offline = append(d.Offline, v.Id)
+		}
+		d.ioErrors += v.IOErrorCount()
+		d.ops += v.Stats.TotalGetProcessed + v.Stats.TotalWriteProcessed
+		d.delay += v.Stats.TotalGetDelay + v.Stats.TotalWriteDelay
+	}
+	s.dlock.Lock()
+	olds = s.disks
+	resets, s.dresets = s.dresets, make(map[string]bool)
+	s.dlock.Unlock()
+	for mp, d = range disks {
+		if od, ok = olds[mp]; !ok {
+			od = &Disk{}
+			changed = changed || len(d.Offline) > 0
+		}
+		d.IOErrors = window(d.ioErrors, od.ioErrors)
+		if ops = window(d.ops, od.ops); ops > 0 {
+			d.Delay = window(d.delay, od.delay) / ops
+		}
+		if s.conf.Store.DiskMaxErrors > 0 && d.IOErrors >= uint64(s.conf.Store.DiskMaxErrors) {
+			d.Status = meta.DiskStatusFail
+		} else if s.conf.Store.DiskMaxDelay.Duration > 0 && d.Delay > uint64(s.conf.Store.DiskMaxDelay.Duration) {
+			d.Status = meta.DiskStatusRead
+		} else {
+			d.Status = meta.DiskStatusHealth
+		}
+		if od.Status == meta.DiskStatusFail && !resets[mp] {
+			d.Status = meta.DiskStatusFail
+		}
+		if d.Status != od.Status || len(d.Offline) != len(od.Offline) {
+			log.Warningf("disk: %s status: %d -> %d, offline volumes: %v, io errors: %d, delay: %v", mp, od.Status, d.Status, d.Offline, d.IOErrors, time.Duration(d.Delay))
+			changed = true
+		}
+	}
+	for _, v = range s.Volumes {
+		d = disks[s.mountPoint(v.Block.File)]
+		if status = d.Status; d.offline(v.Id) {
+			status = meta.DiskStatusFail
+		}
+		v.SetStatus(status)
+	}
+	s.dlock.Lock()
+	s.disks = disks
+	s.dlock.Unlock()
+	return
+}
+
+// ResetDisk reset a failed disk after it's repaired or replaced, the volumes
+// of it are online again from the next check if no io errors then.
+func (s *Store) ResetDisk(path string) (err error) {
+	var (
+		ok bool
+		d  *Disk
+	)
+	s.dlock.Lock()
+	if d, ok = s.disks[path]; ok && d.Status == meta.DiskStatusFail {
+		s.dresets[path] = true
+	} else {
+		ok = false
+	}
+	s.dlock.Unlock()
+	if !ok {
+		log.Errorf("disk: %s not failed", path)
+		err = errors.ErrParam
+		return
+	}
+	log.Warningf("disk: %s reset", path)
+	return
+}
+
+// Disks get the disks of the last check, sorted by the path.
+func (s *Store) Disks() (disks []*meta.Disk) {
+	var d *Disk
+	s.dlock.Lock()
+	for _, d = range s.disks {
+		disks = append(disks, &d.Disk)
+	}
+	s.dlock.Unlock()
+	sort.Sort(diskList(disks))
+	return
+}
+
+// diskList sort disks by the path.
+type diskList []*meta.Disk
+
+func (p diskList) Len() int           { return len(p) }
+func (p diskList) Less(i, j int) bool { return p[i].Path < p[j].Path }
+func (p diskList) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
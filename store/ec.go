@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"bfs/store/volume"
+	"encoding/json"
+	"fmt"
+	log "github.com/golang/glog"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Erasure coded volume, a full volume is coded into k data shards and m
+// parity shards, the shards are spread across stores, every store holds
+// some shards and the whole needle index, a needle is read from the local
+// shards or fetched from the stores hold the others, a lost shard range is
+// reconstructed from any k shards.
+//
+// ec volume index file format:
+//  ----------------------
+// | /bfs/ec_block_1\n    |
+// | /bfs/ec_block_2\n    |
+//  ----------------------
+// the shards, needle index and meta are saved as ec_block_1_0 ...
+// ec_block_1.idx, ec_block_1.meta.
+
+const (
+	ecVolumePrefix = "ec_block_"
+	// ec file
+	ecFileShard = "shard"
+	ecFileIndex = "index"
+	ecFileMeta  = "meta"
+	// api
+	_ecFileApi = "http://%s/ec_file?vid=%d&type=%s&shard=%d&offset=%d&size=%d"
+)
+
+var (
+	_ecClient = &http.Client{
+		Transport: &http.Transport{
+			DisableCompression: true,
+		},
+		Timeout: 5 * time.Second,
+	}
+)
+
+// ecFile get a erasure coded volume file in the dir.
+func (s *Store) ecFile(id int32, dir string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%d", ecVolumePrefix, id))
+}
+
+// parseECVolumeIndex parse the erasure coded volume index from local.
+func (s *Store) parseECVolumeIndex() (err error) {
+	var (
+		data []byte
+		line string
+		ev   *volume.ECVolume
+	)
+	if data, err = ioutil.ReadAll(s.evf); err != nil {
+		log.Errorf("ioutil.ReadAll() error(%v)", err)
+		return
+	}
+	for _, line = range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		if ev, err = volume.NewECVolume(line, s.conf); err != nil {
+			log.Errorf("volume.NewECVolume(\"%s\") error(%v)", line, err)
+			return
+		}
+		s.ECVolumes[ev.Id] = ev
+	}
+	return
+}
+
+// saveECVolumeIndex save the erasure coded volume index to disk.
+func (s *Store) saveECVolumeIndex() (err error) {
+	var (
+		tn, n int
+		ev    *volume.ECVolume
+	)
+	if _, err = s.evf.Seek(0, os.SEEK_SET); err != nil {
+		log.Errorf("evf.Seek() error(%v)", err)
+		return
+	}
+	for _, ev = range s.ECVolumes {
+		if n, err = s.evf.WriteString(fmt.Sprintf("%s\n", ev.File)); err != nil {
+			log.Errorf("evf.WriteString() error(%v)", err)
+			return
+		}
+		tn += n
+	}
+	if err = s.evf.Sync(); err != nil {
+		log.Errorf("evf.Sync() error(%v)", err)
+		return
+	}
+	if err = os.Truncate(s.conf.Store.ECVolumeIndex, int64(tn)); err != nil {
+		log.Errorf("os.Truncate() error(%v)", err)
+	}
+	return
+}
+
+// setECVolume copy-on-write set a erasure coded volume, nil means delete,
+// the old one is closed after a while for the readers may still use it.
+func (s *Store) setECVolume(id int32, ev *volume.ECVolume) (err error) {
+	var (
+		vid     int32
+		v, ov   *volume.ECVolume
+		volumes = make(map[int32]*volume.ECVolume, len(s.ECVolumes)+1)
+	)
+	s.vlock.Lock()
+	for vid, v = range s.ECVolumes {
+		volumes[vid] = v
+	}
+	ov = volumes[id]
+	if ev != nil {
+		volumes[id] = ev
+	} else {
+		delete(volumes, id)
+	}
+	s.ECVolumes = volumes
+	err = s.saveECVolumeIndex()
+	s.vlock.Unlock()
+	if ov != nil {
+		go func() {
+			time.Sleep(_compactSleep)
+			ov.Close()
+			if ev == nil {
+				ov.Destroy()
+			}
+		}()
+	}
+	return
+}
+
+// loadECVolume load a erasure coded volume from file and replace the old.
+func (s *Store) loadECVolume(file string) (ev *volume.ECVolume, err error) {
+	if ev, err = volume.NewECVolume(file, s.conf); err != nil {
+		log.Errorf("volume.NewECVolume(\"%s\") error(%v)", file, err)
+		return
+	}
+	err = s.setECVolume(ev.Id, ev)
+	return
+}
+
+// EncodeECVolume code a full volume into k data shards and m parity shards
+// next to the block, all the shards are on this store until moved.
+func (s *Store) EncodeECVolume(id int32, k, m int) (em *meta.ECVolume, err error) {
+	var (
+		i    int
+		file string
+		v    *volume.Volume
+		sb   meta.SuperBlock
+	)
+	if v = s.Volumes[id]; v == nil {
+		return nil, errors.ErrVolumeNotExist
+	}
+	if s.ECVolumes[id] != nil {
+		return nil, errors.ErrVolumeExist
+	}
+	sb.Offset, sb.Padding = v.Block.Offset, v.Block.Padding
+	if !sb.Full() {
+		return nil, errors.ErrVolumeNotFull
+	}
+	file = s.ecFile(id, filepath.Dir(v.Block.File))
+	if em, err = v.EncodeEC(k, m, file); err != nil {
+		log.Errorf("volume: %d EncodeEC() error(%v)", id, err)
+		return
+	}
+	for i = 0; i < k+m; i++ {
+		em.Stores[i] = s.conf.Zookeeper.ServerId
+	}
+	if err = volume.WriteECMeta(file, em); err != nil {
+		return
+	}
+	_, err = s.loadECVolume(file)
+	return
+}
+
+// AddECShards copy the shards, needle index and meta of a erasure coded
+// volume from the src store admin addr into dir.
+func (s *Store) AddECShards(id int32, shards []int, src, dir string) (err error) {
+	var (
+		shard int
+		file  string
+		ev    *volume.ECVolume
+	)
+	if ev = s.ECVolumes[id]; ev != nil {
+		file = ev.File
+	} else {
+		file = s.ecFile(id, dir)
+	}
+	if err = s.ecFetch(src, id, ecFileMeta, 0, volume.ECMetaFile(file)); err != nil {
+		return
+	}
+	if err = s.ecFetch(src, id, ecFileIndex, 0, volume.ECIndexFile(file)); err != nil {
+		return
+	}
+	for _, shard = range shards {
+		if err = s.ecFetch(src, id, ecFileShard, shard, volume.ECShardFile(file, shard)); err != nil {
+			return
+		}
+	}
+	_, err = s.loadECVolume(file)
+	return
+}
+
+// SetECVolume update the meta of a erasure coded volume, the local shards
+// not belong to this store any more are removed, if none left, the volume
+// is deleted.
+func (s *Store) SetECVolume(em *meta.ECVolume) (err error) {
+	var (
+		i      int
+		shards []int
+		ev     *volume.ECVolume
+	)
+	if ev = s.ECVolumes[em.Vid]; ev == nil {
+		return errors.ErrVolumeNotExist
+	}
+	if len(em.Stores) != em.K+em.M || em.K != ev.Meta.K || em.M != ev.Meta.M {
+		return errors.ErrECParam
+	}
+	if shards = em.Shards(s.conf.Zookeeper.ServerId); len(shards) == 0 {
+		log.Infof("ec volume: %d no shard left, delete it", em.Vid)
+		return s.setECVolume(em.Vid, nil)
+	}
+	em.BlockSize, em.ShardSize = ev.Meta.BlockSize, ev.Meta.ShardSize
+	if err = volume.WriteECMeta(ev.File, em); err != nil {
+		return
+	}
+	for i = 0; i < em.K+em.M; i++ {
+		if em.Stores[i] != s.conf.Zookeeper.ServerId {
+			os.Remove(volume.ECShardFile(ev.File, i))
+		}
+	}
+	_, err = s.loadECVolume(ev.File)
+	return
+}
+
+// RebuildECShard rebuild a lost shard of this store from the others.
+func (s *Store) RebuildECShard(id int32, shard int) (err error) {
+	var ev *volume.ECVolume
+	if ev = s.ECVolumes[id]; ev == nil {
+		return errors.ErrVolumeNotExist
+	}
+	if shard < 0 || shard >= len(ev.Meta.Stores) || ev.Meta.Stores[shard] != s.conf.Zookeeper.ServerId {
+		return errors.ErrECParam
+	}
+	return ev.Rebuild(shard, s.ecFetcher(ev))
+}
+
+// ecFetch copy a erasure coded volume file from the src store, the file is
+// replaced after copied.
+func (s *Store) ecFetch(src string, id int32, typ string, shard int, file string) (err error) {
+	var (
+		f    *os.File
+		resp *http.Response
+		tmp  = file + ".tmp"
+		uri  = fmt.Sprintf(_ecFileApi, src, id, typ, shard, 0, -1)
+	)
+	if resp, err = _recoverClient.Get(uri); err != nil {
+		log.Errorf("_recoverClient.Get(%s) error(%v)", uri, err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("_recoverClient.Get(%s) status: %d", uri, resp.StatusCode)
+		return errors.ErrInternal
+	}
+	if f, err = os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664); err != nil {
+		log.Errorf("os.OpenFile(\"%s\") error(%v)", tmp, err)
+		return
+	}
+	if _, err = io.CopyBuffer(f, resp.Body, make([]byte, _recoverBuffer)); err != nil {
+		log.Errorf("io.Copy(%s) error(%v)", uri, err)
+	} else if err = f.Sync(); err != nil {
+		log.Errorf("file: %s Sync() error(%v)", tmp, err)
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return
+}
+
+// ecFetcher get a fetcher which reads the shard range from the store holds
+// the shard.
+func (s *Store) ecFetcher(ev *volume.ECVolume) volume.ECFetcher {
+	return func(shard int, offset int64, buf []byte) (err error) {
+		var (
+			admin string
+			resp  *http.Response
+			id    = ev.Meta.Stores[shard]
+		)
+		if id == s.conf.Zookeeper.ServerId {
+			return errors.ErrECShardNotExist
+		}
+		if admin, err = s.storeAdmin(id); err != nil {
+			return
+		}
+		uri := fmt.Sprintf(_ecFileApi, admin, ev.Id, ecFileShard, shard, offset, len(buf))
+		if resp, err = _ecClient.Get(uri); err != nil {
+			log.Errorf("_ecClient.Get(%s) error(%v)", uri, err)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Errorf("_ecClient.Get(%s) status: %d", uri, resp.StatusCode)
+			return errors.ErrECShardNotExist
+		}
+		if _, err = io.ReadFull(resp.Body, buf); err != nil {
+			log.Errorf("io.ReadFull(%s) error(%v)", uri, err)
+		}
+		return
+	}
+}
+
+// storeAdmin get the admin addr of a store, the stores are reloaded from
+// zookeeper if not found.
+func (s *Store) storeAdmin(id string) (admin string, err error) {
+	var (
+		ok     bool
+		store  *meta.Store
+		stores []*meta.Store
+	)
+	s.slock.Lock()
+	defer s.slock.Unlock()
+	if admin, ok = s.stores[id]; ok {
+		return
+	}
+	if stores, err = s.zk.Stores(); err != nil {
+		return
+	}
+	s.stores = make(map[string]string, len(stores))
+	for _, store = range stores {
+		s.stores[store.Id] = store.Admin
+	}
+	if admin, ok = s.stores[id]; !ok {
+		log.Errorf("store: %s not exist", id)
+		err = errors.ErrECShardNotExist
+	}
+	return
+}
+
+// ecVolumeMeta parse a erasure coded volume meta.
+func ecVolumeMeta(data string) (em *meta.ECVolume, err error) {
+	em = new(meta.ECVolume)
+	if err = json.Unmarshal([]byte(data), em); err != nil {
+		log.Errorf("json.Unmarshal(\"%s\") error(%v)", data, err)
+		err = errors.ErrParam
+	}
+	return
+}
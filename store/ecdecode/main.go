@@ -0,0 +1,199 @@
+package main
+
+// ecdecode rebuild the lost shards of a erasure coded volume offline from
+// any k local shard files, or decode the shards back to the block file.
+//
+// usage:
+//  ecdecode -f /bfs/ec_block_1 [-s 1,3] [-b /bfs/block_1]
+
+import (
+	"bfs/libs/ec"
+	"bfs/libs/meta"
+	"bfs/store/volume"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	_chunk = 1024 * 1024
+)
+
+var (
+	file   string
+	shards string
+	block  string
+)
+
+func init() {
+	flag.StringVar(&file, "f", "", "erasure coded volume file, e.g. /bfs/ec_block_1")
+	flag.StringVar(&shards, "s", "", "rebuild shards, e.g. 1,3, default all the lost")
+	flag.StringVar(&block, "b", "", "decode the block to the file")
+}
+
+func main() {
+	var err error
+	flag.Parse()
+	if file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err = decode(); err != nil {
+		fmt.Fprintf(os.Stderr, "ecdecode: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func decode() (err error) {
+	var (
+		i, shard    int
+		data        []byte
+		str         string
+		offset, n   int64
+		em          = new(meta.ECVolume)
+		coder       *ec.Coder
+		files       []*os.File
+		rebuild     = map[int]*os.File{}
+		bf          *os.File
+		bufs, parts [][]byte
+	)
+	if data, err = ioutil.ReadFile(volume.ECMetaFile(file)); err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, em); err != nil {
+		return
+	}
+	if coder, err = ec.New(em.K, em.M); err != nil {
+		return
+	}
+	files = make([]*os.File, em.K+em.M)
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	for i = 0; i < em.K+em.M; i++ {
+		if files[i], err = os.Open(volume.ECShardFile(file, i)); err != nil {
+			if !os.IsNotExist(err) {
+				return
+			}
+			files[i], err = nil, nil
+			if shards == "" {
+				rebuild[i] = nil
+			}
+		}
+	}
+	if shards != "" {
+		for _, str = range strings.Split(shards, ",") {
+			if shard, err = strconv.Atoi(str); err != nil || shard < 0 || shard >= em.K+em.M {
+				return fmt.Errorf("shard: %s not valid", str)
+			}
+			if files[shard] != nil {
+				return fmt.Errorf("shard: %d exist", shard)
+			}
+			rebuild[shard] = nil
+		}
+	}
+	for shard = range rebuild {
+		if rebuild[shard], err = os.OpenFile(volume.ECShardFile(file, shard)+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664); err != nil {
+			return
+		}
+	}
+	defer func() {
+		for shard, f := range rebuild {
+			f.Close()
+			if err != nil {
+				os.Remove(volume.ECShardFile(file, shard) + ".tmp")
+			}
+		}
+	}()
+	if block != "" {
+		if bf, err = os.OpenFile(block, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0664); err != nil {
+			return
+		}
+		defer bf.Close()
+	}
+	bufs = make([][]byte, em.K+em.M)
+	for i = 0; i < em.K+em.M; i++ {
+		bufs[i] = make([]byte, _chunk)
+	}
+	parts = make([][]byte, em.K+em.M)
+	for offset = 0; offset < em.ShardSize; offset += n {
+		if n = em.ShardSize - offset; n > _chunk {
+			n = _chunk
+		}
+		for i = 0; i < em.K+em.M; i++ {
+			parts[i] = nil
+			if files[i] == nil {
+				continue
+			}
+			if _, err = files[i].ReadAt(bufs[i][:n], offset); err != nil {
+				if err != io.EOF {
+					return
+				}
+				// treat a short shard as lost
+				err = nil
+				continue
+			}
+			parts[i] = bufs[i][:n]
+		}
+		if err = coder.Reconstruct(parts); err != nil {
+			return
+		}
+		for shard = range rebuild {
+			if _, err = rebuild[shard].Write(parts[shard]); err != nil {
+				return
+			}
+		}
+		if bf != nil {
+			if err = writeBlock(bf, em, parts, offset, n); err != nil {
+				return
+			}
+		}
+	}
+	for shard = range rebuild {
+		if err = rebuild[shard].Sync(); err != nil {
+			return
+		}
+		if err = os.Rename(volume.ECShardFile(file, shard)+".tmp", volume.ECShardFile(file, shard)); err != nil {
+			return
+		}
+		fmt.Printf("shard: %d rebuilt\n", shard)
+	}
+	if bf != nil {
+		if err = bf.Sync(); err == nil {
+			fmt.Printf("block: %s decoded\n", block)
+		}
+	}
+	return
+}
+
+// writeBlock write the data shards range back to the block, the padding of
+// the last shard is dropped.
+func writeBlock(bf *os.File, em *meta.ECVolume, parts [][]byte, offset, n int64) (err error) {
+	var (
+		i    int
+		boff int64
+		size int64
+	)
+	for i = 0; i < em.K; i++ {
+		boff = int64(i)*em.ShardSize + offset
+		if boff >= em.BlockSize {
+			break
+		}
+		if size = em.BlockSize - boff; size > n {
+			size = n
+		}
+		if _, err = bf.WriteAt(parts[i][:size], boff); err != nil {
+			return
+		}
+	}
+	return
+}
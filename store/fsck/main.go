@@ -0,0 +1,318 @@
+package main
+
+// fsck inspect and repair a volume offline from the block and index files,
+// no zookeeper or store config needed, so the files copied off the machine
+// can be checked. every needle is verified (magic, checksum and padding), a
+// corrupt needle in the middle is skipped by the index offsets, the summary
+// counts the live, deleted and corrupt needles, the exit status is 1 if the
+// volume is not clean after the repair.
+//
+// usage:
+//  fsck -f /bfs/block_1 [-i /bfs/block_1.idx] [-d] [-r] [-t]
+
+import (
+	"bfs/store/block"
+	"bfs/store/conf"
+	"bfs/store/index"
+	"bfs/store/needle"
+	"bfs/store/volume"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+const (
+	_tmp    = ".fsck"
+	_backup = ".bak"
+)
+
+var (
+	file     string
+	ifile    string
+	maxSize  int
+	dump     bool
+	rebuild  bool
+	truncate bool
+)
+
+func init() {
+	flag.StringVar(&file, "f", "", "block file, e.g. /bfs/block_1")
+	flag.StringVar(&ifile, "i", "", "index file, e.g. /bfs/block_1.idx")
+	flag.IntVar(&maxSize, "n", 10485760, "needle max size, same as the store config")
+	flag.BoolVar(&dump, "d", false, "dump every needle")
+	flag.BoolVar(&rebuild, "r", false, "rebuild the index from the block, the old one is kept with the suffix .bak")
+	flag.BoolVar(&truncate, "t", false, "truncate the corrupt tail to the last valid needle")
+}
+
+// scanNeedle a needle scanned from the block.
+type scanNeedle struct {
+	key    int64
+	offset uint32
+	size   int32
+	flag   byte
+}
+
+// result the fsck result.
+type result struct {
+	needles  []scanNeedle
+	offsets  map[uint32]int // needle offset -> needles index
+	corrupts []uint32
+	end      uint32 // the offset after the last valid needle
+	tail     int64  // the bytes after the last valid needle
+	// index
+	indexes  []index.Index
+	indexErr error
+	disorder int // the offset less than the last one
+	mismatch int // not match any needle
+}
+
+func main() {
+	var (
+		err error
+		res *result
+	)
+	flag.Parse()
+	if file == "" || (rebuild && ifile == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if res, err = fsck(); err != nil {
+		fmt.Fprintf(os.Stderr, "fsck: %v\n", err)
+		os.Exit(1)
+	}
+	if !res.clean() {
+		os.Exit(1)
+	}
+}
+
+func fsck() (res *result, err error) {
+	var (
+		l int
+		c = conf.NewOfflineConfig(maxSize)
+		b *block.SuperBlock
+	)
+	res = &result{offsets: make(map[uint32]int)}
+	// never create the missing files
+	if _, err = os.Stat(file); err != nil {
+		return
+	}
+	if ifile != "" {
+		if _, err = os.Stat(ifile); err == nil {
+			res.readIndex(c)
+		} else if os.IsNotExist(err) && rebuild {
+			err = nil
+		} else {
+			return
+		}
+	}
+	if b, err = block.NewSuperBlock(file, c); err != nil {
+		return
+	}
+	err = res.scanBlock(b)
+	b.Close()
+	if err != nil {
+		return
+	}
+	res.checkIndex()
+	res.print(b)
+	if truncate && res.tail > 0 {
+		if err = os.Truncate(file, b.BlockOffset(res.end)); err != nil {
+			return
+		}
+		fmt.Printf("block: %s truncated to offset: %d\n", file, res.end)
+		res.tail = 0
+		// the corrupt needle broke the last scan is in the tail
+		if l = len(res.corrupts); l > 0 && res.corrupts[l-1] == res.end {
+			res.corrupts = res.corrupts[:l-1]
+		}
+	}
+	if rebuild {
+		if err = res.rebuildIndex(c); err != nil {
+			return
+		}
+		fmt.Printf("index: %s rebuilt, entries: %d\n", ifile, len(res.indexes))
+	}
+	if res.clean() {
+		fmt.Println("clean")
+	} else {
+		fmt.Println("not clean")
+	}
+	return
+}
+
+// readIndex read all the index entries.
+func (res *result) readIndex(c *conf.Config) {
+	var (
+		err error
+		ix  *index.Indexer
+		r   *os.File
+	)
+	if ix, err = index.NewIndexer(ifile, c); err != nil {
+		res.indexErr = err
+		return
+	}
+	defer ix.Close()
+	if r, err = os.Open(ifile); err != nil {
+		res.indexErr = err
+		return
+	}
+	defer r.Close()
+	res.indexErr = ix.Scan(r, func(i *index.Index) error {
+		res.indexes = append(res.indexes, *i)
+		return nil
+	})
+}
+
+// scanBlock scan the block, a corrupt needle breaks the scan, then it's
+// resumed from the next index offset, the rest is the tail if no one.
+func (res *result) scanBlock(b *block.SuperBlock) (err error) {
+	var (
+		i       int
+		last    uint32
+		offsets []uint32
+		ix      index.Index
+		fi      os.FileInfo
+		r       *os.File
+	)
+	for _, ix = range res.indexes {
+		offsets = append(offsets, ix.Offset)
+	}
+	sort.Sort(uint32s(offsets))
+	if r, err = os.Open(file); err != nil {
+		return
+	}
+	defer r.Close()
+	last = b.Offset
+	for {
+		if err = b.Scan(r, last, func(n *needle.Needle, so, eo uint32) error {
+			res.offsets[so] = len(res.needles)
+			res.needles = append(res.needles, scanNeedle{key: n.Key, offset: so, size: n.TotalSize, flag: n.Flag})
+			if dump {
+				fmt.Printf("offset: %d key: %d cookie: %d flag: %d size: %d total: %d expire: %d meta: %v\n",
+					so, n.Key, n.Cookie, n.Flag, n.Size, n.TotalSize, n.Expire, n.Meta)
+			}
+			last = eo
+			return nil
+		}); err == nil {
+			break
+		}
+		fmt.Printf("corrupt needle at offset: %d error(%v)\n", last, err)
+		res.corrupts = append(res.corrupts, last)
+		for i < len(offsets) && offsets[i] <= last {
+			i++
+		}
+		if i == len(offsets) {
+			break
+		}
+		last = offsets[i]
+	}
+	if fi, err = r.Stat(); err != nil {
+		return
+	}
+	res.end = last
+	res.tail = fi.Size() - b.BlockOffset(last)
+	return
+}
+
+// checkIndex check every index entry points to a scanned needle.
+func (res *result) checkIndex() {
+	var (
+		ok   bool
+		i    int
+		last uint32
+		ix   index.Index
+		n    scanNeedle
+	)
+	for _, ix = range res.indexes {
+		if ix.Offset < last {
+			res.disorder++
+		}
+		last = ix.Offset
+		if i, ok = res.offsets[ix.Offset]; ok {
+			n = res.needles[i]
+		}
+		if !ok || n.key != ix.Key || n.size != ix.Size {
+			res.mismatch++
+		}
+	}
+}
+
+// rebuildIndex write the live needles into a new index, then replace the
+// old one.
+func (res *result) rebuildIndex(c *conf.Config) (err error) {
+	var (
+		n  scanNeedle
+		ix *index.Indexer
+	)
+	os.Remove(ifile + _tmp)
+	if ix, err = index.NewIndexer(ifile+_tmp, c); err != nil {
+		return
+	}
+	res.indexes = res.indexes[:0]
+	for _, n = range res.needles {
+		if n.flag != needle.FlagOK {
+			continue
+		}
+		if err = ix.Write(n.key, n.offset, n.size); err != nil {
+			break
+		}
+		res.indexes = append(res.indexes, index.Index{Key: n.key, Offset: n.offset, Size: n.size})
+	}
+	if err == nil {
+		err = ix.Flush()
+	}
+	ix.Close()
+	if err != nil {
+		os.Remove(ifile + _tmp)
+		return
+	}
+	if err = os.Rename(ifile, ifile+_backup); err != nil && !os.IsNotExist(err) {
+		return
+	}
+	if err = os.Rename(ifile+_tmp, ifile); err == nil {
+		res.indexErr, res.disorder, res.mismatch = nil, 0, 0
+		// the checkpoint is of the old index
+		os.Remove(volume.CheckpointFile(ifile))
+	}
+	return
+}
+
+// print print the summary.
+func (res *result) print(b *block.SuperBlock) {
+	var (
+		live, dels, overwrites int
+		n                      scanNeedle
+		latest                 = make(map[int64]uint32)
+	)
+	for _, n = range res.needles {
+		latest[n.key] = n.offset
+	}
+	for _, n = range res.needles {
+		if n.flag == needle.FlagDel {
+			dels++
+		} else if latest[n.key] != n.offset {
+			overwrites++
+		} else {
+			live++
+		}
+	}
+	fmt.Printf("block: %s ver: %d padding: %d\n", file, b.Ver, b.Padding)
+	fmt.Printf("needles: %d, live: %d, deleted: %d, overwritten: %d, corrupt: %d, tail: %d bytes after offset: %d\n",
+		len(res.needles), live, dels, overwrites, len(res.corrupts), res.tail, res.end)
+	if ifile != "" {
+		fmt.Printf("index: %s entries: %d, out of order: %d, mismatch: %d, error: %v\n",
+			ifile, len(res.indexes), res.disorder, res.mismatch, res.indexErr)
+	}
+}
+
+// clean check no corrupt, tail or bad index left.
+func (res *result) clean() bool {
+	return len(res.corrupts) == 0 && res.tail == 0 && res.indexErr == nil && res.disorder == 0 && res.mismatch == 0
+}
+
+type uint32s []uint32
+
+func (p uint32s) Len() int           { return len(p) }
+func (p uint32s) Less(i, j int) bool { return p[i] < p[j] }
+func (p uint32s) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bfs/store/block"
+	"bfs/store/conf"
+	"bfs/store/index"
+	"bfs/store/needle"
+	"bytes"
+	"os"
+	"testing"
+)
+
+// testVolume write the needles 1-5 into a new block and index, the needle 2
+// is deleted, returns the needle offsets and the block offset of them.
+func testVolume(t *testing.T, bfile, ifile string) (offsets []uint32, boffsets []int64) {
+	var (
+		i   int64
+		err error
+		b   *block.SuperBlock
+		ix  *index.Indexer
+		n   *needle.Needle
+		c   = conf.NewOfflineConfig(maxSize)
+	)
+	os.Remove(bfile)
+	os.Remove(ifile)
+	if b, err = block.NewSuperBlock(bfile, c); err != nil {
+		t.Errorf("NewSuperBlock() error(%v)", err)
+		t.FailNow()
+	}
+	defer b.Close()
+	if ix, err = index.NewIndexer(ifile, c); err != nil {
+		t.Errorf("NewIndexer() error(%v)", err)
+		t.FailNow()
+	}
+	defer ix.Close()
+	for i = 1; i <= 5; i++ {
+		n = needle.NewWriter(i, int32(i), 4)
+		if err = n.ReadFrom(bytes.NewReader([]byte("test"))); err != nil {
+			t.Errorf("n.ReadFrom() error(%v)", err)
+			t.FailNow()
+		}
+		offsets = append(offsets, b.Offset)
+		boffsets = append(boffsets, b.BlockOffset(b.Offset))
+		if err = b.Write(n); err != nil {
+			t.Errorf("b.Write() error(%v)", err)
+			t.FailNow()
+		}
+		if err = ix.Write(i, offsets[i-1], n.TotalSize); err != nil {
+			t.Errorf("ix.Write() error(%v)", err)
+			t.FailNow()
+		}
+		n.Close()
+	}
+	if err = b.Sync(); err != nil {
+		t.Errorf("b.Sync() error(%v)", err)
+		t.FailNow()
+	}
+	if err = b.Delete(offsets[1]); err != nil {
+		t.Errorf("b.Delete() error(%v)", err)
+		t.FailNow()
+	}
+	if err = ix.Flush(); err != nil {
+		t.Errorf("ix.Flush() error(%v)", err)
+		t.FailNow()
+	}
+	return
+}
+
+// testWrite write the bytes at the offset of the file, the end if negative.
+func testWrite(t *testing.T, fname string, offset int64, data []byte) {
+	var (
+		err error
+		f   *os.File
+	)
+	if f, err = os.OpenFile(fname, os.O_WRONLY, 0664); err != nil {
+		t.Errorf("os.OpenFile() error(%v)", err)
+		t.FailNow()
+	}
+	defer f.Close()
+	if offset < 0 {
+		if offset, err = f.Seek(0, os.SEEK_END); err != nil {
+			t.Errorf("f.Seek() error(%v)", err)
+			t.FailNow()
+		}
+	}
+	if _, err = f.WriteAt(data, offset); err != nil {
+		t.Errorf("f.WriteAt() error(%v)", err)
+		t.FailNow()
+	}
+}
+
+func TestFsck(t *testing.T) {
+	var (
+		err      error
+		res      *result
+		offsets  []uint32
+		boffsets []int64
+		bfile    = "../test/test_fsck"
+		xfile    = "../test/test_fsck.idx"
+	)
+	file, ifile = bfile, xfile
+	defer os.Remove(bfile)
+	defer os.Remove(xfile)
+	defer os.Remove(xfile + _backup)
+	offsets, boffsets = testVolume(t, bfile, xfile)
+	if res, err = fsck(); err != nil {
+		t.Errorf("fsck() error(%v)", err)
+		t.FailNow()
+	}
+	if !res.clean() || len(res.needles) != 5 || len(res.indexes) != 5 || res.needles[1].flag != needle.FlagDel {
+		t.Errorf("fsck() needles: %d indexes: %d not clean", len(res.needles), len(res.indexes))
+		t.FailNow()
+	}
+	// corrupt the data of needle 3, the scan is resumed from needle 4
+	testWrite(t, bfile, boffsets[2]+needle.HeaderSize, []byte("x"))
+	if res, err = fsck(); err != nil {
+		t.Errorf("fsck() error(%v)", err)
+		t.FailNow()
+	}
+	if res.clean() || len(res.corrupts) != 1 || res.corrupts[0] != offsets[2] || res.mismatch != 1 || res.tail != 0 {
+		t.Errorf("fsck() corrupts: %v mismatch: %d tail: %d not match", res.corrupts, res.mismatch, res.tail)
+		t.FailNow()
+	}
+	if len(res.needles) != 4 || res.needles[2].key != 4 || res.needles[3].key != 5 || res.end != offsets[4]+offsets[1]-offsets[0] {
+		t.Errorf("fsck() needles: %v end: %d not match", res.needles, res.end)
+		t.FailNow()
+	}
+	// rebuild the index from the live needles, the corrupt one is left
+	rebuild = true
+	defer func() {
+		rebuild = false
+	}()
+	if res, err = fsck(); err != nil {
+		t.Errorf("fsck() error(%v)", err)
+		t.FailNow()
+	}
+	if res.clean() || len(res.indexes) != 3 || res.mismatch != 0 || res.indexErr != nil {
+		t.Errorf("fsck() indexes: %d mismatch: %d error(%v) not match", len(res.indexes), res.mismatch, res.indexErr)
+		t.FailNow()
+	}
+	if _, err = os.Stat(xfile + _backup); err != nil {
+		t.Errorf("os.Stat() error(%v)", err)
+		t.FailNow()
+	}
+	rebuild = false
+	if res, err = fsck(); err != nil {
+		t.Errorf("fsck() error(%v)", err)
+		t.FailNow()
+	}
+	if len(res.indexes) != 3 || res.indexes[0].Key != 1 || res.indexes[1].Key != 4 || res.indexes[2].Key != 5 || res.mismatch != 0 {
+		t.Errorf("fsck() indexes: %v not match", res.indexes)
+		t.FailNow()
+	}
+}
+
+func TestFsckTruncate(t *testing.T) {
+	var (
+		err     error
+		res     *result
+		offsets []uint32
+		fi      os.FileInfo
+		bfile   = "../test/test_fsck_tail"
+		xfile   = "../test/test_fsck_tail.idx"
+	)
+	file, ifile = bfile, xfile
+	defer os.Remove(bfile)
+	defer os.Remove(xfile)
+	offsets, _ = testVolume(t, bfile, xfile)
+	// a half needle left by a crash
+	testWrite(t, bfile, -1, bytes.Repeat([]byte("x"), needle.HeaderSize+3))
+	if res, err = fsck(); err != nil {
+		t.Errorf("fsck() error(%v)", err)
+		t.FailNow()
+	}
+	if res.clean() || len(res.needles) != 5 || res.tail != needle.HeaderSize+3 {
+		t.Errorf("fsck() needles: %d tail: %d not match", len(res.needles), res.tail)
+		t.FailNow()
+	}
+	truncate = true
+	defer func() {
+		truncate = false
+	}()
+	if res, err = fsck(); err != nil {
+		t.Errorf("fsck() error(%v)", err)
+		t.FailNow()
+	}
+	if !res.clean() || res.tail != 0 || res.end != offsets[4]+offsets[1]-offsets[0] {
+		t.Errorf("fsck() corrupts: %v tail: %d end: %d not clean", res.corrupts, res.tail, res.end)
+		t.FailNow()
+	}
+	if fi, err = os.Stat(bfile); err != nil || fi.Size() != needle.BlockOffset(res.end) {
+		t.Errorf("os.Stat() size not match error(%v)", err)
+		t.FailNow()
+	}
+}
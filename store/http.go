@@ -144,7 +144,7 @@ func HttpPostWriter(r *http.Request, wr http.ResponseWriter, start time.Time, er
 
 func HttpGetWriter(r *http.Request, wr http.ResponseWriter, start time.Time, err *error, ret *int) {
 	var errStr string
-	if *ret != http.StatusOK {
+	if *ret != http.StatusOK && *ret != http.StatusPartialContent {
 		if *err != nil {
 			errStr = (*err).Error()
 		}
@@ -2,10 +2,15 @@ package main
 
 import (
 	"bfs/libs/errors"
+	"bfs/libs/meta"
 	"bfs/store/volume"
+	"encoding/json"
 	log "github.com/golang/glog"
+	"io"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -24,7 +29,22 @@ func (s *Server) startAdmin() {
 	serveMux.HandleFunc("/bulk_volume", s.bulkVolume)
 	serveMux.HandleFunc("/compact_volume", s.compactVolume)
 	serveMux.HandleFunc("/add_volume", s.addVolume)
+	serveMux.HandleFunc("/del_volume", s.delVolume)
+	serveMux.HandleFunc("/reset_disk", s.resetDisk)
 	serveMux.HandleFunc("/add_free_volume", s.addFreeVolume)
+	serveMux.HandleFunc("/digest", s.digest)
+	serveMux.HandleFunc("/needles", s.needles)
+	serveMux.HandleFunc("/volume_file", s.volumeFile)
+	serveMux.HandleFunc("/export_volume", s.exportVolume)
+	serveMux.HandleFunc("/import_volume", s.importVolume)
+	serveMux.HandleFunc("/snapshot_volume", s.snapshotVolume)
+	serveMux.HandleFunc("/increment_volume", s.incrementVolume)
+	serveMux.HandleFunc("/recover_volume", s.recoverVolume)
+	serveMux.HandleFunc("/ec_encode", s.ecEncode)
+	serveMux.HandleFunc("/ec_file", s.ecFile)
+	serveMux.HandleFunc("/ec_add_shard", s.ecAddShard)
+	serveMux.HandleFunc("/ec_meta", s.ecMeta)
+	serveMux.HandleFunc("/ec_rebuild", s.ecRebuild)
 	if err = server.Serve(s.adminSvr); err != nil {
 		log.Errorf("server.Serve() error(%v)", err)
 	}
@@ -54,8 +74,11 @@ func (s *Server) probe(wr http.ResponseWriter, r *http.Request) {
 	}
 	if v = s.store.Volumes[int32(vid)]; v != nil {
 		if err = v.Probe(); err != nil {
-			if err == errors.ErrNeedleDeleted || err == errors.ErrNeedleNotExist {
+			if err == errors.ErrNeedleDeleted || err == errors.ErrNeedleNotExist || err == errors.ErrNeedleExpired {
 				ret = http.StatusNotFound
+			} else if err == errors.ErrVolumeOffline {
+				// the disk failed, not the store
+				ret = http.StatusServiceUnavailable
 			} else {
 				ret = http.StatusInternalServerError
 			}
@@ -142,6 +165,41 @@ func (s *Server) addVolume(wr http.ResponseWriter, r *http.Request) {
 	return
 }
 
+func (s *Server) delVolume(wr http.ResponseWriter, r *http.Request) {
+	var (
+		err error
+		vid int64
+		res = map[string]interface{}{}
+	)
+	if r.Method != "POST" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer HttpPostWriter(r, wr, time.Now(), &err, res)
+	if vid, err = strconv.ParseInt(r.FormValue("vid"), 10, 32); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", r.FormValue("vid"), err)
+		err = errors.ErrParam
+		return
+	}
+	log.Infof("del volume: %d", vid)
+	err = s.store.DelVolume(int32(vid))
+	return
+}
+
+func (s *Server) resetDisk(wr http.ResponseWriter, r *http.Request) {
+	var (
+		err error
+		res = map[string]interface{}{}
+	)
+	if r.Method != "POST" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer HttpPostWriter(r, wr, time.Now(), &err, res)
+	err = s.store.ResetDisk(r.FormValue("path"))
+	return
+}
+
 func (s *Server) addFreeVolume(wr http.ResponseWriter, r *http.Request) {
 	var (
 		err        error
@@ -166,3 +224,573 @@ func (s *Server) addFreeVolume(wr http.ResponseWriter, r *http.Request) {
 	res["succeed"] = sn
 	return
 }
+
+func (s *Server) digest(wr http.ResponseWriter, r *http.Request) {
+	var (
+		v      *volume.Volume
+		err    error
+		vid    int64
+		bucket int
+		params = r.URL.Query()
+		res    = map[string]interface{}{}
+	)
+	if r.Method != "GET" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer HttpPostWriter(r, wr, time.Now(), &err, res)
+	if vid, err = strconv.ParseInt(params.Get("vid"), 10, 32); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", params.Get("vid"), err)
+		err = errors.ErrParam
+		return
+	}
+	if v = s.store.Volumes[int32(vid)]; v == nil {
+		err = errors.ErrVolumeNotExist
+		return
+	}
+	if params.Get("bucket") == "" {
+		res["digest"] = v.Digest()
+		return
+	}
+	if bucket, err = strconv.Atoi(params.Get("bucket")); err != nil {
+		log.Errorf("strconv.Atoi(\"%s\") error(%v)", params.Get("bucket"), err)
+		err = errors.ErrParam
+		return
+	}
+	res["needles"], err = v.DigestNeedles(bucket)
+	return
+}
+
+// needles get the keys of the live needles of a volume.
+func (s *Server) needles(wr http.ResponseWriter, r *http.Request) {
+	var (
+		v      *volume.Volume
+		err    error
+		vid    int64
+		params = r.URL.Query()
+		res    = map[string]interface{}{}
+	)
+	if r.Method != "GET" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer HttpPostWriter(r, wr, time.Now(), &err, res)
+	if vid, err = strconv.ParseInt(params.Get("vid"), 10, 32); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", params.Get("vid"), err)
+		err = errors.ErrParam
+		return
+	}
+	if v = s.store.Volumes[int32(vid)]; v == nil {
+		err = errors.ErrVolumeNotExist
+		return
+	}
+	res["keys"] = v.Keys()
+	return
+}
+
+// volumeFile get the block or index file of a volume from the offset to the
+// current size, used by another store to recover the volume.
+func (s *Server) volumeFile(wr http.ResponseWriter, r *http.Request) {
+	var (
+		v            *volume.Volume
+		f            *os.File
+		err          error
+		vid, offset  int64
+		bsize, isize int64
+		size         int64
+		bfile, ifile string
+		file         string
+		ret          = http.StatusOK
+		params       = r.URL.Query()
+		now          = time.Now()
+	)
+	if r.Method != "GET" {
+		ret = http.StatusMethodNotAllowed
+		http.Error(wr, "method not allowed", ret)
+		return
+	}
+	defer HttpGetWriter(r, wr, now, &err, &ret)
+	if vid, err = strconv.ParseInt(params.Get("vid"), 10, 32); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", params.Get("vid"), err)
+		ret = http.StatusBadRequest
+		return
+	}
+	if offset, err = strconv.ParseInt(params.Get("offset"), 10, 64); err != nil || offset < 0 {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", params.Get("offset"), err)
+		err = errors.ErrParam
+		ret = http.StatusBadRequest
+		return
+	}
+	if v = s.store.Volumes[int32(vid)]; v == nil {
+		ret = http.StatusNotFound
+		err = errors.ErrVolumeNotExist
+		return
+	}
+	if bfile, bsize, ifile, isize, err = v.Snapshot(); err != nil {
+		if err == errors.ErrVolumeInCompact {
+			ret = http.StatusConflict
+		} else {
+			ret = http.StatusInternalServerError
+		}
+		return
+	}
+	switch params.Get("type") {
+	case volumeFileBlock:
+		file, size = bfile, bsize
+	case volumeFileIndex:
+		file, size = ifile, isize
+	default:
+		err = errors.ErrParam
+		ret = http.StatusBadRequest
+		return
+	}
+	// the block compacted, the copied bytes are useless
+	if (params.Get("name") != "" && params.Get("name") != bfile) || offset > size {
+		err = errors.ErrVolumeChanged
+		ret = http.StatusGone
+		return
+	}
+	if f, err = os.Open(file); err != nil {
+		log.Errorf("os.Open(\"%s\") error(%v)", file, err)
+		ret = http.StatusInternalServerError
+		return
+	}
+	defer f.Close()
+	if _, err = f.Seek(offset, os.SEEK_SET); err != nil {
+		log.Errorf("file: %s Seek() error(%v)", file, err)
+		ret = http.StatusInternalServerError
+		return
+	}
+	wr.Header().Set("X-Bfs-File", bfile)
+	wr.Header().Set("X-Bfs-Size", strconv.FormatInt(size, 10))
+	wr.Header().Set("Content-Length", strconv.FormatInt(size-offset, 10))
+	if _, err = io.CopyN(wr, f, size-offset); err != nil {
+		log.Errorf("io.CopyN(%s) error(%v)", file, err)
+		err = nil // avoid HttpGetWriter write header twice
+	}
+	return
+}
+
+// exportVolume stream the live needles of a volume (or a key range) as an
+// archive.
+func (s *Server) exportVolume(wr http.ResponseWriter, r *http.Request) {
+	var (
+		v          *volume.Volume
+		err        error
+		vid        int64
+		start, end int64
+		n          int
+		ret        = http.StatusOK
+		params     = r.URL.Query()
+		now        = time.Now()
+	)
+	if r.Method != "GET" {
+		ret = http.StatusMethodNotAllowed
+		http.Error(wr, "method not allowed", ret)
+		return
+	}
+	defer HttpGetWriter(r, wr, now, &err, &ret)
+	if vid, err = strconv.ParseInt(params.Get("vid"), 10, 32); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", params.Get("vid"), err)
+		ret = http.StatusBadRequest
+		return
+	}
+	if params.Get("start") != "" {
+		if start, err = strconv.ParseInt(params.Get("start"), 10, 64); err != nil {
+			log.Errorf("strconv.ParseInt(\"%s\") error(%v)", params.Get("start"), err)
+			ret = http.StatusBadRequest
+			return
+		}
+	}
+	if params.Get("end") != "" {
+		if end, err = strconv.ParseInt(params.Get("end"), 10, 64); err != nil {
+			log.Errorf("strconv.ParseInt(\"%s\") error(%v)", params.Get("end"), err)
+			ret = http.StatusBadRequest
+			return
+		}
+	}
+	if v = s.store.Volumes[int32(vid)]; v == nil {
+		ret = http.StatusNotFound
+		err = errors.ErrVolumeNotExist
+		return
+	}
+	if v.Compact {
+		ret = http.StatusConflict
+		err = errors.ErrVolumeInCompact
+		return
+	}
+	log.Infof("export volume: %d keys: [%d, %d)", vid, start, end)
+	wr.Header().Set("Content-Type", "application/octet-stream")
+	if n, err = v.Export(wr, start, end); err != nil {
+		log.Errorf("volume: %d Export() needles: %d error(%v)", vid, n, err)
+		err = nil // avoid HttpGetWriter write header twice, the trailer is missing
+	}
+	return
+}
+
+// importVolume import an archive posted in the body into a free volume.
+func (s *Server) importVolume(wr http.ResponseWriter, r *http.Request) {
+	var (
+		err error
+		vid int64
+		n   int
+		res = map[string]interface{}{}
+	)
+	if r.Method != "POST" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer HttpPostWriter(r, wr, time.Now(), &err, res)
+	if vid, err = strconv.ParseInt(r.URL.Query().Get("vid"), 10, 32); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", r.URL.Query().Get("vid"), err)
+		err = errors.ErrParam
+		return
+	}
+	log.Infof("import volume: %d", vid)
+	if n, err = s.store.ImportVolume(int32(vid), r.Body); err == nil {
+		res["needles"] = n
+	}
+	return
+}
+
+// snapshotVolume get a consistent cut of a volume.
+func (s *Server) snapshotVolume(wr http.ResponseWriter, r *http.Request) {
+	var (
+		err error
+		vid int64
+		v   *volume.Volume
+		sn  *meta.Snapshot
+		res = map[string]interface{}{}
+	)
+	if r.Method != "GET" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer HttpPostWriter(r, wr, time.Now(), &err, res)
+	if vid, err = strconv.ParseInt(r.FormValue("vid"), 10, 32); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", r.FormValue("vid"), err)
+		err = errors.ErrParam
+		return
+	}
+	if v = s.store.Volumes[int32(vid)]; v == nil {
+		err = errors.ErrVolumeNotExist
+		return
+	}
+	if sn, err = v.Cut(); err == nil {
+		res["snapshot"] = sn
+	}
+	return
+}
+
+// incrementVolume stream the increment of a volume from the cut in the param
+// from to a new cut, which is in the header X-Bfs-Snapshot, no from means a
+// base.
+func (s *Server) incrementVolume(wr http.ResponseWriter, r *http.Request) {
+	var (
+		v        *volume.Volume
+		err      error
+		vid      int64
+		data     []byte
+		from, to *meta.Snapshot
+		ret      = http.StatusOK
+		params   = r.URL.Query()
+		now      = time.Now()
+	)
+	if r.Method != "GET" {
+		ret = http.StatusMethodNotAllowed
+		http.Error(wr, "method not allowed", ret)
+		return
+	}
+	defer HttpGetWriter(r, wr, now, &err, &ret)
+	if vid, err = strconv.ParseInt(params.Get("vid"), 10, 32); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", params.Get("vid"), err)
+		ret = http.StatusBadRequest
+		return
+	}
+	if params.Get("from") != "" {
+		from = new(meta.Snapshot)
+		if err = json.Unmarshal([]byte(params.Get("from")), from); err != nil {
+			log.Errorf("json.Unmarshal(\"%s\") error(%v)", params.Get("from"), err)
+			ret = http.StatusBadRequest
+			return
+		}
+	}
+	if v = s.store.Volumes[int32(vid)]; v == nil {
+		ret = http.StatusNotFound
+		err = errors.ErrVolumeNotExist
+		return
+	}
+	if to, err = v.Cut(); err != nil {
+		ret = http.StatusInternalServerError
+		return
+	}
+	if data, err = json.Marshal(to); err != nil {
+		ret = http.StatusInternalServerError
+		return
+	}
+	wr.Header().Set("X-Bfs-Snapshot", string(data))
+	wr.Header().Set("Content-Type", "application/octet-stream")
+	switch err = v.Increment(wr, from, to); err {
+	case nil:
+	case errors.ErrSnapshotRebase:
+		// compacted or the delete log overflowed, a new base is needed
+		ret = http.StatusGone
+	case errors.ErrSnapshotRange:
+		ret = http.StatusBadRequest
+	case errors.ErrVolumeChanged:
+		ret = http.StatusConflict
+	default:
+		log.Errorf("volume: %d Increment() error(%v)", vid, err)
+		err = nil // avoid HttpGetWriter write header twice, the checksum is missing
+	}
+	return
+}
+
+// recoverVolume start a volume recovery (POST) or get the progress (GET).
+func (s *Server) recoverVolume(wr http.ResponseWriter, r *http.Request) {
+	var (
+		err error
+		vid int64
+		rc  *Recovery
+		res = map[string]interface{}{}
+	)
+	if r.Method != "POST" && r.Method != "GET" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer HttpPostWriter(r, wr, time.Now(), &err, res)
+	if vid, err = strconv.ParseInt(r.FormValue("vid"), 10, 32); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", r.FormValue("vid"), err)
+		err = errors.ErrParam
+		return
+	}
+	if r.Method == "GET" {
+		if rc, err = s.store.Recovery(int32(vid)); err == nil {
+			res["recovery"] = rc
+		}
+		return
+	}
+	if r.FormValue("src") == "" {
+		err = errors.ErrParam
+		return
+	}
+	log.Infof("recover volume: %d from: %s", vid, r.FormValue("src"))
+	err = s.store.RecoverVolume(int32(vid), r.FormValue("src"))
+	return
+}
+
+func (s *Server) ecEncode(wr http.ResponseWriter, r *http.Request) {
+	var (
+		err  error
+		vid  int64
+		k, m int
+		em   *meta.ECVolume
+		res  = map[string]interface{}{}
+	)
+	if r.Method != "POST" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer HttpPostWriter(r, wr, time.Now(), &err, res)
+	if vid, err = strconv.ParseInt(r.FormValue("vid"), 10, 32); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", r.FormValue("vid"), err)
+		err = errors.ErrParam
+		return
+	}
+	if k, err = strconv.Atoi(r.FormValue("k")); err != nil {
+		log.Errorf("strconv.Atoi(\"%s\") error(%v)", r.FormValue("k"), err)
+		err = errors.ErrParam
+		return
+	}
+	if m, err = strconv.Atoi(r.FormValue("m")); err != nil {
+		log.Errorf("strconv.Atoi(\"%s\") error(%v)", r.FormValue("m"), err)
+		err = errors.ErrParam
+		return
+	}
+	log.Infof("ec encode volume: %d k: %d m: %d", vid, k, m)
+	if em, err = s.store.EncodeECVolume(int32(vid), k, m); err == nil {
+		res["meta"] = em
+	}
+	return
+}
+
+func (s *Server) ecFile(wr http.ResponseWriter, r *http.Request) {
+	var (
+		ev           *volume.ECVolume
+		f            *os.File
+		fi           os.FileInfo
+		err          error
+		vid, shard   int64
+		offset, size int64
+		file         string
+		ret          = http.StatusOK
+		params       = r.URL.Query()
+		now          = time.Now()
+	)
+	if r.Method != "GET" {
+		ret = http.StatusMethodNotAllowed
+		http.Error(wr, "method not allowed", ret)
+		return
+	}
+	defer HttpGetWriter(r, wr, now, &err, &ret)
+	if vid, err = strconv.ParseInt(params.Get("vid"), 10, 32); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", params.Get("vid"), err)
+		ret = http.StatusBadRequest
+		return
+	}
+	if shard, err = strconv.ParseInt(params.Get("shard"), 10, 32); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", params.Get("shard"), err)
+		ret = http.StatusBadRequest
+		return
+	}
+	if offset, err = strconv.ParseInt(params.Get("offset"), 10, 64); err != nil || offset < 0 {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", params.Get("offset"), err)
+		err = errors.ErrParam
+		ret = http.StatusBadRequest
+		return
+	}
+	// size < 0 means to the end
+	if size, err = strconv.ParseInt(params.Get("size"), 10, 64); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", params.Get("size"), err)
+		ret = http.StatusBadRequest
+		return
+	}
+	if ev = s.store.ECVolumes[int32(vid)]; ev == nil {
+		ret = http.StatusNotFound
+		err = errors.ErrVolumeNotExist
+		return
+	}
+	switch params.Get("type") {
+	case ecFileShard:
+		file = volume.ECShardFile(ev.File, int(shard))
+	case ecFileIndex:
+		if err = ev.Indexer.Flush(); err != nil {
+			ret = http.StatusInternalServerError
+			return
+		}
+		file = volume.ECIndexFile(ev.File)
+	case ecFileMeta:
+		file = volume.ECMetaFile(ev.File)
+	default:
+		err = errors.ErrParam
+		ret = http.StatusBadRequest
+		return
+	}
+	if f, err = os.Open(file); err != nil {
+		log.Errorf("os.Open(\"%s\") error(%v)", file, err)
+		if os.IsNotExist(err) {
+			err = errors.ErrECShardNotExist
+			ret = http.StatusNotFound
+		} else {
+			ret = http.StatusInternalServerError
+		}
+		return
+	}
+	defer f.Close()
+	if fi, err = f.Stat(); err != nil {
+		log.Errorf("file: %s Stat() error(%v)", file, err)
+		ret = http.StatusInternalServerError
+		return
+	}
+	if size < 0 {
+		size = fi.Size() - offset
+	}
+	if offset+size > fi.Size() {
+		err = errors.ErrParam
+		ret = http.StatusBadRequest
+		return
+	}
+	if _, err = f.Seek(offset, os.SEEK_SET); err != nil {
+		log.Errorf("file: %s Seek() error(%v)", file, err)
+		ret = http.StatusInternalServerError
+		return
+	}
+	wr.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if _, err = io.CopyN(wr, f, size); err != nil {
+		log.Errorf("io.CopyN(%s) error(%v)", file, err)
+		err = nil // avoid HttpGetWriter write header twice
+	}
+	return
+}
+
+func (s *Server) ecAddShard(wr http.ResponseWriter, r *http.Request) {
+	var (
+		err    error
+		vid    int64
+		shard  int
+		shards []int
+		str    string
+		res    = map[string]interface{}{}
+	)
+	if r.Method != "POST" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer HttpPostWriter(r, wr, time.Now(), &err, res)
+	if vid, err = strconv.ParseInt(r.FormValue("vid"), 10, 32); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", r.FormValue("vid"), err)
+		err = errors.ErrParam
+		return
+	}
+	for _, str = range strings.Split(r.FormValue("shards"), ",") {
+		if shard, err = strconv.Atoi(str); err != nil {
+			log.Errorf("strconv.Atoi(\"%s\") error(%v)", str, err)
+			err = errors.ErrParam
+			return
+		}
+		shards = append(shards, shard)
+	}
+	if r.FormValue("src") == "" || r.FormValue("dir") == "" {
+		err = errors.ErrParam
+		return
+	}
+	log.Infof("ec add volume: %d shards: %v from: %s", vid, shards, r.FormValue("src"))
+	err = s.store.AddECShards(int32(vid), shards, r.FormValue("src"), r.FormValue("dir"))
+	return
+}
+
+func (s *Server) ecMeta(wr http.ResponseWriter, r *http.Request) {
+	var (
+		err error
+		em  *meta.ECVolume
+		res = map[string]interface{}{}
+	)
+	if r.Method != "POST" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer HttpPostWriter(r, wr, time.Now(), &err, res)
+	if em, err = ecVolumeMeta(r.FormValue("meta")); err != nil {
+		return
+	}
+	log.Infof("ec set volume: %d stores: %v", em.Vid, em.Stores)
+	err = s.store.SetECVolume(em)
+	return
+}
+
+func (s *Server) ecRebuild(wr http.ResponseWriter, r *http.Request) {
+	var (
+		err   error
+		vid   int64
+		shard int
+		res   = map[string]interface{}{}
+	)
+	if r.Method != "POST" {
+		http.Error(wr, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer HttpPostWriter(r, wr, time.Now(), &err, res)
+	if vid, err = strconv.ParseInt(r.FormValue("vid"), 10, 32); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", r.FormValue("vid"), err)
+		err = errors.ErrParam
+		return
+	}
+	if shard, err = strconv.Atoi(r.FormValue("shard")); err != nil {
+		log.Errorf("strconv.Atoi(\"%s\") error(%v)", r.FormValue("shard"), err)
+		err = errors.ErrParam
+		return
+	}
+	log.Infof("ec rebuild volume: %d shard: %d", vid, shard)
+	err = s.store.RebuildECShard(int32(vid), shard)
+	return
+}
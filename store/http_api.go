@@ -2,12 +2,16 @@ package main
 
 import (
 	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"bfs/store/block"
 	"bfs/store/needle"
 	"bfs/store/volume"
 	log "github.com/golang/glog"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -36,9 +40,13 @@ func (s *Server) startApi() {
 func (s *Server) get(wr http.ResponseWriter, r *http.Request) {
 	var (
 		v                *volume.Volume
+		ev               *volume.ECVolume
 		n                *needle.Needle
+		rng              *meta.Range
 		err              error
 		vid, key, cookie int64
+		data             []byte
+		encoding, disp   string
 		ret              = http.StatusOK
 		params           = r.URL.Query()
 		now              = time.Now()
@@ -69,23 +77,69 @@ func (s *Server) get(wr http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if v = s.store.Volumes[int32(vid)]; v != nil {
-		if n, err = v.Read(key, int32(cookie)); err == nil {
-			wr.Header().Set("Content-Length", strconv.Itoa(len(n.Data)))
-			if _, err = wr.Write(n.Data); err != nil {
-				log.Errorf("wr.Write() error(%v)", err)
-				err = nil // avoid HttpGetWriter write header twice
-			}
-			n.Close()
+		if rng = meta.ParseRange(r.Header.Get("Range")); rng != nil {
+			n, err = v.ReadRange(key, int32(cookie), rng)
 		} else {
-			if err == errors.ErrNeedleDeleted || err == errors.ErrNeedleNotExist {
-				ret = http.StatusNotFound
-			} else {
+			n, err = v.Read(key, int32(cookie))
+		}
+	} else if ev = s.store.ECVolumes[int32(vid)]; ev != nil {
+		// erasure coded volume always return the whole needle
+		n, err = ev.Read(key, int32(cookie), s.store.ecFetcher(ev))
+	} else {
+		err = errors.ErrVolumeNotExist
+	}
+	if err == nil {
+		if data = n.Data; n.Compressed() {
+			if data, encoding, err = decompress(n, rng, r.Header.Get("Accept-Encoding")); err != nil {
+				n.Close()
 				ret = http.StatusInternalServerError
+				return
+			}
+			wr.Header().Set("Vary", "Accept-Encoding")
+			if encoding != "" {
+				wr.Header().Set("Content-Encoding", encoding)
+			}
+		}
+		wr.Header().Set("Accept-Ranges", "bytes")
+		wr.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if n.Expire > 0 {
+			wr.Header().Set("Expires", meta.FormatExpires(n.Expire))
+		}
+		// the needle meta is copied by the repairs from the headers, no
+		// sniffed type for a needle without mine
+		wr.Header()["Content-Type"] = nil
+		if n.Meta != nil {
+			if n.Meta.Mine != "" {
+				wr.Header().Set("Content-Type", n.Meta.Mine)
+			}
+			if n.Meta.MTime > 0 {
+				wr.Header().Set("Last-Modified", time.Unix(0, n.Meta.MTime).UTC().Format(http.TimeFormat))
 			}
+			if disp = meta.FormatDisposition(n.Meta.Filename); n.Meta.Filename != "" && disp != "" {
+				wr.Header().Set("Content-Disposition", disp)
+			}
+		}
+		if rng != nil && !rng.Whole() {
+			ret = http.StatusPartialContent
+			wr.Header().Set("Content-Range", rng.ContentRange())
+			wr.WriteHeader(ret)
 		}
+		if _, err = wr.Write(data); err != nil {
+			log.Errorf("wr.Write() error(%v)", err)
+			err = nil // avoid HttpGetWriter write header twice
+		}
+		n.Close()
 	} else {
-		ret = http.StatusNotFound
-		err = errors.ErrVolumeNotExist
+		if err == errors.ErrRangeNotSatisfiable {
+			ret = http.StatusRequestedRangeNotSatisfiable
+			wr.Header().Set("Content-Range", meta.UnsatisfiedRange(rng.Total))
+		} else if err == errors.ErrNeedleDeleted || err == errors.ErrNeedleNotExist || err == errors.ErrNeedleExpired || err == errors.ErrVolumeNotExist {
+			ret = http.StatusNotFound
+		} else if err == errors.ErrVolumeOffline {
+			ret = http.StatusServiceUnavailable
+		} else {
+			ret = http.StatusInternalServerError
+		}
 	}
 	return
 }
@@ -95,11 +149,18 @@ func (s *Server) upload(wr http.ResponseWriter, r *http.Request) {
 		vid    int64
 		key    int64
 		cookie int64
+		expire int64
 		size   int64
 		err    error
 		str    string
+		mode   string
+		codec  string
+		enc    string
 		v      *volume.Volume
 		n      *needle.Needle
+		m      *needle.Meta
+		rd     io.Reader
+		cb     *codecBuffer
 		file   multipart.File
 		res    = map[string]interface{}{}
 	)
@@ -133,6 +194,71 @@ func (s *Server) upload(wr http.ResponseWriter, r *http.Request) {
 		err = errors.ErrParam
 		return
 	}
+	if expire, err = parseExpire(r.FormValue("expire")); err != nil {
+		return
+	}
+	if m, err = parseMeta(r, expire); err != nil {
+		return
+	}
+	if mode, err = parseDurability(r.FormValue("durability")); err != nil {
+		return
+	}
+	if codec, err = parseCodec(r.FormValue("compress")); err != nil {
+		return
+	}
+	// the data of a compressed needle copied from a replica
+	if enc, err = parseCodec(r.FormValue("encoding")); err != nil {
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		// raw body, stream into super block
+		if r.ContentLength <= 0 {
+			err = errors.ErrParam
+			return
+		}
+		if v = s.store.Volumes[int32(vid)]; v != nil {
+			rd, size = r.Body, r.ContentLength
+			// only a meta needle of the v2 block can be compressed
+			if v.Block.Ver == block.Ver2 && (enc != "" || codec != "") {
+				if m == nil {
+					m = &needle.Meta{Expire: expire}
+				}
+				cb = newCodecBuffer(s.conf.NeedleMaxSize)
+				if enc != "" {
+					rd, err = cb.encoded(enc, m, rd, size)
+				} else {
+					rd, size, err = cb.compress(codec, m, rd, size)
+				}
+				if err != nil {
+					cb.Close()
+					return
+				}
+			} else if enc != "" {
+				err = errors.ErrNeedleCodec
+				return
+			}
+			if m != nil && v.Block.Ver == block.Ver2 {
+				n = needle.NewMetaStreamWriter(key, int32(cookie), int32(size), m)
+			} else {
+				n = needle.NewExpireStreamWriter(key, int32(cookie), int32(size), expire)
+			}
+			if err = v.WriteFrom(n, rd); err == nil {
+				err = v.Commit(mode)
+			}
+			n.Close()
+			if cb != nil {
+				cb.Close()
+			}
+		} else {
+			err = errors.ErrVolumeNotExist
+		}
+		return
+	}
+	// only a raw body copies a compressed needle
+	if enc != "" {
+		err = errors.ErrParam
+		return
+	}
 	if file, _, err = r.FormFile("file"); err != nil {
 		log.Errorf("r.FormFile() error(%v)", err)
 		err = errors.ErrInternal
@@ -140,11 +266,30 @@ func (s *Server) upload(wr http.ResponseWriter, r *http.Request) {
 	}
 	if size, err = checkFileSize(file, s.conf.NeedleMaxSize); err == nil {
 		if v = s.store.Volumes[int32(vid)]; v != nil {
-			n = needle.NewWriter(key, int32(cookie), int32(size))
-			if err = n.ReadFrom(file); err == nil {
-				err = v.Write(n)
+			rd = file
+			if codec != "" && v.Block.Ver == block.Ver2 {
+				if m == nil {
+					m = &needle.Meta{Expire: expire}
+				}
+				cb = newCodecBuffer(s.conf.NeedleMaxSize)
+				rd, size, err = cb.compress(codec, m, rd, size)
+			}
+			if err == nil {
+				if m != nil && v.Block.Ver == block.Ver2 {
+					n = needle.NewMetaWriter(key, int32(cookie), int32(size), m)
+				} else {
+					n = needle.NewExpireWriter(key, int32(cookie), int32(size), expire)
+				}
+				if err = n.ReadFrom(rd); err == nil {
+					if err = v.Write(n); err == nil {
+						err = v.Commit(mode)
+					}
+				}
+				n.Close()
+			}
+			if cb != nil {
+				cb.Close()
 			}
-			n.Close()
 		} else {
 			err = errors.ErrVolumeNotExist
 		}
@@ -160,8 +305,10 @@ func (s *Server) uploads(wr http.ResponseWriter, r *http.Request) {
 		vid     int64
 		key     int64
 		cookie  int64
+		expire  int64
 		size    int64
 		str     string
+		mode    string
 		keys    []string
 		cookies []string
 		v       *volume.Volume
@@ -186,6 +333,12 @@ func (s *Server) uploads(wr http.ResponseWriter, r *http.Request) {
 		err = errors.ErrParam
 		return
 	}
+	if expire, err = parseExpire(r.FormValue("expire")); err != nil {
+		return
+	}
+	if mode, err = parseDurability(r.FormValue("durability")); err != nil {
+		return
+	}
 	keys = r.MultipartForm.Value["keys"]
 	cookies = r.MultipartForm.Value["cookies"]
 	if len(keys) != len(cookies) {
@@ -201,6 +354,7 @@ func (s *Server) uploads(wr http.ResponseWriter, r *http.Request) {
 		return
 	}
 	ns = needle.NewNeedles(nn)
+	ns.Expire = expire
 	for i, fh = range fhs {
 		if key, err = strconv.ParseInt(keys[i], 10, 64); err != nil {
 			log.Errorf("strconv.ParseInt(\"%s\") error(%v)", keys[i], err)
@@ -226,7 +380,9 @@ func (s *Server) uploads(wr http.ResponseWriter, r *http.Request) {
 	}
 	if err == nil {
 		if v = s.store.Volumes[int32(vid)]; v != nil {
-			err = v.Writes(ns)
+			if err = v.Writes(ns); err == nil {
+				err = v.Commit(mode)
+			}
 		} else {
 			err = errors.ErrVolumeNotExist
 		}
@@ -241,6 +397,7 @@ func (s *Server) del(wr http.ResponseWriter, r *http.Request) {
 		key, vid int64
 		str      string
 		v        *volume.Volume
+		ev       *volume.ECVolume
 		res      = map[string]interface{}{}
 	)
 	if r.Method != "POST" {
@@ -266,8 +423,59 @@ func (s *Server) del(wr http.ResponseWriter, r *http.Request) {
 	}
 	if v = s.store.Volumes[int32(vid)]; v != nil {
 		err = v.Delete(key)
+	} else if ev = s.store.ECVolumes[int32(vid)]; ev != nil {
+		err = ev.Delete(key)
 	} else {
 		err = errors.ErrVolumeNotExist
 	}
 	return
 }
+
+// parseExpire parse the optional expire unix seconds of the needles, empty
+// means never.
+func parseExpire(str string) (expire int64, err error) {
+	if str == "" {
+		return
+	}
+	if expire, err = strconv.ParseInt(str, 10, 64); err != nil || expire < 0 {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", str, err)
+		err = errors.ErrParam
+	}
+	return
+}
+
+// parseDurability parse the optional durability mode of the writes, empty
+// means the default of the config.
+func parseDurability(str string) (mode string, err error) {
+	if !meta.ValidDurability(str) {
+		log.Errorf("durability: \"%s\" not valid", str)
+		err = errors.ErrParam
+		return
+	}
+	mode = str
+	return
+}
+
+// parseMeta parse the optional needle meta, which is only saved in a v2
+// block, nil means no meta.
+func parseMeta(r *http.Request, expire int64) (m *needle.Meta, err error) {
+	var (
+		mtime int64
+		str   = r.FormValue("mtime")
+	)
+	if str != "" {
+		if mtime, err = strconv.ParseInt(str, 10, 64); err != nil || mtime < 0 {
+			log.Errorf("strconv.ParseInt(\"%s\") error(%v)", str, err)
+			err = errors.ErrParam
+			return
+		}
+	}
+	m = &needle.Meta{Mine: r.FormValue("mine"), MTime: mtime, Filename: r.FormValue("filename"), Expire: expire}
+	if m.Mine == "" && m.MTime == 0 && m.Filename == "" {
+		return nil, nil
+	}
+	if !m.Valid() {
+		err = errors.ErrParam
+	}
+	return
+}
@@ -45,18 +45,25 @@ func (s *Server) stat(wr http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var (
-		err     error
-		data    []byte
-		v       *volume.Volume
-		volumes = make([]*volume.Volume, 0, len(s.store.Volumes))
-		res     = map[string]interface{}{"ret": errors.RetOK}
+		err       error
+		data      []byte
+		v         *volume.Volume
+		ev        *volume.ECVolume
+		volumes   = make([]*volume.Volume, 0, len(s.store.Volumes))
+		ecVolumes = make([]*volume.ECVolume, 0, len(s.store.ECVolumes))
+		res       = map[string]interface{}{"ret": errors.RetOK}
 	)
 	for _, v = range s.store.Volumes {
 		volumes = append(volumes, v)
 	}
+	for _, ev = range s.store.ECVolumes {
+		ecVolumes = append(ecVolumes, ev)
+	}
 	res["server"] = s.info
 	res["volumes"] = volumes
 	res["free_volumes"] = s.store.FreeVolumes
+	res["ec_volumes"] = ecVolumes
+	res["disks"] = s.store.Disks()
 	if data, err = json.Marshal(res); err == nil {
 		if _, err = wr.Write(data); err != nil {
 			log.Errorf("wr.Write() error(%v)", err)
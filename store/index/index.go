@@ -243,6 +243,18 @@ func (i *Indexer) Flush() (err error) {
 	return
 }
 
+// Size get the index file size aligned with the index, a tail index may be
+// half written by the merge job.
+func (i *Indexer) Size() (size int64, err error) {
+	var fi os.FileInfo
+	if fi, err = os.Stat(i.File); err != nil {
+		log.Errorf("os.Stat(\"%s\") error(%v)", i.File, err)
+		return
+	}
+	size = fi.Size() - fi.Size()%_indexSize
+	return
+}
+
 // mergeRing get index data from ring then write to disk.
 func (i *Indexer) mergeRing() (err error) {
 	var index *Index
@@ -292,6 +304,12 @@ func (i *Indexer) merge() {
 
 // Scan scan a indexer file.
 func (i *Indexer) Scan(r *os.File, fn func(*Index) error) (err error) {
+	return i.ScanFrom(r, 0, fn)
+}
+
+// ScanFrom scan a indexer file from the offset, e.g. the one covered by a
+// checkpoint.
+func (i *Indexer) ScanFrom(r *os.File, offset int64, fn func(*Index) error) (err error) {
 	var (
 		data []byte
 		fi   os.FileInfo
@@ -309,7 +327,7 @@ func (i *Indexer) Scan(r *os.File, fn func(*Index) error) (err error) {
 		log.Errorf("index: %s Fadvise() error(%v)", i.File)
 		return
 	}
-	if _, err = r.Seek(0, os.SEEK_SET); err != nil {
+	if _, err = r.Seek(offset, os.SEEK_SET); err != nil {
 		log.Errorf("index: %s Seek() error(%v)", i.File, err)
 		return
 	}
@@ -352,7 +370,14 @@ func (i *Indexer) Scan(r *os.File, fn func(*Index) error) (err error) {
 // Recovery recovery needle cache meta data in memory, index file  will stop
 // at the right parse data offset.
 func (i *Indexer) Recovery(fn func(*Index) error) (err error) {
-	if i.Scan(i.f, func(ix *Index) (err1 error) {
+	return i.RecoveryFrom(0, fn)
+}
+
+// RecoveryFrom recovery needle cache meta data from the offset, the entries
+// before it must be recovered by the caller.
+func (i *Indexer) RecoveryFrom(offset int64, fn func(*Index) error) (err error) {
+	i.Offset, i.syncOffset = offset, offset
+	if i.ScanFrom(i.f, offset, func(ix *Index) (err1 error) {
 		if err1 = fn(ix); err1 == nil {
 			i.Offset += int64(_indexSize)
 		}
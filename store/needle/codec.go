@@ -0,0 +1,103 @@
+package needle
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	log "github.com/golang/glog"
+)
+
+var (
+	// the gzip writers, the compressor state of one is hundreds of kb
+	_gzipWriters = sync.Pool{
+		New: func() interface{} {
+			return gzip.NewWriter(nil)
+		},
+	}
+)
+
+// Compressed check the data is compressed, only a meta needle can be.
+func (n *Needle) Compressed() bool {
+	return n.Meta != nil && n.Meta.Codec != ""
+}
+
+// RawSize get the data size before compression.
+func (n *Needle) RawSize() int64 {
+	if n.Compressed() {
+		return n.Meta.Size
+	}
+	return int64(n.Size)
+}
+
+// Compress compress the data by the codec, the output is deterministic, so
+// the replicas compressed apart keep the same needle.
+func Compress(codec string, data []byte) (cdata []byte, err error) {
+	var buf bytes.Buffer
+	if err = CompressTo(codec, &buf, data); err != nil {
+		if err != errors.ErrNeedleCodec {
+			log.Errorf("gzip compress error(%v)", err)
+		}
+		return
+	}
+	cdata = buf.Bytes()
+	return
+}
+
+// CompressTo compress the data by the codec into the writer, the error of
+// the writer is returned as is.
+func CompressTo(codec string, w io.Writer, data []byte) (err error) {
+	var gw *gzip.Writer
+	if codec != meta.CodecGzip {
+		return errors.ErrNeedleCodec
+	}
+	gw = _gzipWriters.Get().(*gzip.Writer)
+	gw.Reset(w)
+	if _, err = gw.Write(data); err == nil {
+		err = gw.Close()
+	}
+	gw.Reset(nil)
+	_gzipWriters.Put(gw)
+	return
+}
+
+// Decompress decompress the data by the codec, size is the raw size.
+func Decompress(codec string, cdata []byte, size int64) (data []byte, err error) {
+	var r *gzip.Reader
+	if codec != meta.CodecGzip || size < 0 {
+		return nil, errors.ErrNeedleCodec
+	}
+	if r, err = gzip.NewReader(bytes.NewReader(cdata)); err != nil {
+		log.Errorf("gzip.NewReader() error(%v)", err)
+		return nil, errors.ErrNeedleCodec
+	}
+	data = make([]byte, size)
+	if _, err = io.ReadFull(r, data); err != nil {
+		log.Errorf("gzip decompress error(%v)", err)
+		return nil, errors.ErrNeedleCodec
+	}
+	r.Close()
+	return
+}
+
+// DecompressedSize get the raw size of the data compressed by the codec.
+func DecompressedSize(codec string, cdata []byte) (size int64, err error) {
+	var r *gzip.Reader
+	if codec != meta.CodecGzip {
+		return 0, errors.ErrNeedleCodec
+	}
+	if r, err = gzip.NewReader(bytes.NewReader(cdata)); err != nil {
+		log.Errorf("gzip.NewReader() error(%v)", err)
+		return 0, errors.ErrNeedleCodec
+	}
+	if size, err = io.Copy(ioutil.Discard, r); err != nil {
+		log.Errorf("gzip decompress error(%v)", err)
+		return 0, errors.ErrNeedleCodec
+	}
+	r.Close()
+	return
+}
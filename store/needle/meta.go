@@ -0,0 +1,127 @@
+package needle
+
+import (
+	"bfs/libs/encoding/binary"
+	"bfs/libs/errors"
+)
+
+// Meta is the extensible metadata of a meta needle, saved in the header as a
+// list of tag-length-value items, an unknown tag is skipped when parse, so
+// new items can be added without a new needle format.
+//
+// meta item format:
+//  ---------------
+// | tag (byte)    |
+// | length(uint16)|
+// | value (bytes) |
+//  ---------------
+
+const (
+	// meta tags
+	_metaMine     = byte(1)
+	_metaMTime    = byte(2)
+	_metaFilename = byte(3)
+	_metaExpire   = byte(4)
+	_metaCodec    = byte(5)
+	_metaSize     = byte(6)
+	// size
+	_metaTagSize = 1
+	_metaLenSize = 2
+	_metaIntSize = 8
+	_metaItem    = _metaTagSize + _metaLenSize
+	// MaxMetaString the max length of a string item.
+	MaxMetaString = 255
+	// max meta size, large enough for the known items
+	_maxMetaSize = 1024
+)
+
+// Meta needle metadata.
+type Meta struct {
+	Mine     string `json:"mine"`
+	MTime    int64  `json:"mtime"` // unix nanoseconds
+	Filename string `json:"filename"`
+	Expire   int64  `json:"expire"` // unix seconds, zero means never
+	Codec    string `json:"codec"`  // the compression of the data, see meta.Codec*
+	Size     int64  `json:"size"`   // the data size before compression
+}
+
+// Valid check the string items not too long.
+func (m *Meta) Valid() bool {
+	return len(m.Mine) <= MaxMetaString && len(m.Filename) <= MaxMetaString && len(m.Codec) <= MaxMetaString
+}
+
+// encode encode the non-zero items.
+func (m *Meta) encode() (buf []byte) {
+	var b [_metaIntSize]byte
+	if m.Mine != "" {
+		buf = appendItem(buf, _metaMine, []byte(m.Mine))
+	}
+	if m.MTime != 0 {
+		binary.BigEndian.PutInt64(b[:], m.MTime)
+		buf = appendItem(buf, _metaMTime, b[:])
+	}
+	if m.Filename != "" {
+		buf = appendItem(buf, _metaFilename, []byte(m.Filename))
+	}
+	if m.Expire != 0 {
+		binary.BigEndian.PutInt64(b[:], m.Expire)
+		buf = appendItem(buf, _metaExpire, b[:])
+	}
+	if m.Codec != "" {
+		buf = appendItem(buf, _metaCodec, []byte(m.Codec))
+		binary.BigEndian.PutInt64(b[:], m.Size)
+		buf = appendItem(buf, _metaSize, b[:])
+	}
+	return
+}
+
+// appendItem append a meta item to buf.
+func appendItem(buf []byte, tag byte, value []byte) []byte {
+	var l [_metaLenSize]byte
+	binary.BigEndian.PutUint16(l[:], uint16(len(value)))
+	buf = append(buf, tag)
+	buf = append(buf, l[:]...)
+	return append(buf, value...)
+}
+
+// parseMeta parse the meta items.
+func parseMeta(buf []byte) (m *Meta, err error) {
+	var (
+		tag   byte
+		size  int
+		value []byte
+	)
+	m = new(Meta)
+	for len(buf) > 0 {
+		if len(buf) < _metaItem {
+			return nil, errors.ErrNeedleMeta
+		}
+		tag = buf[0]
+		size = int(binary.BigEndian.Uint16(buf[_metaTagSize:_metaItem]))
+		if len(buf) < _metaItem+size {
+			return nil, errors.ErrNeedleMeta
+		}
+		value = buf[_metaItem : _metaItem+size]
+		buf = buf[_metaItem+size:]
+		switch tag {
+		case _metaMine:
+			m.Mine = string(value)
+		case _metaFilename:
+			m.Filename = string(value)
+		case _metaCodec:
+			m.Codec = string(value)
+		case _metaMTime, _metaExpire, _metaSize:
+			if size != _metaIntSize {
+				return nil, errors.ErrNeedleMeta
+			}
+			if tag == _metaMTime {
+				m.MTime = binary.BigEndian.Int64(value)
+			} else if tag == _metaExpire {
+				m.Expire = binary.BigEndian.Int64(value)
+			} else {
+				m.Size = binary.BigEndian.Int64(value)
+			}
+		}
+	}
+	return
+}
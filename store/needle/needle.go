@@ -24,6 +24,9 @@ import (
 // |    needle    |        |  key (int64)   |
 // |    needle    |        |  flag (byte)   |
 // |    needle    |        |  size (int32)  |
+// |    needle    |        | expire (int64) |
+// |    needle    |        | metasize(int16)|
+// |    needle    |        |  meta (bytes)  |
 // |    needle    |        |  data (bytes)  |
 // |    needle    |        |  magic (int32) |
 // |    needle    |        | checksum(int32)|
@@ -38,10 +41,17 @@ import (
 // key       | 64bit photo id
 // flag      | signifies deleted status
 // size      | data size
+// expire    | expire unix seconds, only in v2 needle
+// metasize  | meta size, only in meta needle
+// meta      | needle meta items, only in meta needle
 // data      | the actual photo data
 // magic     | footer magic number used for checksum
 // checksum  | used to check integrity
 // padding   | total needle size is aligned to 8 bytes
+//
+// a v1 needle has no expire field, the v2 one is written only when the needle
+// has a ttl, the meta needle has no expire field but the meta items which may
+// have a ttl, the version is told by the header magic.
 
 const (
 	// size
@@ -51,6 +61,9 @@ const (
 	_keySize    = 8
 	_flagSize   = 1
 	_sizeSize   = 4
+	_expireSize = 8
+	// meta
+	_metaSizeSize = 2
 	// data
 	// footer
 	// magic
@@ -65,6 +78,12 @@ const (
 	_flagOffset   = _keyOffset + _keySize
 	_sizeOffset   = _flagOffset + _flagSize
 	_dataOffset   = _sizeOffset + _sizeSize
+	// v2
+	_expireOffset = _dataOffset
+	_dataOffsetV2 = _expireOffset + _expireSize
+	// meta
+	_metaSizeOffset = _dataOffset
+	_metaOffset     = _metaSizeOffset + _metaSizeSize
 
 	KeyOffset  = _keyOffset
 	FlagOffset = _flagOffset
@@ -76,6 +95,10 @@ const (
 
 	// header is constant = 21
 	_headerSize = _magicSize + _cookieSize + _keySize + _flagSize + _sizeSize
+	// v2 header is constant = 29
+	_headerSizeV2 = _headerSize + _expireSize
+	// meta header is constant = 23 (no meta)
+	_headerSizeMeta = _headerSize + _metaSizeSize
 	// footer is constant = 8 (no padding)
 	_footerSize = _magicSize + _checksumSize
 
@@ -103,8 +126,10 @@ var (
 	// crc32 checksum table, goroutine safe
 	_crc32Table = crc32.MakeTable(crc32.Koopman)
 	// magic number
-	_headerMagic = []byte{0x12, 0x34, 0x56, 0x78}
-	_footerMagic = []byte{0x87, 0x65, 0x43, 0x21}
+	_headerMagic     = []byte{0x12, 0x34, 0x56, 0x78}
+	_headerMagicV2   = []byte{0x12, 0x34, 0x56, 0x79}
+	_headerMagicMeta = []byte{0x12, 0x34, 0x56, 0x7a}
+	_footerMagic     = []byte{0x87, 0x65, 0x43, 0x21}
 	// flag
 	FlagDelBytes = []byte{FlagDel}
 
@@ -132,38 +157,91 @@ type Needle struct {
 	Key         int64
 	Flag        byte
 	Size        int32 // data size
+	Expire      int64 // unix seconds, zero means never
+	Meta        *Meta // only in meta needle
 	Data        []byte
 	FooterMagic []byte
 	Checksum    uint32
 	Padding     []byte
 	PaddingSize int32
 	TotalSize   int32
+	HeaderSize  int32
 	FooterSize  int32
 	// used in peek
 	IncrOffset uint32
 	Offset     uint32
+	meta       []byte // encoded meta
 	buffer     []byte // needle buffer holder
 }
 
 // NewWriter new a read needle.
 func NewWriter(key int64, cookie, size int32) *Needle {
+	return NewExpireWriter(key, cookie, size, 0)
+}
+
+// NewExpireWriter new a read needle expires at the unix seconds, zero means
+// never.
+func NewExpireWriter(key int64, cookie, size int32, expire int64) *Needle {
 	var n = new(Needle)
+	n.InitWriter(key, cookie, size, expire)
+	return n
+}
+
+func (n *Needle) InitWriter(key int64, cookie, size int32, expire int64) {
 	n.Key = key
 	n.Cookie = cookie
 	n.Size = size
+	n.Expire = expire
+	n.Meta = nil
 	n.init()
 	n.newBuffer()
+}
+
+// NewMetaWriter new a read meta needle, the ttl is the expire of meta.
+func NewMetaWriter(key int64, cookie, size int32, m *Meta) *Needle {
+	var n = new(Needle)
+	n.InitMetaWriter(key, cookie, size, m)
 	return n
 }
 
-func (n *Needle) InitWriter(key int64, cookie, size int32) {
+func (n *Needle) InitMetaWriter(key int64, cookie, size int32, m *Meta) {
 	n.Key = key
 	n.Cookie = cookie
 	n.Size = size
+	n.Meta = m
 	n.init()
 	n.newBuffer()
 }
 
+// NewStreamWriter new a needle writer which holds no buffer, the data is
+// streamed by WriteFrom.
+func NewStreamWriter(key int64, cookie, size int32) *Needle {
+	return NewExpireStreamWriter(key, cookie, size, 0)
+}
+
+// NewExpireStreamWriter new a stream needle writer expires at the unix
+// seconds, zero means never.
+func NewExpireStreamWriter(key int64, cookie, size int32, expire int64) *Needle {
+	var n = new(Needle)
+	n.Key = key
+	n.Cookie = cookie
+	n.Size = size
+	n.Expire = expire
+	n.init()
+	return n
+}
+
+// NewMetaStreamWriter new a stream meta needle writer.
+func NewMetaStreamWriter(key int64, cookie, size int32, m *Meta) *Needle {
+	var n = new(Needle)
+	n.Key = key
+	n.Cookie = cookie
+	n.Size = size
+	n.Meta = m
+	n.init()
+	return n
+}
+
 // NewReader new a write needle.
 func NewReader(key, nc int64) *Needle {
 	var n = new(Needle)
@@ -173,6 +251,16 @@ func NewReader(key, nc int64) *Needle {
 	return n
 }
 
+// NewRangeReader new a needle reader which only holds the header buffer, the
+// data is read by a window in range read.
+func NewRangeReader(key, nc int64) *Needle {
+	var n = new(Needle)
+	n.Key = key
+	n.Offset, n.TotalSize = Cache(nc)
+	n.buffer = _bufPool.Get().([]byte)
+	return n
+}
+
 // Close close a needle.
 func (n *Needle) Close() {
 	n.freeBuffer()
@@ -199,9 +287,26 @@ func (n *Needle) Buffer() []byte {
 	return n.buffer[:n.TotalSize]
 }
 
+// HeaderBuffer get needle header buffer, usually call before ParseHeader.
+// the version is unknown before parse, so the v2 header size is read, a
+// needle is never smaller than it. the header of a meta needle may be longer,
+// then ParseHeader returns ErrNeedleHeaderSize and the buffer is the whole
+// header.
+func (n *Needle) HeaderBuffer() []byte {
+	if n.HeaderSize > _headerSizeV2 {
+		return n.buffer[:n.HeaderSize]
+	}
+	return n.buffer[:_headerSizeV2]
+}
+
+// Expired reports whether the needle is expired at the unix seconds.
+func (n *Needle) Expired(now int64) bool {
+	return n.Expire > 0 && n.Expire <= now
+}
+
 // calcSize calc the needle meta size.
 func (n *Needle) calcSize() {
-	n.TotalSize = int32(_headerSize + n.Size + _footerSize)
+	n.TotalSize = n.HeaderSize + n.Size + _footerSize
 	n.PaddingSize = align(n.TotalSize) - n.TotalSize
 	n.TotalSize += n.PaddingSize
 	n.FooterSize = _footerSize + n.PaddingSize
@@ -210,24 +315,60 @@ func (n *Needle) calcSize() {
 
 // Init parse needle from specified size.
 func (n *Needle) init() {
+	if n.Meta != nil {
+		n.meta = n.Meta.encode()
+		n.Expire = n.Meta.Expire
+		n.HeaderMagic = _headerMagicMeta
+		n.HeaderSize = _headerSizeMeta + int32(len(n.meta))
+	} else if n.Expire > 0 {
+		n.HeaderMagic = _headerMagicV2
+		n.HeaderSize = _headerSizeV2
+	} else {
+		n.HeaderMagic = _headerMagic
+		n.HeaderSize = _headerSize
+	}
 	n.calcSize()
 	n.Flag = FlagOK
-	n.HeaderMagic = _headerMagic
 	n.FooterMagic = _footerMagic
 	n.Padding = _padding[n.PaddingSize]
 	return
 }
 
-// parseHeader parse a needle header part.
+// headerSize get the needle header size by the header magic.
+func headerSize(buf []byte) (size int32, err error) {
+	var metaSize int32
+	if len(buf) < _headerSize {
+		return 0, errors.ErrNeedleHeaderSize
+	}
+	if bytes.Equal(buf[_magicOffset:_cookieOffset], _headerMagic) {
+		size = _headerSize
+	} else if bytes.Equal(buf[_magicOffset:_cookieOffset], _headerMagicV2) {
+		size = _headerSizeV2
+	} else if bytes.Equal(buf[_magicOffset:_cookieOffset], _headerMagicMeta) {
+		if len(buf) < _headerSizeMeta {
+			return 0, errors.ErrNeedleHeaderSize
+		}
+		if metaSize = int32(binary.BigEndian.Uint16(buf[_metaSizeOffset:_metaOffset])); metaSize > _maxMetaSize {
+			return 0, errors.ErrNeedleMeta
+		}
+		size = _headerSizeMeta + metaSize
+	} else {
+		err = errors.ErrNeedleHeaderMagic
+	}
+	return
+}
+
+// parseHeader parse a needle header part, the buf may be longer than the
+// header.
 func (n *Needle) parseHeader(buf []byte) (err error) {
-	if len(buf) != _headerSize {
+	if n.HeaderSize, err = headerSize(buf); err != nil {
+		return
+	}
+	if len(buf) < int(n.HeaderSize) {
 		return errors.ErrNeedleHeaderSize
 	}
 	// magic
 	n.HeaderMagic = buf[_magicOffset:_cookieOffset]
-	if !bytes.Equal(n.HeaderMagic, _headerMagic) {
-		return errors.ErrNeedleHeaderMagic
-	}
 	// cookie
 	n.Cookie = binary.BigEndian.Int32(buf[_cookieOffset:_keyOffset])
 	// key
@@ -242,6 +383,17 @@ func (n *Needle) parseHeader(buf []byte) (err error) {
 	if n.Size < 0 {
 		return errors.ErrNeedleSize
 	}
+	// expire or meta
+	n.Meta = nil
+	n.Expire = 0
+	if bytes.Equal(n.HeaderMagic, _headerMagicV2) {
+		n.Expire = binary.BigEndian.Int64(buf[_expireOffset:_dataOffsetV2])
+	} else if bytes.Equal(n.HeaderMagic, _headerMagicMeta) {
+		if n.Meta, err = parseMeta(buf[_metaOffset:n.HeaderSize]); err != nil {
+			return
+		}
+		n.Expire = n.Meta.Expire
+	}
 	n.calcSize()
 	return
 }
@@ -281,7 +433,7 @@ func (n *Needle) parseFooter(buf []byte) (err error) {
 
 // writeHeader write needle header into buf bytes.
 func (n *Needle) writeHeader(buf []byte) (err error) {
-	if len(buf) != int(_headerSize) {
+	if len(buf) != int(n.HeaderSize) {
 		return errors.ErrNeedleHeaderSize
 	}
 	// magic
@@ -294,6 +446,13 @@ func (n *Needle) writeHeader(buf []byte) (err error) {
 	buf[_flagOffset] = n.Flag
 	// size
 	binary.BigEndian.PutInt32(buf[_sizeOffset:_dataOffset], n.Size)
+	// expire or meta
+	if bytes.Equal(n.HeaderMagic, _headerMagicV2) {
+		binary.BigEndian.PutInt64(buf[_expireOffset:_dataOffsetV2], n.Expire)
+	} else if bytes.Equal(n.HeaderMagic, _headerMagicMeta) {
+		binary.BigEndian.PutUint16(buf[_metaSizeOffset:_metaOffset], uint16(len(n.meta)))
+		copy(buf[_metaOffset:n.HeaderSize], n.meta)
+	}
 	return
 }
 
@@ -315,19 +474,26 @@ func (n *Needle) writeFooter(buf []byte) (err error) {
 // used in scan block by bufio.Reader.
 func (n *Needle) ParseFrom(rd *bufio.Reader) (err error) {
 	var (
+		size         int32
 		dataOffset   int32
 		footerOffset int32
 		endOffset    int32
 		data         []byte
 	)
-	// header
-	if data, err = rd.Peek(_headerSize); err != nil {
+	// header, the version is told by the magic
+	if data, err = rd.Peek(_headerSizeMeta); err != nil {
+		return
+	}
+	if size, err = headerSize(data); err != nil {
+		return
+	}
+	if data, err = rd.Peek(int(size)); err != nil {
 		return
 	}
 	if err = n.parseHeader(data); err != nil {
 		return
 	}
-	dataOffset = _headerSize
+	dataOffset = n.HeaderSize
 	footerOffset = dataOffset + n.Size
 	endOffset = footerOffset + n.FooterSize
 	// no discard, get all needle buffer
@@ -349,24 +515,30 @@ func (n *Needle) ParseFrom(rd *bufio.Reader) (err error) {
 // parse Parse needle from inner buffer, usually call after ReadAt.
 func (n *Needle) Parse() (err error) {
 	var dataOffset int32
-	if err = n.parseHeader(n.buffer[:_headerSize]); err == nil {
-		dataOffset = _headerSize + n.Size
-		if err = n.parseData(n.buffer[_headerSize:dataOffset]); err == nil {
+	if err = n.parseHeader(n.buffer); err == nil {
+		dataOffset = n.HeaderSize + n.Size
+		if err = n.parseData(n.buffer[n.HeaderSize:dataOffset]); err == nil {
 			err = n.parseFooter(n.buffer[dataOffset:n.TotalSize])
 		}
 	}
 	return
 }
 
+// ParseHeader parse needle header from inner buffer, used in range read
+// which no need the whole needle.
+func (n *Needle) ParseHeader() error {
+	return n.parseHeader(n.HeaderBuffer())
+}
+
 // ReadFrom read from io.Reader and write into needle buffer.
 func (n *Needle) ReadFrom(rd io.Reader) (err error) {
 	var (
 		dataOffset int32
 		data       []byte
 	)
-	dataOffset = _headerSize + n.Size
-	data = n.buffer[_headerSize:dataOffset]
-	if err = n.writeHeader(n.buffer[:_headerSize]); err == nil {
+	dataOffset = n.HeaderSize + n.Size
+	data = n.buffer[n.HeaderSize:dataOffset]
+	if err = n.writeHeader(n.buffer[:n.HeaderSize]); err == nil {
 		if _, err = rd.Read(data); err == nil {
 			n.Data = data
 			n.Checksum = crc32.Update(0, _crc32Table, data)
@@ -376,6 +548,49 @@ func (n *Needle) ReadFrom(rd io.Reader) (err error) {
 	return
 }
 
+// WriteFrom read needle data from io.Reader and write the whole needle into
+// io.Writer chunk by chunk, buf is the chunk, so the memory is bounded by the
+// chunk size whatever the needle size.
+func (n *Needle) WriteFrom(rd io.Reader, wr io.Writer, buf []byte) (err error) {
+	var (
+		size  int
+		left  = int(n.Size)
+		chunk []byte
+	)
+	if len(buf) < int(n.HeaderSize) || len(buf) < int(n.FooterSize) {
+		return errors.ErrNeedleChunkSize
+	}
+	if err = n.writeHeader(buf[:n.HeaderSize]); err != nil {
+		return
+	}
+	if _, err = wr.Write(buf[:n.HeaderSize]); err != nil {
+		return
+	}
+	n.Checksum = 0
+	for left > 0 {
+		if size = len(buf); size > left {
+			size = left
+		}
+		chunk = buf[:size]
+		if _, err = io.ReadFull(rd, chunk); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = errors.ErrNeedleDataSize
+			}
+			return
+		}
+		n.Checksum = crc32.Update(n.Checksum, _crc32Table, chunk)
+		if _, err = wr.Write(chunk); err != nil {
+			return
+		}
+		left -= size
+	}
+	if err = n.writeFooter(buf[:n.FooterSize]); err != nil {
+		return
+	}
+	_, err = wr.Write(buf[:n.FooterSize])
+	return
+}
+
 func (n *Needle) String() string {
 	var dn = _displayData
 	if len(n.Data) < dn {
@@ -392,6 +607,8 @@ Cookie:         %d
 Key:            %d
 Flag:           %d
 Size:           %d
+Expire:         %d
+Meta:           %+v
 
 ---- data
 Data:           %v...
@@ -402,6 +619,6 @@ FooterMagic:    %v
 Checksum:       %d
 Padding:        %v
 -----------------------------
-`, n.TotalSize, _headerSize, n.HeaderMagic, n.Cookie, n.Key, n.Flag, n.Size,
-		n.Data[:dn], n.FooterSize, n.FooterMagic, n.Checksum, n.Padding)
+`, n.TotalSize, n.HeaderSize, n.HeaderMagic, n.Cookie, n.Key, n.Flag, n.Size,
+		n.Expire, n.Meta, n.Data[:dn], n.FooterSize, n.FooterMagic, n.Checksum, n.Padding)
 }
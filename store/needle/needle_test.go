@@ -1,6 +1,8 @@
 package needle
 
 import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
 	"bufio"
 	"bytes"
 	"hash/crc32"
@@ -68,6 +70,224 @@ func TestNeedle(t *testing.T) {
 	compareNeedle(t, tn, 4, 4, data2, FlagOK, checksum2)
 }
 
+func TestNeedleWriteFrom(t *testing.T) {
+	var (
+		err      error
+		n, tn    *Needle
+		data     = []byte("test stream needle")
+		checksum = crc32.Update(0, _crc32Table, data)
+		buf      = &bytes.Buffer{}
+		chunk    = make([]byte, 32)
+	)
+	n = NewStreamWriter(5, 5, int32(len(data)))
+	defer n.Close()
+	if err = n.WriteFrom(bytes.NewReader(data), buf, chunk); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if buf.Len() != int(n.TotalSize) {
+		t.Errorf("buf.Len(): %d != %d", buf.Len(), n.TotalSize)
+		t.FailNow()
+	}
+	tn = new(Needle)
+	tn.buffer = buf.Bytes()
+	if err = tn.Parse(); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	compareNeedle(t, tn, 5, 5, data, FlagOK, checksum)
+	// short stream
+	n = NewStreamWriter(6, 6, int32(len(data)+1))
+	defer n.Close()
+	if err = n.WriteFrom(bytes.NewReader(data), &bytes.Buffer{}, chunk); err != errors.ErrNeedleDataSize {
+		t.Errorf("err: %v must be ErrNeedleDataSize", err)
+		t.FailNow()
+	}
+	// small chunk
+	if err = n.WriteFrom(bytes.NewReader(data), &bytes.Buffer{}, chunk[:8]); err != errors.ErrNeedleChunkSize {
+		t.Errorf("err: %v must be ErrNeedleChunkSize", err)
+		t.FailNow()
+	}
+}
+
+func TestNeedleExpire(t *testing.T) {
+	var (
+		err   error
+		n, tn *Needle
+		br    *bufio.Reader
+		data  = []byte("tes1")
+		buf   = &bytes.Buffer{}
+	)
+	n = NewExpireWriter(7, 7, 4, 100)
+	defer n.Close()
+	if n.TotalSize != 48 || n.HeaderSize != _headerSizeV2 {
+		t.Errorf("TotalSize: %d, HeaderSize: %d not match", n.TotalSize, n.HeaderSize)
+		t.FailNow()
+	}
+	if err = n.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	tn = new(Needle)
+	tn.buffer = n.Buffer()
+	if err = tn.Parse(); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	compareNeedle(t, tn, 7, 7, data, FlagOK, crc32.Update(0, _crc32Table, data))
+	if tn.Expire != 100 || tn.Expired(99) || !tn.Expired(100) {
+		t.Errorf("Expire: %d not match", tn.Expire)
+		t.FailNow()
+	}
+	// v1 and v2 needles in a stream
+	buf.Write(n.Buffer())
+	n = NewWriter(8, 8, 4)
+	defer n.Close()
+	if err = n.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	buf.Write(n.Buffer())
+	br = bufio.NewReader(buf)
+	tn = new(Needle)
+	if err = tn.ParseFrom(br); err != nil || tn.Key != 7 || tn.Expire != 100 {
+		t.Errorf("ParseFrom() key: %d, expire: %d error(%v)", tn.Key, tn.Expire, err)
+		t.FailNow()
+	}
+	if err = tn.ParseFrom(br); err != nil || tn.Key != 8 || tn.Expire != 0 || tn.Expired(100) {
+		t.Errorf("ParseFrom() key: %d, expire: %d error(%v)", tn.Key, tn.Expire, err)
+		t.FailNow()
+	}
+}
+
+func TestNeedleMeta(t *testing.T) {
+	var (
+		err   error
+		n, tn *Needle
+		br    *bufio.Reader
+		data  = []byte("tes1")
+		buf   = &bytes.Buffer{}
+		m     = &Meta{Mine: "image/jpeg", MTime: 1, Filename: "a.jpg", Expire: 100}
+	)
+	n = NewMetaWriter(9, 9, 4, m)
+	defer n.Close()
+	if n.HeaderSize != _headerSizeMeta+int32(len(n.meta)) || n.Expire != 100 {
+		t.Errorf("HeaderSize: %d, Expire: %d not match", n.HeaderSize, n.Expire)
+		t.FailNow()
+	}
+	if err = n.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	tn = new(Needle)
+	tn.buffer = n.Buffer()
+	if err = tn.Parse(); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	compareNeedle(t, tn, 9, 9, data, FlagOK, crc32.Update(0, _crc32Table, data))
+	if tn.Meta == nil || *tn.Meta != *m || tn.Expire != 100 {
+		t.Errorf("Meta: %v, Expire: %d not match", tn.Meta, tn.Expire)
+		t.FailNow()
+	}
+	// the header is longer than the v2 one
+	tn = new(Needle)
+	tn.buffer = n.Buffer()
+	if err = tn.ParseHeader(); err != errors.ErrNeedleHeaderSize {
+		t.Errorf("err: %v must be ErrNeedleHeaderSize", err)
+		t.FailNow()
+	}
+	if err = tn.ParseHeader(); err != nil || tn.Meta == nil || tn.Meta.Filename != "a.jpg" {
+		t.Errorf("ParseHeader() meta: %v error(%v)", tn.Meta, err)
+		t.FailNow()
+	}
+	// unknown item is skipped
+	if tn.Meta, err = parseMeta(appendItem(nil, 0xff, []byte("x"))); err != nil || *tn.Meta != (Meta{}) {
+		t.Errorf("parseMeta() meta: %v error(%v)", tn.Meta, err)
+		t.FailNow()
+	}
+	if _, err = parseMeta([]byte{_metaMTime, 0, 1, 0}); err != errors.ErrNeedleMeta {
+		t.Errorf("err: %v must be ErrNeedleMeta", err)
+		t.FailNow()
+	}
+	// v1 and meta needles in a stream
+	buf.Write(n.Buffer())
+	n = NewWriter(10, 10, 4)
+	defer n.Close()
+	if err = n.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	buf.Write(n.Buffer())
+	br = bufio.NewReader(buf)
+	tn = new(Needle)
+	if err = tn.ParseFrom(br); err != nil || tn.Key != 9 || tn.Meta == nil || tn.Meta.Mine != "image/jpeg" {
+		t.Errorf("ParseFrom() key: %d, meta: %v error(%v)", tn.Key, tn.Meta, err)
+		t.FailNow()
+	}
+	if err = tn.ParseFrom(br); err != nil || tn.Key != 10 || tn.Meta != nil {
+		t.Errorf("ParseFrom() key: %d, meta: %v error(%v)", tn.Key, tn.Meta, err)
+		t.FailNow()
+	}
+}
+
+func TestNeedleCompress(t *testing.T) {
+	var (
+		err          error
+		size         int64
+		n, tn        *Needle
+		cdata, rdata []byte
+		data         = bytes.Repeat([]byte("{\"bfs\":1}"), 100)
+	)
+	if cdata, err = Compress(meta.CodecGzip, data); err != nil || len(cdata) >= len(data) {
+		t.Errorf("Compress() size: %d error(%v)", len(cdata), err)
+		t.FailNow()
+	}
+	if rdata, err = Compress(meta.CodecGzip, data); err != nil || !bytes.Equal(rdata, cdata) {
+		t.Error("Compress() not deterministic")
+		t.FailNow()
+	}
+	if _, err = Compress("zip", data); err != errors.ErrNeedleCodec {
+		t.Errorf("err: %v must be ErrNeedleCodec", err)
+		t.FailNow()
+	}
+	if size, err = DecompressedSize(meta.CodecGzip, cdata); err != nil || size != int64(len(data)) {
+		t.Errorf("DecompressedSize() size: %d error(%v)", size, err)
+		t.FailNow()
+	}
+	if _, err = DecompressedSize(meta.CodecGzip, data); err != errors.ErrNeedleCodec {
+		t.Errorf("err: %v must be ErrNeedleCodec", err)
+		t.FailNow()
+	}
+	n = NewMetaWriter(9, 9, int32(len(cdata)), &Meta{Mine: "application/json", Codec: meta.CodecGzip, Size: int64(len(data))})
+	defer n.Close()
+	if err = n.ReadFrom(bytes.NewReader(cdata)); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	tn = new(Needle)
+	tn.buffer = n.Buffer()
+	if err = tn.Parse(); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if !tn.Compressed() || tn.RawSize() != int64(len(data)) || tn.Meta.Codec != meta.CodecGzip {
+		t.Errorf("Meta: %v not match", tn.Meta)
+		t.FailNow()
+	}
+	if rdata, err = Decompress(tn.Meta.Codec, tn.Data, tn.Meta.Size); err != nil || !bytes.Equal(rdata, data) {
+		t.Errorf("Decompress() error(%v)", err)
+		t.FailNow()
+	}
+	// a raw needle
+	n = NewMetaWriter(10, 10, 4, &Meta{Mine: "image/jpeg"})
+	defer n.Close()
+	if n.Compressed() || n.RawSize() != 4 {
+		t.Error("raw needle must not be compressed")
+		t.FailNow()
+	}
+}
+
 func TestAlign(t *testing.T) {
 	var i, m int32
 	i = 1
@@ -168,4 +388,7 @@ func TestSize(t *testing.T) {
 	if Size(4) != 40 {
 		t.FailNow()
 	}
+	if MaxSize(4) != 1064 {
+		t.FailNow()
+	}
 }
@@ -12,6 +12,8 @@ type Needles struct {
 	Num       int
 	needles   []Needle
 	TotalSize int32
+	Expire    int64 // the expire of the needles read from, zero means never
+	Meta      *Meta // the meta of the needles read from, nil means no meta
 }
 
 // NewNeedles new a needles.
@@ -28,7 +30,11 @@ func (ns *Needles) ReadFrom(key int64, cookie, size int32, rd io.Reader) (err er
 		return errors.ErrNeedleFull
 	}
 	var n = &ns.needles[ns.wn]
-	n.InitWriter(key, cookie, size)
+	if ns.Meta != nil {
+		n.InitMetaWriter(key, cookie, size, ns.Meta)
+	} else {
+		n.InitWriter(key, cookie, size, ns.Expire)
+	}
 	if err = n.ReadFrom(rd); err != nil {
 		n.Close()
 		return
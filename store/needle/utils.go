@@ -19,3 +19,8 @@ func align(d int32) int32 {
 func Size(n int) int {
 	return int(align(_headerSize + int32(n) + _footerSize))
 }
+
+// MaxSize get the max needle size with meta data of any version.
+func MaxSize(n int) int {
+	return int(align(_headerSizeMeta + _maxMetaSize + int32(n) + _footerSize))
+}
@@ -0,0 +1,49 @@
+// +build darwin
+package os
+
+import (
+	"syscall"
+)
+
+const (
+	IOCB_CMD_PREAD = 0
+)
+
+type AIOContext uintptr
+
+type IOCB struct {
+	Data   uint64
+	Fd     uint32
+	Buf    []byte
+	Offset int64
+}
+
+type IOEvent struct {
+	Data uint64
+	Obj  uint64
+	Res  int64
+	Res2 int64
+}
+
+func PreparePread(cb *IOCB, fd int, buf []byte, off int64, data uint64) {
+	*cb = IOCB{Data: data, Fd: uint32(fd), Buf: buf, Offset: off}
+}
+
+func IOSetup(nr int) (ctx AIOContext, err error) {
+	err = syscall.ENOSYS
+	return
+}
+
+func IODestroy(ctx AIOContext) (err error) {
+	return syscall.ENOSYS
+}
+
+func IOSubmit(ctx AIOContext, cbs []*IOCB) (n int, err error) {
+	err = syscall.ENOSYS
+	return
+}
+
+func IOGetevents(ctx AIOContext, min int, events []IOEvent, timeout *syscall.Timespec) (n int, err error) {
+	err = syscall.ENOSYS
+	return
+}
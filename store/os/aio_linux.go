@@ -0,0 +1,98 @@
+// +build linux
+package os
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	IOCB_CMD_PREAD = 0
+)
+
+// AIOContext a linux aio context, aio_context_t.
+type AIOContext uintptr
+
+// IOCB a linux aio control block, struct iocb in the little endian layout.
+type IOCB struct {
+	Data      uint64
+	Key       uint32
+	RwFlags   uint32
+	Opcode    uint16
+	ReqPrio   int16
+	Fd        uint32
+	Buf       uint64
+	Nbytes    uint64
+	Offset    int64
+	reserved2 uint64
+	Flags     uint32
+	Resfd     uint32
+}
+
+// IOEvent a linux aio completion, struct io_event, Res is the read bytes or
+// the negative errno.
+type IOEvent struct {
+	Data uint64
+	Obj  uint64
+	Res  int64
+	Res2 int64
+}
+
+// PreparePread set the iocb a pread of the buf, the buf must be kept until
+// the completion.
+func PreparePread(cb *IOCB, fd int, buf []byte, off int64, data uint64) {
+	*cb = IOCB{}
+	cb.Data = data
+	cb.Opcode = IOCB_CMD_PREAD
+	cb.Fd = uint32(fd)
+	cb.Buf = uint64(uintptr(unsafe.Pointer(&buf[0])))
+	cb.Nbytes = uint64(len(buf))
+	cb.Offset = off
+}
+
+func IOSetup(nr int) (ctx AIOContext, err error) {
+	var errno syscall.Errno
+	if _, _, errno = syscall.Syscall(syscall.SYS_IO_SETUP, uintptr(nr), uintptr(unsafe.Pointer(&ctx)), 0); errno != 0 {
+		err = errno
+	}
+	return
+}
+
+func IODestroy(ctx AIOContext) (err error) {
+	var errno syscall.Errno
+	if _, _, errno = syscall.Syscall(syscall.SYS_IO_DESTROY, uintptr(ctx), 0, 0); errno != 0 {
+		err = errno
+	}
+	return
+}
+
+// IOSubmit submit the iocbs, returns the number of the submitted.
+func IOSubmit(ctx AIOContext, cbs []*IOCB) (n int, err error) {
+	var (
+		r     uintptr
+		errno syscall.Errno
+	)
+	if len(cbs) == 0 {
+		return
+	}
+	if r, _, errno = syscall.Syscall(syscall.SYS_IO_SUBMIT, uintptr(ctx), uintptr(len(cbs)), uintptr(unsafe.Pointer(&cbs[0]))); errno != 0 {
+		err = errno
+		return
+	}
+	n = int(r)
+	return
+}
+
+// IOGetevents wait at least min completions until the timeout.
+func IOGetevents(ctx AIOContext, min int, events []IOEvent, timeout *syscall.Timespec) (n int, err error) {
+	var (
+		r     uintptr
+		errno syscall.Errno
+	)
+	if r, _, errno = syscall.Syscall6(syscall.SYS_IO_GETEVENTS, uintptr(ctx), uintptr(min), uintptr(len(events)), uintptr(unsafe.Pointer(&events[0])), uintptr(unsafe.Pointer(timeout)), 0); errno != 0 {
+		err = errno
+		return
+	}
+	n = int(r)
+	return
+}
@@ -0,0 +1,58 @@
+package os
+
+import (
+	"io"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// DirectAlign the memory, offset and length alignment of a direct io,
+	// covers the logical block size of the disks.
+	DirectAlign = 4096
+)
+
+// AlignDown align the offset down to DirectAlign.
+func AlignDown(off int64) int64 {
+	return off &^ (DirectAlign - 1)
+}
+
+// AlignUp align the offset up to DirectAlign.
+func AlignUp(off int64) int64 {
+	return AlignDown(off + DirectAlign - 1)
+}
+
+// AlignedBuffer alloc a buffer, the address is aligned to DirectAlign.
+func AlignedBuffer(size int) []byte {
+	var (
+		off int
+		buf = make([]byte, size+DirectAlign)
+	)
+	if off = int(uintptr(unsafe.Pointer(&buf[0])) & (DirectAlign - 1)); off != 0 {
+		off = DirectAlign - off
+	}
+	return buf[off : off+size : off+size]
+}
+
+// ReadFull pread an aligned buffer until the first need bytes read, a short
+// unaligned read means the end of the file, then io.EOF returned.
+func ReadFull(fd int, buf []byte, off int64, need int) (n int, err error) {
+	var m int
+	for n < need {
+		if m, err = syscall.Pread(fd, buf[n:], off+int64(n)); err != nil {
+			if err == syscall.EINTR {
+				err = nil
+				continue
+			}
+			return
+		}
+		n += m
+		if m == 0 || m&(DirectAlign-1) != 0 {
+			break
+		}
+	}
+	if n < need {
+		err = io.EOF
+	}
+	return
+}
@@ -0,0 +1,6 @@
+// +build darwin
+package os
+
+const (
+	O_DIRECT = 0
+)
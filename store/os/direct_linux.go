@@ -0,0 +1,10 @@
+// +build linux
+package os
+
+import (
+	"syscall"
+)
+
+const (
+	O_DIRECT = syscall.O_DIRECT
+)
@@ -0,0 +1,33 @@
+package os
+
+import (
+	"path/filepath"
+	"syscall"
+)
+
+// MountPoint get the mount point of the path, the farthest parent on the
+// same device.
+func MountPoint(path string) (mp string, err error) {
+	var (
+		parent  string
+		st, pst syscall.Stat_t
+	)
+	if mp, err = filepath.Abs(path); err != nil {
+		return
+	}
+	if err = syscall.Stat(mp, &st); err != nil {
+		return
+	}
+	for {
+		if parent = filepath.Dir(mp); parent == mp {
+			return
+		}
+		if err = syscall.Stat(parent, &pst); err != nil {
+			return
+		}
+		if pst.Dev != st.Dev {
+			return
+		}
+		mp = parent
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bfs/libs/errors"
+	myos "bfs/store/os"
+	"bfs/store/volume"
+	"encoding/json"
+	"fmt"
+	log "github.com/golang/glog"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Recovery copy a lost volume replica from a surviving store, the block and
+// index are streamed over http into a free volume space, then verified and
+// added to this store.
+//
+// the recovery meta file is saved with the volume index, it keeps the source
+// store, the source block file and the local recovering files, so an
+// interrupted recovery resumes from the copied files size, and restarts if
+// the source block changed (compacted).
+
+const (
+	recoverVolumePrefix = "_recover_block_"
+	recoverMetaExt      = ".meta"
+	// volume file
+	volumeFileBlock = "block"
+	volumeFileIndex = "index"
+	// recovery status
+	RecoverRunning   = "running"
+	RecoverVerifying = "verifying"
+	RecoverDone      = "done"
+	RecoverFailed    = "failed"
+
+	_recoverRetry  = 3
+	_recoverBuffer = 1024 * 1024
+	// api
+	_volumeFileApi = "http://%s/volume_file?vid=%d&type=%s&offset=%d&name=%s"
+)
+
+var (
+	_recoverSleep  = time.Second * 5
+	_recoverClient = &http.Client{
+		Transport: &http.Transport{
+			DisableCompression:    true,
+			ResponseHeaderTimeout: 10 * time.Second,
+		},
+	}
+)
+
+// Recovery the progress of a volume recovery.
+type Recovery struct {
+	Vid       int32  `json:"vid"`
+	Src       string `json:"src"`
+	File      string `json:"file"`
+	Block     int64  `json:"block"`
+	BlockSize int64  `json:"block_size"`
+	Index     int64  `json:"index"`
+	IndexSize int64  `json:"index_size"`
+	Status    string `json:"status"`
+	Err       string `json:"err,omitempty"`
+	// local recovering files
+	bfile string
+	ifile string
+	mfile string
+}
+
+// recoverMeta the persisted recovery meta.
+type recoverMeta struct {
+	Src   string `json:"src"`
+	File  string `json:"file"`
+	Block string `json:"block"`
+	Index string `json:"index"`
+}
+
+// recoverFile get volume block & index recovering file name.
+func (s *Store) recoverFile(id int32, bdir, idir string) (bfile, ifile string) {
+	var file = fmt.Sprintf("%s%d", recoverVolumePrefix, id)
+	bfile = filepath.Join(bdir, file)
+	ifile = filepath.Join(idir, file+volumeIndexExt)
+	return
+}
+
+// recoverMetaFile get the recovery meta file name, saved with the volume
+// index.
+func (s *Store) recoverMetaFile(id int32) string {
+	return filepath.Join(filepath.Dir(s.conf.Store.VolumeIndex), fmt.Sprintf("%s%d%s", recoverVolumePrefix, id, recoverMetaExt))
+}
+
+// RecoverVolume start recovering a volume from the src store admin addr.
+func (s *Store) RecoverVolume(id int32, src string) (err error) {
+	var rc *Recovery
+	if s.Volumes[id] != nil {
+		return errors.ErrVolumeExist
+	}
+	s.rlock.Lock()
+	if rc = s.recovers[id]; rc != nil && (rc.Status == RecoverRunning || rc.Status == RecoverVerifying) {
+		err = errors.ErrVolumeRecover
+	} else {
+		rc = &Recovery{Vid: id, Src: src, Status: RecoverRunning}
+		s.recovers[id] = rc
+	}
+	s.rlock.Unlock()
+	if err != nil {
+		return
+	}
+	go s.recover(rc)
+	return
+}
+
+// Recovery get the recovery progress of a volume.
+func (s *Store) Recovery(id int32) (rc *Recovery, err error) {
+	s.rlock.Lock()
+	if rc = s.recovers[id]; rc != nil {
+		nrc := *rc
+		rc = &nrc
+	} else {
+		err = errors.ErrVolumeNotExist
+	}
+	s.rlock.Unlock()
+	return
+}
+
+func (s *Store) recover(rc *Recovery) {
+	var err error
+	log.Infof("recover volume: %d from: %s start", rc.Vid, rc.Src)
+	if err = s.recoverVolume(rc); err != nil {
+		log.Errorf("recover volume: %d from: %s error(%v)", rc.Vid, rc.Src, err)
+	} else {
+		log.Infof("recover volume: %d from: %s done", rc.Vid, rc.Src)
+	}
+	s.rlock.Lock()
+	if err != nil {
+		rc.Status = RecoverFailed
+		rc.Err = err.Error()
+	} else {
+		rc.Status = RecoverDone
+	}
+	s.rlock.Unlock()
+}
+
+// recoverVolume copy, verify and add the volume, restart the copy if the
+// source block changed.
+func (s *Store) recoverVolume(rc *Recovery) (err error) {
+	var (
+		i              int
+		bfile, ifile   string
+		bdir, idir     string
+		nbfile, nifile string
+	)
+	if err = s.recoverInit(rc); err != nil {
+		return
+	}
+	for i = 0; i < _recoverRetry; i++ {
+		if err = s.recoverCopy(rc); err != errors.ErrVolumeChanged {
+			break
+		}
+		log.Warningf("recover volume: %d source block changed, restart", rc.Vid)
+		if err = s.recoverReset(rc); err != nil {
+			return
+		}
+	}
+	if err != nil {
+		return
+	}
+	s.setRecoverStatus(rc, RecoverVerifying)
+	if err = s.recoverVerify(rc); err != nil {
+		// the copied files are broken, next recovery restarts
+		s.recoverReset(rc)
+		return
+	}
+	bfile, ifile = rc.bfile, rc.ifile
+	bdir, idir = filepath.Dir(bfile), filepath.Dir(ifile)
+	for i = 0; ; i++ {
+		if nbfile, nifile = s.file(rc.Vid, bdir, idir, i); !myos.Exist(nbfile) && !myos.Exist(nifile) {
+			break
+		}
+	}
+	log.Infof("rename block: %s to %s", bfile, nbfile)
+	log.Infof("rename index: %s to %s", ifile, nifile)
+	if err = os.Rename(ifile, nifile); err != nil {
+		log.Errorf("os.Rename(\"%s\", \"%s\") error(%v)", ifile, nifile, err)
+		return
+	}
+	if err = os.Rename(bfile, nbfile); err != nil {
+		log.Errorf("os.Rename(\"%s\", \"%s\") error(%v)", bfile, nbfile, err)
+		return
+	}
+	os.Remove(rc.mfile)
+	err = s.BulkVolume(rc.Vid, nbfile, nifile)
+	return
+}
+
+// recoverInit find the interrupted recovery of the volume to resume, or take
+// a free volume space to recover into.
+func (s *Store) recoverInit(rc *Recovery) (err error) {
+	var (
+		data []byte
+		m    = new(recoverMeta)
+	)
+	if rc.mfile = s.recoverMetaFile(rc.Vid); !myos.Exist(rc.mfile) {
+		return s.recoverFreeVolume(rc)
+	}
+	if data, err = ioutil.ReadFile(rc.mfile); err != nil {
+		log.Errorf("ioutil.ReadFile(\"%s\") error(%v)", rc.mfile, err)
+		return
+	}
+	if err = json.Unmarshal(data, m); err != nil {
+		log.Errorf("json.Unmarshal(\"%s\") error(%v)", data, err)
+		return
+	}
+	rc.bfile, rc.ifile = m.Block, m.Index
+	if m.Src != rc.Src || m.File == "" {
+		// another replica has another layout
+		log.Infof("recover volume: %d src: %s, last src: %s, restart", rc.Vid, rc.Src, m.Src)
+		return s.recoverReset(rc)
+	}
+	s.rlock.Lock()
+	rc.File = m.File
+	rc.Block = fileSize(rc.bfile)
+	rc.Index = fileSize(rc.ifile)
+	s.rlock.Unlock()
+	log.Infof("recover volume: %d resume block: %s offset: %d, index: %s offset: %d", rc.Vid, rc.bfile, rc.Block, rc.ifile, rc.Index)
+	return
+}
+
+// recoverFreeVolume take a free volume, rename it's files as recovering
+// files, the fallocated space is reused.
+func (s *Store) recoverFreeVolume(rc *Recovery) (err error) {
+	var v *volume.Volume
+	s.flock.Lock()
+	defer s.flock.Unlock()
+	if len(s.FreeVolumes) == 0 {
+		return errors.ErrStoreNoFreeVolume
+	}
+	v = s.FreeVolumes[0]
+	rc.bfile, rc.ifile = s.recoverFile(rc.Vid, filepath.Dir(v.Block.File), filepath.Dir(v.Indexer.File))
+	if myos.Exist(rc.bfile) || myos.Exist(rc.ifile) {
+		return errors.ErrStoreFileExist
+	}
+	// save meta first, a crash before rename leaves the free volume free
+	if err = s.recoverReset(rc); err != nil {
+		return
+	}
+	log.Infof("rename block: %s to %s", v.Block.File, rc.bfile)
+	log.Infof("rename index: %s to %s", v.Indexer.File, rc.ifile)
+	if err = os.Rename(v.Indexer.File, rc.ifile); err != nil {
+		log.Errorf("os.Rename(\"%s\", \"%s\") error(%v)", v.Indexer.File, rc.ifile, err)
+		return
+	}
+	if err = os.Rename(v.Block.File, rc.bfile); err != nil {
+		log.Errorf("os.Rename(\"%s\", \"%s\") error(%v)", v.Block.File, rc.bfile, err)
+		return
+	}
+	s.FreeVolumes = s.FreeVolumes[1:]
+	err = s.saveFreeVolumeIndex()
+	return
+}
+
+// recoverReset restart the recovery from the beginning and save the meta.
+func (s *Store) recoverReset(rc *Recovery) (err error) {
+	s.rlock.Lock()
+	rc.File = ""
+	rc.Block, rc.Index = 0, 0
+	s.rlock.Unlock()
+	return s.saveRecoverMeta(rc)
+}
+
+// saveRecoverMeta save the recovery meta.
+func (s *Store) saveRecoverMeta(rc *Recovery) (err error) {
+	var (
+		data []byte
+		m    = &recoverMeta{Src: rc.Src, File: rc.File, Block: rc.bfile, Index: rc.ifile}
+	)
+	if data, err = json.Marshal(m); err != nil {
+		log.Errorf("json.Marshal() error(%v)", err)
+		return
+	}
+	if err = ioutil.WriteFile(rc.mfile, data, 0664); err != nil {
+		log.Errorf("ioutil.WriteFile(\"%s\") error(%v)", rc.mfile, err)
+	}
+	return
+}
+
+func (s *Store) setRecoverStatus(rc *Recovery, status string) {
+	s.rlock.Lock()
+	rc.Status = status
+	s.rlock.Unlock()
+}
+
+// recoverCopy copy the block then the index, the index may be newer than the
+// block copied, the index beyond the block is discarded when the volume
+// loading, and the block tail not indexed is recovered by scanning.
+func (s *Store) recoverCopy(rc *Recovery) (err error) {
+	if err = s.recoverFetch(rc, volumeFileBlock, rc.bfile, &rc.Block, &rc.BlockSize); err != nil {
+		return
+	}
+	return s.recoverFetch(rc, volumeFileIndex, rc.ifile, &rc.Index, &rc.IndexSize)
+}
+
+// recoverFetch fetch a volume file to the local file from the offset, retry
+// from the copied offset if the stream broken.
+func (s *Store) recoverFetch(rc *Recovery, typ, file string, offset, size *int64) (err error) {
+	var i int
+	for i = 0; i < _recoverRetry; i++ {
+		if err = s.fetch(rc, typ, file, offset, size); err == nil || err == errors.ErrVolumeChanged || err == errors.ErrVolumeNotExist {
+			break
+		}
+		log.Errorf("recover volume: %d fetch %s offset: %d error(%v), retry", rc.Vid, typ, *offset, err)
+		time.Sleep(_recoverSleep)
+	}
+	if err != nil {
+		return
+	}
+	// a restarted copy may be shorter than the stale local file
+	if fileSize(file) > *size {
+		if err = os.Truncate(file, *size); err != nil {
+			log.Errorf("os.Truncate(\"%s\") error(%v)", file, err)
+		}
+	}
+	return
+}
+
+// recoverWriter write the local file and update the progress.
+type recoverWriter struct {
+	f      *os.File
+	s      *Store
+	offset *int64
+}
+
+func (w *recoverWriter) Write(p []byte) (n int, err error) {
+	n, err = w.f.Write(p)
+	w.s.rlock.Lock()
+	*w.offset += int64(n)
+	w.s.rlock.Unlock()
+	return
+}
+
+// fetch get a volume file from the src store, from the offset to the end
+// of the source snapshot.
+func (s *Store) fetch(rc *Recovery, typ, file string, offset, size *int64) (err error) {
+	var (
+		n     int64
+		total int64
+		f     *os.File
+		resp  *http.Response
+		uri   = fmt.Sprintf(_volumeFileApi, rc.Src, rc.Vid, typ, *offset, url.QueryEscape(rc.File))
+	)
+	if resp, err = _recoverClient.Get(uri); err != nil {
+		log.Errorf("_recoverClient.Get(%s) error(%v)", uri, err)
+		return
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		return errors.ErrVolumeChanged
+	case http.StatusConflict:
+		return errors.ErrVolumeInCompact
+	case http.StatusNotFound:
+		return errors.ErrVolumeNotExist
+	default:
+		log.Errorf("_recoverClient.Get(%s) status: %d", uri, resp.StatusCode)
+		return errors.ErrInternal
+	}
+	if total, err = strconv.ParseInt(resp.Header.Get("X-Bfs-Size"), 10, 64); err != nil {
+		log.Errorf("strconv.ParseInt(\"%s\") error(%v)", resp.Header.Get("X-Bfs-Size"), err)
+		return errors.ErrInternal
+	}
+	s.rlock.Lock()
+	if rc.File == "" {
+		rc.File = resp.Header.Get("X-Bfs-File")
+	}
+	*size = total
+	s.rlock.Unlock()
+	if err = s.saveRecoverMeta(rc); err != nil {
+		return
+	}
+	// no O_TRUNC, the fallocated space of the free volume is reused
+	if f, err = os.OpenFile(file, os.O_WRONLY|os.O_CREATE, 0664); err != nil {
+		log.Errorf("os.OpenFile(\"%s\") error(%v)", file, err)
+		return
+	}
+	defer f.Close()
+	if _, err = f.Seek(*offset, os.SEEK_SET); err != nil {
+		log.Errorf("file: %s Seek() error(%v)", file, err)
+		return
+	}
+	if n, err = io.CopyBuffer(&recoverWriter{f: f, s: s, offset: offset}, resp.Body, make([]byte, _recoverBuffer)); err != nil {
+		log.Errorf("io.Copy(%s) error(%v)", uri, err)
+	} else if *offset != total {
+		log.Errorf("fetch %s copied: %d, offset: %d, size: %d not match", uri, n, *offset, total)
+		err = io.ErrUnexpectedEOF
+	}
+	if err1 := f.Sync(); err1 != nil && err == nil {
+		log.Errorf("file: %s Sync() error(%v)", file, err1)
+		err = err1
+	}
+	return
+}
+
+// recoverVerify load the recovered volume, check every needle of the block.
+func (s *Store) recoverVerify(rc *Recovery) (err error) {
+	var v *volume.Volume
+	if v, err = newVolume(rc.Vid, rc.bfile, rc.ifile, s.conf); err != nil {
+		return
+	}
+	if err = v.Block.Verify(); err != nil {
+		log.Errorf("recover volume: %d block: %s verify error(%v)", rc.Vid, rc.bfile, err)
+	}
+	v.Close()
+	return
+}
+
+// fileSize get the file size, 0 if not exist.
+func fileSize(file string) int64 {
+	var (
+		err error
+		fi  os.FileInfo
+	)
+	if fi, err = os.Stat(file); err != nil {
+		return 0
+	}
+	return fi.Size()
+}
@@ -0,0 +1,329 @@
+package main
+
+// snapshot backup a volume incrementally from a store, or restore it offline
+// at a point-in-time. every backup saves the increment since the last cut as
+// <vid>_<seq>.base or <vid>_<seq>.incr in the dir and the new cut as
+// <vid>_<seq>.json, the first one (or the one after a compaction) is a base.
+// restore replays the nearest base and the increments after it up to the
+// seq into the fresh block and index, then the volume can be checked by fsck
+// and added by the admin api /bulk_volume.
+//
+// usage:
+//  snapshot -a backup -s 127.0.0.1:6063 -v 1 -d /backup [-base]
+//  snapshot -a restore -v 1 -d /backup [-seq 3] -f /bfs/block_1 -i /bfs/block_1.idx
+
+import (
+	"bfs/libs/meta"
+	"bfs/store/block"
+	"bfs/store/conf"
+	"bfs/store/volume"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	_incrementURI = "http://%s/increment_volume?%s"
+	_baseExt      = ".base"
+	_incrExt      = ".incr"
+	_cutExt       = ".json"
+	_tmp          = ".tmp"
+)
+
+var (
+	action  string
+	addr    string
+	vid     int
+	dir     string
+	base    bool
+	seq     int
+	file    string
+	ifile   string
+	maxSize int
+
+	errRebase = fmt.Errorf("volume compacted or delete log overflowed, need a new base")
+)
+
+func init() {
+	flag.StringVar(&action, "a", "", "action: backup or restore")
+	flag.StringVar(&addr, "s", "", "store admin address, e.g. 127.0.0.1:6063")
+	flag.IntVar(&vid, "v", 0, "volume id")
+	flag.StringVar(&dir, "d", "", "backup dir")
+	flag.BoolVar(&base, "base", false, "backup a new base")
+	flag.IntVar(&seq, "seq", -1, "restore up to the seq, default the last one")
+	flag.StringVar(&file, "f", "", "restored block file, must not exist")
+	flag.StringVar(&ifile, "i", "", "restored index file, must not exist")
+	flag.IntVar(&maxSize, "n", 10485760, "needle max size, same as the store config")
+}
+
+func main() {
+	var err error
+	flag.Parse()
+	if dir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	switch action {
+	case "backup":
+		if addr == "" {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = backup()
+	case "restore":
+		if file == "" || ifile == "" {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = restore()
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "snapshot: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// backups list the seqs of the backups, the value is true if a base.
+func backups() (seqs map[int]bool, last int, err error) {
+	var (
+		i     int
+		ext   string
+		names []string
+		name  string
+	)
+	seqs, last = make(map[int]bool), -1
+	if names, err = filepath.Glob(filepath.Join(dir, fmt.Sprintf("%d_*", vid))); err != nil {
+		return
+	}
+	for _, name = range names {
+		if ext = filepath.Ext(name); ext != _baseExt && ext != _incrExt {
+			continue
+		}
+		if i, err = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), fmt.Sprintf("%d_", vid)), ext)); err != nil {
+			return
+		}
+		if seqs[i] = ext == _baseExt; i > last {
+			last = i
+		}
+	}
+	return
+}
+
+// name get the backup file name.
+func name(i int, ext string) string {
+	return filepath.Join(dir, fmt.Sprintf("%d_%d%s", vid, i, ext))
+}
+
+// backup save the increment since the last cut, a base if none or the
+// volume compacted or the delete log overflowed.
+func backup() (err error) {
+	var (
+		last int
+		data []byte
+		seqs map[int]bool
+		from *meta.Snapshot
+		to   *meta.Snapshot
+		ext  = _incrExt
+	)
+	if seqs, last, err = backups(); err != nil {
+		return
+	}
+	if !base && last >= 0 {
+		if data, err = ioutil.ReadFile(name(last, _cutExt)); err != nil {
+			return
+		}
+		from = new(meta.Snapshot)
+		if err = json.Unmarshal(data, from); err != nil {
+			return
+		}
+	}
+	if to, err = fetch(last+1, from); err == errRebase {
+		fmt.Printf("volume: %d compacted or delete log overflowed since seq: %d, backup a new base\n", vid, last)
+		from = nil
+		to, err = fetch(last+1, nil)
+	}
+	if err != nil {
+		return
+	}
+	if from == nil {
+		ext = _baseExt
+	}
+	if data, err = json.Marshal(to); err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(name(last+1, _cutExt), data, 0644); err != nil {
+		return
+	}
+	if err = os.Rename(name(last+1, _tmp), name(last+1, ext)); err != nil {
+		return
+	}
+	fmt.Printf("volume: %d backup seq: %d (%s) block: %d index: %d, backups: %d\n", vid, last+1, ext, to.Block, to.Index, len(seqs)+1)
+	return
+}
+
+// fetch download the increment from the cut into a temp file, then verify it.
+func fetch(i int, from *meta.Snapshot) (to *meta.Snapshot, err error) {
+	var (
+		data   []byte
+		f      *os.File
+		resp   *http.Response
+		params = url.Values{}
+	)
+	params.Set("vid", strconv.Itoa(vid))
+	if from != nil {
+		if data, err = json.Marshal(from); err != nil {
+			return
+		}
+		params.Set("from", string(data))
+	}
+	if resp, err = http.Get(fmt.Sprintf(_incrementURI, addr, params.Encode())); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return nil, errRebase
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("increment volume: %d status: %d", vid, resp.StatusCode)
+	}
+	to = new(meta.Snapshot)
+	if err = json.Unmarshal([]byte(resp.Header.Get("X-Bfs-Snapshot")), to); err != nil {
+		return
+	}
+	if f, err = os.Create(name(i, _tmp)); err != nil {
+		return
+	}
+	// verify when saving, the stream is cut if the store failed in the middle
+	if _, err = volume.ReadIncrement(io.TeeReader(resp.Body, f), func(*volume.Increment) (bw, iw io.Writer, err error) {
+		return ioutil.Discard, ioutil.Discard, nil
+	}); err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(name(i, _tmp))
+	}
+	return
+}
+
+// restore replay the nearest base and the increments up to the seq.
+func restore() (err error) {
+	var (
+		i, start, last int
+		ok, isBase     bool
+		fname          string
+		seqs           map[int]bool
+	)
+	if seqs, last, err = backups(); err != nil {
+		return
+	}
+	if seq >= 0 {
+		last = seq
+	}
+	for start = last; start >= 0; start-- {
+		if isBase, ok = seqs[start]; !ok {
+			return fmt.Errorf("volume: %d seq: %d lost", vid, start)
+		}
+		if isBase {
+			break
+		}
+	}
+	if start < 0 {
+		return fmt.Errorf("volume: %d no base before seq: %d", vid, last)
+	}
+	for _, fname = range []string{file, ifile} {
+		if _, err = os.Stat(fname); err == nil {
+			return fmt.Errorf("file: %s exists", fname)
+		}
+	}
+	for i = start; i <= last; i++ {
+		if seqs[i] {
+			if err = replay(name(i, _baseExt)); err != nil {
+				return
+			}
+		} else if err = replay(name(i, _incrExt)); err != nil {
+			return
+		}
+	}
+	fmt.Printf("volume: %d restored to seq: %d from base seq: %d, block: %s index: %s\n", vid, last, start, file, ifile)
+	return
+}
+
+// replay append the block and index bytes of an increment, then flag the
+// deleted needles, the appended bytes are truncated if any error.
+func replay(fname string) (err error) {
+	var (
+		offset       uint32
+		bsize, isize int64
+		f            *os.File
+		bf, xf       *os.File
+		inc          *volume.Increment
+		b            *block.SuperBlock
+	)
+	if f, err = os.Open(fname); err != nil {
+		return
+	}
+	defer f.Close()
+	if bf, err = os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664); err != nil {
+		return
+	}
+	defer bf.Close()
+	if xf, err = os.OpenFile(ifile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664); err != nil {
+		return
+	}
+	defer xf.Close()
+	bsize, isize = size(bf), size(xf)
+	if inc, err = volume.ReadIncrement(f, func(inc *volume.Increment) (bw, iw io.Writer, err error) {
+		if inc.Vid != int32(vid) || inc.FromBlock != bsize || inc.FromIndex != isize {
+			err = fmt.Errorf("increment: %s block: %d index: %d not follow the restored block: %d index: %d",
+				fname, inc.FromBlock, inc.FromIndex, bsize, isize)
+		}
+		return bf, xf, err
+	}); err != nil {
+		// drop the appended bytes
+		bf.Truncate(bsize)
+		xf.Truncate(isize)
+		return
+	}
+	if err = bf.Sync(); err != nil {
+		return
+	}
+	if err = xf.Sync(); err != nil {
+		return
+	}
+	if b, err = block.NewSuperBlock(file, conf.NewOfflineConfig(maxSize)); err != nil {
+		return
+	}
+	for _, offset = range inc.Dels {
+		if err = b.Delete(offset); err != nil {
+			break
+		}
+	}
+	b.Close()
+	fmt.Printf("replay: %s block: [%d, %d) index: [%d, %d) dels: %d\n", fname, inc.FromBlock, inc.ToBlock,
+		inc.FromIndex, inc.ToIndex, len(inc.Dels))
+	return
+}
+
+// size get the file size.
+func size(f *os.File) int64 {
+	var (
+		err error
+		fi  os.FileInfo
+	)
+	if fi, err = f.Stat(); err != nil {
+		return -1
+	}
+	return fi.Size()
+}
@@ -9,12 +9,16 @@ import (
 	myzk "bfs/store/zk"
 	"fmt"
 	log "github.com/golang/glog"
+	"golang.org/x/time/rate"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -43,20 +47,43 @@ const (
 )
 
 var (
-	_compactSleep = time.Second * 10
+	_compactSleep   = time.Second * 10
+	_expireInterval = time.Minute
+	// the max backoff of a volume failed to compact
+	_compactMaxBackoff = 24 * time.Hour
 )
 
 // Store save volumes.
 type Store struct {
 	vf          *os.File
 	fvf         *os.File
+	evf         *os.File
 	FreeId      int32
 	Volumes     map[int32]*volume.Volume // split volumes lock
 	FreeVolumes []*volume.Volume
+	ECVolumes   map[int32]*volume.ECVolume // split volumes lock
 	zk          *myzk.Zookeeper
 	conf        *conf.Config
 	flock       sync.Mutex // protect FreeId & saveIndex
 	vlock       sync.Mutex // protect Volumes map
+	recovers    map[int32]*Recovery
+	rlock       sync.Mutex // protect recovers
+	stores      map[string]string
+	slock       sync.Mutex // protect stores
+	disks       map[string]*Disk
+	mounts      map[string]string // block dir:mount point
+	dresets     map[string]bool   // the failed disks reset by the admin
+	dlock       sync.Mutex        // protect disks & mounts & dresets
+	// volume id:consecutive failed compactions
+	cfails map[int32]*compactFail
+	clock  sync.Mutex // protect cfails
+}
+
+// compactFail the consecutive failed compactions of a volume, retried after
+// the backoff.
+type compactFail struct {
+	times int
+	next  time.Time
 }
 
 // NewStore
@@ -68,6 +95,12 @@ func NewStore(c *conf.Config) (s *Store, err error) {
 	s.conf = c
 	s.FreeId = 0
 	s.Volumes = make(map[int32]*volume.Volume)
+	s.ECVolumes = make(map[int32]*volume.ECVolume)
+	s.recovers = make(map[int32]*Recovery)
+	s.disks = make(map[string]*Disk)
+	s.mounts = make(map[string]string)
+	s.dresets = make(map[string]bool)
+	s.cfails = make(map[int32]*compactFail)
 	if s.vf, err = os.OpenFile(c.Store.VolumeIndex, os.O_RDWR|os.O_CREATE|myos.O_NOATIME, 0664); err != nil {
 		log.Errorf("os.OpenFile(\"%s\") error(%v)", c.Store.VolumeIndex, err)
 		s.Close()
@@ -78,17 +111,39 @@ func NewStore(c *conf.Config) (s *Store, err error) {
 		s.Close()
 		return nil, err
 	}
+	if s.evf, err = os.OpenFile(c.Store.ECVolumeIndex, os.O_RDWR|os.O_CREATE|myos.O_NOATIME, 0664); err != nil {
+		log.Errorf("os.OpenFile(\"%s\") error(%v)", c.Store.ECVolumeIndex, err)
+		s.Close()
+		return nil, err
+	}
 	if err = s.init(); err != nil {
 		s.Close()
 		return nil, err
 	}
+	// the volumes broken in loading are offline at once
+	s.checkDisks()
+	if c.Volume.ScrubInterval.Duration > 0 {
+		go s.scrubproc()
+	}
+	if c.Volume.CompactInterval.Duration > 0 {
+		go s.compactproc()
+	}
+	if c.Volume.CheckpointInterval.Duration > 0 {
+		go s.checkpointproc()
+	}
+	if c.Store.DiskCheckInterval.Duration > 0 {
+		go s.diskproc()
+	}
+	go s.expireproc()
 	return
 }
 
 // init init the store.
 func (s *Store) init() (err error) {
 	if err = s.parseFreeVolumeIndex(); err == nil {
-		err = s.parseVolumeIndex()
+		if err = s.parseVolumeIndex(); err == nil {
+			err = s.parseECVolumeIndex()
+		}
 	}
 	return
 }
@@ -136,15 +191,17 @@ func (s *Store) parseVolumeIndex() (err error) {
 		i          int
 		ok         bool
 		id         int32
-		bfile      string
-		ifile      string
 		v          *volume.Volume
+		vs         map[int32]*volume.Volume
 		data       []byte
 		lids, zids []int32
+		ids        []int32
 		lines      []string
 		lbfs, lifs []string
 		zbfs, zifs []string
+		bfs, ifs   []string
 		lim, zim   map[int32]struct{}
+		loads      = make(map[int32]struct{})
 	)
 	if data, err = ioutil.ReadAll(s.vf); err != nil {
 		log.Errorf("ioutil.ReadAll() error(%v)", err)
@@ -162,42 +219,97 @@ func (s *Store) parseVolumeIndex() (err error) {
 	}
 	// local index
 	for i = 0; i < len(lbfs); i++ {
-		id, bfile, ifile = lids[i], lbfs[i], lifs[i]
-		if _, ok = s.Volumes[id]; ok {
+		if _, ok = loads[lids[i]]; ok {
 			continue
 		}
-		if v, err = newVolume(id, bfile, ifile, s.conf); err != nil {
-			return
-		}
-		s.Volumes[id] = v
-		if _, ok = zim[id]; !ok {
-			if err = s.zk.AddVolume(id, v.Meta()); err != nil {
-				return
-			}
-		} else {
-			if err = s.zk.SetVolume(id, v.Meta()); err != nil {
-				return
-			}
-		}
+		loads[lids[i]] = struct{}{}
+		ids, bfs, ifs = append(ids, lids[i]), append(bfs, lbfs[i]), append(ifs, lifs[i])
 	}
 	// zk index
 	for i = 0; i < len(zbfs); i++ {
-		id, bfile, ifile = zids[i], zbfs[i], zifs[i]
-		if _, ok = s.Volumes[id]; ok {
+		if _, ok = loads[zids[i]]; ok {
 			continue
 		}
 		// if not exists in local
+		if _, ok = lim[zids[i]]; !ok {
+			loads[zids[i]] = struct{}{}
+			ids, bfs, ifs = append(ids, zids[i]), append(bfs, zbfs[i]), append(ifs, zifs[i])
+		}
+	}
+	vs, err = s.loadVolumes(ids, bfs, ifs)
+	for id, v = range vs {
+		s.Volumes[id] = v
+	}
+	if err != nil {
+		return
+	}
+	// local index
+	for _, id = range ids {
 		if _, ok = lim[id]; !ok {
-			if v, err = newVolume(id, bfile, ifile, s.conf); err != nil {
+			continue
+		}
+		if _, ok = zim[id]; !ok {
+			if err = s.zk.AddVolume(id, s.Volumes[id].Meta()); err != nil {
+				return
+			}
+		} else {
+			if err = s.zk.SetVolume(id, s.Volumes[id].Meta()); err != nil {
 				return
 			}
-			s.Volumes[id] = v
 		}
 	}
 	err = s.saveVolumeIndex()
 	return
 }
 
+// loadVolumes recovery the volumes in parallel, one goroutine per disk (the
+// mount point), the volumes on the same disk are loaded one by one to keep
+// the scans sequential.
+func (s *Store) loadVolumes(ids []int32, bfs, ifs []string) (vs map[int32]*volume.Volume, err error) {
+	var (
+		i     int
+		dir   string
+		is    []int
+		disks = make(map[string][]int)
+		lock  sync.Mutex
+		wg    sync.WaitGroup
+		now   = time.Now()
+	)
+	vs = make(map[int32]*volume.Volume, len(ids))
+	for i = 0; i < len(ids); i++ {
+		dir = s.mountPoint(bfs[i])
+		disks[dir] = append(disks[dir], i)
+	}
+	for dir, is = range disks {
+		wg.Add(1)
+		go func(dir string, is []int) {
+			var (
+				i    int
+				err1 error
+				v    *volume.Volume
+			)
+			for _, i = range is {
+				if v, err1 = newVolume(ids[i], bfs[i], ifs[i], s.conf); err1 != nil {
+					log.Errorf("disk: %s load volume: %d error(%v)", dir, ids[i], err1)
+					break
+				}
+				lock.Lock()
+				vs[ids[i]] = v
+				lock.Unlock()
+			}
+			lock.Lock()
+			if err == nil {
+				err = err1
+			}
+			lock.Unlock()
+			wg.Done()
+		}(dir, is)
+	}
+	wg.Wait()
+	log.Infof("load %d volumes on %d disks in %v", len(vs), len(disks), time.Since(now))
+	return
+}
+
 // parseIndex parse volume info from a index file.
 func (s *Store) parseIndex(lines []string) (im map[int32]struct{}, ids []int32, bfs, ifs []string, err error) {
 	var (
@@ -295,6 +407,7 @@ func (s *Store) SetZookeeper() (err error) {
 		Stat:  s.conf.StatListen,
 		Admin: s.conf.AdminListen,
 		Api:   s.conf.ApiListen,
+		Disks: s.Disks(),
 	}); err != nil {
 		log.Errorf("zk.SetStore() error(%v)", err)
 		return
@@ -428,15 +541,49 @@ func (s *Store) addVolume(id int32, nv *volume.Volume) {
 
 // AddVolume add a new volume.
 func (s *Store) AddVolume(id int32) (v *volume.Volume, err error) {
-	var ov *volume.Volume
 	// try check exists
-	if ov = s.Volumes[id]; ov != nil {
+	if s.Volumes[id] != nil {
 		return nil, errors.ErrVolumeExist
 	}
 	// find a free volume
 	if v, err = s.freeVolume(id); err != nil {
 		return
 	}
+	err = s.saveVolume(id, v)
+	return
+}
+
+// ImportVolume import an archive into a free volume, the volume is added
+// only if all the needles imported.
+func (s *Store) ImportVolume(id int32, r io.Reader) (n int, err error) {
+	var (
+		err1       error
+		v          *volume.Volume
+		bdir, idir string
+	)
+	if s.Volumes[id] != nil {
+		return 0, errors.ErrVolumeExist
+	}
+	if v, err = s.freeVolume(id); err != nil {
+		return
+	}
+	if n, err = v.Import(r); err != nil {
+		log.Errorf("volume: %d import error(%v)", id, err)
+		// the free volume is used up, add a new one instead
+		v.Destroy()
+		bdir, idir = filepath.Dir(v.Block.File), filepath.Dir(v.Indexer.File)
+		if _, err1 = s.AddFreeVolume(1, bdir, idir); err1 != nil {
+			log.Errorf("AddFreeVolume() error(%v)", err1)
+		}
+		return
+	}
+	err = s.saveVolume(id, v)
+	return
+}
+
+// saveVolume add a new volume then save the local and zookeeper index.
+func (s *Store) saveVolume(id int32, v *volume.Volume) (err error) {
+	var ov *volume.Volume
 	s.vlock.Lock()
 	if ov = s.Volumes[id]; ov == nil {
 		s.addVolume(id, v)
@@ -524,6 +671,12 @@ func (s *Store) BulkVolume(id int32, bfile, ifile string) (err error) {
 
 // CompactVolume compact a super block to another file.
 func (s *Store) CompactVolume(id int32) (err error) {
+	return s.compactVolume(id, nil)
+}
+
+// compactVolume compact a super block to another file, the read bytes are
+// throttled by the limiter if not nil.
+func (s *Store) compactVolume(id int32, l *rate.Limiter) (err error) {
 	var (
 		v, nv      *volume.Volume
 		bdir, idir string
@@ -542,7 +695,7 @@ func (s *Store) CompactVolume(id int32) (err error) {
 	}
 	log.Infof("start compact volume: (%d) %s to %s", id, v.Block.File, nv.Block.File)
 	// no lock here, Compact is no side-effect
-	if err = v.StartCompact(nv); err != nil {
+	if err = v.StartCompact(nv, l); err != nil {
 		nv.Destroy()
 		v.StopCompact(nil)
 		return
@@ -577,22 +730,228 @@ func (s *Store) CompactVolume(id int32) (err error) {
 	return
 }
 
+// scrubproc scrub the volumes one by one every ScrubInterval, all the
+// volumes share the read rate.
+func (s *Store) scrubproc() {
+	var (
+		err error
+		v   *volume.Volume
+		r   = rate.Inf
+		l   *rate.Limiter
+	)
+	if s.conf.Volume.ScrubRate > 0 {
+		r = rate.Limit(s.conf.Volume.ScrubRate)
+	}
+	l = rate.NewLimiter(r, s.conf.BlockMaxSize)
+	for {
+		time.Sleep(s.conf.Volume.ScrubInterval.Duration)
+		for _, v = range s.Volumes {
+			if v.DiskStatus() == meta.DiskStatusFail {
+				continue
+			}
+			if err = v.Scrub(l); err != nil {
+				log.Errorf("volume: %d scrub error(%v)", v.Id, err)
+			}
+		}
+	}
+}
+
+// checkpointproc checkpoint the needle index of the volumes every
+// CheckpointInterval, the unchanged ones are skipped.
+func (s *Store) checkpointproc() {
+	var (
+		err error
+		v   *volume.Volume
+	)
+	for {
+		time.Sleep(s.conf.Volume.CheckpointInterval.Duration)
+		for _, v = range s.Volumes {
+			if v.DiskStatus() == meta.DiskStatusFail {
+				continue
+			}
+			if err = v.Checkpoint(); err != nil && err != errors.ErrVolumeInCompact {
+				log.Errorf("volume: %d checkpoint error(%v)", v.Id, err)
+			}
+		}
+	}
+}
+
+// expireproc delete the expired needles of the volumes every
+// _expireInterval.
+func (s *Store) expireproc() {
+	var v *volume.Volume
+	for {
+		time.Sleep(_expireInterval)
+		for _, v = range s.Volumes {
+			v.Expire(time.Now().Unix())
+		}
+	}
+}
+
+// compactproc compact the volumes which deleted bytes ratio is over
+// CompactRatio every CompactInterval in the compact windows, the volumes on
+// the same disk are compacted one by one, the most garbage first.
+func (s *Store) compactproc() {
+	var (
+		dir   string
+		v     *volume.Volume
+		vs    garbageVolumes
+		disks map[string]garbageVolumes
+		wg    sync.WaitGroup
+	)
+	for {
+		time.Sleep(s.conf.Volume.CompactInterval.Duration)
+		if !s.compactWindow(time.Now()) {
+			continue
+		}
+		disks = make(map[string]garbageVolumes)
+		for _, v = range s.Volumes {
+			// a volume need repair or on a bad disk can't be compacted
+			if v.Compact || v.Repair || v.DiskStatus() != meta.DiskStatusHealth || v.Garbage() < s.conf.Volume.CompactRatio {
+				continue
+			}
+			if s.compactBackoff(v.Id, time.Now()) {
+				log.Warningf("volume: %d failed to compact, backoff", v.Id)
+				continue
+			}
+			dir = s.mountPoint(v.Block.File)
+			disks[dir] = append(disks[dir], v)
+		}
+		for dir, vs = range disks {
+			sort.Sort(vs)
+			wg.Add(1)
+			go func(dir string, vs garbageVolumes) {
+				s.compactDisk(dir, vs)
+				wg.Done()
+			}(dir, vs)
+		}
+		wg.Wait()
+	}
+}
+
+// compactDisk compact the volumes of a disk one by one, stop if out of the
+// compact windows.
+func (s *Store) compactDisk(dir string, vs garbageVolumes) {
+	var (
+		err error
+		v   *volume.Volume
+		r   = rate.Inf
+		l   *rate.Limiter
+	)
+	if s.conf.Volume.CompactRate > 0 {
+		r = rate.Limit(s.conf.Volume.CompactRate)
+	}
+	l = rate.NewLimiter(r, s.conf.BlockMaxSize)
+	for _, v = range vs {
+		if !s.compactWindow(time.Now()) {
+			log.Infof("disk: %s out of compact windows, stop", dir)
+			return
+		}
+		log.Infof("disk: %s compact volume: %d live: %d deleted: %d", dir, v.Id, atomic.LoadInt64(&v.LiveBytes), atomic.LoadInt64(&v.DeletedBytes))
+		if err = s.compactVolume(v.Id, l); err != nil {
+			log.Errorf("compact volume: %d error(%v)", v.Id, err)
+		}
+		s.compactDone(v.Id, err, time.Now())
+	}
+}
+
+// compactBackoff reports whether the volume failed to compact is waiting
+// for the retry.
+func (s *Store) compactBackoff(id int32, now time.Time) (ok bool) {
+	var f *compactFail
+	s.clock.Lock()
+	if f, ok = s.cfails[id]; ok {
+		ok = now.Before(f.next)
+	}
+	s.clock.Unlock()
+	return
+}
+
+// compactDone record the result of a compaction, the backoff of a volume
+// doubles from CompactInterval by every failure up to _compactMaxBackoff, a
+// success resets it.
+func (s *Store) compactDone(id int32, err error, now time.Time) {
+	var (
+		ok      bool
+		i       int
+		backoff = s.conf.Volume.CompactInterval.Duration
+		f       *compactFail
+	)
+	s.clock.Lock()
+	defer s.clock.Unlock()
+	if err == nil {
+		delete(s.cfails, id)
+		return
+	}
+	if f, ok = s.cfails[id]; !ok {
+		f = new(compactFail)
+		s.cfails[id] = f
+	}
+	for f.times++; i < f.times-1 && backoff < _compactMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > _compactMaxBackoff {
+		backoff = _compactMaxBackoff
+	}
+	f.next = now.Add(backoff)
+	log.Warningf("volume: %d compact failed: %d times, retry after: %s", id, f.times, backoff)
+}
+
+// compactWindow check the time in the compact windows, no window means any
+// time.
+func (s *Store) compactWindow(t time.Time) bool {
+	var i int
+	if len(s.conf.Volume.CompactWindows) == 0 {
+		return true
+	}
+	for i = 0; i < len(s.conf.Volume.CompactWindows); i++ {
+		if s.conf.Volume.CompactWindows[i].In(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// garbageVolumes sort volumes by the deleted bytes ratio desc.
+type garbageVolumes []*volume.Volume
+
+func (p garbageVolumes) Len() int           { return len(p) }
+func (p garbageVolumes) Less(i, j int) bool { return p[i].Garbage() > p[j].Garbage() }
+func (p garbageVolumes) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
 // Close close the store.
 // WARN the global variable store must first set nil and reject any other
 // requests then safty close.
 func (s *Store) Close() {
 	log.Info("store close")
-	var v *volume.Volume
+	var (
+		err error
+		v   *volume.Volume
+		ev  *volume.ECVolume
+	)
 	if s.vf != nil {
 		s.vf.Close()
 	}
 	if s.fvf != nil {
 		s.fvf.Close()
 	}
+	if s.evf != nil {
+		s.evf.Close()
+	}
 	for _, v = range s.Volumes {
 		log.Infof("volume[%d] close", v.Id)
+		// the next start recovers from it
+		if s.conf.Volume.CheckpointInterval.Duration > 0 && v.DiskStatus() != meta.DiskStatusFail {
+			if err = v.Checkpoint(); err != nil {
+				log.Errorf("volume: %d checkpoint error(%v)", v.Id, err)
+			}
+		}
 		v.Close()
 	}
+	for _, ev = range s.ECVolumes {
+		log.Infof("ec volume[%d] close", ev.Id)
+		ev.Close()
+	}
 	if s.zk != nil {
 		s.zk.Close()
 	}
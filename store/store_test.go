@@ -1,12 +1,20 @@
 package main
 
 import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"bfs/store/conf"
 	"bfs/store/needle"
 	"bfs/store/volume"
 	"bfs/store/zk"
 	"bytes"
+	"io"
+	"io/ioutil"
 	"os"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestStore(t *testing.T) {
@@ -20,6 +28,7 @@ func TestStore(t *testing.T) {
 	)
 	os.Remove(testConf.Store.VolumeIndex)
 	os.Remove(testConf.Store.FreeVolumeIndex)
+	os.Remove(testConf.Store.ECVolumeIndex)
 	os.Remove("./test/_free_block_1")
 	os.Remove("./test/_free_block_1.idx")
 	os.Remove("./test/_free_block_2")
@@ -34,6 +43,7 @@ func TestStore(t *testing.T) {
 	os.Remove("./test/block_store_1.idx")
 	defer os.Remove(testConf.Store.VolumeIndex)
 	defer os.Remove(testConf.Store.FreeVolumeIndex)
+	defer os.Remove(testConf.Store.ECVolumeIndex)
 	defer os.Remove("./test/_free_block_1")
 	defer os.Remove("./test/_free_block_1.idx")
 	defer os.Remove("./test/_free_block_2")
@@ -132,3 +142,238 @@ func TestStore(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestStoreDisks(t *testing.T) {
+	var (
+		v, bv  *volume.Volume
+		n      *needle.Needle
+		disks  []*meta.Disk
+		err    error
+		c      = *testConf
+		sc     = *testConf.Store
+		s      = &Store{conf: &c, Volumes: make(map[int32]*volume.Volume), disks: make(map[string]*Disk), mounts: make(map[string]string), dresets: make(map[string]bool)}
+		status = func(d int, vs ...int32) {
+			var vid int32
+			for _, vid = range vs {
+				if s.Volumes[vid].DiskStatus() != d {
+					t.Errorf("volume: %d status: %d, must be %d", vid, s.Volumes[vid].DiskStatus(), d)
+					t.FailNow()
+				}
+			}
+		}
+	)
+	c.Store = &sc
+	sc.DiskMaxErrors = 10
+	sc.DiskMaxDelay = conf.Duration{Duration: time.Second}
+	for _, file := range []string{"./test/disk_1", "./test/disk_1.idx", "./test/disk_2", "./test/disk_2.idx"} {
+		os.Remove(file)
+		defer os.Remove(file)
+	}
+	if v, err = volume.NewVolume(1, "./test/disk_1", "./test/disk_1.idx", &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer v.Close()
+	s.Volumes[1] = v
+	if bv, err = volume.NewVolume(2, "./test/disk_2", "./test/disk_2.idx", &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer bv.Close()
+	s.Volumes[2] = bv
+	n = needle.NewWriter(1, 1, 4)
+	if err = n.ReadFrom(bytes.NewReader([]byte("test"))); err != nil {
+		t.Errorf("n.ReadFrom() error(%v)", err)
+		t.FailNow()
+	}
+	if err = bv.Write(n); err != nil {
+		t.Errorf("Write() error(%v)", err)
+		t.FailNow()
+	}
+	n.Close()
+	if s.checkDisks() {
+		t.Errorf("checkDisks() changed")
+		t.FailNow()
+	}
+	if disks = s.Disks(); len(disks) != 1 || len(disks[0].Volumes) != 2 || disks[0].Status != meta.DiskStatusHealth {
+		t.Errorf("disks: %v not match", disks)
+		t.FailNow()
+	}
+	// a broken volume is offline alone
+	bv.Block.LastErr = syscall.EIO
+	if _, err = bv.Read(1, 1); err != syscall.EIO || bv.IOErrorCount() != 1 {
+		t.Errorf("Read() error(%v), io errors: %d", err, bv.IOErrorCount())
+		t.FailNow()
+	}
+	if !s.checkDisks() {
+		t.Errorf("checkDisks() not changed")
+		t.FailNow()
+	}
+	status(meta.DiskStatusHealth, 1)
+	status(meta.DiskStatusFail, 2)
+	if _, err = bv.Read(1, 1); err != errors.ErrVolumeOffline {
+		t.Errorf("Read() error(%v), must be offline", err)
+		t.FailNow()
+	}
+	// the io errors fail the disk
+	atomic.AddUint64(&bv.IOErrors, 10)
+	if !s.checkDisks() {
+		t.Errorf("checkDisks() not changed")
+		t.FailNow()
+	}
+	status(meta.DiskStatusFail, 1, 2)
+	if disks = s.Disks(); disks[0].Status != meta.DiskStatusFail || disks[0].IOErrors != 10 || len(disks[0].Offline) != 1 {
+		t.Errorf("disk: %v not match", disks[0])
+		t.FailNow()
+	}
+	// the failed disk stays failed without new io errors
+	if s.checkDisks() {
+		t.Errorf("checkDisks() changed")
+		t.FailNow()
+	}
+	status(meta.DiskStatusFail, 1, 2)
+	if disks = s.Disks(); disks[0].Status != meta.DiskStatusFail || disks[0].IOErrors != 0 {
+		t.Errorf("disk: %v not match", disks[0])
+		t.FailNow()
+	}
+	if err = s.ResetDisk("none"); err != errors.ErrParam {
+		t.Errorf("ResetDisk() error(%v)", err)
+		t.FailNow()
+	}
+	// the repaired disk is reset
+	if err = s.ResetDisk(disks[0].Path); err != nil {
+		t.Errorf("ResetDisk() error(%v)", err)
+		t.FailNow()
+	}
+	// a slow disk is read only
+	v.Stats.TotalGetProcessed++
+	v.Stats.TotalGetDelay += uint64(2 * time.Second)
+	bv.Block.LastErr = nil
+	if !s.checkDisks() {
+		t.Errorf("checkDisks() not changed")
+		t.FailNow()
+	}
+	status(meta.DiskStatusRead, 1, 2)
+	if err = v.Write(needle.NewWriter(1, 1, 0)); err != errors.ErrVolumeReadOnly {
+		t.Errorf("Write() error(%v), must be read only", err)
+		t.FailNow()
+	}
+	if _, err = v.Read(1, 1); err != errors.ErrNeedleNotExist {
+		t.Errorf("Read() error(%v)", err)
+		t.FailNow()
+	}
+	if !s.checkDisks() {
+		t.Errorf("checkDisks() not changed")
+		t.FailNow()
+	}
+	status(meta.DiskStatusHealth, 1, 2)
+}
+
+func TestStoreCompress(t *testing.T) {
+	var (
+		err        error
+		size       int64
+		enc        string
+		rd         io.Reader
+		cdata, out []byte
+		rng        *meta.Range
+		m          = &needle.Meta{}
+		n          = &needle.Needle{}
+		data       = bytes.Repeat([]byte("bfs "), 256)
+		cb         = newCodecBuffer(len(data))
+	)
+	if rd, size, err = cb.compress(meta.CodecGzip, m, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Errorf("compress() error(%v)", err)
+		t.FailNow()
+	}
+	if m.Codec != meta.CodecGzip || m.Size != int64(len(data)) || size >= int64(len(data)) {
+		t.Errorf("compress() meta: %v size: %d not match", m, size)
+		t.FailNow()
+	}
+	cdata, _ = ioutil.ReadAll(rd)
+	cb.Close()
+	// the incompressible data is kept raw
+	m = &needle.Meta{}
+	cb = newCodecBuffer(len(data))
+	if _, size, err = cb.compress(meta.CodecGzip, m, bytes.NewReader([]byte("b")), 1); err != nil || m.Codec != "" || size != 1 {
+		t.Errorf("compress() meta: %v size: %d error(%v)", m, size, err)
+		t.FailNow()
+	}
+	// the upload over the max size is refused before read
+	if _, _, err = cb.compress(meta.CodecGzip, m, bytes.NewReader(data), int64(len(data)+1)); err != errors.ErrNeedleTooLarge {
+		t.Errorf("compress() error(%v) must be ErrNeedleTooLarge", err)
+		t.FailNow()
+	}
+	cb.Close()
+	// the pooled buffers compress the same
+	m = &needle.Meta{}
+	cb = newCodecBuffer(len(data))
+	if rd, _, err = cb.compress(meta.CodecGzip, m, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Errorf("compress() error(%v)", err)
+		t.FailNow()
+	}
+	if out, _ = ioutil.ReadAll(rd); !bytes.Equal(out, cdata) {
+		t.Errorf("compress() data not match")
+		t.FailNow()
+	}
+	// the copy of a compressed needle
+	m = &needle.Meta{}
+	if _, err = cb.encoded(meta.CodecGzip, m, bytes.NewReader(cdata), int64(len(cdata))); err != nil || m.Size != int64(len(data)) {
+		t.Errorf("encoded() meta: %v error(%v)", m, err)
+		t.FailNow()
+	}
+	cb.Close()
+	n.Data = cdata
+	n.Meta = m
+	if out, enc, err = decompress(n, nil, "deflate, gzip;q=0.5"); err != nil || enc != meta.CodecGzip || !bytes.Equal(out, cdata) {
+		t.Errorf("decompress() encoding: %s error(%v)", enc, err)
+		t.FailNow()
+	}
+	if out, enc, err = decompress(n, nil, "gzip;q=0"); err != nil || enc != "" || !bytes.Equal(out, data) {
+		t.Errorf("decompress() encoding: %s error(%v)", enc, err)
+		t.FailNow()
+	}
+	rng = meta.ParseRange("bytes=4-6")
+	rng.Fit(n.RawSize())
+	if out, enc, err = decompress(n, rng, "gzip"); err != nil || enc != "" || string(out) != "bfs" {
+		t.Errorf("decompress() range: %s encoding: %s error(%v)", out, enc, err)
+		t.FailNow()
+	}
+}
+
+func TestStoreCompactBackoff(t *testing.T) {
+	var (
+		i   int
+		now = time.Now()
+		s   = &Store{conf: &conf.Config{Volume: &conf.Volume{}}, cfails: make(map[int32]*compactFail)}
+	)
+	s.conf.Volume.CompactInterval.Duration = time.Hour
+	if s.compactBackoff(1, now) {
+		t.Errorf("compactBackoff() must be false")
+		t.FailNow()
+	}
+	// the backoff doubles up to the max
+	s.compactDone(1, errors.ErrVolumeInCompact, now)
+	if !s.compactBackoff(1, now.Add(59*time.Minute)) || s.compactBackoff(1, now.Add(time.Hour)) {
+		t.Errorf("compactBackoff() 1h not match")
+		t.FailNow()
+	}
+	s.compactDone(1, errors.ErrVolumeInCompact, now)
+	if !s.compactBackoff(1, now.Add(time.Hour)) || s.compactBackoff(1, now.Add(2*time.Hour)) {
+		t.Errorf("compactBackoff() 2h not match")
+		t.FailNow()
+	}
+	for i = 0; i < 10; i++ {
+		s.compactDone(1, errors.ErrVolumeInCompact, now)
+	}
+	if s.compactBackoff(1, now.Add(_compactMaxBackoff)) {
+		t.Errorf("compactBackoff() max not match")
+		t.FailNow()
+	}
+	// a success resets it
+	s.compactDone(1, nil, now)
+	if s.compactBackoff(1, now) {
+		t.Errorf("compactBackoff() must be reset")
+		t.FailNow()
+	}
+}
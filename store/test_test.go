@@ -25,6 +25,7 @@ var (
 		Store: &conf.Store{
 			VolumeIndex:     "./test/volume.idx",
 			FreeVolumeIndex: "./test/free_volume.idx",
+			ECVolumeIndex:   "./test/ec_volume.idx",
 		},
 		Volume: &conf.Volume{
 			SyncDelete:      10,
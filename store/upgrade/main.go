@@ -0,0 +1,131 @@
+package main
+
+// upgrade rewrite a v1 super block into a v2 one with a larger padding
+// offline, every needle is copied with the flag kept, the index is rebuilt
+// from the live needles, the v1 block and index are kept as backup with the
+// ".v1" suffix. the store must be stopped or the volume must be offline.
+//
+// usage:
+//  upgrade -c ./store.toml -f /bfs/block_1 -i /bfs/block_1.idx [-p 64]
+
+import (
+	"bfs/store/block"
+	"bfs/store/conf"
+	"bfs/store/index"
+	"bfs/store/needle"
+	"bfs/store/volume"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const (
+	_tmp    = ".v2"
+	_backup = ".v1"
+)
+
+var (
+	config  string
+	file    string
+	ifile   string
+	padding int
+)
+
+func init() {
+	flag.StringVar(&config, "c", "./store.toml", "store config file")
+	flag.StringVar(&file, "f", "", "v1 block file, e.g. /bfs/block_1")
+	flag.StringVar(&ifile, "i", "", "index file, e.g. /bfs/block_1.idx")
+	flag.IntVar(&padding, "p", 0, "v2 block padding, power of 2 in [8, 4096], default the config one")
+}
+
+func main() {
+	var err error
+	flag.Parse()
+	if file == "" || ifile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err = upgrade(); err != nil {
+		fmt.Fprintf(os.Stderr, "upgrade: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func upgrade() (err error) {
+	var (
+		needles, lives int
+		offset         uint32
+		c              *conf.Config
+		dc             conf.Config
+		bc             conf.Block
+		src, dst       *block.SuperBlock
+		ix             *index.Indexer
+		r              *os.File
+	)
+	if c, err = conf.NewConfig(config); err != nil {
+		return
+	}
+	if src, err = block.NewSuperBlock(file, c); err != nil {
+		return
+	}
+	defer src.Close()
+	if src.Ver != block.Ver1 {
+		return fmt.Errorf("block: %s ver: %d not v1", file, src.Ver)
+	}
+	// the new block is v2 whatever the config
+	dc, bc = *c, *c.Block
+	dc.Block = &bc
+	bc.Ver = int(block.Ver2)
+	if padding > 0 {
+		bc.Padding = padding
+	}
+	os.Remove(file + _tmp)
+	os.Remove(ifile + _tmp)
+	if dst, err = block.NewSuperBlock(file+_tmp, &dc); err != nil {
+		return
+	}
+	if ix, err = index.NewIndexer(ifile+_tmp, &dc); err != nil {
+		dst.Close()
+		os.Remove(file + _tmp)
+		return
+	}
+	if r, err = os.Open(file); err == nil {
+		err = src.Scan(r, 0, func(n *needle.Needle, so, eo uint32) (err1 error) {
+			offset = dst.Offset
+			if err1 = dst.Write(n); err1 != nil {
+				return
+			}
+			needles++
+			if n.Flag == needle.FlagOK {
+				err1 = ix.Write(n.Key, offset, n.TotalSize)
+				lives++
+			}
+			return
+		})
+		r.Close()
+	}
+	ix.Close()
+	dst.Close()
+	if err != nil {
+		os.Remove(file + _tmp)
+		os.Remove(ifile + _tmp)
+		return
+	}
+	// keep the v1 files as backup
+	if err = os.Rename(file, file+_backup); err != nil {
+		return
+	}
+	if err = os.Rename(ifile, ifile+_backup); err != nil && !os.IsNotExist(err) {
+		return
+	}
+	if err = os.Rename(file+_tmp, file); err != nil {
+		return
+	}
+	if err = os.Rename(ifile+_tmp, ifile); err != nil {
+		return
+	}
+	// the checkpoint is of the v1 files
+	os.Remove(volume.CheckpointFile(ifile))
+	fmt.Printf("block: %s upgraded to v2, padding: %d, needles: %d, live: %d, backup: %s\n", file, dst.Padding, needles, lives, file+_backup)
+	return
+}
@@ -0,0 +1,192 @@
+package volume
+
+import (
+	"bfs/libs/encoding/binary"
+	"bfs/libs/errors"
+	"bfs/store/needle"
+	"bufio"
+	"bytes"
+	log "github.com/golang/glog"
+	"io"
+	"os"
+	"time"
+)
+
+// Archive is a portable stream of the live needles of a volume, used to move
+// a volume between clusters or into the cold backup, the needles are the raw
+// needles in block (any version), so the checksum is verified when import.
+//
+// archive format:
+//  ---------------
+// |    header     |         ----------------
+// |    needle     |        |  magic (4bytes)|
+// |    needle     |  ----> |  ver (byte)    |
+// |    ......     |        |  padding (3)   |
+// |    trailer    |        |  vid (int32)   |
+//  ---------------          ----------------
+//
+// the trailer is magic(4bytes), padding(4bytes) and the needle count
+// (int64), a stream without it is truncated.
+
+const (
+	// size
+	_archiveMagicSize          = 4
+	_archiveVerSize            = 1
+	_archivePaddingSize        = 3
+	_archiveVidSize            = 4
+	_archiveTrailerPaddingSize = 4
+	_archiveCountSize          = 8
+	_archiveHeaderSize         = _archiveMagicSize + _archiveVerSize + _archivePaddingSize + _archiveVidSize
+	_archiveTrailerSize        = _archiveMagicSize + _archiveTrailerPaddingSize + _archiveCountSize
+	// offset
+	_archiveVerOffset   = _archiveMagicSize
+	_archiveVidOffset   = _archiveVerOffset + _archiveVerSize + _archivePaddingSize
+	_archiveCountOffset = _archiveTrailerSize - _archiveCountSize
+	// ver
+	ArchiveVer1 = byte(1)
+)
+
+var (
+	_archiveMagic    = []byte{0xab, 0xcd, 0xef, 0x01}
+	_archiveEndMagic = []byte{0xab, 0xcd, 0xef, 0x02}
+)
+
+// Export write the live needles with key in [start, end) into w as an
+// archive, end zero means no limit, the deleted or expired needles are
+// skipped, returns the exported needles.
+func (v *Volume) Export(w io.Writer, start, end int64) (n int, err error) {
+	var (
+		offset uint32
+		file   string
+		buf    []byte
+		r      *os.File
+		keys   map[int64]int64
+		now    = time.Now().Unix()
+	)
+	v.lock.RLock()
+	if v.closed {
+		err = errors.ErrVolumeClosed
+	} else if v.Compact {
+		err = errors.ErrVolumeInCompact
+	} else {
+		file = v.Block.File
+		keys = make(map[int64]int64)
+		v.needles.Range(func(key int64, nc int64) bool {
+			if offset, _ = needle.Cache(nc); offset != needle.CacheDelOffset && key >= start && (end == 0 || key < end) {
+				keys[key] = nc
+			}
+			return true
+		})
+	}
+	v.lock.RUnlock()
+	if err != nil {
+		return
+	}
+	if r, err = os.Open(file); err != nil {
+		log.Errorf("os.Open(\"%s\") error(%v)", file, err)
+		return
+	}
+	defer r.Close()
+	buf = make([]byte, _archiveHeaderSize)
+	copy(buf, _archiveMagic)
+	buf[_archiveVerOffset] = ArchiveVer1
+	binary.BigEndian.PutInt32(buf[_archiveVidOffset:], v.Id)
+	if _, err = w.Write(buf); err != nil {
+		return
+	}
+	log.Infof("volume: %d export start, needles: %d", v.Id, len(keys))
+	// the needles written after the snapshot are not in keys
+	if err = v.Block.Scan(r, 0, func(nd *needle.Needle, so, eo uint32) (err1 error) {
+		if nd.Flag != needle.FlagOK || keys[nd.Key] != needle.NewCache(so, nd.TotalSize) || nd.Expired(now) {
+			return
+		}
+		if _, err1 = w.Write(nd.Buffer()); err1 == nil {
+			n++
+		}
+		return
+	}); err != nil {
+		return
+	}
+	buf = make([]byte, _archiveTrailerSize)
+	copy(buf, _archiveEndMagic)
+	binary.BigEndian.PutInt64(buf[_archiveCountOffset:], int64(n))
+	_, err = w.Write(buf)
+	log.Infof("volume: %d export stop, needles: %d error(%v)", v.Id, n, err)
+	return
+}
+
+// ReadArchive read an archive, every needle is verified then passed to fn,
+// the needle buffer is valid only in fn, size is the read buffer size which
+// must hold the max needle.
+func ReadArchive(r io.Reader, size int, fn func(*needle.Needle) error) (vid int32, n int64, err error) {
+	var (
+		data []byte
+		nd   = new(needle.Needle)
+		rd   = bufio.NewReaderSize(r, size)
+	)
+	if data, err = rd.Peek(_archiveHeaderSize); err != nil {
+		return
+	}
+	if !bytes.Equal(data[:_archiveMagicSize], _archiveMagic) {
+		return 0, 0, errors.ErrArchiveMagic
+	}
+	if data[_archiveVerOffset] != ArchiveVer1 {
+		return 0, 0, errors.ErrArchiveVer
+	}
+	vid = binary.BigEndian.Int32(data[_archiveVidOffset:])
+	if _, err = rd.Discard(_archiveHeaderSize); err != nil {
+		return
+	}
+	for {
+		if data, err = rd.Peek(_archiveMagicSize); err != nil {
+			break
+		}
+		if bytes.Equal(data, _archiveEndMagic) {
+			if data, err = rd.Peek(_archiveTrailerSize); err == nil && binary.BigEndian.Int64(data[_archiveCountOffset:]) != n {
+				err = errors.ErrArchiveCount
+			}
+			break
+		}
+		if err = nd.ParseFrom(rd); err != nil {
+			break
+		}
+		n++
+		if err = fn(nd); err != nil {
+			break
+		}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// no trailer, the stream is truncated
+		err = errors.ErrArchiveCount
+	}
+	return
+}
+
+// Import write the needles of an archive into the volume, the deleted or
+// expired needles are skipped, returns the imported needles. the archive of
+// another volume is refused before any write.
+func (v *Volume) Import(r io.Reader) (n int, err error) {
+	var (
+		data []byte
+		now  = time.Now().Unix()
+		rd   = bufio.NewReaderSize(r, v.conf.Block.BufferSize)
+	)
+	// the header is checked again by ReadArchive, which reuses rd
+	if data, err = rd.Peek(_archiveHeaderSize); err == nil && bytes.Equal(data[:_archiveMagicSize], _archiveMagic) &&
+		binary.BigEndian.Int32(data[_archiveVidOffset:]) != v.Id {
+		log.Errorf("volume: %d import archive of volume: %d", v.Id, binary.BigEndian.Int32(data[_archiveVidOffset:]))
+		return 0, errors.ErrArchiveVid
+	}
+	log.Infof("volume: %d import start", v.Id)
+	_, _, err = ReadArchive(rd, v.conf.Block.BufferSize, func(nd *needle.Needle) (err1 error) {
+		if nd.Flag != needle.FlagOK || nd.Expired(now) {
+			return
+		}
+		if err1 = v.Write(nd); err1 == nil {
+			n++
+		}
+		return
+	})
+	log.Infof("volume: %d import stop, needles: %d error(%v)", v.Id, n, err)
+	return
+}
@@ -0,0 +1,121 @@
+package volume
+
+import (
+	"bfs/libs/errors"
+	"bfs/store/needle"
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestVolumeArchive(t *testing.T) {
+	var (
+		v, nv  *Volume
+		ov     *Volume
+		n      *needle.Needle
+		err    error
+		cnt    int
+		vid    int32
+		total  int64
+		c      = *_c
+		now    = time.Now().Unix()
+		data   = []byte("test")
+		buf    = &bytes.Buffer{}
+		bfile  = "../test/test_archive"
+		ifile  = "../test/test_archive.idx"
+		nbfile = "../test/test_archive_import"
+		nifile = "../test/test_archive_import.idx"
+		obfile = "../test/test_archive_other"
+		oifile = "../test/test_archive_other.idx"
+		write  = func(key, expire int64) {
+			n = needle.NewExpireWriter(key, int32(key), 4, expire)
+			if err = n.ReadFrom(bytes.NewReader(data)); err != nil {
+				t.Errorf("n.ReadFrom() error(%v)", err)
+				t.FailNow()
+			}
+			if err = v.Write(n); err != nil {
+				t.Errorf("Write() error(%v)", err)
+				t.FailNow()
+			}
+			n.Close()
+		}
+	)
+	for _, file := range []string{bfile, ifile, nbfile, nifile, obfile, oifile} {
+		os.Remove(file)
+		defer os.Remove(file)
+	}
+	c.BlockMaxSize = needle.MaxSize(c.NeedleMaxSize)
+	if v, err = NewVolume(1, bfile, ifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer v.Close()
+	write(1, 0)
+	write(2, 0)
+	write(3, now-1)
+	write(4, now+3600)
+	write(5, 0)
+	write(2, 0)
+	if err = v.Delete(1); err != nil {
+		t.Errorf("Delete() error(%v)", err)
+		t.FailNow()
+	}
+	// key 1 deleted, key 3 expired, key 5 out of range
+	if cnt, err = v.Export(buf, 1, 5); err != nil || cnt != 2 {
+		t.Errorf("Export() %d error(%v)", cnt, err)
+		t.FailNow()
+	}
+	if vid, total, err = ReadArchive(bytes.NewReader(buf.Bytes()), c.Block.BufferSize, func(*needle.Needle) error {
+		return nil
+	}); err != nil || vid != 1 || total != 2 {
+		t.Errorf("ReadArchive() vid: %d, needles: %d error(%v)", vid, total, err)
+		t.FailNow()
+	}
+	// truncated stream
+	if _, _, err = ReadArchive(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), c.Block.BufferSize, func(*needle.Needle) error {
+		return nil
+	}); err != errors.ErrArchiveCount {
+		t.Errorf("err: %v must be ErrArchiveCount", err)
+		t.FailNow()
+	}
+	// the archive of another volume
+	if ov, err = NewVolume(2, obfile, oifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	cnt, err = ov.Import(bytes.NewReader(buf.Bytes()))
+	// the block preallocates the max size
+	ov.Destroy()
+	if err != errors.ErrArchiveVid || cnt != 0 {
+		t.Errorf("Import() %d error(%v) must be ErrArchiveVid", cnt, err)
+		t.FailNow()
+	}
+	if nv, err = NewVolume(1, nbfile, nifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer nv.Close()
+	if cnt, err = nv.Import(bytes.NewReader(buf.Bytes())); err != nil || cnt != 2 {
+		t.Errorf("Import() %d error(%v)", cnt, err)
+		t.FailNow()
+	}
+	for _, key := range []int64{2, 4} {
+		if n, err = nv.Read(key, int32(key)); err != nil || !bytes.Equal(n.Data, data) {
+			t.Errorf("Read(%d) error(%v)", key, err)
+			t.FailNow()
+		}
+		n.Close()
+	}
+	if n, err = nv.Read(4, 4); err != nil || n.Expire != now+3600 {
+		t.Errorf("Read(4) expire not kept error(%v)", err)
+		t.FailNow()
+	}
+	n.Close()
+	for _, key := range []int64{1, 3, 5} {
+		if _, err = nv.Read(key, int32(key)); err != errors.ErrNeedleNotExist {
+			t.Errorf("Read(%d) error(%v) must be ErrNeedleNotExist", key, err)
+			t.FailNow()
+		}
+	}
+}
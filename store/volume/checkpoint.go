@@ -0,0 +1,371 @@
+package volume
+
+import (
+	"bfs/libs/encoding/binary"
+	"bfs/libs/errors"
+	"bfs/store/block"
+	"bufio"
+	"bytes"
+	log "github.com/golang/glog"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// Checkpoint is a dump of the needle table of a volume, with the index and
+// block offsets it covers, so the volume recovers from it and only replays
+// the index and block after them instead of the whole files. it's saved as
+// the index file name plus ".ckp", any invalid checkpoint is ignored.
+//
+// checkpoint format:
+//  ---------------
+// |    header     |         ----------------------
+// |    needles    |        |  magic (4bytes)      |
+// |    expires    |        |  ver (byte)          |
+// |   checksum    |        |  padding (3)         |
+//  ---------------   ----> |  vid (int32)         |
+//                          |  block (uint32)      |
+//                          |  index (int64)       |
+//                          |  last index (16bytes)|
+//                          |  live bytes (int64)  |
+//                          |  deleted bytes(int64)|
+//                           ----------------------
+//
+// field       | explanation
+// --------------------------------------------------
+// block       | the needle offset of the block covered
+// index       | the size of the index file covered
+// last index  | the last index entry before the index size, verify the index
+//             | file isn't replaced
+// needles     | the count (int64) and the key (int64) and needle cache (int64)
+// expires     | the count (int64) and the key (int64) and expire (int64)
+// checksum    | the crc32 (uint32) of all the bytes before
+
+const (
+	// size
+	_ckpMagicSize    = 4
+	_ckpVerSize      = 1
+	_ckpPaddingSize  = 3
+	_ckpVidSize      = 4
+	_ckpBlockSize    = 4
+	_ckpIndexSize    = 8
+	_ckpLastSize     = 16
+	_ckpKeySize      = 8
+	_ckpBytesSize    = 8
+	_ckpCountSize    = 8
+	_ckpEntrySize    = 16
+	_ckpChecksumSize = 4
+	_ckpHeaderSize   = _ckpMagicSize + _ckpVerSize + _ckpPaddingSize + _ckpVidSize + _ckpBlockSize +
+		_ckpIndexSize + _ckpLastSize + 2*_ckpBytesSize
+	// offset
+	_ckpVerOffset     = _ckpMagicSize
+	_ckpVidOffset     = _ckpVerOffset + _ckpVerSize + _ckpPaddingSize
+	_ckpBlockOffset   = _ckpVidOffset + _ckpVidSize
+	_ckpIndexOffset   = _ckpBlockOffset + _ckpBlockSize
+	_ckpLastOffset    = _ckpIndexOffset + _ckpIndexSize
+	_ckpLiveOffset    = _ckpLastOffset + _ckpLastSize
+	_ckpDeletedOffset = _ckpLiveOffset + _ckpBytesSize
+	// ver
+	CheckpointVer1 = byte(1)
+	// file
+	_ckpExt    = ".ckp"
+	_ckpTmpExt = ".tmp"
+	// buffer
+	_ckpBufferSize = 1024 * 1024
+)
+
+var (
+	_ckpMagic = []byte{0xab, 0xcd, 0xef, 0x05}
+)
+
+// checkpoint the needle table and the offsets it covers.
+type checkpoint struct {
+	block   uint32
+	index   int64
+	last    []byte
+	live    int64
+	deleted int64
+	keys    []int64
+	ncs     []int64
+	expires map[int64]int64
+}
+
+// lastEnd get the needle offset after the last index entry, zero if the
+// index is empty.
+func (c *checkpoint) lastEnd(b *block.SuperBlock) (offset uint32) {
+	var size int32
+	if c.index < _ckpLastSize {
+		return
+	}
+	// key (int64), offset (uint32), size (int32)
+	offset = binary.BigEndian.Uint32(c.last[_ckpKeySize:])
+	size = binary.BigEndian.Int32(c.last[_ckpKeySize+_ckpBlockSize:])
+	offset += b.NeedleOffset(int64(size))
+	return
+}
+
+// CheckpointFile get the checkpoint file of the index file.
+func CheckpointFile(ifile string) string {
+	return ifile + _ckpExt
+}
+
+// Checkpoint save the needle table into the checkpoint file, nothing is done
+// if no needle written or deleted since the last one. the table is copied
+// under the lock, the file is written after, so writes are only blocked by
+// the copy.
+func (v *Volume) Checkpoint() (err error) {
+	var (
+		key, expire int64
+		dels        int
+		epoch       int64
+		ifile       string
+		c           = &checkpoint{expires: make(map[int64]int64)}
+	)
+	v.clock.Lock()
+	defer v.clock.Unlock()
+	v.wlock.Lock()
+	v.lock.RLock()
+	if v.closed {
+		err = errors.ErrVolumeClosed
+	} else if v.Compact {
+		// the table is replaced after the compaction
+		err = errors.ErrVolumeInCompact
+	} else if epoch, dels = v.epoch, len(v.dels); v.Block.Offset != v.ckpBlock || epoch != v.ckpEpoch || dels != v.ckpDels {
+		ifile = v.Indexer.File
+		c.block, c.live, c.deleted = v.Block.Offset, v.LiveBytes, v.DeletedBytes
+		// the index is behind the block, the tail is replayed from the block
+		if c.index, err = v.Indexer.Size(); err == nil {
+			c.keys, c.ncs = make([]int64, 0, v.needles.Len()), make([]int64, 0, v.needles.Len())
+			v.needles.Range(func(key int64, nc int64) bool {
+				c.keys = append(c.keys, key)
+				c.ncs = append(c.ncs, nc)
+				return true
+			})
+			for key, expire = range v.expires {
+				c.expires[key] = expire
+			}
+		}
+	}
+	v.lock.RUnlock()
+	v.wlock.Unlock()
+	if err != nil || ifile == "" {
+		return
+	}
+	if c.last, err = lastIndex(ifile, c.index); err != nil {
+		return
+	}
+	if err = v.writeCheckpoint(CheckpointFile(ifile), c); err != nil {
+		return
+	}
+	// a compaction or reopen after the copy makes a new epoch, so the next
+	// one isn't skipped
+	v.lock.Lock()
+	v.ckpBlock, v.ckpEpoch, v.ckpDels = c.block, epoch, dels
+	v.CheckpointTime = time.Now().UnixNano()
+	v.lock.Unlock()
+	log.Infof("volume: %d checkpoint block: %d index: %d needles: %d", v.Id, c.block, c.index, len(c.keys))
+	return
+}
+
+// lastIndex read the last index entry before the size, zero if empty.
+func lastIndex(ifile string, size int64) (last []byte, err error) {
+	var f *os.File
+	last = make([]byte, _ckpLastSize)
+	if size < _ckpLastSize {
+		return
+	}
+	if f, err = os.Open(ifile); err != nil {
+		log.Errorf("os.Open(\"%s\") error(%v)", ifile, err)
+		return
+	}
+	if _, err = f.ReadAt(last, size-_ckpLastSize); err != nil {
+		log.Errorf("index: %s ReadAt() error(%v)", ifile, err)
+	}
+	f.Close()
+	return
+}
+
+// writeCheckpoint write the checkpoint into a temp file, then rename it.
+func (v *Volume) writeCheckpoint(file string, c *checkpoint) (err error) {
+	var (
+		i           int
+		key, expire int64
+		f           *os.File
+		tmp         = file + _ckpTmpExt
+		buf         = make([]byte, _ckpHeaderSize)
+		w           *bufio.Writer
+		h           = crc32.NewIEEE()
+	)
+	if f, err = os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664); err != nil {
+		log.Errorf("os.OpenFile(\"%s\") error(%v)", tmp, err)
+		return
+	}
+	w = bufio.NewWriterSize(io.MultiWriter(f, h), _ckpBufferSize)
+	copy(buf, _ckpMagic)
+	buf[_ckpVerOffset] = CheckpointVer1
+	binary.BigEndian.PutInt32(buf[_ckpVidOffset:], v.Id)
+	binary.BigEndian.PutUint32(buf[_ckpBlockOffset:], c.block)
+	binary.BigEndian.PutInt64(buf[_ckpIndexOffset:], c.index)
+	copy(buf[_ckpLastOffset:], c.last)
+	binary.BigEndian.PutInt64(buf[_ckpLiveOffset:], c.live)
+	binary.BigEndian.PutInt64(buf[_ckpDeletedOffset:], c.deleted)
+	if _, err = w.Write(buf); err != nil {
+		goto failed
+	}
+	buf = make([]byte, _ckpEntrySize)
+	binary.BigEndian.PutInt64(buf, int64(len(c.keys)))
+	if _, err = w.Write(buf[:_ckpCountSize]); err != nil {
+		goto failed
+	}
+	for i = 0; i < len(c.keys); i++ {
+		binary.BigEndian.PutInt64(buf, c.keys[i])
+		binary.BigEndian.PutInt64(buf[_ckpCountSize:], c.ncs[i])
+		if _, err = w.Write(buf); err != nil {
+			goto failed
+		}
+	}
+	binary.BigEndian.PutInt64(buf, int64(len(c.expires)))
+	if _, err = w.Write(buf[:_ckpCountSize]); err != nil {
+		goto failed
+	}
+	for key, expire = range c.expires {
+		binary.BigEndian.PutInt64(buf, key)
+		binary.BigEndian.PutInt64(buf[_ckpCountSize:], expire)
+		if _, err = w.Write(buf); err != nil {
+			goto failed
+		}
+	}
+	if err = w.Flush(); err != nil {
+		goto failed
+	}
+	// the checksum itself is not hashed
+	binary.BigEndian.PutUint32(buf, h.Sum32())
+	if _, err = f.Write(buf[:_ckpChecksumSize]); err != nil {
+		goto failed
+	}
+	if err = f.Sync(); err != nil {
+		goto failed
+	}
+	if err = f.Close(); err != nil {
+		log.Errorf("checkpoint: %s Close() error(%v)", tmp, err)
+		os.Remove(tmp)
+		return
+	}
+	if err = os.Rename(tmp, file); err != nil {
+		log.Errorf("os.Rename(\"%s\", \"%s\") error(%v)", tmp, file, err)
+		os.Remove(tmp)
+	}
+	return
+failed:
+	log.Errorf("checkpoint: %s write error(%v)", tmp, err)
+	f.Close()
+	os.Remove(tmp)
+	return
+}
+
+// loadCheckpoint load the checkpoint of the volume, it must match the block
+// and index files.
+func (v *Volume) loadCheckpoint() (c *checkpoint, err error) {
+	var (
+		i, n    int64
+		key, nc int64
+		f       *os.File
+		fi      os.FileInfo
+		last    []byte
+		file    = CheckpointFile(v.Indexer.File)
+		buf     = make([]byte, _ckpHeaderSize)
+		h       = crc32.NewIEEE()
+		rd      *bufio.Reader
+		tr      io.Reader
+	)
+	if f, err = os.Open(file); err != nil {
+		return
+	}
+	defer f.Close()
+	if fi, err = f.Stat(); err != nil {
+		return
+	}
+	rd = bufio.NewReaderSize(f, _ckpBufferSize)
+	tr = io.TeeReader(rd, h)
+	if _, err = io.ReadFull(tr, buf); err != nil {
+		return
+	}
+	if !bytes.Equal(buf[:_ckpMagicSize], _ckpMagic) {
+		return nil, errors.ErrCheckpointMagic
+	}
+	if buf[_ckpVerOffset] != CheckpointVer1 {
+		return nil, errors.ErrCheckpointVer
+	}
+	c = &checkpoint{
+		block:   binary.BigEndian.Uint32(buf[_ckpBlockOffset:]),
+		index:   binary.BigEndian.Int64(buf[_ckpIndexOffset:]),
+		last:    append([]byte{}, buf[_ckpLastOffset:_ckpLastOffset+_ckpLastSize]...),
+		live:    binary.BigEndian.Int64(buf[_ckpLiveOffset:]),
+		deleted: binary.BigEndian.Int64(buf[_ckpDeletedOffset:]),
+		expires: make(map[int64]int64),
+	}
+	if binary.BigEndian.Int32(buf[_ckpVidOffset:]) != v.Id || v.Block.BlockOffset(c.block) > v.Block.Size {
+		return nil, errors.ErrCheckpointStale
+	}
+	if last, err = lastIndex(v.Indexer.File, c.index); err != nil {
+		return nil, errors.ErrCheckpointStale
+	}
+	if !bytes.Equal(last, c.last) {
+		return nil, errors.ErrCheckpointStale
+	}
+	if n, err = readCount(tr, buf, fi.Size()); err != nil {
+		return
+	}
+	c.keys, c.ncs = make([]int64, 0, n), make([]int64, 0, n)
+	for i = 0; i < n; i++ {
+		if key, nc, err = readEntry(tr, buf); err != nil {
+			return
+		}
+		c.keys = append(c.keys, key)
+		c.ncs = append(c.ncs, nc)
+	}
+	if n, err = readCount(tr, buf, fi.Size()); err != nil {
+		return
+	}
+	for i = 0; i < n; i++ {
+		if key, nc, err = readEntry(tr, buf); err != nil {
+			return
+		}
+		c.expires[key] = nc
+	}
+	err = readChecksum(rd, buf, h)
+	return
+}
+
+// readCount read the count of the entries, it must fit in the file.
+func readCount(r io.Reader, buf []byte, size int64) (n int64, err error) {
+	if _, err = io.ReadFull(r, buf[:_ckpCountSize]); err != nil {
+		err = errors.ErrCheckpointChecksum
+		return
+	}
+	if n = binary.BigEndian.Int64(buf); n < 0 || n > size/_ckpEntrySize {
+		err = errors.ErrCheckpointChecksum
+	}
+	return
+}
+
+// readEntry read a key and value entry.
+func readEntry(r io.Reader, buf []byte) (key, value int64, err error) {
+	if _, err = io.ReadFull(r, buf[:_ckpEntrySize]); err != nil {
+		// the file is truncated
+		err = errors.ErrCheckpointChecksum
+		return
+	}
+	key, value = binary.BigEndian.Int64(buf), binary.BigEndian.Int64(buf[_ckpCountSize:])
+	return
+}
+
+// readChecksum read and verify the checksum.
+func readChecksum(r io.Reader, buf []byte, h hash.Hash32) (err error) {
+	if _, err = io.ReadFull(r, buf[:_ckpChecksumSize]); err != nil || binary.BigEndian.Uint32(buf) != h.Sum32() {
+		err = errors.ErrCheckpointChecksum
+	}
+	return
+}
@@ -0,0 +1,145 @@
+package volume
+
+import (
+	"bfs/store/needle"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestVolumeCheckpoint(t *testing.T) {
+	var (
+		v, cv  *Volume
+		n      *needle.Needle
+		err    error
+		ctime  int64
+		live   int64
+		isize  int64
+		fdata  []byte
+		c      = *_c
+		data   = []byte("test")
+		bfile  = "../test/test_checkpoint"
+		ifile  = "../test/test_checkpoint.idx"
+		cbfile = "../test/test_checkpoint_crash"
+		cifile = "../test/test_checkpoint_crash.idx"
+		write  = func(key int64) {
+			n = needle.NewWriter(key, int32(key), 4)
+			if err = n.ReadFrom(bytes.NewReader(data)); err != nil {
+				t.Errorf("n.ReadFrom() error(%v)", err)
+				t.FailNow()
+			}
+			if err = v.Write(n); err != nil {
+				t.Errorf("Write() error(%v)", err)
+				t.FailNow()
+			}
+			n.Close()
+		}
+		check = func(v *Volume, keys ...int64) {
+			var key int64
+			for _, key = range keys {
+				if n, err = v.Read(key, int32(key)); err != nil || !bytes.Equal(n.Data, data) {
+					t.Errorf("Read(%d) error(%v)", key, err)
+					t.FailNow()
+				}
+				n.Close()
+			}
+			if v.LiveBytes != live {
+				t.Errorf("LiveBytes: %d, must be %d", v.LiveBytes, live)
+				t.FailNow()
+			}
+		}
+		copyFile = func(src, dst string) {
+			if fdata, err = ioutil.ReadFile(src); err != nil {
+				t.Errorf("ioutil.ReadFile(\"%s\") error(%v)", src, err)
+				t.FailNow()
+			}
+			if err = ioutil.WriteFile(dst, fdata, 0664); err != nil {
+				t.Errorf("ioutil.WriteFile(\"%s\") error(%v)", dst, err)
+				t.FailNow()
+			}
+		}
+	)
+	for _, file := range []string{bfile, ifile, CheckpointFile(ifile), cbfile, cifile, CheckpointFile(cifile)} {
+		os.Remove(file)
+		defer os.Remove(file)
+	}
+	c.BlockMaxSize = needle.MaxSize(c.NeedleMaxSize)
+	if v, err = NewVolume(1, bfile, ifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer v.Close()
+	write(1)
+	write(2)
+	write(3)
+	if err = v.Checkpoint(); err != nil {
+		t.Errorf("Checkpoint() error(%v)", err)
+		t.FailNow()
+	}
+	if ctime = v.CheckpointTime; ctime == 0 {
+		t.Errorf("CheckpointTime not set")
+		t.FailNow()
+	}
+	// unchanged, skipped
+	if err = v.Checkpoint(); err != nil || v.CheckpointTime != ctime {
+		t.Errorf("Checkpoint() error(%v) not skipped", err)
+		t.FailNow()
+	}
+	write(4)
+	live = v.LiveBytes
+	// crashed, the index entries in the ring are lost
+	copyFile(bfile, cbfile)
+	copyFile(ifile, cifile)
+	copyFile(CheckpointFile(ifile), CheckpointFile(cifile))
+	// reopen, the tail after the checkpoint replayed
+	v.Close()
+	if err = v.Open(); err != nil {
+		t.Errorf("Open() error(%v)", err)
+		t.FailNow()
+	}
+	check(v, 1, 2, 3, 4)
+	// the lost index entries of the checkpoint are written back
+	if cv, err = NewVolume(1, cbfile, cifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	check(cv, 1, 2, 3, 4)
+	cv.Close()
+	if isize, err = cv.Indexer.Size(); err != nil || isize != 4*16 {
+		t.Errorf("index size: %d error(%v), must be %d", isize, err, 4*16)
+		t.FailNow()
+	}
+	os.Remove(CheckpointFile(cifile))
+	if cv, err = NewVolume(1, cbfile, cifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	check(cv, 1, 2, 3, 4)
+	cv.Close()
+	// corrupted, recovery from the index
+	if fdata, err = ioutil.ReadFile(CheckpointFile(ifile)); err != nil {
+		t.Errorf("ioutil.ReadFile() error(%v)", err)
+		t.FailNow()
+	}
+	fdata[len(fdata)-1]++
+	if err = ioutil.WriteFile(CheckpointFile(ifile), fdata, 0664); err != nil {
+		t.Errorf("ioutil.WriteFile() error(%v)", err)
+		t.FailNow()
+	}
+	if _, err = v.loadCheckpoint(); err == nil {
+		t.Errorf("loadCheckpoint() must be failed")
+		t.FailNow()
+	}
+	v.Close()
+	if err = v.Open(); err != nil {
+		t.Errorf("Open() error(%v)", err)
+		t.FailNow()
+	}
+	check(v, 1, 2, 3, 4)
+	v.Destroy()
+	if _, err = os.Stat(CheckpointFile(ifile)); !os.IsNotExist(err) {
+		t.Errorf("checkpoint not destroyed, error(%v)", err)
+		t.FailNow()
+	}
+}
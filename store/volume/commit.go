@@ -0,0 +1,125 @@
+package volume
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"bfs/store/block"
+	log "github.com/golang/glog"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// the pending group commits
+	_commitBuffer = 1024
+)
+
+// commit a group commit of a writer, the block is the one written, the
+// error of the fdatasync is sent to ch.
+type commit struct {
+	b  *block.SuperBlock
+	ch chan error
+}
+
+// Commit wait the needles written before are durable by the mode, the
+// default durability of the config if empty. it must be called after the
+// write returned, a needle moved by the compaction is synced before the block
+// swapped, so the block at the commit time always has it.
+func (v *Volume) Commit(mode string) (err error) {
+	var (
+		b *block.SuperBlock
+		c *commit
+	)
+	if mode == "" {
+		mode = v.conf.Volume.Durability
+	}
+	if mode != meta.DurabilitySync && mode != meta.DurabilityGroup {
+		return
+	}
+	v.lock.RLock()
+	if v.closed {
+		err = errors.ErrVolumeClosed
+	} else if b = v.Block; mode == meta.DurabilityGroup {
+		// the commit job takes no lock, it's sent before the close job
+		c = &commit{b: b, ch: make(chan error, 1)}
+		v.commits <- c
+	}
+	v.lock.RUnlock()
+	if err != nil {
+		return
+	}
+	if c != nil {
+		err = <-c.ch
+	} else {
+		err = v.sync(b)
+	}
+	v.ioError(err)
+	return
+}
+
+// sync fdatasync the block.
+func (v *Volume) sync(b *block.SuperBlock) (err error) {
+	var now = time.Now().UnixNano()
+	if err = b.Sync(); err == nil {
+		atomic.AddUint64(&v.Stats.TotalFlushProcessed, 1)
+		atomic.AddUint64(&v.Stats.TotalFlushDelay, uint64(time.Now().UnixNano()-now))
+	}
+	return
+}
+
+// commitproc batch the group commits, the writers arrived in the GroupCommit
+// window after the first one share one fdatasync.
+func (v *Volume) commitproc() {
+	var (
+		c     *commit
+		cs    []*commit
+		timer <-chan time.Time
+	)
+	log.Infof("volume: %d commit job start", v.Id)
+	for {
+		if c = <-v.commits; c == nil {
+			break
+		}
+		cs = append(cs[:0], c)
+		timer = time.After(v.conf.Volume.GroupCommit.Duration)
+	window:
+		for c != nil {
+			select {
+			case c = <-v.commits:
+				if c != nil {
+					cs = append(cs, c)
+				}
+			case <-timer:
+				break window
+			}
+		}
+		v.commit(cs)
+		// signal exit
+		if c == nil {
+			break
+		}
+	}
+	v.cwg.Done()
+	log.Warningf("volume[%d] commit job exit", v.Id)
+	return
+}
+
+// commit fdatasync the blocks of the group commits once, then ack them.
+func (v *Volume) commit(cs []*commit) {
+	var (
+		ok   bool
+		err  error
+		c    *commit
+		errs = make(map[*block.SuperBlock]error, 1)
+	)
+	for _, c = range cs {
+		if err, ok = errs[c.b]; !ok {
+			err = v.sync(c.b)
+			errs[c.b] = err
+		}
+		c.ch <- err
+	}
+	if log.V(1) {
+		log.Infof("volume: %d group commit: %d writers, %d fdatasync", v.Id, len(cs), len(errs))
+	}
+}
@@ -0,0 +1,89 @@
+package volume
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"bfs/store/conf"
+	"bfs/store/needle"
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVolumeCommit(t *testing.T) {
+	var (
+		i       int
+		v       *Volume
+		err     error
+		flushes uint64
+		wg      sync.WaitGroup
+		errs    = make(chan error, 16)
+		c       = *_c
+		vc      = *_vc
+		ic      = *_ic
+		data    = []byte("test")
+		bfile   = "../test/test_commit"
+		ifile   = "../test/test_commit.idx"
+		write   = func(key int64, mode string) (err error) {
+			var n = needle.NewWriter(key, int32(key), 4)
+			defer n.Close()
+			if err = n.ReadFrom(bytes.NewReader(data)); err != nil {
+				return
+			}
+			if err = v.Write(n); err != nil {
+				return
+			}
+			return v.Commit(mode)
+		}
+	)
+	os.Remove(bfile)
+	os.Remove(ifile)
+	defer os.Remove(bfile)
+	defer os.Remove(ifile)
+	vc.GroupCommit = conf.Duration{Duration: 100 * time.Millisecond}
+	// the concurrent writers
+	ic.RingBuffer = 1024
+	c.Volume, c.Index = &vc, &ic
+	if v, err = NewVolume(1, bfile, ifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer v.Close()
+	// page cache, no fdatasync
+	if err = write(1, meta.DurabilityCache); err != nil || v.Stats.TotalFlushProcessed != 0 {
+		t.Errorf("write() error(%v) flushes: %d", err, v.Stats.TotalFlushProcessed)
+		t.FailNow()
+	}
+	if err = write(2, meta.DurabilitySync); err != nil || v.Stats.TotalFlushProcessed != 1 {
+		t.Errorf("write() error(%v) flushes: %d", err, v.Stats.TotalFlushProcessed)
+		t.FailNow()
+	}
+	// the writers in the window share the fdatasync
+	for i = 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func(key int64) {
+			errs <- write(key, meta.DurabilityGroup)
+			wg.Done()
+		}(int64(i + 10))
+	}
+	wg.Wait()
+	close(errs)
+	for err = range errs {
+		if err != nil {
+			t.Errorf("write() error(%v)", err)
+			t.FailNow()
+		}
+	}
+	if flushes = v.Stats.TotalFlushProcessed - 1; flushes == 0 || flushes >= uint64(cap(errs)) {
+		t.Errorf("group commit flushes: %d, writers: %d", flushes, cap(errs))
+		t.FailNow()
+	}
+	t.Logf("group commit flushes: %d, writers: %d", flushes, cap(errs))
+	v.Close()
+	if err = v.Commit(meta.DurabilityGroup); err != errors.ErrVolumeClosed {
+		t.Errorf("Commit() error(%v), must be ErrVolumeClosed", err)
+		t.FailNow()
+	}
+}
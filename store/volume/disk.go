@@ -0,0 +1,59 @@
+package volume
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"sync/atomic"
+)
+
+// isIOError check the error is an io error, not a bfs error.
+func isIOError(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, ok := err.(errors.Error)
+	return !ok
+}
+
+// Broken reports whether the block or index keeps an io error, they fail
+// all the calls until reopened.
+func (v *Volume) Broken() bool {
+	return isIOError(v.Block.LastErr) || isIOError(v.Indexer.LastErr)
+}
+
+// ioError count the failed call of a broken volume as an io error of the
+// disk.
+func (v *Volume) ioError(err error) {
+	if err != nil && v.Broken() {
+		atomic.AddUint64(&v.IOErrors, 1)
+	}
+}
+
+// SetStatus set the disk status of the volume.
+func (v *Volume) SetStatus(status int) {
+	atomic.StoreInt32(&v.Status, int32(status))
+}
+
+// DiskStatus get the disk status of the volume.
+func (v *Volume) DiskStatus() int {
+	return int(atomic.LoadInt32(&v.Status))
+}
+
+// IOErrorCount get the io errors of the volume.
+func (v *Volume) IOErrorCount() uint64 {
+	return atomic.LoadUint64(&v.IOErrors)
+}
+
+// available check the disk status of the volume, a volume of a failed disk
+// is offline, a volume of a slow disk rejects the writes.
+func (v *Volume) available(write bool) (err error) {
+	switch v.DiskStatus() {
+	case meta.DiskStatusFail:
+		err = errors.ErrVolumeOffline
+	case meta.DiskStatusRead:
+		if write {
+			err = errors.ErrVolumeReadOnly
+		}
+	}
+	return
+}
@@ -0,0 +1,497 @@
+package volume
+
+import (
+	"bfs/libs/ec"
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"bfs/libs/stat"
+	"bfs/store/conf"
+	"bfs/store/index"
+	"bfs/store/needle"
+	"encoding/json"
+	"fmt"
+	log "github.com/golang/glog"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// stripe size when encode or rebuild shards
+	_ecChunk = 1024 * 1024
+)
+
+// ECFetcher read a range of a shard from the store which holds it.
+type ECFetcher func(shard int, offset int64, buf []byte) error
+
+// ECShardFile get the shard file of a erasure coded volume file.
+func ECShardFile(file string, shard int) string {
+	return fmt.Sprintf("%s_%d", file, shard)
+}
+
+// ECIndexFile get the needle index file of a erasure coded volume file.
+func ECIndexFile(file string) string {
+	return file + ".idx"
+}
+
+// ECMetaFile get the meta file of a erasure coded volume file.
+func ECMetaFile(file string) string {
+	return file + ".meta"
+}
+
+// WriteECMeta save the erasure coded volume meta.
+func WriteECMeta(file string, em *meta.ECVolume) (err error) {
+	var data []byte
+	if data, err = json.Marshal(em); err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(ECMetaFile(file), data, 0664); err != nil {
+		log.Errorf("ioutil.WriteFile(\"%s\") error(%v)", ECMetaFile(file), err)
+	}
+	return
+}
+
+// ECVolume is a erasure coded volume, it holds some of the shards and the
+// whole needle index, the data of other shards are fetched from other
+// stores, a lost range is reconstructed from any k shards.
+type ECVolume struct {
+	lock    sync.RWMutex
+	Id      int32          `json:"id"`
+	File    string         `json:"file"`
+	Meta    *meta.ECVolume `json:"meta"`
+	Shards  []int          `json:"shards"`
+	Stats   *stat.Stats    `json:"stats"`
+	Indexer *index.Indexer `json:"index"`
+	// the estimated memory of the needle index
+	NeedlesMemory int64 `json:"needles_memory"`
+	needles       Needles
+	files         map[int]*os.File
+	coder         *ec.Coder
+}
+
+// NewECVolume load a erasure coded volume from the meta, index and local
+// shard files.
+func NewECVolume(file string, c *conf.Config) (v *ECVolume, err error) {
+	var (
+		i    int
+		data []byte
+		f    *os.File
+	)
+	v = &ECVolume{}
+	v.File = file
+	v.Stats = &stat.Stats{}
+	if v.needles, err = NewNeedles(c.Volume.Needles); err != nil {
+		return nil, err
+	}
+	v.files = make(map[int]*os.File)
+	if data, err = ioutil.ReadFile(ECMetaFile(file)); err != nil {
+		log.Errorf("ioutil.ReadFile(\"%s\") error(%v)", ECMetaFile(file), err)
+		return nil, err
+	}
+	v.Meta = new(meta.ECVolume)
+	if err = json.Unmarshal(data, v.Meta); err != nil {
+		log.Errorf("json.Unmarshal() error(%v)", err)
+		return nil, err
+	}
+	v.Id = v.Meta.Vid
+	if v.coder, err = ec.New(v.Meta.K, v.Meta.M); err != nil {
+		return nil, err
+	}
+	for i = 0; i < v.Meta.K+v.Meta.M; i++ {
+		if f, err = os.Open(ECShardFile(file, i)); err != nil {
+			if os.IsNotExist(err) {
+				err = nil
+				continue
+			}
+			log.Errorf("os.Open(\"%s\") error(%v)", ECShardFile(file, i), err)
+			v.Close()
+			return nil, err
+		}
+		v.files[i] = f
+		v.Shards = append(v.Shards, i)
+	}
+	if v.Indexer, err = index.NewIndexer(ECIndexFile(file), c); err != nil {
+		v.Close()
+		return nil, err
+	}
+	if err = v.Indexer.Recovery(func(ix *index.Index) error {
+		v.needles.Set(ix.Key, needle.NewCache(ix.Offset, ix.Size))
+		return nil
+	}); err != nil {
+		v.Close()
+		return nil, err
+	}
+	v.NeedlesMemory = v.needles.Memory()
+	return
+}
+
+// Read get a needle by key and cookie.
+func (v *ECVolume) Read(key int64, cookie int32, fetch ECFetcher) (n *needle.Needle, err error) {
+	var (
+		ok   bool
+		nc   int64
+		size int32
+		now  = time.Now().UnixNano()
+	)
+	v.lock.RLock()
+	if nc, ok = v.needles.Get(key); !ok {
+		err = errors.ErrNeedleNotExist
+	}
+	v.lock.RUnlock()
+	if err != nil {
+		return
+	}
+	if n = needle.NewReader(key, nc); n.Offset == needle.CacheDelOffset {
+		n.Close()
+		return nil, errors.ErrNeedleDeleted
+	}
+	size = n.TotalSize
+	if err = v.ReadAt(n.Buffer(), v.Meta.BlockOffset(n.Offset), fetch); err == nil {
+		if err = n.Parse(); err == nil {
+			if n.Key != key {
+				err = errors.ErrNeedleKey
+			} else if n.TotalSize != size {
+				err = errors.ErrNeedleSize
+			} else if n.Flag == needle.FlagDel {
+				err = errors.ErrNeedleDeleted
+			} else if n.Expired(time.Now().Unix()) {
+				err = errors.ErrNeedleExpired
+			} else if n.Cookie != cookie {
+				err = errors.ErrNeedleCookie
+			}
+		}
+	}
+	if err != nil {
+		n.Close()
+		return nil, err
+	}
+	atomic.AddUint64(&v.Stats.TotalGetProcessed, 1)
+	atomic.AddUint64(&v.Stats.TotalReadBytes, uint64(size))
+	atomic.AddUint64(&v.Stats.TotalGetDelay, uint64(time.Now().UnixNano()-now))
+	return
+}
+
+// ReadAt read a range of the origin block.
+func (v *ECVolume) ReadAt(buf []byte, offset int64, fetch ECFetcher) (err error) {
+	var r meta.ECRange
+	for _, r = range v.Meta.Locate(offset, int64(len(buf))) {
+		if err = v.readShard(r.Shard, r.Offset, buf[:r.Size], fetch); err != nil {
+			log.Warningf("volume: %d shard: %d offset: %d read error(%v), reconstruct it", v.Id, r.Shard, r.Offset, err)
+			if err = v.reconstruct(r.Shard, r.Offset, buf[:r.Size], fetch); err != nil {
+				return
+			}
+		}
+		buf = buf[r.Size:]
+	}
+	return
+}
+
+// ReadShard read a range of a local shard.
+func (v *ECVolume) ReadShard(shard int, offset int64, buf []byte) (err error) {
+	return v.readShard(shard, offset, buf, nil)
+}
+
+// readShard read a range of a shard, from local file or fetch.
+func (v *ECVolume) readShard(shard int, offset int64, buf []byte, fetch ECFetcher) (err error) {
+	var (
+		ok bool
+		f  *os.File
+	)
+	v.lock.RLock()
+	f, ok = v.files[shard]
+	v.lock.RUnlock()
+	if ok {
+		_, err = f.ReadAt(buf, offset)
+	} else if fetch != nil {
+		err = fetch(shard, offset, buf)
+	} else {
+		err = errors.ErrECShardNotExist
+	}
+	return
+}
+
+// reconstruct rebuild a range of a shard from any k other shards, the local
+// shards are read first.
+func (v *ECVolume) reconstruct(shard int, offset int64, buf []byte, fetch ECFetcher) (err error) {
+	var (
+		i, n   int
+		ok     bool
+		b      []byte
+		ids    = make([]int, 0, v.Meta.K+v.Meta.M)
+		shards = make([][]byte, v.Meta.K+v.Meta.M)
+	)
+	v.lock.RLock()
+	for i = 0; i < v.Meta.K+v.Meta.M; i++ {
+		if _, ok = v.files[i]; ok {
+			ids = append(ids, i)
+		}
+	}
+	for i = 0; i < v.Meta.K+v.Meta.M; i++ {
+		if _, ok = v.files[i]; !ok {
+			ids = append(ids, i)
+		}
+	}
+	v.lock.RUnlock()
+	for _, i = range ids {
+		if i == shard {
+			continue
+		}
+		b = make([]byte, len(buf))
+		if err = v.readShard(i, offset, b, fetch); err != nil {
+			log.Errorf("volume: %d shard: %d offset: %d read error(%v)", v.Id, i, offset, err)
+			continue
+		}
+		shards[i] = b
+		if n++; n == v.Meta.K {
+			break
+		}
+	}
+	if n < v.Meta.K {
+		return errors.ErrECShardLack
+	}
+	if err = v.coder.Reconstruct(shards); err == nil {
+		copy(buf, shards[shard])
+	}
+	return
+}
+
+// Rebuild rebuild a lost shard into the local shard file from the others.
+func (v *ECVolume) Rebuild(shard int, fetch ECFetcher) (err error) {
+	var (
+		f      *os.File
+		offset int64
+		size   int64
+		buf    []byte
+		file   = ECShardFile(v.File, shard)
+		tmp    = file + ".tmp"
+	)
+	if shard < 0 || shard >= v.Meta.K+v.Meta.M {
+		return errors.ErrECParam
+	}
+	if f, err = os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664); err != nil {
+		log.Errorf("os.OpenFile(\"%s\") error(%v)", tmp, err)
+		return
+	}
+	for offset = 0; offset < v.Meta.ShardSize; offset += size {
+		if size = v.Meta.ShardSize - offset; size > _ecChunk {
+			size = _ecChunk
+		}
+		buf = make([]byte, size)
+		if err = v.reconstruct(shard, offset, buf, fetch); err != nil {
+			break
+		}
+		if _, err = f.Write(buf); err != nil {
+			log.Errorf("f.Write() error(%v)", err)
+			break
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return
+	}
+	if f, err = os.Open(file); err != nil {
+		return
+	}
+	v.lock.Lock()
+	if _, ok := v.files[shard]; ok {
+		v.files[shard].Close()
+	} else {
+		v.Shards = append(append([]int(nil), v.Shards...), shard)
+	}
+	v.files[shard] = f
+	v.lock.Unlock()
+	log.Infof("volume: %d shard: %d rebuilt", v.Id, shard)
+	return
+}
+
+// Delete logical delete a needle, the erasure coded shards are immutable,
+// only the needle index is updated.
+func (v *ECVolume) Delete(key int64) (err error) {
+	var (
+		ok     bool
+		nc     int64
+		size   int32
+		offset uint32
+	)
+	v.lock.Lock()
+	if nc, ok = v.needles.Get(key); ok {
+		if offset, size = needle.Cache(nc); offset != needle.CacheDelOffset {
+			v.needles.Set(key, needle.NewCache(needle.CacheDelOffset, size))
+			err = v.Indexer.Add(key, needle.CacheDelOffset, size)
+		} else {
+			err = errors.ErrNeedleDeleted
+		}
+	} else {
+		err = errors.ErrNeedleNotExist
+	}
+	v.lock.Unlock()
+	if err == nil {
+		atomic.AddUint64(&v.Stats.TotalDelProcessed, 1)
+	}
+	return
+}
+
+// Close close the shard files and index.
+func (v *ECVolume) Close() {
+	var f *os.File
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	for _, f = range v.files {
+		f.Close()
+	}
+	if v.Indexer != nil {
+		v.Indexer.Close()
+	}
+}
+
+// Destroy remove the shard files, index and meta, must called after Close().
+func (v *ECVolume) Destroy() {
+	var i int
+	for i = 0; i < v.Meta.K+v.Meta.M; i++ {
+		os.Remove(ECShardFile(v.File, i))
+	}
+	if v.Indexer != nil {
+		v.Indexer.Destroy()
+	}
+	os.Remove(ECMetaFile(v.File))
+}
+
+// EncodeEC code the volume block into k data shards and m parity shards, the
+// shards and needle index are saved to the ECShardFile and ECIndexFile of
+// file, the volume must be no longer written.
+func (v *Volume) EncodeEC(k, m int, file string) (em *meta.ECVolume, err error) {
+	var (
+		i       int
+		key     int64
+		nc      int64
+		bsize   int64
+		offset  int64
+		pos     int64
+		size    int64
+		rsize   int64
+		noffset uint32
+		nsize   int32
+		r       *os.File
+		ix      *index.Indexer
+		coder   *ec.Coder
+		ws      = make([]*os.File, k+m)
+		shards  = make([][]byte, k+m)
+		bufs    = make([][]byte, k+m)
+		needles = make(map[int64]int64)
+	)
+	if coder, err = ec.New(k, m); err != nil {
+		return
+	}
+	v.wlock.Lock()
+	v.lock.RLock()
+	if v.Compact {
+		err = errors.ErrVolumeInCompact
+	} else {
+		bsize = v.Block.BlockOffset(v.Block.Offset)
+		v.needles.Range(func(key int64, nc int64) bool {
+			needles[key] = nc
+			return true
+		})
+	}
+	v.lock.RUnlock()
+	v.wlock.Unlock()
+	if err != nil {
+		return
+	}
+	em = meta.NewECVolume(v.Id, k, m, bsize)
+	em.Padding = v.Block.Padding
+	if r, err = os.Open(v.Block.File); err != nil {
+		log.Errorf("os.Open(\"%s\") error(%v)", v.Block.File, err)
+		return
+	}
+	defer r.Close()
+	for i = 0; i < k+m; i++ {
+		if ws[i], err = os.OpenFile(ECShardFile(file, i), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664); err != nil {
+			log.Errorf("os.OpenFile(\"%s\") error(%v)", ECShardFile(file, i), err)
+			goto failed
+		}
+		bufs[i] = make([]byte, _ecChunk)
+	}
+	log.Infof("volume: %d encode block: %s size: %d into %d+%d shards", v.Id, v.Block.File, bsize, k, m)
+	for offset = 0; offset < em.ShardSize; offset += size {
+		if size = em.ShardSize - offset; size > _ecChunk {
+			size = _ecChunk
+		}
+		for i = 0; i < k+m; i++ {
+			shards[i] = bufs[i][:size]
+		}
+		for i = 0; i < k; i++ {
+			// zero padding after the block end
+			pos = int64(i)*em.ShardSize + offset
+			if rsize = bsize - pos; rsize > size {
+				rsize = size
+			} else if rsize < 0 {
+				rsize = 0
+			}
+			if rsize > 0 {
+				if _, err = r.ReadAt(shards[i][:rsize], pos); err != nil {
+					log.Errorf("block: %s ReadAt() error(%v)", v.Block.File, err)
+					goto failed
+				}
+			}
+			for pos = rsize; pos < size; pos++ {
+				shards[i][pos] = 0
+			}
+		}
+		if err = coder.Encode(shards); err != nil {
+			goto failed
+		}
+		for i = 0; i < k+m; i++ {
+			if _, err = ws[i].Write(shards[i]); err != nil {
+				log.Errorf("shard: %s Write() error(%v)", ws[i].Name(), err)
+				goto failed
+			}
+		}
+	}
+	for i = 0; i < k+m; i++ {
+		if err = ws[i].Sync(); err != nil {
+			log.Errorf("shard: %s Sync() error(%v)", ws[i].Name(), err)
+			goto failed
+		}
+	}
+	// the needle index, skip the needles out of the encoded block
+	os.Remove(ECIndexFile(file))
+	if ix, err = index.NewIndexer(ECIndexFile(file), v.conf); err != nil {
+		goto failed
+	}
+	for key, nc = range needles {
+		if noffset, nsize = needle.Cache(nc); noffset != needle.CacheDelOffset && v.Block.BlockOffset(noffset)+int64(nsize) > bsize {
+			continue
+		}
+		if err = ix.Write(key, noffset, nsize); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = ix.Flush()
+	}
+	ix.Close()
+failed:
+	for i = 0; i < k+m; i++ {
+		if ws[i] != nil {
+			ws[i].Close()
+			if err != nil {
+				os.Remove(ws[i].Name())
+			}
+		}
+	}
+	if err != nil {
+		os.Remove(ECIndexFile(file))
+		em = nil
+	}
+	return
+}
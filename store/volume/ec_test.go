@@ -0,0 +1,163 @@
+package volume
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"bfs/store/needle"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestECVolume(t *testing.T) {
+	var (
+		i      int
+		v      *Volume
+		ev     *ECVolume
+		n      *needle.Needle
+		em     *meta.ECVolume
+		err    error
+		c      = *_c
+		key    int64
+		data   []byte
+		shard  []byte
+		lost   []byte
+		bfile  = "../test/test_ec"
+		ifile  = "../test/test_ec.idx"
+		efile  = "../test/test_ec_block"
+		buf    = &bytes.Buffer{}
+		remote = map[int][]byte{}
+		fetch  = func(shard int, offset int64, buf []byte) error {
+			if b, ok := remote[shard]; ok {
+				copy(buf, b[offset:])
+				return nil
+			}
+			return errors.ErrECShardNotExist
+		}
+	)
+	c.BlockMaxSize = needle.Size(c.NeedleMaxSize)
+	os.Remove(bfile)
+	os.Remove(ifile)
+	defer os.Remove(bfile)
+	defer os.Remove(ifile)
+	if v, err = NewVolume(1, bfile, ifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer v.Close()
+	for key = 1; key <= 10; key++ {
+		data = bytes.Repeat([]byte{byte(key)}, int(key)*100)
+		buf.Write(data)
+		n = needle.NewWriter(key, int32(key), int32(len(data)))
+		if err = n.ReadFrom(buf); err != nil {
+			t.Errorf("n.ReadFrom() error(%v)", err)
+			t.FailNow()
+		}
+		if err = v.Write(n); err != nil {
+			t.Errorf("Write() error(%v)", err)
+			t.FailNow()
+		}
+		n.Close()
+	}
+	if err = v.Delete(10); err != nil {
+		t.Errorf("Delete() error(%v)", err)
+		t.FailNow()
+	}
+	if em, err = v.EncodeEC(3, 2, efile); err != nil {
+		t.Errorf("EncodeEC() error(%v)", err)
+		t.FailNow()
+	}
+	defer func() {
+		for i = 0; i < 5; i++ {
+			os.Remove(ECShardFile(efile, i))
+		}
+		os.Remove(ECIndexFile(efile))
+		os.Remove(ECMetaFile(efile))
+	}()
+	if em.BlockSize != needle.BlockOffset(v.Block.Offset) || em.ShardSize*3 < em.BlockSize {
+		t.Errorf("EncodeEC() meta: %v not match", em)
+		t.FailNow()
+	}
+	if err = WriteECMeta(efile, em); err != nil {
+		t.Errorf("WriteECMeta() error(%v)", err)
+		t.FailNow()
+	}
+	// shard 0 and 3 are on other stores, shard 1 is lost
+	for _, i = range []int{0, 1, 3} {
+		if shard, err = ioutil.ReadFile(ECShardFile(efile, i)); err != nil {
+			t.Errorf("ioutil.ReadFile() error(%v)", err)
+			t.FailNow()
+		}
+		if int64(len(shard)) != em.ShardSize {
+			t.Errorf("shard: %d size: %d not match", i, len(shard))
+			t.FailNow()
+		}
+		if i != 1 {
+			remote[i] = shard
+		} else {
+			lost = shard
+		}
+		os.Remove(ECShardFile(efile, i))
+	}
+	if ev, err = NewECVolume(efile, &c); err != nil {
+		t.Errorf("NewECVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer ev.Close()
+	if len(ev.Shards) != 2 || ev.Shards[0] != 2 || ev.Shards[1] != 4 {
+		t.Errorf("NewECVolume() shards: %v not match", ev.Shards)
+		t.FailNow()
+	}
+	for key = 1; key <= 9; key++ {
+		if n, err = ev.Read(key, int32(key), fetch); err != nil {
+			t.Errorf("Read(%d) error(%v)", key, err)
+			t.FailNow()
+		}
+		if !bytes.Equal(n.Data, bytes.Repeat([]byte{byte(key)}, int(key)*100)) {
+			t.Errorf("Read(%d) data not match", key)
+			t.FailNow()
+		}
+		n.Close()
+	}
+	if _, err = ev.Read(10, 10, fetch); err != errors.ErrNeedleDeleted {
+		t.Errorf("Read(10) error(%v) not match", err)
+		t.FailNow()
+	}
+	if _, err = ev.Read(1, 2, fetch); err != errors.ErrNeedleCookie {
+		t.Errorf("Read(1) error(%v) not match", err)
+		t.FailNow()
+	}
+	// two shards lost, three left
+	if _, err = ev.Read(1, 1, nil); err != errors.ErrECShardLack {
+		t.Errorf("Read(1) error(%v) not match", err)
+		t.FailNow()
+	}
+	if err = ev.Delete(9); err != nil {
+		t.Errorf("Delete() error(%v)", err)
+		t.FailNow()
+	}
+	// rebuild the lost shard
+	if err = ev.Rebuild(1, fetch); err != nil {
+		t.Errorf("Rebuild() error(%v)", err)
+		t.FailNow()
+	}
+	if data, err = ioutil.ReadFile(ECShardFile(efile, 1)); err != nil || !bytes.Equal(data, lost) {
+		t.Errorf("Rebuild() shard not match, error(%v)", err)
+		t.FailNow()
+	}
+	ev.Close()
+	if ev, err = NewECVolume(efile, &c); err != nil {
+		t.Errorf("NewECVolume() error(%v)", err)
+		t.FailNow()
+	}
+	if _, err = ev.Read(9, 9, fetch); err != errors.ErrNeedleDeleted {
+		t.Errorf("Read(9) error(%v) not match", err)
+		t.FailNow()
+	}
+	if n, err = ev.Read(5, 5, nil); err != nil {
+		t.Errorf("Read(5) error(%v)", err)
+		t.FailNow()
+	}
+	n.Close()
+}
@@ -0,0 +1,212 @@
+package volume
+
+import (
+	"bfs/libs/errors"
+	log "github.com/golang/glog"
+	"sort"
+)
+
+const (
+	// NeedlesMap the go map needle index.
+	NeedlesMap = "map"
+	// NeedlesCompact the sorted array plus delta needle index.
+	NeedlesCompact = "compact"
+
+	// the estimated bytes of a map[int64]int64 entry, including the bucket
+	// overhead and the load factor
+	_mapEntrySize = 40
+	// the bytes of a key or a needle cache
+	_int64Size = 8
+	// the delta is merged when it's over 1/_deltaRatio of the array, and
+	// at least _minDelta
+	_deltaRatio = 8
+	_minDelta   = 4096
+)
+
+// Needles is the in-memory needle index of a volume, key -> needle cache
+// (offset and size, see needle.NewCache). a needle is never removed, the
+// deleted one is kept with the del offset, the compaction builds a new one.
+// it's not goroutine safe, the volume lock protects it.
+type Needles interface {
+	// Get get the needle cache of the key.
+	Get(key int64) (nc int64, ok bool)
+	// Set set the needle cache of the key.
+	Set(key int64, nc int64)
+	// Len get the number of keys.
+	Len() int
+	// Range call fn for every key until fn returns false.
+	Range(fn func(key int64, nc int64) bool)
+	// Memory get the estimated bytes used.
+	Memory() int64
+}
+
+// NewNeedles new a needle index of the type, empty means compact, ErrParam
+// if unknown.
+func NewNeedles(typ string) (ns Needles, err error) {
+	switch typ {
+	case NeedlesMap:
+		ns = newMapNeedles()
+	case NeedlesCompact, "":
+		ns = newCompactNeedles()
+	default:
+		log.Errorf("unknown needles type: %s", typ)
+		err = errors.ErrParam
+	}
+	return
+}
+
+// hasNeedle check the needle cache of the key is still nc.
+func hasNeedle(ns Needles, key int64, nc int64) bool {
+	var onc, ok = ns.Get(key)
+	return ok && onc == nc
+}
+
+// mapNeedles is the needle index by a go map, fast but costs 40+ bytes per
+// needle.
+type mapNeedles map[int64]int64
+
+func newMapNeedles() mapNeedles {
+	return make(map[int64]int64)
+}
+
+func (m mapNeedles) Get(key int64) (nc int64, ok bool) {
+	nc, ok = m[key]
+	return
+}
+
+func (m mapNeedles) Set(key int64, nc int64) {
+	m[key] = nc
+}
+
+func (m mapNeedles) Len() int {
+	return len(m)
+}
+
+func (m mapNeedles) Range(fn func(key int64, nc int64) bool) {
+	var key, nc int64
+	for key, nc = range m {
+		if !fn(key, nc) {
+			return
+		}
+	}
+}
+
+func (m mapNeedles) Memory() int64 {
+	return int64(len(m)) * _mapEntrySize
+}
+
+// compactNeedles is the needle index by a sorted key array and a needle cache
+// array, the new keys are added into a delta map which is merged into the
+// arrays when it grows over 1/_deltaRatio of them, so a needle costs about
+// 16 bytes. an existed key is updated in place.
+type compactNeedles struct {
+	keys  []int64
+	ncs   []int64
+	delta map[int64]int64
+}
+
+func newCompactNeedles() *compactNeedles {
+	return &compactNeedles{delta: make(map[int64]int64)}
+}
+
+// search binary search the key in the arrays.
+func (c *compactNeedles) search(key int64) (i int, ok bool) {
+	var (
+		j, h int
+		n    = len(c.keys)
+	)
+	// inline sort.Search, no closure in the hot path
+	for i, j = 0, n; i < j; {
+		if h = int(uint(i+j) >> 1); c.keys[h] < key {
+			i = h + 1
+		} else {
+			j = h
+		}
+	}
+	ok = i < n && c.keys[i] == key
+	return
+}
+
+func (c *compactNeedles) Get(key int64) (nc int64, ok bool) {
+	var i int
+	if i, ok = c.search(key); ok {
+		nc = c.ncs[i]
+		return
+	}
+	nc, ok = c.delta[key]
+	return
+}
+
+func (c *compactNeedles) Set(key int64, nc int64) {
+	var (
+		i  int
+		ok bool
+	)
+	if i, ok = c.search(key); ok {
+		c.ncs[i] = nc
+		return
+	}
+	c.delta[key] = nc
+	if len(c.delta) >= _minDelta && len(c.delta)*_deltaRatio >= len(c.keys) {
+		c.merge()
+	}
+}
+
+// merge merge the delta into the arrays from the tail, the delta keys are
+// never in the arrays.
+func (c *compactNeedles) merge() {
+	var (
+		i, j, k int
+		key     int64
+		dkeys   = make(int64Slice, 0, len(c.delta))
+	)
+	for key = range c.delta {
+		dkeys = append(dkeys, key)
+	}
+	sort.Sort(dkeys)
+	i, j, k = len(c.keys)-1, len(dkeys)-1, len(c.keys)+len(dkeys)-1
+	c.keys = append(c.keys, dkeys...)
+	c.ncs = append(c.ncs, make([]int64, len(dkeys))...)
+	for ; j >= 0; k-- {
+		if i >= 0 && c.keys[i] > dkeys[j] {
+			c.keys[k], c.ncs[k] = c.keys[i], c.ncs[i]
+			i--
+		} else {
+			c.keys[k], c.ncs[k] = dkeys[j], c.delta[dkeys[j]]
+			j--
+		}
+	}
+	c.delta = make(map[int64]int64)
+}
+
+func (c *compactNeedles) Len() int {
+	return len(c.keys) + len(c.delta)
+}
+
+func (c *compactNeedles) Range(fn func(key int64, nc int64) bool) {
+	var (
+		i       int
+		key, nc int64
+	)
+	for i = 0; i < len(c.keys); i++ {
+		if !fn(c.keys[i], c.ncs[i]) {
+			return
+		}
+	}
+	for key, nc = range c.delta {
+		if !fn(key, nc) {
+			return
+		}
+	}
+}
+
+func (c *compactNeedles) Memory() int64 {
+	return int64(cap(c.keys)+cap(c.ncs))*_int64Size + int64(len(c.delta))*_mapEntrySize
+}
+
+// int64Slice key sort.
+type int64Slice []int64
+
+func (p int64Slice) Len() int           { return len(p) }
+func (p int64Slice) Less(i, j int) bool { return p[i] < p[j] }
+func (p int64Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
@@ -0,0 +1,140 @@
+package volume
+
+import (
+	"bfs/libs/errors"
+	"bfs/store/needle"
+	"math/rand"
+	"testing"
+)
+
+func TestNeedles(t *testing.T) {
+	var (
+		i            int
+		key, nc, onc int64
+		ok           bool
+		err          error
+		typ          string
+		ns           Needles
+		m            map[int64]int64
+		r            = rand.New(rand.NewSource(1))
+	)
+	if _, err = NewNeedles("btree"); err != errors.ErrParam {
+		t.Errorf("NewNeedles() error(%v) must be ErrParam", err)
+		t.FailNow()
+	}
+	for _, typ = range []string{NeedlesMap, NeedlesCompact} {
+		if ns, err = NewNeedles(typ); err != nil {
+			t.Errorf("NewNeedles() error(%v)", err)
+			t.FailNow()
+		}
+		m = make(map[int64]int64)
+		// enough for some merges, half of the writes are overwrites
+		for i = 0; i < 10*_minDelta; i++ {
+			key = r.Int63n(5 * _minDelta)
+			nc = needle.NewCache(uint32(i+1), int32(key))
+			ns.Set(key, nc)
+			m[key] = nc
+		}
+		if ns.Len() != len(m) {
+			t.Errorf("%s: Len() %d, must be %d", typ, ns.Len(), len(m))
+			t.FailNow()
+		}
+		for key, nc = range m {
+			if onc, ok = ns.Get(key); !ok || onc != nc {
+				t.Errorf("%s: Get(%d) %d, must be %d", typ, key, onc, nc)
+				t.FailNow()
+			}
+		}
+		if _, ok = ns.Get(-1); ok {
+			t.Errorf("%s: Get(-1) must not exist", typ)
+			t.FailNow()
+		}
+		i = 0
+		ns.Range(func(key int64, nc int64) bool {
+			if m[key] != nc {
+				t.Errorf("%s: Range() key: %d %d, must be %d", typ, key, nc, m[key])
+			}
+			i++
+			return true
+		})
+		if i != len(m) {
+			t.Errorf("%s: Range() %d keys, must be %d", typ, i, len(m))
+			t.FailNow()
+		}
+		i = 0
+		ns.Range(func(key int64, nc int64) bool {
+			i++
+			return false
+		})
+		if i != 1 {
+			t.Errorf("%s: Range() not stopped", typ)
+			t.FailNow()
+		}
+	}
+}
+
+func TestNeedlesMemory(t *testing.T) {
+	var (
+		i        int64
+		mem      = make(map[string]int64)
+		typ      string
+		ns       Needles
+		nneedles = int64(100 * _minDelta)
+	)
+	for _, typ = range []string{NeedlesMap, NeedlesCompact} {
+		ns, _ = NewNeedles(typ)
+		for i = 0; i < nneedles; i++ {
+			ns.Set(i*7919, needle.NewCache(uint32(i+1), 4))
+		}
+		mem[typ] = ns.Memory()
+		t.Logf("%s: %d needles, memory: %d bytes, %d bytes per needle", typ, nneedles, mem[typ], mem[typ]/nneedles)
+	}
+	if mem[NeedlesCompact] >= mem[NeedlesMap] {
+		t.Errorf("compact memory: %d must less than map: %d", mem[NeedlesCompact], mem[NeedlesMap])
+	}
+}
+
+func benchmarkNeedlesSet(b *testing.B, typ string) {
+	var (
+		i     int
+		ns, _ = NewNeedles(typ)
+		r     = rand.New(rand.NewSource(1))
+	)
+	b.ResetTimer()
+	for i = 0; i < b.N; i++ {
+		ns.Set(r.Int63(), needle.NewCache(uint32(i+1), 4))
+	}
+}
+
+func benchmarkNeedlesGet(b *testing.B, typ string) {
+	var (
+		i     int
+		keys  = make([]int64, 1000000)
+		ns, _ = NewNeedles(typ)
+		r     = rand.New(rand.NewSource(1))
+	)
+	for i = 0; i < len(keys); i++ {
+		keys[i] = r.Int63()
+		ns.Set(keys[i], needle.NewCache(uint32(i+1), 4))
+	}
+	b.ResetTimer()
+	for i = 0; i < b.N; i++ {
+		ns.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkNeedlesMapSet(b *testing.B) {
+	benchmarkNeedlesSet(b, NeedlesMap)
+}
+
+func BenchmarkNeedlesCompactSet(b *testing.B) {
+	benchmarkNeedlesSet(b, NeedlesCompact)
+}
+
+func BenchmarkNeedlesMapGet(b *testing.B) {
+	benchmarkNeedlesGet(b, NeedlesMap)
+}
+
+func BenchmarkNeedlesCompactGet(b *testing.B) {
+	benchmarkNeedlesGet(b, NeedlesCompact)
+}
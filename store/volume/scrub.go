@@ -0,0 +1,190 @@
+package volume
+
+import (
+	"bfs/libs/errors"
+	"bfs/store/needle"
+	log "github.com/golang/glog"
+	"golang.org/x/time/rate"
+	"os"
+	"sort"
+	"time"
+)
+
+// scrubNeedle a live needle snapshot when scrub start.
+type scrubNeedle struct {
+	key    int64
+	offset uint32
+	size   int32
+	expire int64
+}
+
+type scrubNeedles []scrubNeedle
+
+func (p scrubNeedles) Len() int           { return len(p) }
+func (p scrubNeedles) Less(i, j int) bool { return p[i].offset < p[j].offset }
+func (p scrubNeedles) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// isCorrupt check the error means the needle on disk is corrupt.
+func isCorrupt(err error) bool {
+	switch err {
+	case errors.ErrNeedleChecksum, errors.ErrNeedleHeaderMagic,
+		errors.ErrNeedleFooterMagic, errors.ErrNeedlePadding,
+		errors.ErrNeedleFlag, errors.ErrNeedleKey, errors.ErrNeedleSize,
+		errors.ErrNeedleMeta:
+		return true
+	}
+	return false
+}
+
+// Scrub scan the super block and verify the magic, checksum and padding of
+// every live needle, the read bytes are throttled by the limiter. a corrupt
+// needle breaks the scan, then it's resumed from the next live needle. the
+// needles flaged deleted on disk are accounted as deleted, the expire of the
+// ttl needles are recorded.
+func (v *Volume) Scrub(l *rate.Limiter) (err error) {
+	var (
+		i       int
+		key     int64
+		nc      int64
+		size    int32
+		last    uint32
+		end     uint32
+		file    string
+		r       *os.File
+		sn      scrubNeedle
+		sns     scrubNeedles
+		keys    = make(map[int64]int64)
+		dels    = make(map[int64]int64)
+		corrupt = func(sn scrubNeedle) {
+			log.Errorf("volume: %d scrub needle key: %d offset: %d corrupt", v.Id, sn.key, sn.offset)
+			keys[sn.key] = needle.NewCache(sn.offset, sn.size)
+		}
+	)
+	v.lock.RLock()
+	if v.closed {
+		err = errors.ErrVolumeClosed
+	} else if v.Compact {
+		err = errors.ErrVolumeInCompact
+	} else {
+		file, end = v.Block.File, v.Block.Offset
+		sns = make(scrubNeedles, 0, v.needles.Len())
+		v.needles.Range(func(key int64, nc int64) bool {
+			if sn.offset, sn.size = needle.Cache(nc); sn.offset != needle.CacheDelOffset {
+				sn.key = key
+				sns = append(sns, sn)
+			}
+			return true
+		})
+	}
+	v.lock.RUnlock()
+	if err != nil {
+		return
+	}
+	sort.Sort(sns)
+	if r, err = os.Open(file); err != nil {
+		log.Errorf("os.Open(\"%s\") error(%v)", file, err)
+		return
+	}
+	defer r.Close()
+	log.Infof("volume: %d scrub start, block: %s needles: %d", v.Id, file, len(sns))
+	v.ScrubOffset = 0
+	for i < len(sns) {
+		last = sns[i].offset
+		if err = v.Block.Scan(r, last, func(n *needle.Needle, so, eo uint32) error {
+			time.Sleep(l.ReserveN(time.Now(), int(n.TotalSize)).Delay())
+			for i < len(sns) && sns[i].offset < so {
+				i++
+			}
+			if i < len(sns) && sns[i].offset == so {
+				// a deleted one is removed after scrub start
+				if n.Flag == needle.FlagOK && (n.Key != sns[i].key || n.TotalSize != sns[i].size) {
+					corrupt(sns[i])
+				} else if n.Flag == needle.FlagDel && n.Key == sns[i].key {
+					dels[n.Key] = needle.NewCache(sns[i].offset, sns[i].size)
+				} else if n.Flag == needle.FlagOK {
+					sns[i].expire = n.Expire
+				}
+				i++
+			}
+			last = eo
+			v.ScrubOffset = eo
+			return nil
+		}); last >= end {
+			// the tail may be writing, ignore it
+			err = nil
+			break
+		}
+		if err != nil && !isCorrupt(err) {
+			return
+		}
+		// the needle at last is corrupt or truncated, skip it
+		for i < len(sns) && sns[i].offset < last {
+			i++
+		}
+		if i < len(sns) && sns[i].offset == last {
+			corrupt(sns[i])
+			i++
+		}
+	}
+	err = nil
+	// a corrupt needle rewritten or deleted after scrub start is sound
+	v.lock.Lock()
+	for key, nc = range keys {
+		if !hasNeedle(v.needles, key, nc) {
+			delete(keys, key)
+		}
+	}
+	// the needles deleted before the store restart are live in memory
+	for key, nc = range dels {
+		if hasNeedle(v.needles, key, nc) {
+			_, size = needle.Cache(nc)
+			v.setNeedle(key, needle.NewCache(needle.CacheDelOffset, size))
+		}
+	}
+	// the ttl needles recovered from index
+	for _, sn = range sns {
+		if sn.expire > 0 && hasNeedle(v.needles, sn.key, needle.NewCache(sn.offset, sn.size)) {
+			v.setExpire(sn.key, sn.expire)
+		}
+	}
+	v.corrupts = keys
+	v.setCorrupts()
+	v.ScrubTime = time.Now().UnixNano()
+	v.lock.Unlock()
+	log.Infof("volume: %d scrub stop, corrupt needles: %d", v.Id, len(keys))
+	return
+}
+
+// corrupt mark a needle corrupt which found when read.
+func (v *Volume) corrupt(key int64, nc int64) {
+	v.lock.Lock()
+	if hasNeedle(v.needles, key, nc) {
+		v.corrupts[key] = nc
+		v.setCorrupts()
+	}
+	v.lock.Unlock()
+	log.Errorf("volume: %d needle: %d corrupt", v.Id, key)
+}
+
+// uncorrupt clear the corrupt mark of a rewritten or deleted needle, must
+// called with lock held.
+func (v *Volume) uncorrupt(key int64) {
+	if _, ok := v.corrupts[key]; ok {
+		delete(v.corrupts, key)
+		v.setCorrupts()
+	}
+}
+
+// setCorrupts reset the exported corrupt keys, a new slice is made for the
+// stat may be reading the old one, must called with lock held.
+func (v *Volume) setCorrupts() {
+	var (
+		key      int64
+		corrupts = make([]int64, 0, len(v.corrupts))
+	)
+	for key = range v.corrupts {
+		corrupts = append(corrupts, key)
+	}
+	v.Corrupts = corrupts
+	v.Repair = v.conf.Volume.ScrubMark && len(corrupts) > 0
+}
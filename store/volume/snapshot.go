@@ -0,0 +1,309 @@
+package volume
+
+import (
+	"bfs/libs/encoding/binary"
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"bfs/store/needle"
+	"bufio"
+	"bytes"
+	log "github.com/golang/glog"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// Increment is an incremental snapshot of a volume between two cuts, the
+// block and index bytes after the from cut and the needles deleted since it,
+// a base is an increment from the empty cut. the bytes keep the block format,
+// so a volume is restored by appending the increments in order then flaging
+// the deleted needles.
+//
+// increment format:
+//  ---------------
+// |    header     |         --------------------
+// |  block bytes  |        |  magic (4bytes)    |
+// |  index bytes  |  ----> |  ver (byte)        |
+// |     dels      |        |  padding (3)       |
+// |    trailer    |        |  vid (int32)       |
+//  ---------------         |  from block (int64)|
+//                          |  to block (int64)  |
+//                          |  from index (int64)|
+//                          |  to index (int64)  |
+//                           --------------------
+//
+// dels is the count (int64) and the deleted needle offsets (uint32), the
+// trailer is magic(4bytes) and the crc32 (uint32) of all the bytes before.
+
+const (
+	// size
+	_incrMagicSize    = 4
+	_incrVerSize      = 1
+	_incrPaddingSize  = 3
+	_incrVidSize      = 4
+	_incrSizeSize     = 8
+	_incrCountSize    = 8
+	_incrDelSize      = 4
+	_incrChecksumSize = 4
+	_incrHeaderSize   = _incrMagicSize + _incrVerSize + _incrPaddingSize + _incrVidSize + 4*_incrSizeSize
+	_incrTrailerSize  = _incrMagicSize + _incrChecksumSize
+	// offset
+	_incrVerOffset       = _incrMagicSize
+	_incrVidOffset       = _incrVerOffset + _incrVerSize + _incrPaddingSize
+	_incrFromBlockOffset = _incrVidOffset + _incrVidSize
+	_incrToBlockOffset   = _incrFromBlockOffset + _incrSizeSize
+	_incrFromIndexOffset = _incrToBlockOffset + _incrSizeSize
+	_incrToIndexOffset   = _incrFromIndexOffset + _incrSizeSize
+	_incrChecksumOffset  = _incrMagicSize
+	// ver
+	IncrementVer1 = byte(1)
+	// the max offsets of the delete log, the cuts before an overflow need a
+	// new base
+	_maxDels = 1 << 20
+)
+
+var (
+	_incrMagic    = []byte{0xab, 0xcd, 0xef, 0x03}
+	_incrEndMagic = []byte{0xab, 0xcd, 0xef, 0x04}
+)
+
+// Increment the header and the deleted needle offsets of an increment.
+type Increment struct {
+	Vid       int32
+	FromBlock int64
+	ToBlock   int64
+	FromIndex int64
+	ToIndex   int64
+	Dels      []uint32
+}
+
+// resetEpoch start a new delete log, must called with lock held or before
+// the volume used.
+func (v *Volume) resetEpoch() {
+	v.epoch = time.Now().UnixNano()
+	v.dels = []uint32{}
+}
+
+// rebaseEpoch start a new delete log when it's full, the increments from the
+// cuts before are refused, must called with lock held.
+func (v *Volume) rebaseEpoch() {
+	log.Warningf("volume: %d delete log overflow: %d, the snapshots need a new base", v.Id, len(v.dels))
+	v.resetEpoch()
+	v.rebase = v.epoch
+}
+
+// Cut get a consistent cut of the volume, the block and index bytes before
+// the sizes are immutable except the needle del flag.
+func (v *Volume) Cut() (s *meta.Snapshot, err error) {
+	v.wlock.Lock()
+	v.lock.RLock()
+	if v.closed {
+		err = errors.ErrVolumeClosed
+	} else {
+		s = &meta.Snapshot{
+			Vid:   v.Id,
+			File:  v.Block.File,
+			Block: v.Block.BlockOffset(v.Block.Offset),
+			Epoch: v.epoch,
+			Dels:  len(v.dels),
+		}
+		// the index is behind the block, no entry after the cut in it
+		s.Index, err = v.Indexer.Size()
+	}
+	v.lock.RUnlock()
+	v.wlock.Unlock()
+	return
+}
+
+// Increment write the increment from the cut from to the cut to into w, from
+// nil means a base, to must be got by Cut. ErrSnapshotRebase is returned if
+// the block is replaced by compaction or the delete log overflowed since
+// from, then a new base is needed.
+func (v *Volume) Increment(w io.Writer, from, to *meta.Snapshot) (err error) {
+	var (
+		scan         bool
+		start        int
+		offset       uint32
+		bfile, ifile string
+		buf          []byte
+		r            *os.File
+		h            = crc32.NewIEEE()
+		mw           = io.MultiWriter(w, h)
+		inc          = &Increment{Vid: v.Id, ToBlock: to.Block, ToIndex: to.Index}
+	)
+	if to.Vid != v.Id {
+		return errors.ErrSnapshotRange
+	}
+	if from != nil {
+		if from.File != to.File {
+			return errors.ErrSnapshotRebase
+		}
+		if from.Vid != to.Vid || from.Block > to.Block || from.Index > to.Index ||
+			(from.Epoch == to.Epoch && from.Dels > to.Dels) {
+			return errors.ErrSnapshotRange
+		}
+		inc.FromBlock, inc.FromIndex = from.Block, from.Index
+	}
+	v.lock.RLock()
+	if v.closed {
+		err = errors.ErrVolumeClosed
+	} else if to.File != v.Block.File || (from != nil && from.Epoch < v.rebase) {
+		err = errors.ErrSnapshotRebase
+	} else if to.Epoch != v.epoch || to.Dels > len(v.dels) {
+		// reopened after the cut
+		err = errors.ErrVolumeChanged
+	} else {
+		bfile, ifile = v.Block.File, v.Indexer.File
+		offset = v.Block.NeedleOffset(to.Block)
+		if from != nil && from.Epoch == to.Epoch {
+			start = from.Dels
+		}
+		// reopened since from, the deletes before are only on disk
+		scan = from != nil && from.Epoch != to.Epoch
+		inc.Dels = append(inc.Dels, v.dels[start:to.Dels]...)
+	}
+	v.lock.RUnlock()
+	if err != nil {
+		return
+	}
+	if r, err = os.Open(bfile); err != nil {
+		log.Errorf("os.Open(\"%s\") error(%v)", bfile, err)
+		return
+	}
+	defer r.Close()
+	if scan {
+		if err = v.Block.Scan(r, 0, func(n *needle.Needle, so, eo uint32) error {
+			if n.Flag == needle.FlagDel && so < offset {
+				inc.Dels = append(inc.Dels, so)
+			}
+			return nil
+		}); err != nil {
+			return
+		}
+	}
+	log.Infof("volume: %d increment block: [%d, %d) index: [%d, %d) dels: %d", v.Id, inc.FromBlock,
+		inc.ToBlock, inc.FromIndex, inc.ToIndex, len(inc.Dels))
+	buf = make([]byte, _incrHeaderSize)
+	copy(buf, _incrMagic)
+	buf[_incrVerOffset] = IncrementVer1
+	binary.BigEndian.PutInt32(buf[_incrVidOffset:], inc.Vid)
+	binary.BigEndian.PutInt64(buf[_incrFromBlockOffset:], inc.FromBlock)
+	binary.BigEndian.PutInt64(buf[_incrToBlockOffset:], inc.ToBlock)
+	binary.BigEndian.PutInt64(buf[_incrFromIndexOffset:], inc.FromIndex)
+	binary.BigEndian.PutInt64(buf[_incrToIndexOffset:], inc.ToIndex)
+	if _, err = mw.Write(buf); err != nil {
+		return
+	}
+	if err = copyRange(mw, r, inc.FromBlock, inc.ToBlock); err != nil {
+		return
+	}
+	if r, err = os.Open(ifile); err != nil {
+		log.Errorf("os.Open(\"%s\") error(%v)", ifile, err)
+		return
+	}
+	defer r.Close()
+	if err = copyRange(mw, r, inc.FromIndex, inc.ToIndex); err != nil {
+		return
+	}
+	buf = make([]byte, _incrCountSize+len(inc.Dels)*_incrDelSize)
+	binary.BigEndian.PutInt64(buf, int64(len(inc.Dels)))
+	for start = 0; start < len(inc.Dels); start++ {
+		binary.BigEndian.PutUint32(buf[_incrCountSize+start*_incrDelSize:], inc.Dels[start])
+	}
+	if _, err = mw.Write(buf); err != nil {
+		return
+	}
+	buf = make([]byte, _incrTrailerSize)
+	copy(buf, _incrEndMagic)
+	if _, err = mw.Write(buf[:_incrMagicSize]); err != nil {
+		return
+	}
+	binary.BigEndian.PutUint32(buf[_incrChecksumOffset:], h.Sum32())
+	_, err = w.Write(buf[_incrChecksumOffset:])
+	return
+}
+
+// copyRange copy the bytes [start, end) of the file into w.
+func copyRange(w io.Writer, r *os.File, start, end int64) (err error) {
+	if _, err = r.Seek(start, os.SEEK_SET); err != nil {
+		log.Errorf("file: %s Seek() error(%v)", r.Name(), err)
+		return
+	}
+	if _, err = io.CopyN(w, r, end-start); err != nil {
+		log.Errorf("file: %s io.CopyN() error(%v)", r.Name(), err)
+	}
+	return
+}
+
+// ReadIncrement read an increment, fn is called with the header to get the
+// writers of the block and index bytes, the checksum is verified at last, so
+// the written bytes must be dropped if any error.
+func ReadIncrement(r io.Reader, fn func(*Increment) (bw, iw io.Writer, err error)) (inc *Increment, err error) {
+	var (
+		i, n   int64
+		bw, iw io.Writer
+		buf    = make([]byte, _incrHeaderSize)
+		h      = crc32.NewIEEE()
+		rd     = bufio.NewReader(r)
+		tr     = io.TeeReader(rd, h)
+	)
+	if _, err = io.ReadFull(tr, buf); err != nil {
+		return
+	}
+	if !bytes.Equal(buf[:_incrMagicSize], _incrMagic) {
+		return nil, errors.ErrSnapshotMagic
+	}
+	if buf[_incrVerOffset] != IncrementVer1 {
+		return nil, errors.ErrSnapshotVer
+	}
+	inc = &Increment{
+		Vid:       binary.BigEndian.Int32(buf[_incrVidOffset:]),
+		FromBlock: binary.BigEndian.Int64(buf[_incrFromBlockOffset:]),
+		ToBlock:   binary.BigEndian.Int64(buf[_incrToBlockOffset:]),
+		FromIndex: binary.BigEndian.Int64(buf[_incrFromIndexOffset:]),
+		ToIndex:   binary.BigEndian.Int64(buf[_incrToIndexOffset:]),
+	}
+	if inc.FromBlock < 0 || inc.FromBlock > inc.ToBlock || inc.FromIndex < 0 || inc.FromIndex > inc.ToIndex {
+		return nil, errors.ErrSnapshotRange
+	}
+	if bw, iw, err = fn(inc); err != nil {
+		return
+	}
+	if _, err = io.CopyN(bw, tr, inc.ToBlock-inc.FromBlock); err != nil {
+		goto failed
+	}
+	if _, err = io.CopyN(iw, tr, inc.ToIndex-inc.FromIndex); err != nil {
+		goto failed
+	}
+	if _, err = io.ReadFull(tr, buf[:_incrCountSize]); err != nil {
+		goto failed
+	}
+	n = binary.BigEndian.Int64(buf)
+	for i = 0; i < n; i++ {
+		if _, err = io.ReadFull(tr, buf[:_incrDelSize]); err != nil {
+			goto failed
+		}
+		inc.Dels = append(inc.Dels, binary.BigEndian.Uint32(buf))
+	}
+	if _, err = io.ReadFull(tr, buf[:_incrMagicSize]); err != nil {
+		goto failed
+	}
+	if !bytes.Equal(buf[:_incrMagicSize], _incrEndMagic) {
+		return inc, errors.ErrSnapshotMagic
+	}
+	// the checksum itself is not hashed
+	if _, err = io.ReadFull(rd, buf[:_incrChecksumSize]); err != nil {
+		goto failed
+	}
+	if binary.BigEndian.Uint32(buf) != h.Sum32() {
+		err = errors.ErrSnapshotChecksum
+	}
+	return
+failed:
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// the stream is truncated
+		err = errors.ErrSnapshotChecksum
+	}
+	return
+}
@@ -0,0 +1,212 @@
+package volume
+
+import (
+	"bfs/libs/errors"
+	"bfs/libs/meta"
+	"bfs/store/block"
+	"bfs/store/needle"
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestVolumeSnapshot(t *testing.T) {
+	var (
+		v, nv, rv        *Volume
+		n                *needle.Needle
+		err              error
+		cut1, cut2, cut3 *meta.Snapshot
+		base, incr       = &bytes.Buffer{}, &bytes.Buffer{}
+		c                = *_c
+		data             = []byte("test")
+		bfile            = "../test/test_snapshot"
+		ifile            = "../test/test_snapshot.idx"
+		nbfile           = "../test/test_snapshot_compact"
+		nifile           = "../test/test_snapshot_compact.idx"
+		rbfile           = "../test/test_snapshot_restore"
+		rifile           = "../test/test_snapshot_restore.idx"
+		write            = func(key int64) {
+			n = needle.NewWriter(key, int32(key), 4)
+			if err = n.ReadFrom(bytes.NewReader(data)); err != nil {
+				t.Errorf("n.ReadFrom() error(%v)", err)
+				t.FailNow()
+			}
+			if err = v.Write(n); err != nil {
+				t.Errorf("Write() error(%v)", err)
+				t.FailNow()
+			}
+			n.Close()
+		}
+		replay = func(r io.Reader) {
+			var (
+				offset uint32
+				inc    *Increment
+				bf, xf *os.File
+				b      *block.SuperBlock
+			)
+			if bf, err = os.OpenFile(rbfile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664); err != nil {
+				t.Errorf("os.OpenFile() error(%v)", err)
+				t.FailNow()
+			}
+			defer bf.Close()
+			if xf, err = os.OpenFile(rifile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664); err != nil {
+				t.Errorf("os.OpenFile() error(%v)", err)
+				t.FailNow()
+			}
+			defer xf.Close()
+			if inc, err = ReadIncrement(r, func(*Increment) (io.Writer, io.Writer, error) {
+				return bf, xf, nil
+			}); err != nil {
+				t.Errorf("ReadIncrement() error(%v)", err)
+				t.FailNow()
+			}
+			if b, err = block.NewSuperBlock(rbfile, &c); err != nil {
+				t.Errorf("NewSuperBlock() error(%v)", err)
+				t.FailNow()
+			}
+			for _, offset = range inc.Dels {
+				if err = b.Delete(offset); err != nil {
+					t.Errorf("Delete() error(%v)", err)
+					t.FailNow()
+				}
+			}
+			b.Close()
+		}
+		check = func(lives, dels []int64) {
+			var key int64
+			if rv, err = NewVolume(1, rbfile, rifile, &c); err != nil {
+				t.Errorf("NewVolume() error(%v)", err)
+				t.FailNow()
+			}
+			defer rv.Close()
+			for _, key = range lives {
+				if n, err = rv.Read(key, int32(key)); err != nil || !bytes.Equal(n.Data, data) {
+					t.Errorf("Read(%d) error(%v)", key, err)
+					t.FailNow()
+				}
+				n.Close()
+			}
+			for _, key = range dels {
+				if _, err = rv.Read(key, int32(key)); err != errors.ErrNeedleDeleted && err != errors.ErrNeedleNotExist {
+					t.Errorf("Read(%d) error(%v) must be deleted", key, err)
+					t.FailNow()
+				}
+			}
+		}
+	)
+	for _, file := range []string{bfile, ifile, nbfile, nifile, rbfile, rifile} {
+		os.Remove(file)
+		defer os.Remove(file)
+	}
+	c.BlockMaxSize = needle.MaxSize(c.NeedleMaxSize)
+	if v, err = NewVolume(1, bfile, ifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer v.Close()
+	write(1)
+	write(2)
+	write(3)
+	// base
+	if cut1, err = v.Cut(); err != nil {
+		t.Errorf("Cut() error(%v)", err)
+		t.FailNow()
+	}
+	if err = v.Increment(base, nil, cut1); err != nil {
+		t.Errorf("Increment() error(%v)", err)
+		t.FailNow()
+	}
+	// delete one in the base, overwrite one and add one
+	if err = v.Delete(1); err != nil {
+		t.Errorf("Delete() error(%v)", err)
+		t.FailNow()
+	}
+	write(2)
+	write(4)
+	write(5)
+	if err = v.Delete(5); err != nil {
+		t.Errorf("Delete() error(%v)", err)
+		t.FailNow()
+	}
+	if cut2, err = v.Cut(); err != nil {
+		t.Errorf("Cut() error(%v)", err)
+		t.FailNow()
+	}
+	if err = v.Increment(incr, cut1, cut2); err != nil {
+		t.Errorf("Increment() error(%v)", err)
+		t.FailNow()
+	}
+	// truncated
+	if _, err = ReadIncrement(bytes.NewReader(incr.Bytes()[:incr.Len()-1]), func(*Increment) (io.Writer, io.Writer, error) {
+		return &bytes.Buffer{}, &bytes.Buffer{}, nil
+	}); err != errors.ErrSnapshotChecksum {
+		t.Errorf("err: %v must be ErrSnapshotChecksum", err)
+		t.FailNow()
+	}
+	// point-in-time restore
+	replay(bytes.NewReader(base.Bytes()))
+	check([]int64{1, 2, 3}, nil)
+	replay(bytes.NewReader(incr.Bytes()))
+	check([]int64{2, 3, 4}, []int64{1, 5})
+	// reopened, the deletes are scanned from the block
+	if err = v.Delete(3); err != nil {
+		t.Errorf("Delete() error(%v)", err)
+		t.FailNow()
+	}
+	v.Close()
+	if err = v.Open(); err != nil {
+		t.Errorf("Open() error(%v)", err)
+		t.FailNow()
+	}
+	if cut3, err = v.Cut(); err != nil {
+		t.Errorf("Cut() error(%v)", err)
+		t.FailNow()
+	}
+	incr.Reset()
+	if err = v.Increment(incr, cut2, cut3); err != nil {
+		t.Errorf("Increment() error(%v)", err)
+		t.FailNow()
+	}
+	replay(bytes.NewReader(incr.Bytes()))
+	check([]int64{2, 4}, []int64{1, 3, 5})
+	// compacted, need a new base
+	if nv, err = NewVolume(2, nbfile, nifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer nv.Close()
+	if err = v.StartCompact(nv, nil); err != nil {
+		t.Errorf("StartCompact() error(%v)", err)
+		t.FailNow()
+	}
+	if err = v.StopCompact(nv); err != nil {
+		t.Errorf("StopCompact() error(%v)", err)
+		t.FailNow()
+	}
+	if cut1, err = v.Cut(); err != nil {
+		t.Errorf("Cut() error(%v)", err)
+		t.FailNow()
+	}
+	if err = v.Increment(incr, cut3, cut1); err != errors.ErrSnapshotRebase {
+		t.Errorf("err: %v must be ErrSnapshotRebase", err)
+		t.FailNow()
+	}
+	// the delete log overflowed, need a new base
+	v.lock.Lock()
+	v.rebaseEpoch()
+	v.lock.Unlock()
+	if cut2, err = v.Cut(); err != nil {
+		t.Errorf("Cut() error(%v)", err)
+		t.FailNow()
+	}
+	if err = v.Increment(incr, cut1, cut2); err != errors.ErrSnapshotRebase {
+		t.Errorf("err: %v must be ErrSnapshotRebase", err)
+		t.FailNow()
+	}
+	incr.Reset()
+	if err = v.Increment(incr, nil, cut2); err != nil {
+		t.Errorf("Increment() error(%v)", err)
+		t.FailNow()
+	}
+}
@@ -2,6 +2,7 @@ package volume
 
 import (
 	"bfs/libs/errors"
+	"bfs/libs/meta"
 	"bfs/libs/stat"
 	"bfs/store/block"
 	"bfs/store/conf"
@@ -9,6 +10,10 @@ import (
 	"bfs/store/needle"
 	"fmt"
 	log "github.com/golang/glog"
+	"golang.org/x/time/rate"
+	"io"
+	"math/rand"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
@@ -33,20 +38,54 @@ func (p uint32Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 type Volume struct {
 	wg   sync.WaitGroup
 	lock sync.RWMutex
+	// wlock serialize the block writer, a stream write holds it without
+	// holding lock, so readers are not blocked by a slow upload.
+	wlock sync.Mutex
 	// meta
 	Id      int32             `json:"id"`
 	Stats   *stat.Stats       `json:"stats"`
 	Block   *block.SuperBlock `json:"block"`
 	Indexer *index.Indexer    `json:"index"`
 	// data
-	needles map[int64]int64
+	needles Needles
+	expires map[int64]int64 // the expire unix seconds of the ttl needles
 	ch      chan uint32
 	conf    *conf.Config
+	// group commit
+	commits chan *commit
+	cwg     sync.WaitGroup
 	// compact
 	Compact       bool   `json:"compact"`
 	CompactOffset uint32 `json:"compact_offset"`
 	CompactTime   int64  `json:"compact_time"`
 	compactKeys   []int64
+	// space, the bytes of live needles and deleted (or overwritten) needles,
+	// changed with lock held by the atomic writes, so read by atomic loads
+	// without lock
+	LiveBytes    int64 `json:"live_bytes"`
+	DeletedBytes int64 `json:"deleted_bytes"`
+	// the estimated memory of the needle index
+	NeedlesMemory int64 `json:"needles_memory"`
+	// scrub
+	ScrubOffset uint32  `json:"scrub_offset"`
+	ScrubTime   int64   `json:"scrub_time"`
+	Corrupts    []int64 `json:"corrupts"`
+	Repair      bool    `json:"repair"`
+	corrupts    map[int64]int64
+	// snapshot, the needle offsets turned deleted since the epoch, the cuts
+	// before the rebase epoch need a new base
+	epoch  int64
+	dels   []uint32
+	rebase int64
+	// checkpoint, clock serialize the checkpoint writer
+	CheckpointTime int64 `json:"checkpoint_time"`
+	clock          sync.Mutex
+	ckpBlock       uint32
+	ckpEpoch       int64
+	ckpDels        int
+	// disk, the status is set by the disk check of the store
+	Status   int32  `json:"status"`
+	IOErrors uint64 `json:"io_errors"`
 	// status
 	closed bool
 }
@@ -57,14 +96,17 @@ func NewVolume(id int32, bfile, ifile string, c *conf.Config) (v *Volume, err er
 	v.Id = id
 	v.Stats = &stat.Stats{}
 	// data
-	v.needles = make(map[int64]int64)
 	v.ch = make(chan uint32, c.Volume.SyncDelete)
+	v.commits = make(chan *commit, _commitBuffer)
 	v.conf = c
 	// compact
 	v.Compact = false
 	v.CompactOffset = 0
 	v.CompactTime = 0
 	v.compactKeys = []int64{}
+	// scrub
+	v.Corrupts = []int64{}
+	v.corrupts = make(map[int64]int64)
 	// status
 	v.closed = false
 	if v.Block, err = block.NewSuperBlock(bfile, c); err != nil {
@@ -78,56 +120,130 @@ func NewVolume(id int32, bfile, ifile string, c *conf.Config) (v *Volume, err er
 		v.Close()
 		return nil, err
 	}
+	v.resetEpoch()
 	v.wg.Add(1)
 	go v.delproc()
+	v.cwg.Add(1)
+	go v.commitproc()
 	return
 }
 
-// init recovery super block from index or super block.
+// init recovery super block from the checkpoint, index or super block.
 func (v *Volume) init() (err error) {
 	var (
+		i          int
 		size       int64
+		ioffset    int64
 		offset     uint32
 		lastOffset uint32
+		covered    uint32
+		c          *checkpoint
+		sns        scrubNeedles
 	)
+	if v.needles, err = NewNeedles(v.conf.Volume.Needles); err != nil {
+		return
+	}
+	v.expires = make(map[int64]int64)
+	atomic.StoreInt64(&v.LiveBytes, 0)
+	atomic.StoreInt64(&v.DeletedBytes, 0)
+	v.NeedlesMemory = v.needles.Memory()
+	// recovery from checkpoint
+	if c, err = v.loadCheckpoint(); err == nil {
+		for i = 0; i < len(c.keys); i++ {
+			v.needles.Set(c.keys[i], c.ncs[i])
+		}
+		v.expires = c.expires
+		atomic.StoreInt64(&v.LiveBytes, c.live)
+		atomic.StoreInt64(&v.DeletedBytes, c.deleted)
+		v.NeedlesMemory = v.needles.Memory()
+		ioffset, covered, lastOffset = c.index, c.block, c.block
+		// the block is replayed from the end of the index at least
+		offset = c.lastEnd(v.Block)
+		log.Infof("volume: %d recovery from checkpoint block: %d index: %d needles: %d", v.Id, c.block, c.index, len(c.keys))
+	} else if !os.IsNotExist(err) {
+		log.Warningf("volume: %d load checkpoint error(%v), recovery from index", v.Id, err)
+	}
 	// recovery from index
-	if err = v.Indexer.Recovery(func(ix *index.Index) error {
+	if err = v.Indexer.RecoveryFrom(ioffset, func(ix *index.Index) error {
+		// covered by the checkpoint
+		if ix.Offset < covered {
+			offset = ix.Offset + v.Block.NeedleOffset(int64(ix.Size))
+			return nil
+		}
 		// must no less than last offset
 		if ix.Offset < lastOffset {
 			log.Error("recovery index: %s lastoffset: %d error(%v)", ix, lastOffset, errors.ErrIndexOffset)
 			return errors.ErrIndexOffset
 		}
 		// WARN if index's offset more than the block, discard it.
-		if size = int64(ix.Size) + needle.BlockOffset(ix.Offset); size > v.Block.Size {
+		if size = int64(ix.Size) + v.Block.BlockOffset(ix.Offset); size > v.Block.Size {
 			log.Error("recovery index: %s EOF", ix)
 			return errors.ErrIndexEOF
 		}
-		v.needles[ix.Key] = needle.NewCache(ix.Offset, ix.Size)
-		offset = ix.Offset + needle.NeedleOffset(int64(ix.Size))
+		v.setNeedle(ix.Key, needle.NewCache(ix.Offset, ix.Size))
+		sns = append(sns, scrubNeedle{key: ix.Key, offset: ix.Offset, size: ix.Size})
+		offset = ix.Offset + v.Block.NeedleOffset(int64(ix.Size))
 		lastOffset = ix.Offset
 		return nil
 	}); err != nil && err != errors.ErrIndexEOF {
 		return
 	}
-	// recovery from super block
+	// recovery from super block, the needles covered by the checkpoint only
+	// miss the index entries which were not merged to disk
 	if err = v.Block.Recovery(offset, func(n *needle.Needle, so, eo uint32) (err1 error) {
+		if so < covered {
+			if n.Flag == needle.FlagOK {
+				err1 = v.Indexer.Write(n.Key, so, n.TotalSize)
+			}
+			return
+		}
 		if n.Flag == needle.FlagOK {
 			if err1 = v.Indexer.Write(n.Key, so, n.TotalSize); err1 != nil {
 				return
 			}
 		} else {
 			so = needle.CacheDelOffset
+			atomic.AddInt64(&v.DeletedBytes, int64(n.TotalSize))
+		}
+		v.setNeedle(n.Key, needle.NewCache(so, n.TotalSize))
+		if n.Flag == needle.FlagOK {
+			v.setExpire(n.Key, n.Expire)
 		}
-		v.needles[n.Key] = needle.NewCache(so, n.TotalSize)
 		return
 	}); err != nil {
 		return
 	}
+	v.loadExpires(sns)
 	// flush index
 	err = v.Indexer.Flush()
 	return
 }
 
+// loadExpires read the headers of the needles recovered from the index, the
+// expire of the ttl ones is recorded, which the index has no room for. a
+// needle failed to read is left to the scrub.
+func (v *Volume) loadExpires(sns scrubNeedles) {
+	var (
+		err error
+		sn  scrubNeedle
+		nc  int64
+		n   *needle.Needle
+	)
+	for _, sn = range sns {
+		// replaced by the tail of the block
+		if nc = needle.NewCache(sn.offset, sn.size); !hasNeedle(v.needles, sn.key, nc) {
+			continue
+		}
+		n = needle.NewRangeReader(sn.key, nc)
+		if err = v.Block.ReadHeaderAt(n); err != nil {
+			log.Errorf("volume: %d read needle: %d header error(%v)", v.Id, sn.key, err)
+		} else if n.Key == sn.key && n.Flag == needle.FlagOK {
+			v.setExpire(sn.key, n.Expire)
+		}
+		n.Close()
+	}
+}
+
 // Meta get index meta data.
 func (v *Volume) Meta() []byte {
 	return []byte(fmt.Sprintf("%s,%s,%d", v.Block.File, v.Indexer.File, v.Id))
@@ -163,17 +279,24 @@ func (v *Volume) read(n *needle.Needle) (err error) {
 	var (
 		key  = n.Key
 		size = n.TotalSize
+		nc   = needle.NewCache(n.Offset, size)
 		now  = time.Now().UnixNano()
 	)
 	// pread syscall is atomic, no lock
 	if err = v.Block.ReadAt(n); err != nil {
-		return
-	}
-	if n.Key != key {
-		return errors.ErrNeedleKey
+		v.ioError(err)
+	} else {
+		if n.Key != key {
+			err = errors.ErrNeedleKey
+		} else if n.TotalSize != size {
+			err = errors.ErrNeedleSize
+		}
 	}
-	if n.TotalSize != size {
-		return errors.ErrNeedleSize
+	if err != nil {
+		if isCorrupt(err) {
+			v.corrupt(key, nc)
+		}
+		return
 	}
 	if log.V(1) {
 		log.Infof("get needle key: %d, cookie: %d, offset: %d, size: %d", n.Key, n.Cookie, n.Offset, size)
@@ -181,10 +304,11 @@ func (v *Volume) read(n *needle.Needle) (err error) {
 	}
 	// needles map may be out-dated, recheck
 	if n.Flag == needle.FlagDel {
-		v.lock.Lock()
-		v.needles[key] = needle.NewCache(needle.CacheDelOffset, size)
-		v.lock.Unlock()
+		v.deleted(key, nc)
 		err = errors.ErrNeedleDeleted
+	} else if n.Expired(time.Now().Unix()) {
+		v.delete(key, nc)
+		err = errors.ErrNeedleExpired
 	} else {
 		atomic.AddUint64(&v.Stats.TotalGetProcessed, 1)
 		atomic.AddUint64(&v.Stats.TotalReadBytes, uint64(size))
@@ -199,8 +323,11 @@ func (v *Volume) Read(key int64, cookie int32) (n *needle.Needle, err error) {
 		ok bool
 		nc int64
 	)
+	if err = v.available(false); err != nil {
+		return
+	}
 	v.lock.RLock()
-	if nc, ok = v.needles[key]; !ok {
+	if nc, ok = v.needles.Get(key); !ok {
 		err = errors.ErrNeedleNotExist
 	}
 	v.lock.RUnlock()
@@ -222,20 +349,202 @@ func (v *Volume) Read(key int64, cookie int32) (n *needle.Needle, err error) {
 	return
 }
 
-// Probe probe a needle.
-func (v *Volume) Probe() (err error) {
+// ReadRange get a window of needle data by key, cookie and a http range, only
+// the needle header and the window are read from disk. if the range covers
+// the whole needle, fallback to Read for verifying the checksum. the range of
+// a compressed needle fits the raw size, and the whole needle is read.
+func (v *Volume) ReadRange(key int64, cookie int32, rng *meta.Range) (n *needle.Needle, err error) {
+	var (
+		ok   bool
+		nc   int64
+		size int32
+		now  = time.Now().UnixNano()
+	)
+	if err = v.available(false); err != nil {
+		return
+	}
+	v.lock.RLock()
+	if nc, ok = v.needles.Get(key); !ok {
+		err = errors.ErrNeedleNotExist
+	}
+	v.lock.RUnlock()
+	if err != nil {
+		return
+	}
+	if n = needle.NewRangeReader(key, nc); n.Offset == needle.CacheDelOffset {
+		n.Close()
+		return nil, errors.ErrNeedleDeleted
+	}
+	size = n.TotalSize
+	if err = v.Block.ReadHeaderAt(n); err != nil {
+		v.ioError(err)
+	} else {
+		if n.Key != key {
+			err = errors.ErrNeedleKey
+		} else if n.TotalSize != size {
+			err = errors.ErrNeedleSize
+		} else if n.Flag == needle.FlagDel {
+			v.deleted(key, nc)
+			err = errors.ErrNeedleDeleted
+		} else if n.Expired(time.Now().Unix()) {
+			v.delete(key, nc)
+			err = errors.ErrNeedleExpired
+		} else if n.Cookie != cookie {
+			err = errors.ErrNeedleCookie
+		} else {
+			err = rng.Fit(n.RawSize())
+		}
+	}
+	if err != nil {
+		if isCorrupt(err) {
+			v.corrupt(key, nc)
+		}
+		n.Close()
+		return nil, err
+	}
+	// the compressed data is cut by the caller after decompression
+	if rng.Whole() || n.Compressed() {
+		n.Close()
+		return v.Read(key, cookie)
+	}
+	if err = v.Block.ReadDataAt(n, rng.Offset, rng.Size); err != nil {
+		v.ioError(err)
+		n.Close()
+		return nil, err
+	}
+	if log.V(1) {
+		log.Infof("get needle key: %d, cookie: %d, offset: %d, range: %s", n.Key, n.Cookie, n.Offset, rng.ContentRange())
+	}
+	atomic.AddUint64(&v.Stats.TotalGetProcessed, 1)
+	atomic.AddUint64(&v.Stats.TotalReadBytes, uint64(rng.Size))
+	atomic.AddUint64(&v.Stats.TotalGetDelay, uint64(time.Now().UnixNano()-now))
+	return
+}
+
+// Digest get the needles digest of the volume, used by anti-entropy to
+// compare the replicas.
+func (v *Volume) Digest() (d *meta.Digest) {
+	var (
+		offset uint32
+		dn     meta.DigestNeedle
+	)
+	d = &meta.Digest{Vid: v.Id, Buckets: make([]uint64, meta.DigestBuckets)}
+	v.lock.RLock()
+	v.needles.Range(func(key int64, nc int64) bool {
+		dn.Key = key
+		offset, dn.Size = needle.Cache(nc)
+		dn.Del = (offset == needle.CacheDelOffset)
+		d.Buckets[meta.DigestBucket(key)] += dn.Hash()
+		return true
+	})
+	v.lock.RUnlock()
+	return
+}
+
+// Keys get the keys of the live needles, used by the directory gc to find
+// the needles referenced by no file.
+func (v *Volume) Keys() (keys []int64) {
+	var offset uint32
+	v.lock.RLock()
+	keys = make([]int64, 0, v.needles.Len())
+	v.needles.Range(func(key int64, nc int64) bool {
+		if offset, _ = needle.Cache(nc); offset != needle.CacheDelOffset {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	v.lock.RUnlock()
+	return
+}
+
+// DigestNeedles get the needles of a digest bucket, the cookie of a live
+// needle is read from the block, a needle flaged deleted on disk is del.
+func (v *Volume) DigestNeedles(bucket int) (dns []*meta.DigestNeedle, err error) {
 	var (
-		ok  bool
-		nc  int64
 		key int64
+		nc  int64
+		ncs = make(map[int64]int64)
 		n   *needle.Needle
+		dn  *meta.DigestNeedle
 	)
+	if bucket < 0 || bucket >= meta.DigestBuckets {
+		return nil, errors.ErrParam
+	}
+	v.lock.RLock()
+	v.needles.Range(func(key int64, nc int64) bool {
+		if meta.DigestBucket(key) == bucket {
+			ncs[key] = nc
+		}
+		return true
+	})
+	v.lock.RUnlock()
+	for key, nc = range ncs {
+		n = needle.NewRangeReader(key, nc)
+		dn = &meta.DigestNeedle{Key: key, Size: n.TotalSize, Del: n.Offset == needle.CacheDelOffset}
+		if !dn.Del {
+			if err = v.Block.ReadHeaderAt(n); err != nil {
+				n.Close()
+				return nil, err
+			}
+			if n.Key != key {
+				n.Close()
+				return nil, errors.ErrNeedleKey
+			}
+			dn.Cookie = n.Cookie
+			dn.Del = (n.Flag == needle.FlagDel)
+		}
+		n.Close()
+		dns = append(dns, dn)
+	}
+	return
+}
+
+// Snapshot get the block and index files and their sizes, the bytes before
+// the sizes are immutable except the needle del flag, so they can be copied
+// while the volume is writing.
+func (v *Volume) Snapshot() (bfile string, bsize int64, ifile string, isize int64, err error) {
+	v.wlock.Lock()
+	v.lock.RLock()
+	if v.Compact {
+		err = errors.ErrVolumeInCompact
+	} else {
+		bfile, bsize = v.Block.File, v.Block.BlockOffset(v.Block.Offset)
+		ifile = v.Indexer.File
+	}
+	v.lock.RUnlock()
+	v.wlock.Unlock()
+	if err != nil {
+		return
+	}
+	isize, err = v.Indexer.Size()
+	return
+}
+
+// Probe probe a needle.
+func (v *Volume) Probe() (err error) {
+	var (
+		ok   bool
+		i, j int
+		nc   int64
+		key  int64
+		n    *needle.Needle
+	)
+	if err = v.available(false); err != nil {
+		return
+	}
 	v.lock.RLock()
 	// get a rand key
-	for key, _ = range v.needles {
-		break
+	if j = v.needles.Len(); j > 0 {
+		i = rand.Intn(j)
+		v.needles.Range(func(k int64, c int64) bool {
+			if i--; i >= 0 {
+				return true
+			}
+			key, nc, ok = k, c, true
+			return false
+		})
 	}
-	if nc, ok = v.needles[key]; !ok {
+	if !ok {
 		err = errors.ErrNeedleNotExist
 	}
 	v.lock.RUnlock()
@@ -259,15 +568,23 @@ func (v *Volume) Write(n *needle.Needle) (err error) {
 		offset uint32
 		now    = time.Now().UnixNano()
 	)
+	if err = v.available(true); err != nil {
+		return
+	}
+	v.wlock.Lock()
 	v.lock.Lock()
 	n.Offset = v.Block.Offset
 	if err = v.Block.Write(n); err == nil {
 		if err = v.Indexer.Add(n.Key, n.Offset, n.TotalSize); err == nil {
-			nc, ok = v.needles[n.Key]
-			v.needles[n.Key] = needle.NewCache(n.Offset, n.TotalSize)
+			nc, ok = v.needles.Get(n.Key)
+			v.setNeedle(n.Key, needle.NewCache(n.Offset, n.TotalSize))
+			v.setExpire(n.Key, n.Expire)
+			v.uncorrupt(n.Key)
 		}
 	}
 	v.lock.Unlock()
+	v.wlock.Unlock()
+	v.ioError(err)
 	if err == nil {
 		if log.V(1) {
 			log.Infof("add needle, offset: %d, size: %d", n.Offset, n.TotalSize)
@@ -284,6 +601,47 @@ func (v *Volume) Write(n *needle.Needle) (err error) {
 	return
 }
 
+// WriteFrom stream a needle from io.Reader into super block, if key exists
+// append to super block, then update needle cache offset to new offset.
+func (v *Volume) WriteFrom(n *needle.Needle, rd io.Reader) (err error) {
+	var (
+		ok     bool
+		nc     int64
+		offset uint32
+		now    = time.Now().UnixNano()
+	)
+	if err = v.available(true); err != nil {
+		return
+	}
+	v.wlock.Lock()
+	n.Offset = v.Block.Offset
+	if err = v.Block.WriteFrom(n, rd); err == nil {
+		v.lock.Lock()
+		if err = v.Indexer.Add(n.Key, n.Offset, n.TotalSize); err == nil {
+			nc, ok = v.needles.Get(n.Key)
+			v.setNeedle(n.Key, needle.NewCache(n.Offset, n.TotalSize))
+			v.setExpire(n.Key, n.Expire)
+			v.uncorrupt(n.Key)
+		}
+		v.lock.Unlock()
+	}
+	v.wlock.Unlock()
+	v.ioError(err)
+	if err == nil {
+		if log.V(1) {
+			log.Infof("add needle, offset: %d, size: %d", n.Offset, n.TotalSize)
+		}
+		if ok {
+			offset, _ = needle.Cache(nc)
+			v.del(offset)
+		}
+		atomic.AddUint64(&v.Stats.TotalWriteProcessed, 1)
+		atomic.AddUint64(&v.Stats.TotalWriteBytes, uint64(n.TotalSize))
+		atomic.AddUint64(&v.Stats.TotalWriteDelay, uint64(time.Now().UnixNano()-now))
+	}
+	return
+}
+
 // Writes add needles, if key exists append to super block, then update
 // needle cache offset to new offset.
 func (v *Volume) Writes(ns *needle.Needles) (err error) {
@@ -295,6 +653,10 @@ func (v *Volume) Writes(ns *needle.Needles) (err error) {
 		n      *needle.Needle
 		now    = time.Now().UnixNano()
 	)
+	if err = v.available(true); err != nil {
+		return
+	}
+	v.wlock.Lock()
 	v.lock.Lock()
 	for n = ns.Next(); n != nil; n = ns.Next() {
 		offset = v.Block.Offset
@@ -304,16 +666,20 @@ func (v *Volume) Writes(ns *needle.Needles) (err error) {
 		if err = v.Indexer.Add(n.Key, offset, n.TotalSize); err != nil {
 			break
 		}
-		if nc, ok = v.needles[n.Key]; ok {
+		if nc, ok = v.needles.Get(n.Key); ok {
 			ncs = append(ncs, nc)
 		}
-		v.needles[n.Key] = needle.NewCache(offset, n.TotalSize)
+		v.setNeedle(n.Key, needle.NewCache(offset, n.TotalSize))
+		v.setExpire(n.Key, n.Expire)
+		v.uncorrupt(n.Key)
 		if log.V(1) {
 			log.Infof("add needle, offset: %d, size: %d", offset, n.TotalSize)
 			log.Info(n)
 		}
 	}
 	v.lock.Unlock()
+	v.wlock.Unlock()
+	v.ioError(err)
 	if err == nil {
 		for _, nc = range ncs {
 			offset, _ = needle.Cache(nc)
@@ -326,6 +692,78 @@ func (v *Volume) Writes(ns *needle.Needles) (err error) {
 	return
 }
 
+// setNeedle set the needle cache and account the live and deleted bytes, a
+// replaced live needle turns deleted and is logged for the snapshot, the
+// expire of the old one is cleared, must called with lock held.
+func (v *Volume) setNeedle(key int64, nc int64) {
+	var (
+		ok     bool
+		onc    int64
+		offset uint32
+		size   int32
+	)
+	if onc, ok = v.needles.Get(key); ok {
+		if offset, size = needle.Cache(onc); offset != needle.CacheDelOffset {
+			atomic.AddInt64(&v.LiveBytes, -int64(size))
+			atomic.AddInt64(&v.DeletedBytes, int64(size))
+			if v.dels = append(v.dels, offset); len(v.dels) >= _maxDels {
+				v.rebaseEpoch()
+			}
+		}
+	}
+	if offset, size = needle.Cache(nc); offset != needle.CacheDelOffset {
+		atomic.AddInt64(&v.LiveBytes, int64(size))
+	}
+	v.needles.Set(key, nc)
+	v.NeedlesMemory = v.needles.Memory()
+	delete(v.expires, key)
+}
+
+// setExpire set the expire of a needle, zero means never, must called with
+// lock held after setNeedle.
+func (v *Volume) setExpire(key int64, expire int64) {
+	if expire > 0 {
+		v.expires[key] = expire
+	}
+}
+
+// deleted update the out-dated needle cache which is flaged deleted on disk,
+// e.g. deleted before the store restart.
+func (v *Volume) deleted(key int64, nc int64) {
+	var _, size = needle.Cache(nc)
+	v.lock.Lock()
+	if hasNeedle(v.needles, key, nc) {
+		v.setNeedle(key, needle.NewCache(needle.CacheDelOffset, size))
+	}
+	v.lock.Unlock()
+}
+
+// Garbage get the ratio of the deleted and expired bytes, the expired ones
+// are live until deleted by Expire.
+func (v *Volume) Garbage() float64 {
+	var (
+		key, nc        int64
+		expire         int64
+		size           int32
+		garbage, total int64
+		now            = time.Now().Unix()
+	)
+	v.lock.RLock()
+	garbage, total = v.DeletedBytes, v.LiveBytes+v.DeletedBytes
+	for key, expire = range v.expires {
+		if expire <= now {
+			nc, _ = v.needles.Get(key)
+			_, size = needle.Cache(nc)
+			garbage += int64(size)
+		}
+	}
+	v.lock.RUnlock()
+	if total == 0 {
+		return 0
+	}
+	return float64(garbage) / float64(total)
+}
+
 // del signal the godel goroutine aync merge all offsets and del.
 func (v *Volume) del(offset uint32) (err error) {
 	if offset == needle.CacheDelOffset {
@@ -343,16 +781,26 @@ func (v *Volume) del(offset uint32) (err error) {
 // Delete logical delete a needle, update disk needle flag and memory needle
 // cache offset to zero.
 func (v *Volume) Delete(key int64) (err error) {
+	if err = v.available(true); err != nil {
+		return
+	}
+	return v.delete(key, 0)
+}
+
+// delete logical delete a needle, if nc is not zero, only delete it when the
+// needle cache is still nc.
+func (v *Volume) delete(key int64, nc int64) (err error) {
 	var (
 		ok     bool
-		nc     int64
+		onc    int64
 		size   int32
 		offset uint32
 	)
 	v.lock.Lock()
-	if nc, ok = v.needles[key]; ok {
-		if offset, size = needle.Cache(nc); offset != needle.CacheDelOffset {
-			v.needles[key] = needle.NewCache(needle.CacheDelOffset, size)
+	if onc, ok = v.needles.Get(key); ok && (nc == 0 || nc == onc) {
+		if offset, size = needle.Cache(onc); offset != needle.CacheDelOffset {
+			v.setNeedle(key, needle.NewCache(needle.CacheDelOffset, size))
+			v.uncorrupt(key)
 			// when in compact, must save all del operations.
 			if v.Compact {
 				v.compactKeys = append(v.compactKeys, key)
@@ -370,6 +818,35 @@ func (v *Volume) Delete(key int64) (err error) {
 	return
 }
 
+// Expire delete the needles expired at the unix seconds, so the space is
+// reclaimed by compaction.
+func (v *Volume) Expire(now int64) (n int) {
+	var (
+		key    int64
+		nc     int64
+		expire int64
+		ncs    = make(map[int64]int64)
+	)
+	v.lock.RLock()
+	if !v.closed {
+		for key, expire = range v.expires {
+			if expire <= now {
+				ncs[key], _ = v.needles.Get(key)
+			}
+		}
+	}
+	v.lock.RUnlock()
+	for key, nc = range ncs {
+		if v.delete(key, nc) == nil {
+			n++
+		}
+	}
+	if n > 0 {
+		log.Infof("volume: %d expire needles: %d", v.Id, n)
+	}
+	return
+}
+
 // del merge from volume signal, then update block needles flag.
 func (v *Volume) delproc() {
 	var (
@@ -400,6 +877,7 @@ func (v *Volume) delproc() {
 				// operation but when Compact must finish the job, the cached
 				// offset is a old block owner.
 				if err = v.Block.Delete(offset); err != nil {
+					v.ioError(err)
 					break
 				}
 				atomic.AddUint64(&v.Stats.TotalDelProcessed, 1)
@@ -418,23 +896,79 @@ func (v *Volume) delproc() {
 	return
 }
 
-// compact compact v to new v.
-func (v *Volume) compact(nv *Volume) (err error) {
-	err = v.Block.Compact(v.CompactOffset, func(n *needle.Needle, so, eo uint32) (err1 error) {
-		if n.Flag != needle.FlagDel {
-			if err1 = nv.Write(n); err1 != nil {
-				return
+// compact compact v to new v, the read bytes are throttled by the limiter
+// if not nil. a known corrupt needle breaks the scan, then it's skipped and
+// the scan is resumed from the next live needle, the skipped one is repaired
+// from the other replicas.
+func (v *Volume) compact(nv *Volume, l *rate.Limiter, locked bool) (err error) {
+	var (
+		ok   bool
+		next uint32
+		now  = time.Now().Unix()
+	)
+	if v.CompactOffset == 0 {
+		v.CompactOffset = v.Block.FirstOffset()
+	}
+	for {
+		if err = v.Block.Compact(v.CompactOffset, func(n *needle.Needle, so, eo uint32) (err1 error) {
+			if l != nil {
+				time.Sleep(l.ReserveN(time.Now(), int(n.TotalSize)).Delay())
+			}
+			if n.Flag != needle.FlagDel && !n.Expired(now) {
+				if err1 = nv.Write(n); err1 != nil {
+					return
+				}
 			}
+			v.CompactOffset = eo
+			return
+		}); err == nil || !isCorrupt(err) {
+			return
+		}
+		if !locked {
+			v.lock.RLock()
 		}
-		v.CompactOffset = eo
+		next, ok = v.corruptNext(v.CompactOffset)
+		if !locked {
+			v.lock.RUnlock()
+		}
+		if !ok {
+			return
+		}
+		log.Warningf("volume: %d compact skip corrupt needle offset: %d, resume from: %d", v.Id, v.CompactOffset, next)
+		v.CompactOffset = next
+	}
+}
+
+// corruptNext get the offset of the next live needle after the needle at the
+// offset, ok if it's a known corrupt one, must called with lock held.
+func (v *Volume) corruptNext(offset uint32) (next uint32, ok bool) {
+	var (
+		nc int64
+		so uint32
+	)
+	for _, nc = range v.corrupts {
+		if so, _ = needle.Cache(nc); so == offset {
+			ok = true
+			break
+		}
+	}
+	if !ok {
 		return
+	}
+	next = v.Block.Offset
+	v.needles.Range(func(key int64, nc int64) bool {
+		if so, _ = needle.Cache(nc); so != needle.CacheDelOffset && so > offset && so < next {
+			next = so
+		}
+		return true
 	})
 	return
 }
 
-// Compact copy the super block to another space, and drop the "delete"
-// needle, so this can reduce disk space cost.
-func (v *Volume) StartCompact(nv *Volume) (err error) {
+// Compact copy the super block to another space, and drop the "delete" and
+// expired needle, so this can reduce disk space cost. the read bytes are throttled
+// by the limiter if not nil.
+func (v *Volume) StartCompact(nv *Volume, l *rate.Limiter) (err error) {
 	v.lock.Lock()
 	if v.Compact {
 		err = errors.ErrVolumeInCompact
@@ -446,7 +980,7 @@ func (v *Volume) StartCompact(nv *Volume) (err error) {
 		return
 	}
 	v.CompactTime = time.Now().UnixNano()
-	if err = v.compact(nv); err != nil {
+	if err = v.compact(nv, l, false); err != nil {
 		return
 	}
 	atomic.AddUint64(&v.Stats.TotalCompactProcessed, 1)
@@ -460,17 +994,24 @@ func (v *Volume) StartCompact(nv *Volume) (err error) {
 // if nv is nil, only reset compact status.
 func (v *Volume) StopCompact(nv *Volume) (err error) {
 	var key int64
+	v.wlock.Lock()
+	defer v.wlock.Unlock()
 	v.lock.Lock()
 	defer v.lock.Unlock()
 	if nv != nil {
-		if err = v.compact(nv); err != nil {
+		if err = v.compact(nv, nil, true); err != nil {
 			goto free
 		}
 		for _, key = range v.compactKeys {
-			if err = nv.Delete(key); err != nil {
+			// the expired or deleted one may be dropped by compact
+			if err = nv.Delete(key); err != nil && err != errors.ErrNeedleNotExist {
 				goto free
 			}
 		}
+		// the acked group commits may be synced on the old block
+		if err = nv.Block.Sync(); err != nil {
+			goto free
+		}
 		// NOTE MUST wait old block finish async delete operations.
 		v.ch <- _finish
 		v.wg.Wait()
@@ -478,6 +1019,15 @@ func (v *Volume) StopCompact(nv *Volume) (err error) {
 		v.Block, nv.Block = nv.Block, v.Block
 		v.Indexer, nv.Indexer = nv.Indexer, v.Indexer
 		v.needles, nv.needles = nv.needles, v.needles
+		v.expires, nv.expires = nv.expires, v.expires
+		nv.LiveBytes = atomic.SwapInt64(&v.LiveBytes, nv.LiveBytes)
+		nv.DeletedBytes = atomic.SwapInt64(&v.DeletedBytes, nv.DeletedBytes)
+		v.NeedlesMemory, nv.NeedlesMemory = nv.NeedlesMemory, v.NeedlesMemory
+		// the needles moved, the corrupt ones are skipped by compact
+		v.corrupts = make(map[int64]int64)
+		v.setCorrupts()
+		// the block replaced, the snapshots need a new base
+		v.resetEpoch()
 		atomic.AddUint64(&v.Stats.TotalCompactDelay, uint64(time.Now().UnixNano()-v.CompactTime))
 		// NOTE MUST restart delproc job
 		v.wg.Add(1)
@@ -510,9 +1060,12 @@ func (v *Volume) Open() (err error) {
 		v.Close()
 		return
 	}
+	v.resetEpoch()
 	v.closed = false
 	v.wg.Add(1)
 	go v.delproc()
+	v.cwg.Add(1)
+	go v.commitproc()
 	return
 }
 
@@ -521,6 +1074,10 @@ func (v *Volume) close() {
 		v.ch <- _finish
 		v.wg.Wait()
 	}
+	if v.commits != nil {
+		v.commits <- nil
+		v.cwg.Wait()
+	}
 	if v.Block != nil {
 		v.Block.Close()
 	}
@@ -532,6 +1089,8 @@ func (v *Volume) close() {
 
 // Close close the volume.
 func (v *Volume) Close() {
+	v.wlock.Lock()
+	defer v.wlock.Unlock()
 	v.lock.Lock()
 	defer v.lock.Unlock()
 	v.close()
@@ -539,6 +1098,8 @@ func (v *Volume) Close() {
 
 // Destroy remove block and index file, must called after Close().
 func (v *Volume) Destroy() {
+	v.wlock.Lock()
+	defer v.wlock.Unlock()
 	v.lock.Lock()
 	defer v.lock.Unlock()
 	if !v.closed {
@@ -549,5 +1110,6 @@ func (v *Volume) Destroy() {
 	}
 	if v.Indexer != nil {
 		v.Indexer.Destroy()
+		os.Remove(CheckpointFile(v.Indexer.File))
 	}
 }
@@ -2,9 +2,11 @@ package volume
 
 import (
 	"bfs/libs/errors"
+	"bfs/libs/meta"
 	"bfs/store/conf"
 	"bfs/store/needle"
 	"bytes"
+	"golang.org/x/time/rate"
 	"os"
 	"testing"
 	"time"
@@ -21,6 +23,7 @@ var (
 	}
 	_bc = &conf.Block{
 		BufferSize:    4 * 1024 * 1024,
+		StreamBuffer:  64 * 1024,
 		SyncWrite:     1024,
 		Syncfilerange: true,
 	}
@@ -50,6 +53,15 @@ func TestVolume(t *testing.T) {
 		ifile = "../test/test1.idx"
 		ns    = needle.NewNeedles(3)
 		buf   = &bytes.Buffer{}
+		d, d1 *meta.Digest
+		dns   []*meta.DigestNeedle
+		key   int64
+		keys  []int64
+		cdata []byte
+		rng   *meta.Range
+		// snapshot
+		sbfile, sifile string
+		bsize, isize   int64
 	)
 	os.Remove(bfile)
 	os.Remove(ifile)
@@ -137,6 +149,28 @@ func TestVolume(t *testing.T) {
 		t.Errorf("Write() error(%v)", err)
 		t.FailNow()
 	}
+	// test stream write
+	n = needle.NewStreamWriter(7, 7, 4)
+	if err = v.WriteFrom(n, bytes.NewReader(data[:3])); err != errors.ErrNeedleDataSize {
+		t.Errorf("err: %v must be ErrNeedleDataSize", err)
+		t.FailNow()
+	}
+	n.Close()
+	n = needle.NewStreamWriter(7, 7, 4)
+	if err = v.WriteFrom(n, bytes.NewReader(data)); err != nil {
+		t.Errorf("WriteFrom() error(%v)", err)
+		t.FailNow()
+	}
+	n.Close()
+	if n, err = v.Read(7, 7); err != nil {
+		t.Errorf("Read() error(%v)", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(n.Data, data) {
+		t.Errorf("Read() data: %s not match", n.Data)
+		t.FailNow()
+	}
+	n.Close()
 	if err = v.Delete(3); err != nil {
 		t.Errorf("Del error(%v)", err)
 		t.FailNow()
@@ -147,6 +181,128 @@ func TestVolume(t *testing.T) {
 	} else {
 		err = nil
 	}
+	// test range read
+	if n, err = v.ReadRange(1, 1, meta.ParseRange("bytes=1-2")); err != nil {
+		t.Errorf("ReadRange() error(%v)", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(n.Data, []byte("es")) {
+		t.Errorf("ReadRange() data: %s not match", n.Data)
+		t.FailNow()
+	}
+	n.Close()
+	if n, err = v.ReadRange(5, 5, meta.ParseRange("bytes=-1")); err != nil {
+		t.Errorf("ReadRange() error(%v)", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(n.Data, []byte("t")) {
+		t.Errorf("ReadRange() data: %s not match", n.Data)
+		t.FailNow()
+	}
+	n.Close()
+	if n, err = v.ReadRange(2, 2, meta.ParseRange("bytes=0-")); err != nil {
+		t.Errorf("ReadRange() error(%v)", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(n.Data, data) {
+		t.Errorf("ReadRange() data: %s not match", n.Data)
+		t.FailNow()
+	}
+	n.Close()
+	if _, err = v.ReadRange(1, 1, meta.ParseRange("bytes=4-")); err != errors.ErrRangeNotSatisfiable {
+		t.Error("err must be ErrRangeNotSatisfiable")
+		t.FailNow()
+	}
+	if _, err = v.ReadRange(1, 2, meta.ParseRange("bytes=0-1")); err != errors.ErrNeedleCookie {
+		t.Error("err must be ErrNeedleCookie")
+		t.FailNow()
+	}
+	// the range of a compressed needle fits the raw size, the whole is read
+	if cdata, err = needle.Compress(meta.CodecGzip, bytes.Repeat(data, 100)); err != nil {
+		t.Errorf("Compress() error(%v)", err)
+		t.FailNow()
+	}
+	n = needle.NewMetaWriter(100, 100, int32(len(cdata)), &needle.Meta{Codec: meta.CodecGzip, Size: 400})
+	if err = n.ReadFrom(bytes.NewReader(cdata)); err != nil {
+		t.Errorf("ReadFrom() error(%v)", err)
+		t.FailNow()
+	}
+	if err = v.Write(n); err != nil {
+		t.Errorf("Write() error(%v)", err)
+		t.FailNow()
+	}
+	n.Close()
+	rng = meta.ParseRange("bytes=300-309")
+	if n, err = v.ReadRange(100, 100, rng); err != nil {
+		t.Errorf("ReadRange() error(%v)", err)
+		t.FailNow()
+	}
+	if !bytes.Equal(n.Data, cdata) || rng.Offset != 300 || rng.Size != 10 {
+		t.Errorf("ReadRange() range: %v not match", rng)
+		t.FailNow()
+	}
+	n.Close()
+	if _, err = v.ReadRange(3, 3, meta.ParseRange("bytes=0-1")); err != errors.ErrNeedleDeleted {
+		t.Error("err must be ErrNeedleDeleted")
+		t.FailNow()
+	} else {
+		err = nil
+	}
+	// test digest
+	d = v.Digest()
+	if d.Vid != 1 || len(d.Buckets) != meta.DigestBuckets || d.Buckets[meta.DigestBucket(1)] == 0 {
+		t.Errorf("Digest() %v not match", d.Vid)
+		t.FailNow()
+	}
+	if dns, err = v.DigestNeedles(meta.DigestBucket(1)); err != nil {
+		t.Errorf("DigestNeedles() error(%v)", err)
+		t.FailNow()
+	}
+	if len(dns) != 1 || dns[0].Key != 1 || dns[0].Cookie != 1 || dns[0].Del {
+		t.Errorf("DigestNeedles() %v not match", dns)
+		t.FailNow()
+	}
+	if dns, err = v.DigestNeedles(meta.DigestBucket(3)); err != nil {
+		t.Errorf("DigestNeedles() error(%v)", err)
+		t.FailNow()
+	}
+	if len(dns) != 1 || dns[0].Key != 3 || !dns[0].Del {
+		t.Errorf("DigestNeedles() %v not match", dns)
+		t.FailNow()
+	}
+	if _, err = v.DigestNeedles(meta.DigestBuckets); err != errors.ErrParam {
+		t.Error("err must be ErrParam")
+		t.FailNow()
+	}
+	if err = v.Delete(1); err != nil {
+		t.Errorf("Delete() error(%v)", err)
+		t.FailNow()
+	}
+	if d1 = v.Digest(); len(d.Diff(d1)) != 1 || d.Diff(d1)[0] != meta.DigestBucket(1) {
+		t.Errorf("Digest() diff: %v not match", d.Diff(d1))
+		t.FailNow()
+	}
+	// test keys, the deleted needles are excluded
+	keys = v.Keys()
+	for _, key = range keys {
+		if key == 1 || key == 3 {
+			t.Errorf("Keys() %v not match", keys)
+			t.FailNow()
+		}
+	}
+	if len(keys) != 6 {
+		t.Errorf("Keys() %v not match", keys)
+		t.FailNow()
+	}
+	// test snapshot
+	if sbfile, bsize, sifile, isize, err = v.Snapshot(); err != nil {
+		t.Errorf("Snapshot() error(%v)", err)
+		t.FailNow()
+	}
+	if sbfile != bfile || sifile != ifile || bsize != needle.BlockOffset(v.Block.Offset) || isize%16 != 0 {
+		t.Errorf("Snapshot() %s, %d, %s, %d not match", sbfile, bsize, sifile, isize)
+		t.FailNow()
+	}
 }
 
 /*
@@ -308,3 +464,355 @@ func BenchmarkVolumeGet(b *testing.B) {
 	})
 }
 */
+
+func TestVolumeScrub(t *testing.T) {
+	var (
+		v, nv  *Volume
+		n      *needle.Needle
+		f      *os.File
+		err    error
+		i, nc  int64
+		c      = *_c
+		vc     = *_vc
+		data   = []byte("test")
+		bfile  = "../test/test_scrub"
+		ifile  = "../test/test_scrub.idx"
+		nbfile = "../test/test_scrub_compact"
+		nifile = "../test/test_scrub_compact.idx"
+		buf    = &bytes.Buffer{}
+		l      = rate.NewLimiter(rate.Inf, 0)
+	)
+	for _, file := range []string{bfile, ifile, nbfile, nifile} {
+		os.Remove(file)
+		defer os.Remove(file)
+	}
+	vc.ScrubMark = true
+	c.Volume = &vc
+	c.BlockMaxSize = needle.Size(c.NeedleMaxSize)
+	if v, err = NewVolume(1, bfile, ifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer v.Close()
+	for i = 1; i <= 3; i++ {
+		buf.Write(data)
+		n = needle.NewWriter(i, int32(i), 4)
+		if err = n.ReadFrom(buf); err != nil {
+			t.Errorf("n.ReadFrom() error(%v)", err)
+			t.FailNow()
+		}
+		if err = v.Write(n); err != nil {
+			t.Errorf("Write() error(%v)", err)
+			t.FailNow()
+		}
+		n.Close()
+	}
+	if err = v.Scrub(l); err != nil || len(v.Corrupts) != 0 || v.Repair {
+		t.Errorf("Scrub() error(%v), corrupts: %v", err, v.Corrupts)
+		t.FailNow()
+	}
+	// corrupt the data of needle 2
+	nc, _ = v.needles.Get(2)
+	n = needle.NewReader(2, nc)
+	if f, err = os.OpenFile(bfile, os.O_WRONLY, 0664); err != nil {
+		t.Errorf("os.OpenFile() error(%v)", err)
+		t.FailNow()
+	}
+	if _, err = f.WriteAt([]byte("x"), needle.BlockOffset(n.Offset)+needle.HeaderSize); err != nil {
+		t.Errorf("f.WriteAt() error(%v)", err)
+		t.FailNow()
+	}
+	f.Close()
+	n.Close()
+	if err = v.Scrub(l); err != nil {
+		t.Errorf("Scrub() error(%v)", err)
+		t.FailNow()
+	}
+	if len(v.Corrupts) != 1 || v.Corrupts[0] != 2 || !v.Repair || v.ScrubOffset != v.Block.Offset {
+		t.Errorf("Scrub() corrupts: %v, repair: %t not match", v.Corrupts, v.Repair)
+		t.FailNow()
+	}
+	if _, err = v.Read(2, 2); err != errors.ErrNeedleChecksum {
+		t.Errorf("Read() error(%v) not match", err)
+		t.FailNow()
+	}
+	// rewrite clear the mark
+	buf.Write(data)
+	n = needle.NewWriter(2, 2, 4)
+	defer n.Close()
+	if err = n.ReadFrom(buf); err != nil {
+		t.Errorf("n.ReadFrom() error(%v)", err)
+		t.FailNow()
+	}
+	if err = v.Write(n); err != nil {
+		t.Errorf("Write() error(%v)", err)
+		t.FailNow()
+	}
+	if len(v.Corrupts) != 0 || v.Repair {
+		t.Errorf("Write() corrupts: %v not cleared", v.Corrupts)
+		t.FailNow()
+	}
+	if err = v.Scrub(l); err != nil || len(v.Corrupts) != 0 {
+		t.Errorf("Scrub() error(%v), corrupts: %v", err, v.Corrupts)
+		t.FailNow()
+	}
+	// compact skip the corrupt first needle
+	nc, _ = v.needles.Get(1)
+	n = needle.NewReader(1, nc)
+	if f, err = os.OpenFile(bfile, os.O_WRONLY, 0664); err != nil {
+		t.Errorf("os.OpenFile() error(%v)", err)
+		t.FailNow()
+	}
+	if _, err = f.WriteAt([]byte("x"), needle.BlockOffset(n.Offset)+needle.HeaderSize); err != nil {
+		t.Errorf("f.WriteAt() error(%v)", err)
+		t.FailNow()
+	}
+	f.Close()
+	n.Close()
+	if err = v.Scrub(l); err != nil || len(v.Corrupts) != 1 || v.Corrupts[0] != 1 {
+		t.Errorf("Scrub() error(%v), corrupts: %v", err, v.Corrupts)
+		t.FailNow()
+	}
+	if nv, err = NewVolume(2, nbfile, nifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer nv.Close()
+	if err = v.StartCompact(nv, l); err != nil {
+		t.Errorf("StartCompact() error(%v)", err)
+		t.FailNow()
+	}
+	if err = v.StopCompact(nv); err != nil {
+		t.Errorf("StopCompact() error(%v)", err)
+		t.FailNow()
+	}
+	if len(v.Corrupts) != 0 || v.Repair {
+		t.Errorf("StopCompact() corrupts: %v not cleared", v.Corrupts)
+		t.FailNow()
+	}
+	if _, err = v.Read(1, 1); err != errors.ErrNeedleNotExist {
+		t.Errorf("Read() error(%v) not match", err)
+		t.FailNow()
+	}
+	for i = 2; i <= 3; i++ {
+		if n, err = v.Read(i, int32(i)); err != nil {
+			t.Errorf("Read(%d) error(%v)", i, err)
+			t.FailNow()
+		}
+		n.Close()
+	}
+}
+
+func TestVolumeSpace(t *testing.T) {
+	var (
+		v, nv  *Volume
+		n      *needle.Needle
+		err    error
+		i      int64
+		size   int64
+		c      = *_c
+		data   = []byte("test")
+		bfile  = "../test/test_space"
+		ifile  = "../test/test_space.idx"
+		nbfile = "../test/test_space_compact"
+		nifile = "../test/test_space_compact.idx"
+		buf    = &bytes.Buffer{}
+		l      = rate.NewLimiter(rate.Inf, 0)
+		write  = func(key int64) {
+			buf.Write(data)
+			n = needle.NewWriter(key, int32(key), 4)
+			if err = n.ReadFrom(buf); err != nil {
+				t.Errorf("n.ReadFrom() error(%v)", err)
+				t.FailNow()
+			}
+			if err = v.Write(n); err != nil {
+				t.Errorf("Write() error(%v)", err)
+				t.FailNow()
+			}
+			size = int64(n.TotalSize)
+			n.Close()
+		}
+		check = func(live, deleted int64) {
+			if v.LiveBytes != live*size || v.DeletedBytes != deleted*size {
+				t.Errorf("live: %d deleted: %d not match %d %d", v.LiveBytes, v.DeletedBytes, live*size, deleted*size)
+				t.FailNow()
+			}
+		}
+	)
+	for _, file := range []string{bfile, ifile, nbfile, nifile} {
+		os.Remove(file)
+		defer os.Remove(file)
+	}
+	c.BlockMaxSize = needle.Size(c.NeedleMaxSize)
+	if v, err = NewVolume(1, bfile, ifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	for i = 1; i <= 4; i++ {
+		write(i)
+	}
+	check(4, 0)
+	// overwrite
+	write(1)
+	check(4, 1)
+	if err = v.Delete(2); err != nil {
+		t.Errorf("Delete() error(%v)", err)
+		t.FailNow()
+	}
+	check(3, 2)
+	if v.Garbage() != 0.4 {
+		t.Errorf("Garbage() %f not match", v.Garbage())
+		t.FailNow()
+	}
+	// recovery, the overwritten one is counted, the deleted one is live
+	// until read or scrubbed
+	v.Close()
+	if v, err = NewVolume(1, bfile, ifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer v.Close()
+	check(4, 1)
+	if err = v.Scrub(l); err != nil {
+		t.Errorf("Scrub() error(%v)", err)
+		t.FailNow()
+	}
+	check(3, 2)
+	// compact drop all the deleted
+	if nv, err = NewVolume(2, nbfile, nifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer nv.Close()
+	if err = v.StartCompact(nv, l); err != nil {
+		t.Errorf("StartCompact() error(%v)", err)
+		t.FailNow()
+	}
+	if err = v.StopCompact(nv); err != nil {
+		t.Errorf("StopCompact() error(%v)", err)
+		t.FailNow()
+	}
+	check(3, 0)
+	if _, err = v.Read(2, 2); err != errors.ErrNeedleDeleted && err != errors.ErrNeedleNotExist {
+		t.Errorf("Read() error(%v) not match", err)
+		t.FailNow()
+	}
+	if n, err = v.Read(3, 3); err != nil {
+		t.Errorf("Read() error(%v)", err)
+		t.FailNow()
+	}
+	n.Close()
+}
+
+func TestVolumeExpire(t *testing.T) {
+	var (
+		v, nv  *Volume
+		n      *needle.Needle
+		err    error
+		cnt    int
+		nc     int64
+		size   int32
+		c      = *_c
+		now    = time.Now().Unix()
+		data   = []byte("test")
+		bfile  = "../test/test_expire"
+		ifile  = "../test/test_expire.idx"
+		nbfile = "../test/test_expire_compact"
+		nifile = "../test/test_expire_compact.idx"
+		l      = rate.NewLimiter(rate.Inf, 0)
+		write  = func(key, expire int64) {
+			n = needle.NewExpireWriter(key, int32(key), 4, expire)
+			if err = n.ReadFrom(bytes.NewReader(data)); err != nil {
+				t.Errorf("n.ReadFrom() error(%v)", err)
+				t.FailNow()
+			}
+			if err = v.Write(n); err != nil {
+				t.Errorf("Write() error(%v)", err)
+				t.FailNow()
+			}
+			n.Close()
+		}
+		read = func(key int64, e error) {
+			if n, err = v.Read(key, int32(key)); err != e {
+				t.Errorf("Read(%d) error(%v) not match %v", key, err, e)
+				t.FailNow()
+			}
+			if err == nil {
+				n.Close()
+			}
+		}
+	)
+	for _, file := range []string{bfile, ifile, nbfile, nifile} {
+		os.Remove(file)
+		defer os.Remove(file)
+	}
+	c.BlockMaxSize = needle.MaxSize(c.NeedleMaxSize)
+	if v, err = NewVolume(1, bfile, ifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	write(1, now+3600)
+	write(2, now-1)
+	write(3, 0)
+	write(4, now-1)
+	// expired when read
+	read(2, errors.ErrNeedleExpired)
+	read(2, errors.ErrNeedleDeleted)
+	read(1, nil)
+	read(3, nil)
+	// expired by sweep
+	if cnt = v.Expire(now + 3600); cnt != 2 {
+		t.Errorf("Expire() %d not match", cnt)
+		t.FailNow()
+	}
+	read(1, errors.ErrNeedleDeleted)
+	read(4, errors.ErrNeedleDeleted)
+	// the expire is recovered on load, the expired one is garbage
+	write(5, now+3600)
+	write(6, now-1)
+	v.Close()
+	if v, err = NewVolume(1, bfile, ifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer v.Close()
+	if len(v.expires) != 2 || v.expires[5] != now+3600 || v.expires[6] != now-1 {
+		t.Errorf("expires: %v not match", v.expires)
+		t.FailNow()
+	}
+	nc, _ = v.needles.Get(6)
+	_, size = needle.Cache(nc)
+	if v.Garbage() != float64(v.DeletedBytes+int64(size))/float64(v.LiveBytes+v.DeletedBytes) {
+		t.Errorf("Garbage() %f not match", v.Garbage())
+		t.FailNow()
+	}
+	if err = v.Scrub(l); err != nil {
+		t.Errorf("Scrub() error(%v)", err)
+		t.FailNow()
+	}
+	if v.expires[5] != now+3600 || v.expires[6] != now-1 {
+		t.Errorf("expires: %v not match", v.expires)
+		t.FailNow()
+	}
+	// compact drop the expired one
+	if nv, err = NewVolume(2, nbfile, nifile, &c); err != nil {
+		t.Errorf("NewVolume() error(%v)", err)
+		t.FailNow()
+	}
+	defer nv.Close()
+	if err = v.StartCompact(nv, l); err != nil {
+		t.Errorf("StartCompact() error(%v)", err)
+		t.FailNow()
+	}
+	if err = v.StopCompact(nv); err != nil {
+		t.Errorf("StopCompact() error(%v)", err)
+		t.FailNow()
+	}
+	read(6, errors.ErrNeedleNotExist)
+	read(5, nil)
+	read(3, nil)
+	if v.expires[5] != now+3600 || len(v.expires) != 1 {
+		t.Errorf("expires: %v not match", v.expires)
+		t.FailNow()
+	}
+}
@@ -102,6 +102,41 @@ func (z *Zookeeper) Volumes() (lines []string, err error) {
 	return
 }
 
+// Stores get all the stores meta in the racks.
+func (z *Zookeeper) Stores() (stores []*meta.Store, err error) {
+	var (
+		rack, store  string
+		racks, nodes []string
+		data         []byte
+		spath        string
+		sm           *meta.Store
+	)
+	if racks, _, err = z.c.Children(z.conf.Zookeeper.Root); err != nil {
+		log.Errorf("zk.Children(\"%s\") error(%v)", z.conf.Zookeeper.Root, err)
+		return
+	}
+	for _, rack = range racks {
+		spath = path.Join(z.conf.Zookeeper.Root, rack)
+		if nodes, _, err = z.c.Children(spath); err != nil {
+			log.Errorf("zk.Children(\"%s\") error(%v)", spath, err)
+			return
+		}
+		for _, store = range nodes {
+			if data, _, err = z.c.Get(path.Join(spath, store)); err != nil {
+				log.Errorf("zk.Get(\"%s\") error(%v)", path.Join(spath, store), err)
+				return
+			}
+			sm = new(meta.Store)
+			if err = json.Unmarshal(data, sm); err != nil {
+				log.Errorf("json.Unmarshal() error(%v)", err)
+				return
+			}
+			stores = append(stores, sm)
+		}
+	}
+	return
+}
+
 func (z *Zookeeper) volumePath(id int32) string {
 	return path.Join(z.fpath, strconv.Itoa(int(id)))
 }